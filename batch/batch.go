@@ -0,0 +1,245 @@
+// Package batch collects individual calls into batches, in the manner of
+// DataLoader.
+//
+// Handlers that call a per-item endpoint in a loop can instead call the
+// endpoint returned by New, which queues each request for a short window and
+// sends all queued requests as a single call to a batch endpoint. The result
+// of each item is handed back to its caller.
+package batch
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/tnnyio/yoroi/endpoint"
+	"github.com/tnnyio/yoroi/metrics"
+	"github.com/tnnyio/yoroi/metrics/discard"
+	"github.com/tnnyio/yoroi/recovery"
+)
+
+// DefaultWait is the default time a batch waits for more requests.
+const DefaultWait = 2 * time.Millisecond
+
+// DefaultMaxSize is the default maximum number of requests in a batch.
+const DefaultMaxSize = 100
+
+// ErrResultCount is returned to the callers of a batch when the batch
+// endpoint returns a different number of results than it received requests.
+var ErrResultCount = errors.New("batch endpoint returned the wrong number of results")
+
+// Result is the outcome of a single request of a batch.
+type Result[O interface{}] struct {
+	Response O
+	Err      error
+}
+
+// KeyFunc derives the deduplication key of a request. Requests with the same
+// key that end up in the same batch are sent only once, and share the result.
+// Returning an empty key opts the request out of deduplication.
+type KeyFunc func(ctx context.Context, request interface{}) string
+
+// Option sets an optional parameter for batching.
+type Option func(*options)
+
+// Wait sets how long a batch waits for more requests after the first one
+// arrived. By default, DefaultWait is used.
+func Wait(d time.Duration) Option {
+	return func(o *options) { o.wait = d }
+}
+
+// MaxSize sets the maximum number of distinct requests in a batch. A batch
+// that is full is sent right away. By default, DefaultMaxSize is used.
+func MaxSize(n int) Option {
+	return func(o *options) { o.maxSize = n }
+}
+
+// Key sets the function deriving the deduplication key of requests. By
+// default, requests of comparable types are deduplicated by value, and other
+// requests aren't deduplicated.
+func Key(key KeyFunc) Option {
+	return func(o *options) { o.key = key }
+}
+
+// SizeHistogram sets a histogram that observes the number of requests sent
+// in every batch.
+func SizeHistogram(h metrics.Histogram) Option {
+	return func(o *options) { o.size = h }
+}
+
+type options struct {
+	wait    time.Duration
+	maxSize int
+	key     KeyFunc
+	size    metrics.Histogram
+}
+
+// New returns an endpoint that batches calls to the batch endpoint. The
+// batch endpoint is called with a []interface{} of the distinct requests,
+// and must return one Result per request, in the same order. If it returns
+// an error instead, that error is returned to every caller of the batch.
+//
+// A caller whose context is done returns immediately with the context
+// error. While its batch is still pending, the request is dropped from it,
+// unless another caller waits for the same request, and a batch left with
+// no requests isn't sent at all. Once the batch is in flight, the request
+// stays in it and its result is discarded. The batch endpoint is called with
+// a context that carries the values of the caller that started the batch,
+// and is canceled once every caller in the batch has given up.
+func New[O interface{}](batch endpoint.Endpoint[[]Result[O]], opts ...Option) endpoint.Endpoint[O] {
+	o := options{
+		wait:    DefaultWait,
+		maxSize: DefaultMaxSize,
+		size:    discard.NewHistogram(),
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	b := &batcher[O]{batch: batch, opts: o}
+	return b.do
+}
+
+type item[O interface{}] struct {
+	request interface{}
+	waiters int
+	done    chan struct{}
+	result  Result[O]
+}
+
+type pending[O interface{}] struct {
+	ctx     context.Context
+	cancel  context.CancelFunc
+	timer   *time.Timer
+	sent    bool
+	waiters int
+	items   []*item[O]
+	byKey   map[interface{}]*item[O]
+}
+
+type batcher[O interface{}] struct {
+	batch endpoint.Endpoint[[]Result[O]]
+	opts  options
+	mtx   sync.Mutex
+	cur   *pending[O]
+}
+
+func (b *batcher[O]) do(ctx context.Context, request interface{}) (O, error) {
+	b.mtx.Lock()
+	p := b.cur
+	if p == nil {
+		p = &pending[O]{ctx: ctx, byKey: map[interface{}]*item[O]{}}
+		p.timer = time.AfterFunc(b.opts.wait, func() { b.send(p) })
+		b.cur = p
+	}
+	key, dedupe := b.key(ctx, request)
+	it, ok := p.byKey[key]
+	if !dedupe || !ok {
+		it = &item[O]{request: request, done: make(chan struct{})}
+		p.items = append(p.items, it)
+		if dedupe {
+			p.byKey[key] = it
+		}
+	}
+	it.waiters++
+	p.waiters++
+	full := len(p.items) >= b.opts.maxSize
+	if full {
+		b.cur = nil
+	}
+	b.mtx.Unlock()
+
+	if full && p.timer.Stop() {
+		go b.send(p)
+	}
+
+	select {
+	case <-it.done:
+		return it.result.Response, it.result.Err
+	case <-ctx.Done():
+		b.leave(p, it)
+		var zero O
+		return zero, ctx.Err()
+	}
+}
+
+func (b *batcher[O]) key(ctx context.Context, request interface{}) (key interface{}, ok bool) {
+	if b.opts.key != nil {
+		k := b.opts.key(ctx, request)
+		return k, k != ""
+	}
+	// The value, not the type, decides: a struct with an interface field
+	// holding a slice has a comparable type, but can't be a map key.
+	if request == nil || !reflect.ValueOf(request).Comparable() {
+		return nil, false
+	}
+	return request, true
+}
+
+// leave unregisters a caller that gave up waiting, and cancels the batch once
+// all of its callers are gone.
+func (b *batcher[O]) leave(p *pending[O], it *item[O]) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	it.waiters--
+	p.waiters--
+	if p.waiters == 0 && p.cancel != nil {
+		p.cancel()
+	}
+}
+
+func (b *batcher[O]) send(p *pending[O]) {
+	b.mtx.Lock()
+	if b.cur == p {
+		b.cur = nil
+	}
+	if p.sent {
+		b.mtx.Unlock()
+		return
+	}
+	p.sent = true
+	var (
+		items    []*item[O]
+		requests []interface{}
+	)
+	for _, it := range p.items {
+		if it.waiters > 0 {
+			items = append(items, it)
+			requests = append(requests, it.request)
+		}
+	}
+	ctx, cancel := context.WithCancel(context.WithoutCancel(p.ctx))
+	p.cancel = cancel
+	b.mtx.Unlock()
+	defer cancel()
+
+	if len(items) == 0 {
+		return
+	}
+	b.opts.size.Observe(float64(len(items)))
+
+	results, err := b.call(ctx, requests)
+	if err == nil && len(results) != len(items) {
+		err = ErrResultCount
+	}
+	for i, it := range items {
+		if err != nil {
+			it.result = Result[O]{Err: err}
+		} else {
+			it.result = results[i]
+		}
+		close(it.done)
+	}
+}
+
+// call makes the batch call. A panic of the batch endpoint fails the batch
+// with a *recovery.PanicError, since no caller's goroutine would recover it.
+func (b *batcher[O]) call(ctx context.Context, requests []interface{}) (results []Result[O], err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			results, err = nil, recovery.NewPanicError(r)
+		}
+	}()
+	return b.batch(ctx, requests)
+}
@@ -0,0 +1,235 @@
+package batch_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tnnyio/yoroi/batch"
+	"github.com/tnnyio/yoroi/metrics/generic"
+	"github.com/tnnyio/yoroi/recovery"
+)
+
+// recorder is a batch endpoint that answers every int request n with
+// "item n", and records the batches it received.
+type recorder struct {
+	mtx     sync.Mutex
+	batches [][]interface{}
+}
+
+func (r *recorder) endpoint(ctx context.Context, request interface{}) ([]batch.Result[string], error) {
+	requests := request.([]interface{})
+	r.mtx.Lock()
+	r.batches = append(r.batches, requests)
+	r.mtx.Unlock()
+
+	results := make([]batch.Result[string], len(requests))
+	for i, req := range requests {
+		if req.(int) < 0 {
+			results[i].Err = fmt.Errorf("negative item %d", req)
+			continue
+		}
+		results[i].Response = fmt.Sprintf("item %d", req)
+	}
+	return results, nil
+}
+
+func (r *recorder) sizes() []int {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	var sizes []int
+	for _, b := range r.batches {
+		sizes = append(sizes, len(b))
+	}
+	sort.Ints(sizes)
+	return sizes
+}
+
+// callAll calls e concurrently with every request, and returns the responses
+// and errors in the order of the requests.
+func callAll(ctx context.Context, e func(context.Context, interface{}) (string, error), requests ...interface{}) ([]string, []error) {
+	var (
+		wg        sync.WaitGroup
+		responses = make([]string, len(requests))
+		errs      = make([]error, len(requests))
+	)
+	for i, request := range requests {
+		wg.Add(1)
+		go func(i int, request interface{}) {
+			defer wg.Done()
+			responses[i], errs[i] = e(ctx, request)
+		}(i, request)
+	}
+	wg.Wait()
+	return responses, errs
+}
+
+func TestBatch(t *testing.T) {
+	var (
+		r         = &recorder{}
+		histogram = generic.NewHistogram("batch_size", 10)
+		e         = batch.New[string](r.endpoint, batch.Wait(50*time.Millisecond), batch.SizeHistogram(histogram))
+	)
+	responses, errs := callAll(context.Background(), e, 1, 2, 3, -4)
+
+	if want, have := []string{"item 1", "item 2", "item 3", ""}, responses; !reflect.DeepEqual(want, have) {
+		t.Errorf("responses: want %v, have %v", want, have)
+	}
+	for i, err := range errs[:3] {
+		if err != nil {
+			t.Errorf("request %d: unexpected error: %v", i, err)
+		}
+	}
+	if errs[3] == nil {
+		t.Error("want error for negative item")
+	}
+	if want, have := []int{4}, r.sizes(); !reflect.DeepEqual(want, have) {
+		t.Errorf("batch sizes: want %v, have %v", want, have)
+	}
+	if want, have := 4.0, histogram.Quantile(0.5); want != have {
+		t.Errorf("histogram: want %v, have %v", want, have)
+	}
+}
+
+func TestMaxSize(t *testing.T) {
+	r := &recorder{}
+	e := batch.New[string](r.endpoint, batch.Wait(time.Hour), batch.MaxSize(2))
+	callAll(context.Background(), e, 1, 2, 3, 4)
+
+	if want, have := []int{2, 2}, r.sizes(); !reflect.DeepEqual(want, have) {
+		t.Errorf("batch sizes: want %v, have %v", want, have)
+	}
+}
+
+func TestDeduplicate(t *testing.T) {
+	r := &recorder{}
+	e := batch.New[string](r.endpoint, batch.Wait(50*time.Millisecond))
+	responses, _ := callAll(context.Background(), e, 1, 1, 2, 1)
+
+	if want, have := []string{"item 1", "item 1", "item 2", "item 1"}, responses; !reflect.DeepEqual(want, have) {
+		t.Errorf("responses: want %v, have %v", want, have)
+	}
+	if want, have := []int{2}, r.sizes(); !reflect.DeepEqual(want, have) {
+		t.Errorf("batch sizes: want %v, have %v", want, have)
+	}
+}
+
+func TestUnhashableRequest(t *testing.T) {
+	type request struct{ V interface{} }
+	e := batch.New[string](func(_ context.Context, requests interface{}) ([]batch.Result[string], error) {
+		results := make([]batch.Result[string], len(requests.([]interface{})))
+		for i, req := range requests.([]interface{}) {
+			results[i].Response = fmt.Sprint(req.(request).V)
+		}
+		return results, nil
+	}, batch.Wait(10*time.Millisecond))
+
+	// Not deduplicated, rather than panicking as a map key.
+	responses, errs := callAll(context.Background(), e, request{[]int{1}}, request{[]int{1}})
+	if want, have := []string{"[1]", "[1]"}, responses; !reflect.DeepEqual(want, have) {
+		t.Errorf("responses: want %v, have %v (errors %v)", want, have, errs)
+	}
+}
+
+func TestKeyFunc(t *testing.T) {
+	r := &recorder{}
+	e := batch.New[string](r.endpoint, batch.Wait(50*time.Millisecond), batch.Key(func(context.Context, interface{}) string {
+		return "" // no deduplication
+	}))
+	callAll(context.Background(), e, 1, 1)
+
+	if want, have := []int{2}, r.sizes(); !reflect.DeepEqual(want, have) {
+		t.Errorf("batch sizes: want %v, have %v", want, have)
+	}
+}
+
+func TestBatchError(t *testing.T) {
+	errBatch := errors.New("batch failed")
+	e := batch.New[string](func(context.Context, interface{}) ([]batch.Result[string], error) {
+		return nil, errBatch
+	})
+	_, errs := callAll(context.Background(), e, 1, 2)
+	for i, err := range errs {
+		if err != errBatch {
+			t.Errorf("request %d: want %v, have %v", i, errBatch, err)
+		}
+	}
+}
+
+func TestBatchPanic(t *testing.T) {
+	panicking := func(context.Context, interface{}) ([]batch.Result[string], error) { panic("boom") }
+	for name, e := range map[string]func(context.Context, interface{}) (string, error){
+		"wait":     batch.New[string](panicking),
+		"max size": batch.New[string](panicking, batch.Wait(time.Hour), batch.MaxSize(2)),
+	} {
+		_, errs := callAll(context.Background(), e, 1, 2)
+		for i, err := range errs {
+			var perr *recovery.PanicError
+			if !errors.As(err, &perr) {
+				t.Errorf("%s, request %d: want a *recovery.PanicError, have %v", name, i, err)
+			}
+		}
+	}
+}
+
+func TestResultCount(t *testing.T) {
+	e := batch.New[string](func(context.Context, interface{}) ([]batch.Result[string], error) {
+		return []batch.Result[string]{}, nil
+	})
+	if _, err := e(context.Background(), 1); err != batch.ErrResultCount {
+		t.Errorf("want %v, have %v", batch.ErrResultCount, err)
+	}
+}
+
+func TestCallerContext(t *testing.T) {
+	r := &recorder{}
+	e := batch.New[string](r.endpoint, batch.Wait(50*time.Millisecond))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error, 1)
+	go func() {
+		_, err := e(ctx, 1)
+		errc <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	if want, have := context.Canceled, <-errc; want != have {
+		t.Errorf("canceled caller: want %v, have %v", want, have)
+	}
+
+	response, err := e(context.Background(), 2)
+	if err != nil || response != "item 2" {
+		t.Fatalf("want item 2, have %q (%v)", response, err)
+	}
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	if want, have := [][]interface{}{{2}}, r.batches; !reflect.DeepEqual(want, have) {
+		t.Errorf("batches: want %v, have %v", want, have)
+	}
+}
+
+func TestBatchContextCanceled(t *testing.T) {
+	started := make(chan struct{})
+	canceled := make(chan struct{})
+	e := batch.New[string](func(ctx context.Context, _ interface{}) ([]batch.Result[string], error) {
+		close(started)
+		<-ctx.Done()
+		close(canceled)
+		return nil, ctx.Err()
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go e(ctx, 1)
+	<-started
+	cancel()
+	select {
+	case <-canceled:
+	case <-time.After(time.Second):
+		t.Fatal("batch context not canceled after all callers left")
+	}
+}
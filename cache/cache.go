@@ -0,0 +1,237 @@
+// Package cache provides an endpoint middleware that caches responses.
+//
+// The middleware is transport-agnostic: wrap a server-side endpoint to avoid
+// recomputing read-heavy responses, or a client-side endpoint to avoid
+// repeating calls to a remote service.
+package cache
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/tnnyio/log"
+	"github.com/tnnyio/yoroi/endpoint"
+	"github.com/tnnyio/yoroi/metrics"
+	"github.com/tnnyio/yoroi/metrics/discard"
+	"github.com/tnnyio/yoroi/recovery"
+	"github.com/tnnyio/yoroi/transport"
+)
+
+// DefaultTTL is the time a successful response stays fresh when no TTL
+// option is given.
+const DefaultTTL = time.Minute
+
+// KeyFunc derives the cache key for a request. Returning an empty key
+// bypasses the cache for that request.
+type KeyFunc func(ctx context.Context, request interface{}) string
+
+// Option sets an optional parameter for the cache middleware.
+type Option[O interface{}] func(*options[O])
+
+// TTL sets the time a successful response stays fresh. By default,
+// DefaultTTL is used.
+func TTL[O interface{}](d time.Duration) Option[O] {
+	return func(o *options[O]) { o.ttl = func(O) time.Duration { return d } }
+}
+
+// TTLFunc sets a function that decides the freshness lifetime of each
+// successful response. A non-positive duration prevents the response from
+// being cached.
+func TTLFunc[O interface{}](f func(response O) time.Duration) Option[O] {
+	return func(o *options[O]) { o.ttl = f }
+}
+
+// NegativeCache caches errors for which match returns true, for d. Errors are
+// not cached by default.
+func NegativeCache[O interface{}](d time.Duration, match func(error) bool) Option[O] {
+	return func(o *options[O]) {
+		o.negativeTTL = d
+		o.negativeMatch = match
+	}
+}
+
+// StaleWhileRevalidate allows an expired response to be served for up to d
+// after it expired, while the entry is refreshed in the background. At most
+// one refresh per key is in flight at a time.
+func StaleWhileRevalidate[O interface{}](d time.Duration) Option[O] {
+	return func(o *options[O]) { o.staleWhileRevalidate = d }
+}
+
+// StaleIfError allows an expired response to be served for up to d after it
+// expired, if refreshing it fails.
+func StaleIfError[O interface{}](d time.Duration) Option[O] {
+	return func(o *options[O]) { o.staleIfError = d }
+}
+
+// Hits sets the counter incremented whenever a request is answered from the
+// cache, including stale answers.
+func Hits[O interface{}](c metrics.Counter) Option[O] {
+	return func(o *options[O]) { o.hits = c }
+}
+
+// Misses sets the counter incremented whenever a request is passed on to the
+// next endpoint.
+func Misses[O interface{}](c metrics.Counter) Option[O] {
+	return func(o *options[O]) { o.misses = c }
+}
+
+// ErrorHandler is used to handle store errors and errors from background
+// revalidation. By default, they are ignored.
+func ErrorHandler[O interface{}](h transport.ErrorHandler) Option[O] {
+	return func(o *options[O]) { o.errorHandler = h }
+}
+
+type options[O interface{}] struct {
+	ttl                  func(O) time.Duration
+	negativeTTL          time.Duration
+	negativeMatch        func(error) bool
+	staleWhileRevalidate time.Duration
+	staleIfError         time.Duration
+	hits                 metrics.Counter
+	misses               metrics.Counter
+	errorHandler         transport.ErrorHandler
+	timeNow              func() time.Time
+}
+
+// New returns an endpoint.Middleware that serves responses from store, keyed
+// by key, and fills the store from the next endpoint on a miss.
+func New[O interface{}](key KeyFunc, store Store[O], opts ...Option[O]) endpoint.Middleware[O] {
+	o := options[O]{
+		ttl:          func(O) time.Duration { return DefaultTTL },
+		hits:         discard.NewCounter(),
+		misses:       discard.NewCounter(),
+		errorHandler: transport.NewLogErrorHandler(log.NewNopLogger()),
+		timeNow:      time.Now,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return func(next endpoint.Endpoint[O]) endpoint.Endpoint[O] {
+		c := &cache[O]{
+			next:       next,
+			store:      store,
+			opts:       o,
+			refreshing: map[string]struct{}{},
+		}
+		return func(ctx context.Context, request interface{}) (O, error) {
+			k := key(ctx, request)
+			if k == "" {
+				return next(ctx, request)
+			}
+			return c.serve(ctx, k, request)
+		}
+	}
+}
+
+type cache[O interface{}] struct {
+	next  endpoint.Endpoint[O]
+	store Store[O]
+	opts  options[O]
+
+	mtx        sync.Mutex
+	refreshing map[string]struct{}
+}
+
+func (c *cache[O]) serve(ctx context.Context, k string, request interface{}) (O, error) {
+	e, ok, err := c.store.Get(ctx, k)
+	if err != nil {
+		c.opts.errorHandler.Handle(ctx, err)
+		ok = false
+	}
+	now := c.opts.timeNow()
+
+	if ok && now.Before(e.Expires) {
+		c.opts.hits.Add(1)
+		return e.Response, e.Err
+	}
+
+	if ok && e.Err == nil && now.Before(e.Expires.Add(c.opts.staleWhileRevalidate)) {
+		c.opts.hits.Add(1)
+		c.revalidate(context.WithoutCancel(ctx), k, request, e)
+		return e.Response, nil
+	}
+
+	c.opts.misses.Add(1)
+	var stale *Entry[O]
+	if ok && e.Err == nil {
+		stale = &e
+	}
+	response, err := c.fetch(ctx, k, request, stale)
+	if err != nil && ok && e.Err == nil && now.Before(e.Expires.Add(c.opts.staleIfError)) {
+		c.opts.errorHandler.Handle(ctx, err)
+		return e.Response, nil
+	}
+	return response, err
+}
+
+// fetch calls the next endpoint and stores its result, if cacheable. An
+// error doesn't replace stale, the response it refreshes, while StaleIfError
+// allows serving it.
+func (c *cache[O]) fetch(ctx context.Context, k string, request interface{}, stale *Entry[O]) (O, error) {
+	response, err := c.next(ctx, request)
+
+	now := c.opts.timeNow()
+	var ttl time.Duration
+	switch {
+	case err == nil:
+		ttl = c.opts.ttl(response)
+	case stale != nil && now.Before(stale.Expires.Add(c.opts.staleIfError)):
+		// keep serving the stale response
+	case c.opts.negativeMatch != nil && c.opts.negativeMatch(err):
+		ttl = c.opts.negativeTTL
+	}
+	if ttl <= 0 {
+		return response, err
+	}
+
+	e := Entry[O]{
+		Response: response,
+		Err:      err,
+		Created:  now,
+		Expires:  now.Add(ttl),
+	}
+	e.StaleUntil = e.Expires
+	if err == nil {
+		e.StaleUntil = e.Expires.Add(maxDuration(c.opts.staleWhileRevalidate, c.opts.staleIfError))
+	}
+	if serr := c.store.Set(ctx, k, e); serr != nil {
+		c.opts.errorHandler.Handle(ctx, serr)
+	}
+	return response, err
+}
+
+// revalidate refreshes the stale entry for k in the background, unless a
+// refresh for k is already in flight.
+func (c *cache[O]) revalidate(ctx context.Context, k string, request interface{}, stale Entry[O]) {
+	c.mtx.Lock()
+	if _, ok := c.refreshing[k]; ok {
+		c.mtx.Unlock()
+		return
+	}
+	c.refreshing[k] = struct{}{}
+	c.mtx.Unlock()
+
+	go func() {
+		defer func() {
+			c.mtx.Lock()
+			delete(c.refreshing, k)
+			c.mtx.Unlock()
+		}()
+		defer func() {
+			if r := recover(); r != nil {
+				c.opts.errorHandler.Handle(ctx, recovery.NewPanicError(r))
+			}
+		}()
+		if _, err := c.fetch(ctx, k, request, &stale); err != nil {
+			c.opts.errorHandler.Handle(ctx, err)
+		}
+	}()
+}
+
+func maxDuration(a, b time.Duration) time.Duration {
+	if a > b {
+		return a
+	}
+	return b
+}
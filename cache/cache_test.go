@@ -0,0 +1,309 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/tnnyio/yoroi/metrics/generic"
+	"github.com/tnnyio/yoroi/recovery"
+	"github.com/tnnyio/yoroi/transport"
+)
+
+var errNotFound = errors.New("not found")
+
+func withClock[O interface{}](now func() time.Time) Option[O] {
+	return func(o *options[O]) { o.timeNow = now }
+}
+
+func requestKey(_ context.Context, request interface{}) string {
+	s, _ := request.(string)
+	return s
+}
+
+type clock struct{ now time.Time }
+
+func (c *clock) Now() time.Time          { return c.now }
+func (c *clock) Advance(d time.Duration) { c.now = c.now.Add(d) }
+
+// counting returns an endpoint that returns the number of times it has been
+// called, or err if it is set.
+func counting(calls *int64, err *error) func(context.Context, interface{}) (int64, error) {
+	return func(context.Context, interface{}) (int64, error) {
+		n := atomic.AddInt64(calls, 1)
+		if err != nil && *err != nil {
+			return 0, *err
+		}
+		return n, nil
+	}
+}
+
+func TestCacheHitAndExpiry(t *testing.T) {
+	var (
+		calls  int64
+		clk    = &clock{now: time.Now()}
+		hits   = generic.NewCounter("hits")
+		misses = generic.NewCounter("misses")
+		e      = New[int64](requestKey, NewLRU[int64](10),
+			TTL[int64](time.Minute),
+			Hits[int64](hits),
+			Misses[int64](misses),
+			withClock[int64](clk.Now),
+		)(counting(&calls, nil))
+	)
+
+	for i := 0; i < 3; i++ {
+		if response, _ := e(context.Background(), "a"); response != 1 {
+			t.Fatalf("want cached response 1, have %d", response)
+		}
+	}
+	clk.Advance(2 * time.Minute)
+	if response, _ := e(context.Background(), "a"); response != 2 {
+		t.Fatalf("want fresh response 2 after expiry, have %d", response)
+	}
+	if want, have := 2.0, hits.Value(); want != have {
+		t.Errorf("hits: want %v, have %v", want, have)
+	}
+	if want, have := 2.0, misses.Value(); want != have {
+		t.Errorf("misses: want %v, have %v", want, have)
+	}
+}
+
+func TestCacheEmptyKeyBypasses(t *testing.T) {
+	var (
+		calls int64
+		e     = New[int64](func(context.Context, interface{}) string { return "" }, NewLRU[int64](10))(counting(&calls, nil))
+	)
+	e(context.Background(), "a")
+	e(context.Background(), "a")
+	if want, have := int64(2), calls; want != have {
+		t.Errorf("calls: want %d, have %d", want, have)
+	}
+}
+
+func TestCacheTTLFunc(t *testing.T) {
+	var (
+		calls int64
+		e     = New[int64](requestKey, NewLRU[int64](10),
+			TTLFunc[int64](func(response int64) time.Duration {
+				if response == 1 {
+					return 0 // don't cache the first response
+				}
+				return time.Minute
+			}),
+		)(counting(&calls, nil))
+	)
+	e(context.Background(), "a")
+	e(context.Background(), "a")
+	e(context.Background(), "a")
+	if want, have := int64(2), calls; want != have {
+		t.Errorf("calls: want %d, have %d", want, have)
+	}
+}
+
+func TestCacheNegative(t *testing.T) {
+	var (
+		calls int64
+		fail  = errNotFound
+		e     = New[int64](requestKey, NewLRU[int64](10),
+			NegativeCache[int64](time.Minute, func(err error) bool { return errors.Is(err, errNotFound) }),
+		)(counting(&calls, &fail))
+	)
+	for i := 0; i < 3; i++ {
+		if _, err := e(context.Background(), "a"); !errors.Is(err, errNotFound) {
+			t.Fatalf("want %v, have %v", errNotFound, err)
+		}
+	}
+	if want, have := int64(1), calls; want != have {
+		t.Errorf("calls: want %d, have %d", want, have)
+	}
+
+	// Errors that don't match are not cached.
+	fail = errors.New("other")
+	e(context.Background(), "b")
+	e(context.Background(), "b")
+	if want, have := int64(3), calls; want != have {
+		t.Errorf("calls: want %d, have %d", want, have)
+	}
+}
+
+func TestCacheStaleWhileRevalidate(t *testing.T) {
+	var (
+		calls   int64
+		clk     = &clock{now: time.Now()}
+		release = make(chan struct{})
+		done    = make(chan struct{}, 1)
+		e       = New[int64](requestKey, NewLRU[int64](10),
+			TTL[int64](time.Minute),
+			StaleWhileRevalidate[int64](time.Minute),
+			withClock[int64](clk.Now),
+		)(func(context.Context, interface{}) (int64, error) {
+			n := atomic.AddInt64(&calls, 1)
+			if n > 1 {
+				<-release
+				defer func() { done <- struct{}{} }()
+			}
+			return n, nil
+		})
+	)
+
+	e(context.Background(), "a")
+	clk.Advance(90 * time.Second)
+
+	// Stale responses are served while a single refresh runs.
+	for i := 0; i < 3; i++ {
+		if response, _ := e(context.Background(), "a"); response != 1 {
+			t.Fatalf("want stale response 1, have %d", response)
+		}
+	}
+	close(release)
+	<-done
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		if response, _ := e(context.Background(), "a"); response == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("refreshed response was never served")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if want, have := int64(2), atomic.LoadInt64(&calls); want != have {
+		t.Errorf("calls: want %d, have %d", want, have)
+	}
+}
+
+func TestCacheStaleIfError(t *testing.T) {
+	var (
+		calls int64
+		fail  error
+		clk   = &clock{now: time.Now()}
+		e     = New[int64](requestKey, NewLRU[int64](10),
+			TTL[int64](time.Minute),
+			StaleIfError[int64](time.Minute),
+			withClock[int64](clk.Now),
+		)(counting(&calls, &fail))
+	)
+
+	e(context.Background(), "a")
+	fail = errors.New("backend down")
+	clk.Advance(90 * time.Second)
+	if response, err := e(context.Background(), "a"); err != nil || response != 1 {
+		t.Fatalf("want stale response 1, have %d (%v)", response, err)
+	}
+
+	clk.Advance(time.Minute) // past the stale-if-error window
+	if _, err := e(context.Background(), "a"); err != fail {
+		t.Fatalf("want %v, have %v", fail, err)
+	}
+}
+
+func TestCacheStaleIfErrorNegative(t *testing.T) {
+	var (
+		calls int64
+		fail  error
+		clk   = &clock{now: time.Now()}
+		e     = New[int64](requestKey, NewLRU[int64](10),
+			TTL[int64](time.Minute),
+			StaleIfError[int64](time.Minute),
+			NegativeCache[int64](time.Hour, func(err error) bool { return errors.Is(err, errNotFound) }),
+			withClock[int64](clk.Now),
+		)(counting(&calls, &fail))
+	)
+
+	e(context.Background(), "a")
+	fail = errNotFound
+	clk.Advance(90 * time.Second)
+
+	// A failed refresh doesn't replace the stale response with the error.
+	for i := 0; i < 2; i++ {
+		if response, err := e(context.Background(), "a"); err != nil || response != 1 {
+			t.Fatalf("want stale response 1, have %d (%v)", response, err)
+		}
+	}
+}
+
+func TestCacheRevalidatePanic(t *testing.T) {
+	var (
+		calls int64
+		clk   = &clock{now: time.Now()}
+		errc  = make(chan error, 1)
+		e     = New[int64](requestKey, NewLRU[int64](10),
+			TTL[int64](time.Minute),
+			StaleWhileRevalidate[int64](time.Minute),
+			ErrorHandler[int64](transport.ErrorHandlerFunc(func(_ context.Context, err error) { errc <- err })),
+			withClock[int64](clk.Now),
+		)(func(context.Context, interface{}) (int64, error) {
+			if atomic.AddInt64(&calls, 1) > 1 {
+				panic("boom")
+			}
+			return 1, nil
+		})
+	)
+
+	e(context.Background(), "a")
+	clk.Advance(90 * time.Second)
+	if response, _ := e(context.Background(), "a"); response != 1 {
+		t.Fatalf("want stale response 1, have %d", response)
+	}
+	select {
+	case err := <-errc:
+		var perr *recovery.PanicError
+		if !errors.As(err, &perr) {
+			t.Errorf("want a PanicError, have %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("panic not handled")
+	}
+}
+
+func TestLRUEviction(t *testing.T) {
+	var (
+		ctx   = context.Background()
+		store = NewLRU[int](2)
+		entry = func(v int) Entry[int] {
+			return Entry[int]{Response: v, StaleUntil: time.Now().Add(time.Hour)}
+		}
+	)
+	store.Set(ctx, "a", entry(1))
+	store.Set(ctx, "b", entry(2))
+	store.Get(ctx, "a") // a is now the most recently used
+	store.Set(ctx, "c", entry(3))
+
+	if _, ok, _ := store.Get(ctx, "b"); ok {
+		t.Error("want b evicted")
+	}
+	for _, k := range []string{"a", "c"} {
+		if _, ok, _ := store.Get(ctx, k); !ok {
+			t.Errorf("want %s present", k)
+		}
+	}
+	if want, have := 2, store.Len(); want != have {
+		t.Errorf("len: want %d, have %d", want, have)
+	}
+
+	store.Delete(ctx, "a")
+	if _, ok, _ := store.Get(ctx, "a"); ok {
+		t.Error("want a deleted")
+	}
+}
+
+func TestLRUDropsStaleEntries(t *testing.T) {
+	var (
+		ctx   = context.Background()
+		clk   = &clock{now: time.Now()}
+		store = NewLRU[int](0)
+	)
+	store.timeNow = clk.Now
+	store.Set(ctx, "a", Entry[int]{Response: 1, StaleUntil: clk.now.Add(time.Minute)})
+	clk.Advance(2 * time.Minute)
+	if _, ok, _ := store.Get(ctx, "a"); ok {
+		t.Error("want entry past StaleUntil to be dropped")
+	}
+	if want, have := 0, store.Len(); want != have {
+		t.Errorf("len: want %d, have %d", want, have)
+	}
+}
@@ -0,0 +1,123 @@
+package cache
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+// Entry is a cached endpoint result. Exactly one of Response and Err is
+// meaningful: entries with a non-nil Err are negative cache entries.
+type Entry[O interface{}] struct {
+	Response O
+	Err      error
+
+	// Created is the time the entry was stored.
+	Created time.Time
+
+	// Expires is the time until which the entry is fresh.
+	Expires time.Time
+
+	// StaleUntil is the time until which the entry may still be served
+	// stale. Stores may evict the entry once it has passed.
+	StaleUntil time.Time
+}
+
+// Store is the storage backend of the cache middleware. Implementations must
+// be safe for concurrent use.
+type Store[O interface{}] interface {
+	// Get returns the entry for key, and whether it was found.
+	Get(ctx context.Context, key string) (Entry[O], bool, error)
+
+	// Set stores the entry for key, replacing any previous entry.
+	Set(ctx context.Context, key string, e Entry[O]) error
+
+	// Delete removes the entry for key, if any.
+	Delete(ctx context.Context, key string) error
+}
+
+// LRU is an in-memory Store that holds up to a fixed number of entries,
+// evicting the least recently used entry when full. Entries past their
+// StaleUntil time are dropped on access.
+type LRU[O interface{}] struct {
+	mtx      sync.Mutex
+	capacity int
+	ll       *list.List
+	items    map[string]*list.Element
+	timeNow  func() time.Time
+}
+
+type lruItem[O interface{}] struct {
+	key   string
+	entry Entry[O]
+}
+
+// NewLRU returns an LRU store holding up to capacity entries. A capacity of
+// zero or less means the store is unbounded.
+func NewLRU[O interface{}](capacity int) *LRU[O] {
+	return &LRU[O]{
+		capacity: capacity,
+		ll:       list.New(),
+		items:    map[string]*list.Element{},
+		timeNow:  time.Now,
+	}
+}
+
+// Get implements Store.
+func (c *LRU[O]) Get(_ context.Context, key string) (e Entry[O], ok bool, err error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	el, ok := c.items[key]
+	if !ok {
+		return e, false, nil
+	}
+	item := el.Value.(*lruItem[O])
+	if c.timeNow().After(item.entry.StaleUntil) {
+		c.remove(el)
+		return e, false, nil
+	}
+	c.ll.MoveToFront(el)
+	return item.entry, true, nil
+}
+
+// Set implements Store.
+func (c *LRU[O]) Set(_ context.Context, key string, e Entry[O]) error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if el, ok := c.items[key]; ok {
+		el.Value.(*lruItem[O]).entry = e
+		c.ll.MoveToFront(el)
+		return nil
+	}
+	c.items[key] = c.ll.PushFront(&lruItem[O]{key: key, entry: e})
+	if c.capacity > 0 && c.ll.Len() > c.capacity {
+		c.remove(c.ll.Back())
+	}
+	return nil
+}
+
+// Delete implements Store.
+func (c *LRU[O]) Delete(_ context.Context, key string) error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if el, ok := c.items[key]; ok {
+		c.remove(el)
+	}
+	return nil
+}
+
+// Len returns the number of entries currently held by the store.
+func (c *LRU[O]) Len() int {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.ll.Len()
+}
+
+func (c *LRU[O]) remove(el *list.Element) {
+	c.ll.Remove(el)
+	delete(c.items, el.Value.(*lruItem[O]).key)
+}
@@ -0,0 +1,172 @@
+package circuitbreaker
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/afex/hystrix-go/hystrix"
+	"github.com/sony/gobreaker"
+	"github.com/streadway/handy/breaker"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/tnnyio/yoroi/endpoint"
+	"github.com/tnnyio/yoroi/metrics"
+	"github.com/tnnyio/yoroi/metrics/discard"
+	"github.com/tnnyio/yoroi/sd/lb"
+)
+
+// DegradedHeader is the header set by DegradedHTTPResponseFunc and
+// DegradedGRPCResponseFunc when a fallback response was served.
+const DegradedHeader = "X-Degraded"
+
+// Classifier decides whether an error returned by the primary endpoint
+// should be answered with a fallback response.
+type Classifier func(err error) bool
+
+// DefaultClassifier falls back on open circuits of any of the breakers in
+// this package, on timeouts, and when no endpoints are available.
+func DefaultClassifier(err error) bool {
+	return IsCircuitOpen(err) || IsTimeout(err) || errors.Is(retryFinal(err), lb.ErrNoEndpoints)
+}
+
+// IsCircuitOpen reports whether err was returned by the Gobreaker, Hystrix
+// or HandyBreaker middleware because the circuit is open, or because the
+// breaker is rejecting requests to limit concurrency.
+func IsCircuitOpen(err error) bool {
+	err = retryFinal(err)
+	return errors.Is(err, gobreaker.ErrOpenState) ||
+		errors.Is(err, gobreaker.ErrTooManyRequests) ||
+		errors.Is(err, hystrix.ErrCircuitOpen) ||
+		errors.Is(err, hystrix.ErrMaxConcurrency) ||
+		errors.Is(err, breaker.ErrCircuitOpen)
+}
+
+// IsTimeout reports whether err is a context deadline, a Hystrix timeout,
+// or an error with a Timeout method that returns true.
+func IsTimeout(err error) bool {
+	err = retryFinal(err)
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, hystrix.ErrTimeout) {
+		return true
+	}
+	var t interface{ Timeout() bool }
+	return errors.As(err, &t) && t.Timeout()
+}
+
+// retryFinal unwraps the terminating error of an lb.RetryError.
+func retryFinal(err error) error {
+	var re lb.RetryError
+	if errors.As(err, &re) && re.Final != nil {
+		return re.Final
+	}
+	return err
+}
+
+// FallbackOption sets an optional parameter for the Fallback middleware.
+type FallbackOption func(*fallbackOptions)
+
+// FallbackClassifier sets the classifier that decides which errors trigger
+// the fallback. By default, DefaultClassifier is used.
+func FallbackClassifier(c Classifier) FallbackOption {
+	return func(o *fallbackOptions) { o.classifier = c }
+}
+
+// FallbackCounter sets a counter that is incremented whenever a fallback
+// response is served.
+func FallbackCounter(c metrics.Counter) FallbackOption {
+	return func(o *fallbackOptions) { o.counter = c }
+}
+
+type fallbackOptions struct {
+	classifier Classifier
+	counter    metrics.Counter
+}
+
+// Fallback returns an endpoint.Middleware that invokes the fallback endpoint
+// whenever the wrapped endpoint fails with an error matched by the
+// classifier. Place it outside of the circuit breaker, so that open circuits
+// can be answered with a degraded response instead of an error.
+//
+// When a fallback response is served, the request is marked as degraded in
+// the context, if it was prepared with DegradedContext, so that transports
+// can signal the degradation to the client.
+func Fallback[O interface{}](fallback endpoint.Endpoint[O], options ...FallbackOption) endpoint.Middleware[O] {
+	o := fallbackOptions{
+		classifier: DefaultClassifier,
+		counter:    discard.NewCounter(),
+	}
+	for _, option := range options {
+		option(&o)
+	}
+	return func(next endpoint.Endpoint[O]) endpoint.Endpoint[O] {
+		return func(ctx context.Context, request interface{}) (O, error) {
+			response, err := next(ctx, request)
+			if err == nil || !o.classifier(err) {
+				return response, err
+			}
+			response, err = fallback(ctx, request)
+			if err != nil {
+				return response, err
+			}
+			if d, ok := ctx.Value(degradedKey).(*atomic.Bool); ok {
+				d.Store(true)
+			}
+			o.counter.Add(1)
+			return response, nil
+		}
+	}
+}
+
+type degradedKeyType struct{}
+
+var degradedKey degradedKeyType
+
+// DegradedContext returns a context in which the Fallback middleware records
+// whether a fallback response was served. It is typically installed by a
+// transport's before function, and read back by an after function.
+func DegradedContext(ctx context.Context) context.Context {
+	return context.WithValue(ctx, degradedKey, new(atomic.Bool))
+}
+
+// IsDegraded reports whether a fallback response was served in ctx, which
+// must have been prepared with DegradedContext.
+func IsDegraded(ctx context.Context) bool {
+	d, ok := ctx.Value(degradedKey).(*atomic.Bool)
+	return ok && d.Load()
+}
+
+// DegradedHTTPRequestFunc prepares the context with DegradedContext. It can be
+// used as an HTTP transport RequestFunc, e.g. with ServerBefore.
+func DegradedHTTPRequestFunc(ctx context.Context, _ *http.Request) context.Context {
+	return DegradedContext(ctx)
+}
+
+// DegradedHTTPResponseFunc sets the DegradedHeader if a fallback response was
+// served. It can be used as an HTTP transport ServerResponseFunc, e.g. with
+// ServerAfter.
+func DegradedHTTPResponseFunc(ctx context.Context, w http.ResponseWriter) context.Context {
+	if IsDegraded(ctx) {
+		w.Header().Set(DegradedHeader, "true")
+	}
+	return ctx
+}
+
+// DegradedGRPCRequestFunc prepares the context with DegradedContext. It can be
+// used as a gRPC transport ServerRequestFunc, e.g. with ServerBefore.
+func DegradedGRPCRequestFunc(ctx context.Context, _ metadata.MD) context.Context {
+	return DegradedContext(ctx)
+}
+
+// DegradedGRPCResponseFunc sets the DegradedHeader in the response header
+// metadata if a fallback response was served. It can be used as a gRPC
+// transport ServerResponseFunc, e.g. with ServerAfter.
+func DegradedGRPCResponseFunc(ctx context.Context, header *metadata.MD, _ *metadata.MD) context.Context {
+	if IsDegraded(ctx) {
+		if *header == nil {
+			*header = metadata.MD{}
+		}
+		header.Set(DegradedHeader, "true")
+	}
+	return ctx
+}
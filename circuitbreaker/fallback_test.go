@@ -0,0 +1,152 @@
+package circuitbreaker_test
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/afex/hystrix-go/hystrix"
+	"github.com/sony/gobreaker"
+	handybreaker "github.com/streadway/handy/breaker"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/tnnyio/yoroi/circuitbreaker"
+	"github.com/tnnyio/yoroi/endpoint"
+	"github.com/tnnyio/yoroi/metrics/generic"
+	"github.com/tnnyio/yoroi/sd/lb"
+)
+
+func TestDefaultClassifier(t *testing.T) {
+	for _, testcase := range []struct {
+		err  error
+		want bool
+	}{
+		{gobreaker.ErrOpenState, true},
+		{gobreaker.ErrTooManyRequests, true},
+		{hystrix.ErrCircuitOpen, true},
+		{hystrix.ErrTimeout, true},
+		{handybreaker.ErrCircuitOpen, true},
+		{context.DeadlineExceeded, true},
+		{lb.ErrNoEndpoints, true},
+		{lb.RetryError{RawErrors: []error{lb.ErrNoEndpoints}, Final: lb.ErrNoEndpoints}, true},
+		{errors.New("business error"), false},
+		{context.Canceled, false},
+	} {
+		if want, have := testcase.want, circuitbreaker.DefaultClassifier(testcase.err); want != have {
+			t.Errorf("%v: want %v, have %v", testcase.err, want, have)
+		}
+	}
+}
+
+func TestFallback(t *testing.T) {
+	var (
+		primaryErr error
+		counter    = generic.NewCounter("fallbacks")
+		primary    = func(context.Context, interface{}) (string, error) { return "primary", primaryErr }
+		fallback   = func(context.Context, interface{}) (string, error) { return "fallback", nil }
+		e          = circuitbreaker.Fallback[string](fallback, circuitbreaker.FallbackCounter(counter))(primary)
+	)
+
+	ctx := circuitbreaker.DegradedContext(context.Background())
+	if response, err := e(ctx, struct{}{}); err != nil || response != "primary" {
+		t.Fatalf("want primary response, have %q (%v)", response, err)
+	}
+	if circuitbreaker.IsDegraded(ctx) {
+		t.Error("want not degraded")
+	}
+
+	primaryErr = errors.New("business error")
+	if _, err := e(ctx, struct{}{}); err != primaryErr {
+		t.Fatalf("want %v, have %v", primaryErr, err)
+	}
+
+	primaryErr = gobreaker.ErrOpenState
+	if response, err := e(ctx, struct{}{}); err != nil || response != "fallback" {
+		t.Fatalf("want fallback response, have %q (%v)", response, err)
+	}
+	if !circuitbreaker.IsDegraded(ctx) {
+		t.Error("want degraded")
+	}
+	if want, have := 1.0, counter.Value(); want != have {
+		t.Errorf("fallbacks: want %v, have %v", want, have)
+	}
+}
+
+func TestFallbackError(t *testing.T) {
+	var (
+		fallbackErr = errors.New("fallback failed")
+		primary     = func(context.Context, interface{}) (string, error) { return "", gobreaker.ErrOpenState }
+		fallback    = func(context.Context, interface{}) (string, error) { return "", fallbackErr }
+		e           = circuitbreaker.Fallback[string](fallback)(primary)
+		ctx         = circuitbreaker.DegradedContext(context.Background())
+	)
+	if _, err := e(ctx, struct{}{}); err != fallbackErr {
+		t.Errorf("want %v, have %v", fallbackErr, err)
+	}
+	if circuitbreaker.IsDegraded(ctx) {
+		t.Error("want not degraded")
+	}
+}
+
+func TestFallbackCustomClassifier(t *testing.T) {
+	var (
+		errBusy  = errors.New("busy")
+		primary  = func(context.Context, interface{}) (string, error) { return "", errBusy }
+		fallback = func(context.Context, interface{}) (string, error) { return "fallback", nil }
+		e        = circuitbreaker.Fallback[string](fallback, circuitbreaker.FallbackClassifier(func(err error) bool {
+			return errors.Is(err, errBusy)
+		}))(primary)
+	)
+	if response, _ := e(context.Background(), struct{}{}); response != "fallback" {
+		t.Errorf("want fallback response, have %q", response)
+	}
+}
+
+func TestFallbackWithOpenGobreaker(t *testing.T) {
+	var (
+		cb = gobreaker.NewCircuitBreaker(gobreaker.Settings{
+			ReadyToTrip: func(c gobreaker.Counts) bool { return c.ConsecutiveFailures >= 1 },
+			Timeout:     time.Hour,
+		})
+		primary  = func(context.Context, interface{}) (interface{}, error) { return nil, errors.New("tragedy") }
+		fallback = func(context.Context, interface{}) (interface{}, error) { return "cached", nil }
+		e        = endpoint.Chain[interface{}](
+			circuitbreaker.Fallback[interface{}](fallback),
+			circuitbreaker.Gobreaker[interface{}](cb),
+		)(primary)
+	)
+	if _, err := e(context.Background(), struct{}{}); err == nil {
+		t.Fatal("want the first failure to pass through")
+	}
+	if response, err := e(context.Background(), struct{}{}); err != nil || response != "cached" {
+		t.Errorf("want fallback once the circuit is open, have %v (%v)", response, err)
+	}
+}
+
+func TestDegradedResponseFuncs(t *testing.T) {
+	ctx := circuitbreaker.DegradedHTTPRequestFunc(context.Background(), nil)
+	rec := httptest.NewRecorder()
+	circuitbreaker.DegradedHTTPResponseFunc(ctx, rec)
+	if have := rec.Header().Get(circuitbreaker.DegradedHeader); have != "" {
+		t.Errorf("want no header, have %q", have)
+	}
+
+	fallback := func(context.Context, interface{}) (string, error) { return "fallback", nil }
+	open := func(context.Context, interface{}) (string, error) { return "", hystrix.ErrCircuitOpen }
+	circuitbreaker.Fallback[string](fallback)(open)(ctx, struct{}{})
+
+	circuitbreaker.DegradedHTTPResponseFunc(ctx, rec)
+	if want, have := "true", rec.Header().Get(circuitbreaker.DegradedHeader); want != have {
+		t.Errorf("HTTP: want %q, have %q", want, have)
+	}
+
+	var header, trailer metadata.MD
+	grpcCtx := circuitbreaker.DegradedGRPCRequestFunc(context.Background(), nil)
+	circuitbreaker.Fallback[string](fallback)(open)(grpcCtx, struct{}{})
+	circuitbreaker.DegradedGRPCResponseFunc(grpcCtx, &header, &trailer)
+	if want, have := []string{"true"}, header.Get(circuitbreaker.DegradedHeader); len(have) != 1 || want[0] != have[0] {
+		t.Errorf("gRPC: want %v, have %v", want, have)
+	}
+}
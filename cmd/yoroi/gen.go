@@ -0,0 +1,377 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"go/format"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+func runGen(args []string) error {
+	fs := flag.NewFlagSet("gen", flag.ExitOnError)
+	var (
+		typeName   = fs.String("type", "", "name of the service interface (required)")
+		pb         = fs.String("pb", "", "import path of the protoc-generated package, required for the grpc transport")
+		transports = fs.String("transports", "http,grpc,jsonrpc", "comma-separated transports to generate bindings for")
+		output     = fs.String("o", "", "output file (default <type>_yoroi.go next to the input file)")
+	)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "USAGE\n")
+		fmt.Fprintf(os.Stderr, "  yoroi gen [flags] <file.go>\n\n")
+		fmt.Fprintf(os.Stderr, "FLAGS\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 || *typeName == "" {
+		fs.Usage()
+		return errors.New("gen needs a -type and a single input file")
+	}
+
+	cfg := genConfig{PB: *pb}
+	for _, t := range strings.Split(*transports, ",") {
+		switch t = strings.TrimSpace(t); t {
+		case "http":
+			cfg.HTTP = true
+		case "grpc":
+			if cfg.PB == "" {
+				return errors.New("the grpc transport needs -pb, or leave it out of -transports")
+			}
+			cfg.GRPC = true
+		case "jsonrpc":
+			cfg.JSONRPC = true
+		case "":
+		default:
+			return fmt.Errorf("unknown transport %q", t)
+		}
+	}
+
+	input := fs.Arg(0)
+	s, err := parse(input, nil, *typeName)
+	if err != nil {
+		return err
+	}
+	src, err := generate(s, cfg)
+	if err != nil {
+		return err
+	}
+	if *output == "" {
+		*output = filepath.Join(filepath.Dir(input), strings.ToLower(*typeName)+"_yoroi.go")
+	}
+	return os.WriteFile(*output, src, 0o644)
+}
+
+// genConfig selects what is generated.
+type genConfig struct {
+	PB      string
+	HTTP    bool
+	GRPC    bool
+	JSONRPC bool
+}
+
+// generate renders and formats the code generated for s.
+func generate(s *service, cfg genConfig) ([]byte, error) {
+	specs := append([]importSpec{
+		{Path: "context"},
+		{Path: "fmt"},
+		{Path: "github.com/tnnyio/yoroi/endpoint"},
+	}, s.Imports...)
+	if cfg.HTTP || cfg.JSONRPC {
+		specs = append(specs, importSpec{Path: "net/url"}, importSpec{Path: "strings"})
+	}
+	if cfg.HTTP {
+		specs = append(specs,
+			importSpec{Path: "bytes"},
+			importSpec{Path: "encoding/json"},
+			importSpec{Path: "io"},
+			importSpec{Path: "net/http"},
+			importSpec{Name: "httptransport", Path: "github.com/tnnyio/yoroi/transport/http"},
+		)
+	}
+	if cfg.GRPC {
+		specs = append(specs,
+			importSpec{Path: "google.golang.org/grpc"},
+			importSpec{Name: "grpctransport", Path: "github.com/tnnyio/yoroi/transport/grpc"},
+			importSpec{Name: "pb", Path: cfg.PB},
+		)
+	}
+	if cfg.JSONRPC {
+		specs = append(specs,
+			importSpec{Path: "encoding/json"},
+			importSpec{Path: "github.com/tnnyio/yoroi/transport/http/jsonrpc"},
+		)
+	}
+	std, other := imports(specs)
+
+	var buf bytes.Buffer
+	err := genTemplate.Execute(&buf, struct {
+		*service
+		genConfig
+		Std, Other []string
+	}{s, cfg, std, other})
+	if err != nil {
+		return nil, err
+	}
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("formatting generated code: %w\n%s", err, buf.Bytes())
+	}
+	return src, nil
+}
+
+// genTemplate renders the generated file. Its unexported identifiers start
+// with yoroi and the service name, so that they clash neither with the code
+// of the package nor with the code generated for another service of it.
+var genTemplate = template.Must(template.New("gen").Funcs(template.FuncMap{
+	"kebab":      kebab,
+	"unexported": unexported,
+}).Parse(`// Code generated by yoroi gen. DO NOT EDIT.
+
+package {{.Package}}
+
+import (
+{{- range .Std}}
+	{{.}}
+{{- end}}
+{{if .Other}}
+{{- range .Other}}
+	{{.}}
+{{- end}}
+{{- end}}
+)
+
+{{range .Methods}}
+// {{.Name}}Request holds the parameters of {{$.Name}}.{{.Name}}.
+type {{.Name}}Request struct {
+{{- range .Params}}
+	{{.Field}} {{.Type}} ` + "`json:\"{{.JSON}}\"`" + `
+{{- end}}
+}
+
+// {{.Name}}Response holds the results of {{$.Name}}.{{.Name}}.
+type {{.Name}}Response struct {
+{{- if .ReturnsContext}}
+	Ctx context.Context ` + "`json:\"-\"`" + `
+{{- end}}
+{{- range .Results}}
+	{{.Field}} {{.Type}} ` + "`json:\"{{.JSON}}\"`" + `
+{{- end}}
+}
+
+// Make{{.Name}}Endpoint returns an endpoint calling svc.{{.Name}} with a
+// {{.Name}}Request.
+func Make{{.Name}}Endpoint(svc {{$.Name}}) endpoint.Endpoint[*{{.Name}}Response] {
+	return func(ctx context.Context, request interface{}) (*{{.Name}}Response, error) {
+		req, ok := request.({{.Name}}Request)
+		if !ok {
+			return nil, fmt.Errorf("{{$.Name}}.{{.Name}}: unexpected request type %T", request)
+		}
+		{{if .ReturnsContext}}rctx, {{end}}{{range .Results}}{{.Var}}, {{end}}err := svc.{{.Name}}(ctx{{range .Params}}, req.{{.Field}}{{.Spread}}{{end}})
+		if err != nil {
+			return nil, err
+		}
+		return &{{.Name}}Response{ {{- if .ReturnsContext}}Ctx: rctx, {{end}}{{range .Results}}{{.Field}}: {{.Var}}, {{end -}} }, nil
+	}
+}
+{{end}}
+
+// {{.Name}}Endpoints holds an endpoint per method of {{.Name}}. It implements
+// {{.Name}} itself, so that endpoints built from transport clients can be
+// used as the service.
+type {{.Name}}Endpoints struct {
+{{- range .Methods}}
+	{{.Name}}Endpoint endpoint.Endpoint[*{{.Name}}Response]
+{{- end}}
+}
+
+// {{.Name}}Middlewares holds the middlewares that Make{{.Name}}Endpoints
+// applies to each endpoint, outermost first.
+type {{.Name}}Middlewares struct {
+{{- range .Methods}}
+	{{.Name}} []endpoint.Middleware[*{{.Name}}Response]
+{{- end}}
+}
+
+// Make{{.Name}}Endpoints returns the endpoints of svc, wrapped in their
+// middlewares.
+func Make{{.Name}}Endpoints(svc {{.Name}}, mw {{.Name}}Middlewares) {{.Name}}Endpoints {
+	return {{.Name}}Endpoints{
+{{- range .Methods}}
+		{{.Name}}Endpoint: yoroi{{$.Name}}Chain(Make{{.Name}}Endpoint(svc), mw.{{.Name}}),
+{{- end}}
+	}
+}
+
+func yoroi{{$.Name}}Chain[O interface{}](e endpoint.Endpoint[O], mw []endpoint.Middleware[O]) endpoint.Endpoint[O] {
+	for i := len(mw) - 1; i >= 0; i-- {
+		e = mw[i](e)
+	}
+	return e
+}
+{{range .Methods}}
+// {{.Name}} implements {{$.Name}}.
+func (e {{$.Name}}Endpoints) {{.Name}}(ctx context.Context{{range .Params}}, {{.Var}} {{.ParamType}}{{end}}) ({{if .ReturnsContext}}rctx context.Context, {{end}}{{range .Results}}{{.Var}} {{.Type}}, {{end}}err error) {
+	resp, err := e.{{.Name}}Endpoint(ctx, {{.Name}}Request{ {{- range .Params}}{{.Field}}: {{.Var}}, {{end -}} })
+	if err != nil {
+		return {{if .ReturnsContext}}ctx, {{end}}{{range .Results}}{{.Var}}, {{end}}err
+	}
+{{- if .ReturnsContext}}
+	rctx = ctx
+	if resp.Ctx != nil {
+		rctx = resp.Ctx
+	}
+{{- end}}
+	return {{if .ReturnsContext}}rctx, {{end}}{{range .Results}}resp.{{.Field}}, {{end}}nil
+}
+{{end}}
+{{- if or .HTTP .JSONRPC}}
+func yoroi{{$.Name}}ParseInstance(instance string) (*url.URL, error) {
+	if !strings.Contains(instance, "://") {
+		instance = "http://" + instance
+	}
+	return url.Parse(instance)
+}
+{{end}}
+{{- if .HTTP}}
+// New{{.Name}}HTTPHandler returns an http.Handler serving the endpoints,
+// with JSON requests and responses. Method M is served under /m, in kebab
+// case.
+func New{{.Name}}HTTPHandler(e {{.Name}}Endpoints) http.Handler {
+	mux := http.NewServeMux()
+{{- range .Methods}}
+	mux.Handle("/{{kebab .Name}}", httptransport.NewServer(e.{{.Name}}Endpoint, yoroi{{$.Name}}DecodeHTTPRequest[{{.Name}}Request], httptransport.EncodeJSONResponse[*{{.Name}}Response]))
+{{- end}}
+	return mux
+}
+
+// New{{.Name}}HTTPClient returns endpoints calling the HTTP server at
+// instance.
+func New{{.Name}}HTTPClient(instance string) ({{.Name}}Endpoints, error) {
+	u, err := yoroi{{$.Name}}ParseInstance(instance)
+	if err != nil {
+		return {{.Name}}Endpoints{}, err
+	}
+	return {{.Name}}Endpoints{
+{{- range .Methods}}
+		{{.Name}}Endpoint: httptransport.NewClient("POST", u.JoinPath("/{{kebab .Name}}"), yoroi{{$.Name}}EncodeHTTPRequest[{{.Name}}Request], yoroi{{$.Name}}DecodeHTTPResponse[{{.Name}}Response]).Endpoint(),
+{{- end}}
+	}, nil
+}
+
+func yoroi{{$.Name}}DecodeHTTPRequest[I interface{}](_ context.Context, r *http.Request) (request I, err error) {
+	err = json.NewDecoder(r.Body).Decode(&request)
+	return request, err
+}
+
+func yoroi{{$.Name}}EncodeHTTPRequest[I interface{}](ctx context.Context, r *http.Request, request I) error {
+	return httptransport.EncodeJSONRequest(ctx, r, request)
+}
+
+func yoroi{{$.Name}}DecodeHTTPResponse[O interface{}](_ context.Context, r *http.Response) (*O, error) {
+	if r.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(r.Body)
+		return nil, fmt.Errorf("%s: %s", r.Status, bytes.TrimSpace(body))
+	}
+	response := new(O)
+	return response, json.NewDecoder(r.Body).Decode(response)
+}
+{{end}}
+{{- if .GRPC}}
+type yoroi{{.Name}}GRPCServer struct {
+	pb.Unimplemented{{.Name}}Server
+{{- range .Methods}}
+	{{unexported .Name}}Handler grpctransport.Handler
+{{- end}}
+}
+
+// New{{.Name}}GRPCServer returns a pb.{{.Name}}Server serving the endpoints.
+func New{{.Name}}GRPCServer(e {{.Name}}Endpoints) pb.{{.Name}}Server {
+	return &yoroi{{.Name}}GRPCServer{
+{{- range .Methods}}
+		{{unexported .Name}}Handler: grpctransport.NewServer(e.{{.Name}}Endpoint, yoroi{{$.Name}}DecodeGRPC{{.Name}}Request, yoroi{{$.Name}}EncodeGRPC{{.Name}}Response),
+{{- end}}
+	}
+}
+{{range .Methods}}
+func (s *yoroi{{$.Name}}GRPCServer) {{.Name}}(ctx context.Context, req *pb.{{.Name}}Request) (*pb.{{.Name}}Response, error) {
+	_, resp, err := s.{{unexported .Name}}Handler.ServeGRPC(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*pb.{{.Name}}Response), nil
+}
+{{end}}
+// New{{.Name}}GRPCClient returns endpoints calling the gRPC server of cc.
+func New{{.Name}}GRPCClient(cc *grpc.ClientConn) {{.Name}}Endpoints {
+	service := pb.{{.Name}}_ServiceDesc.ServiceName
+	return {{.Name}}Endpoints{
+{{- range .Methods}}
+		{{.Name}}Endpoint: grpctransport.NewClient(cc, service, "{{.Name}}", yoroi{{$.Name}}EncodeGRPC{{.Name}}Request, yoroi{{$.Name}}DecodeGRPC{{.Name}}Response, &pb.{{.Name}}Response{}).Endpoint(),
+{{- end}}
+	}
+}
+{{range .Methods}}
+func yoroi{{$.Name}}DecodeGRPC{{.Name}}Request(_ context.Context, r interface{}) ({{.Name}}Request, error) {
+	req := r.(*pb.{{.Name}}Request)
+	return {{.Name}}Request{ {{- range .Params}}{{.Field}}: req.{{.Field}}, {{end -}} }, nil
+}
+
+func yoroi{{$.Name}}EncodeGRPC{{.Name}}Response(_ context.Context, r *{{.Name}}Response) (interface{}, error) {
+	return &pb.{{.Name}}Response{ {{- range .Results}}{{.Field}}: r.{{.Field}}, {{end -}} }, nil
+}
+
+func yoroi{{$.Name}}EncodeGRPC{{.Name}}Request(_ context.Context, r {{.Name}}Request) (interface{}, error) {
+	return &pb.{{.Name}}Request{ {{- range .Params}}{{.Field}}: r.{{.Field}}, {{end -}} }, nil
+}
+
+func yoroi{{$.Name}}DecodeGRPC{{.Name}}Response(_ context.Context, r interface{}) (*{{.Name}}Response, error) {
+	resp := r.(*pb.{{.Name}}Response)
+	return &{{.Name}}Response{ {{- range .Results}}{{.Field}}: resp.{{.Field}}, {{end -}} }, nil
+}
+{{end}}
+{{- end}}
+{{- if .JSONRPC}}
+// New{{.Name}}JSONRPCHandler returns a JSON RPC server serving the
+// endpoints. Method M is served as the JSON RPC method {{.Name}}.M.
+func New{{.Name}}JSONRPCHandler(e {{.Name}}Endpoints, options ...jsonrpc.ServerOption) *jsonrpc.Server {
+	return jsonrpc.NewServer(jsonrpc.EndpointCodecMap{
+{{- range .Methods}}
+		"{{$.Name}}.{{.Name}}": {
+			Endpoint: func(ctx context.Context, request interface{}) (interface{}, error) {
+				return e.{{.Name}}Endpoint(ctx, request)
+			},
+			Decode: yoroi{{$.Name}}DecodeJSONRPCRequest[{{.Name}}Request],
+			Encode: yoroi{{$.Name}}EncodeJSONRPCResponse,
+		},
+{{- end}}
+	}, options...)
+}
+
+// New{{.Name}}JSONRPCClient returns endpoints calling the JSON RPC server at
+// instance.
+func New{{.Name}}JSONRPCClient(instance string) ({{.Name}}Endpoints, error) {
+	u, err := yoroi{{$.Name}}ParseInstance(instance)
+	if err != nil {
+		return {{.Name}}Endpoints{}, err
+	}
+	return {{.Name}}Endpoints{
+{{- range .Methods}}
+		{{.Name}}Endpoint: jsonrpc.NewClient[{{.Name}}Request, *{{.Name}}Response](u, "{{$.Name}}.{{.Name}}").Endpoint(),
+{{- end}}
+	}, nil
+}
+
+func yoroi{{$.Name}}DecodeJSONRPCRequest[I interface{}](_ context.Context, params json.RawMessage) (interface{}, error) {
+	var request I
+	err := json.Unmarshal(params, &request)
+	return request, err
+}
+
+func yoroi{{$.Name}}EncodeJSONRPCResponse(_ context.Context, response interface{}) (json.RawMessage, error) {
+	return json.Marshal(response)
+}
+{{end}}`))
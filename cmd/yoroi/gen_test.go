@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "update the golden files")
+
+const goldenPB = "github.com/tnnyio/yoroi/transport/grpc/_grpc_test/pb"
+
+func TestGolden(t *testing.T) {
+	s, err := parse("testdata/test/service.go", nil, "Test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	have, err := generate(s, genConfig{PB: goldenPB, HTTP: true, GRPC: true, JSONRPC: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *update {
+		if err := os.WriteFile("testdata/test/test_yoroi.go", have, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile("testdata/test/test_yoroi.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(want, have) {
+		t.Errorf("generated code differs from testdata/test/test_yoroi.go, run go test -update")
+	}
+}
+
+// TestGeneratedCode runs the tests of the golden package, which call the
+// generated code through each transport.
+func TestGeneratedCode(t *testing.T) {
+	if testing.Short() {
+		t.Skip("runs go test")
+	}
+	out, err := exec.Command("go", "test", "./testdata/test").CombinedOutput()
+	if err != nil {
+		t.Fatalf("%v\n%s", err, out)
+	}
+}
+
+func TestParse(t *testing.T) {
+	const src = `package svc
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_model/go"
+	xcontext "golang.org/x/net/context"
+)
+
+type Svc interface {
+	Plain(context.Context, string, int) error
+	Named(ctx context.Context, e string, tags ...string) (ctx2 context.Context, id int, at time.Time, err error)
+	Metric(context.Context) (*io_prometheus_client.Metric, error)
+}
+
+var _ xcontext.Context
+`
+	s, err := parse("svc.go", src, "Svc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, have := "[{ time} { github.com/prometheus/client_model/go}]", fmt.Sprint(s.Imports); want != have {
+		t.Fatalf("imports: want %s, have %s", want, have)
+	}
+
+	plain := s.Methods[0]
+	if want, have := "P0 P1", fieldNames(plain.Params); want != have {
+		t.Errorf("plain params: want %s, have %s", want, have)
+	}
+	if len(plain.Results) != 0 || plain.ReturnsContext {
+		t.Errorf("plain results: have %v", plain.Results)
+	}
+
+	named := s.Methods[1]
+	if want, have := "E Tags", fieldNames(named.Params); want != have {
+		t.Errorf("named params: want %s, have %s", want, have)
+	}
+	if want, have := "pe", named.Params[0].Var; want != have {
+		t.Errorf("reserved variable: want %s, have %s", want, have)
+	}
+	if tags := named.Params[1]; !tags.Variadic || tags.Type != "[]string" || tags.ParamType() != "...string" {
+		t.Errorf("variadic: have %+v", tags)
+	}
+	if want, have := "Id At", fieldNames(named.Results); want != have || !named.ReturnsContext {
+		t.Errorf("named results: want %s, have %s (context %v)", want, have, named.ReturnsContext)
+	}
+
+	if _, err := generate(s, genConfig{HTTP: true, JSONRPC: true}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for src, want := range map[string]string{
+		`package svc; type Svc interface { M(string) error }`:                          "first parameter must be a context.Context",
+		`package svc; import "context"; type Svc interface { M(context.Context) int }`: "last result must be an error",
+		`package svc; type Other interface{}`:                                          "no interface named Svc",
+		`package svc; type Svc interface{}`:                                            "has no methods",
+	} {
+		if _, err := parse("svc.go", src, "Svc"); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: want error containing %q, have %v", src, want, err)
+		}
+	}
+}
+
+func TestNames(t *testing.T) {
+	for in, want := range map[string]string{"Test": "test", "TestB": "test-b", "GetURLPath": "get-url-path", "V2Sum": "v2-sum"} {
+		if have := kebab(in); want != have {
+			t.Errorf("kebab(%s): want %s, have %s", in, want, have)
+		}
+	}
+	for in, want := range map[string]string{"V": "v", "ID": "id", "URLPath": "urlPath", "P0": "p0"} {
+		if have := unexported(in); want != have {
+			t.Errorf("unexported(%s): want %s, have %s", in, want, have)
+		}
+	}
+}
+
+func TestPackageName(t *testing.T) {
+	for path, want := range map[string]string{
+		"net/http":                              "http",
+		"github.com/prometheus/client_model/go": "io_prometheus_client",
+		"example.com/missing/v2":                "missing",
+		"example.com/missing":                   "missing",
+	} {
+		if have := packageName(path, "."); want != have {
+			t.Errorf("%s: want %s, have %s", path, want, have)
+		}
+	}
+}
+
+func fieldNames(fs []field) string {
+	names := make([]string, len(fs))
+	for i, f := range fs {
+		names[i] = f.Field
+	}
+	return strings.Join(names, " ")
+}
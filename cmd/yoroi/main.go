@@ -0,0 +1,54 @@
+// Command yoroi is the toolkit's command line tool.
+//
+// The gen subcommand reads a Go service interface and generates its
+// endpoints, request and response types, and the HTTP, gRPC and JSON RPC
+// bindings of the service, servers and clients:
+//
+//	yoroi gen -type GreetService -pb example.com/greet/pb service.go
+//
+// Every method of the interface must take a context.Context as its first
+// parameter, and return an error as its last result. It may also return a
+// context.Context as its first result. The gRPC bindings use the types
+// generated by protoc in the package given with -pb: a method M maps to the
+// RPC M of the service with the same name as the interface, with messages
+// MRequest and MResponse whose fields have the names of the generated
+// request and response struct fields.
+//
+// The generated code is written next to the input file, to a file named
+// after the interface with a _yoroi.go suffix, unless -o is set. A package
+// should hold a single generated file.
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	var err error
+	switch os.Args[1] {
+	case "gen":
+		err = runGen(os.Args[2:])
+	case "help", "-h", "-help", "--help":
+		usage()
+		return
+	default:
+		fmt.Fprintf(os.Stderr, "yoroi: unknown command %q\n", os.Args[1])
+		usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "yoroi: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "USAGE\n")
+	fmt.Fprintf(os.Stderr, "  yoroi gen [flags] <file.go>\n\n")
+	fmt.Fprintf(os.Stderr, "Run 'yoroi gen -h' for the flags of gen.\n")
+}
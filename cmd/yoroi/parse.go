@@ -0,0 +1,288 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// service is the model of a service interface that the templates render.
+type service struct {
+	Package string
+	Name    string
+	Methods []method
+
+	// Imports are the imports of the source file used by the method
+	// signatures.
+	Imports []importSpec
+}
+
+type importSpec struct {
+	Name string // explicit name, if any
+	Path string
+}
+
+type method struct {
+	Name           string
+	Params         []field
+	Results        []field
+	ReturnsContext bool
+}
+
+// field is a parameter or a result of a method, and the corresponding field
+// of the request or response struct.
+type field struct {
+	Field    string // struct field name
+	JSON     string // JSON key
+	Var      string // variable name in generated signatures
+	Type     string // struct field type
+	Variadic bool
+}
+
+// ParamType returns the type of the field as a parameter.
+func (f field) ParamType() string {
+	if f.Variadic {
+		return "..." + strings.TrimPrefix(f.Type, "[]")
+	}
+	return f.Type
+}
+
+// Spread returns the suffix spreading the field in a call.
+func (f field) Spread() string {
+	if f.Variadic {
+		return "..."
+	}
+	return ""
+}
+
+// reserved are the names of the variables used by generated code.
+var reserved = map[string]bool{"ctx": true, "e": true, "resp": true, "err": true, "rctx": true}
+
+// parse reads the interface named typeName in the Go source file.
+func parse(filename string, src interface{}, typeName string) (*service, error) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, filename, src, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	var iface *ast.InterfaceType
+	ast.Inspect(f, func(n ast.Node) bool {
+		if ts, ok := n.(*ast.TypeSpec); ok && ts.Name.Name == typeName {
+			iface, _ = ts.Type.(*ast.InterfaceType)
+			return false
+		}
+		return iface == nil
+	})
+	if iface == nil {
+		return nil, fmt.Errorf("%s: no interface named %s", filename, typeName)
+	}
+
+	s := &service{Package: f.Name.Name, Name: typeName}
+	used := map[string]bool{}
+	for _, m := range iface.Methods.List {
+		ft, ok := m.Type.(*ast.FuncType)
+		if !ok || len(m.Names) == 0 {
+			return nil, fmt.Errorf("%s: embedded interfaces are not supported", typeName)
+		}
+		meth, err := parseMethod(m.Names[0].Name, ft)
+		if err != nil {
+			return nil, fmt.Errorf("%s.%s: %w", typeName, m.Names[0].Name, err)
+		}
+		s.Methods = append(s.Methods, meth)
+		collectPackages(ft, used)
+	}
+	if len(s.Methods) == 0 {
+		return nil, fmt.Errorf("%s has no methods", typeName)
+	}
+
+	for _, is := range f.Imports {
+		path, _ := strconv.Unquote(is.Path.Value)
+		spec := importSpec{Path: path}
+		var name string
+		if is.Name != nil {
+			name, spec.Name = is.Name.Name, is.Name.Name
+		} else {
+			name = packageName(path, filepath.Dir(filename))
+		}
+		if used[name] && path != "context" {
+			s.Imports = append(s.Imports, spec)
+		}
+	}
+	return s, nil
+}
+
+// packageName returns the name of the package imported with path by a file
+// of dir. If the package can't be found, the name is guessed from the path:
+// the last element, skipping a major version suffix.
+func packageName(path, dir string) string {
+	if p, err := build.Import(path, dir, 0); err == nil && p.Name != "" {
+		return p.Name
+	}
+	elems := strings.Split(path, "/")
+	name := elems[len(elems)-1]
+	if len(elems) > 1 && len(name) > 1 && name[0] == 'v' && strings.Trim(name[1:], "0123456789") == "" {
+		name = elems[len(elems)-2]
+	}
+	return name
+}
+
+func parseMethod(name string, ft *ast.FuncType) (method, error) {
+	m := method{Name: name}
+
+	params := expand(ft.Params)
+	if len(params) == 0 || !isContext(params[0].typ) {
+		return m, fmt.Errorf("first parameter must be a context.Context")
+	}
+	for i, p := range params[1:] {
+		f := newField(p, "P"+strconv.Itoa(i))
+		if _, ok := p.typ.(*ast.Ellipsis); ok {
+			f.Variadic = true
+		}
+		m.Params = append(m.Params, f)
+	}
+
+	results := expand(ft.Results)
+	if len(results) == 0 || types.ExprString(results[len(results)-1].typ) != "error" {
+		return m, fmt.Errorf("last result must be an error")
+	}
+	results = results[:len(results)-1]
+	if len(results) > 0 && isContext(results[0].typ) {
+		m.ReturnsContext = true
+		results = results[1:]
+	}
+	for i, r := range results {
+		fallback := "V"
+		if len(results) > 1 {
+			fallback = "V" + strconv.Itoa(i)
+		}
+		f := newField(r, fallback)
+		f.Var = "r" + strconv.Itoa(i)
+		m.Results = append(m.Results, f)
+	}
+	return m, nil
+}
+
+type param struct {
+	name string
+	typ  ast.Expr
+}
+
+// expand returns one param per name of the field list.
+func expand(fl *ast.FieldList) []param {
+	if fl == nil {
+		return nil
+	}
+	var params []param
+	for _, f := range fl.List {
+		if len(f.Names) == 0 {
+			params = append(params, param{typ: f.Type})
+			continue
+		}
+		for _, n := range f.Names {
+			params = append(params, param{name: n.Name, typ: f.Type})
+		}
+	}
+	return params
+}
+
+func newField(p param, fallback string) field {
+	f := field{Field: fallback, Var: strings.ToLower(fallback), Type: types.ExprString(p.typ)}
+	if e, ok := p.typ.(*ast.Ellipsis); ok {
+		f.Type = "[]" + types.ExprString(e.Elt)
+	}
+	if p.name != "" && p.name != "_" {
+		f.Field, f.Var = exported(p.name), p.name
+	}
+	if reserved[f.Var] {
+		f.Var = "p" + f.Var
+	}
+	f.JSON = unexported(f.Field)
+	return f
+}
+
+func isContext(e ast.Expr) bool {
+	return types.ExprString(e) == "context.Context"
+}
+
+// collectPackages adds the names of the packages referenced by ft to used.
+func collectPackages(ft *ast.FuncType, used map[string]bool) {
+	ast.Inspect(ft, func(n ast.Node) bool {
+		if sel, ok := n.(*ast.SelectorExpr); ok {
+			if id, ok := sel.X.(*ast.Ident); ok {
+				used[id.Name] = true
+			}
+			return false
+		}
+		return true
+	})
+}
+
+func exported(s string) string {
+	r := []rune(s)
+	r[0] = unicode.ToUpper(r[0])
+	return string(r)
+}
+
+func unexported(s string) string {
+	r := []rune(s)
+	for i := 0; i < len(r) && unicode.IsUpper(r[i]); i++ {
+		// Lower a leading initialism, except for the first letter of the
+		// next word: URLPath becomes urlPath.
+		if i > 0 && i+1 < len(r) && unicode.IsLower(r[i+1]) {
+			break
+		}
+		r[i] = unicode.ToLower(r[i])
+	}
+	return string(r)
+}
+
+// kebab returns the kebab case form of a Go identifier: TestB becomes
+// test-b.
+func kebab(s string) string {
+	var b strings.Builder
+	r := []rune(s)
+	for i, c := range r {
+		if unicode.IsUpper(c) {
+			if i > 0 && (unicode.IsLower(r[i-1]) || unicode.IsDigit(r[i-1]) ||
+				(i+1 < len(r) && unicode.IsLower(r[i+1]) && unicode.IsUpper(r[i-1]))) {
+				b.WriteByte('-')
+			}
+			c = unicode.ToLower(c)
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// imports returns the sorted import lines of the generated file: standard
+// library packages first, then the others.
+func imports(specs []importSpec) (std, other []string) {
+	seen := map[string]bool{}
+	for _, s := range specs {
+		line := strconv.Quote(s.Path)
+		if s.Name != "" {
+			line = s.Name + " " + line
+		}
+		if seen[line] {
+			continue
+		}
+		seen[line] = true
+		if strings.Contains(strings.SplitN(s.Path, "/", 2)[0], ".") {
+			other = append(other, line)
+		} else {
+			std = append(std, line)
+		}
+	}
+	sort.Strings(std)
+	sort.Strings(other)
+	return std, other
+}
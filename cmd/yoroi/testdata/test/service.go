@@ -0,0 +1,14 @@
+// Package test holds a service used to test the generator. Its gRPC bindings
+// use the protobuf types of the gRPC transport tests.
+package test
+
+//go:generate go run github.com/tnnyio/yoroi/cmd/yoroi gen -type Test -pb github.com/tnnyio/yoroi/transport/grpc/_grpc_test/pb service.go
+
+import (
+	"context"
+)
+
+// Test is a service.
+type Test interface {
+	Test(ctx context.Context, a string, b int64) (v string, err error)
+}
@@ -0,0 +1,116 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http/httptest"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/tnnyio/yoroi/endpoint"
+	"github.com/tnnyio/yoroi/transport/grpc/_grpc_test/pb"
+)
+
+type service struct{}
+
+func (service) Test(_ context.Context, a string, b int64) (string, error) {
+	if b < 0 {
+		return "", errors.New("negative")
+	}
+	return fmt.Sprintf("%s = %d", a, b), nil
+}
+
+func TestChain(t *testing.T) {
+	var calls []string
+	mark := func(name string) endpoint.Middleware[*TestResponse] {
+		return func(next endpoint.Endpoint[*TestResponse]) endpoint.Endpoint[*TestResponse] {
+			return func(ctx context.Context, request interface{}) (*TestResponse, error) {
+				calls = append(calls, name)
+				return next(ctx, request)
+			}
+		}
+	}
+	e := MakeTestEndpoints(service{}, TestMiddlewares{Test: []endpoint.Middleware[*TestResponse]{mark("outer"), mark("inner")}})
+	check(t, e)
+	if want, have := "[outer inner outer inner]", fmt.Sprint(calls); want != have {
+		t.Errorf("want %s, have %s", want, have)
+	}
+}
+
+func TestHTTP(t *testing.T) {
+	server := httptest.NewServer(NewTestHTTPHandler(MakeTestEndpoints(service{}, TestMiddlewares{})))
+	defer server.Close()
+	client, err := NewTestHTTPClient(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(t, client)
+}
+
+func TestJSONRPC(t *testing.T) {
+	server := httptest.NewServer(NewTestJSONRPCHandler(MakeTestEndpoints(service{}, TestMiddlewares{})))
+	defer server.Close()
+	client, err := NewTestJSONRPCClient(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(t, client)
+}
+
+func TestParseInstance(t *testing.T) {
+	for instance, want := range map[string]string{
+		"10.0.0.1:8080":       "http://10.0.0.1:8080",
+		"httpbin:8080":        "http://httpbin:8080",
+		"http-gw.internal:80": "http://http-gw.internal:80",
+		"https://api:443":     "https://api:443",
+	} {
+		u, err := yoroiTestParseInstance(instance)
+		if err != nil {
+			t.Errorf("%s: %v", instance, err)
+			continue
+		}
+		if have := u.String(); want != have {
+			t.Errorf("%s: want %s, have %s", instance, want, have)
+		}
+	}
+}
+
+func TestGRPC(t *testing.T) {
+	var (
+		server = grpc.NewServer()
+		ln     = bufconn.Listen(1 << 16)
+	)
+	pb.RegisterTestServer(server, NewTestGRPCServer(MakeTestEndpoints(service{}, TestMiddlewares{})))
+	go server.Serve(ln)
+	defer server.Stop()
+
+	cc, err := grpc.Dial("bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return ln.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cc.Close()
+	check(t, NewTestGRPCClient(cc))
+}
+
+// check calls svc through the generated endpoints.
+func check(t *testing.T, svc Test) {
+	t.Helper()
+	v, err := svc.Test(context.Background(), "answer", 42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, have := "answer = 42", v; want != have {
+		t.Errorf("want %q, have %q", want, have)
+	}
+	if _, err := svc.Test(context.Background(), "answer", -1); err == nil {
+		t.Error("want error")
+	}
+}
@@ -0,0 +1,183 @@
+// Package coalesce provides a middleware that collapses concurrent, identical
+// requests into a single call to the wrapped endpoint.
+//
+// It is most useful in front of expensive or cache-backed endpoints, where a
+// cache miss would otherwise cause a storm of identical requests to reach the
+// backend at the same time.
+package coalesce
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/tnnyio/yoroi/endpoint"
+	"github.com/tnnyio/yoroi/recovery"
+)
+
+// KeyFunc derives the coalescing key for a request. Concurrent requests with
+// the same key share a single call to the next endpoint. Returning an empty
+// key opts the request out of coalescing.
+type KeyFunc func(ctx context.Context, request interface{}) string
+
+// Option sets an optional parameter for the coalescing middleware.
+type Option func(*options)
+
+// SharedResultTTL keeps the result of a successful call around for d, so that
+// callers arriving shortly after the call returned share it as well. Errors
+// are never retained. By default, results are forgotten as soon as the call
+// completes.
+func SharedResultTTL(d time.Duration) Option {
+	return func(o *options) { o.ttl = d }
+}
+
+type options struct {
+	ttl     time.Duration
+	timeNow func() time.Time
+}
+
+// New returns an endpoint.Middleware that shares one in-flight call to the
+// next endpoint among all concurrent callers whose requests map to the same
+// key.
+//
+// Each caller waits on its own context. A caller whose context is done
+// returns immediately with the context error, without affecting the other
+// callers. The shared call runs with a context that carries the values of
+// the caller that started it, and is only canceled once every caller waiting
+// on it has given up.
+func New[O interface{}](key KeyFunc, opts ...Option) endpoint.Middleware[O] {
+	o := options{timeNow: time.Now}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return func(next endpoint.Endpoint[O]) endpoint.Endpoint[O] {
+		g := &group[O]{
+			next:  next,
+			calls: map[string]*call[O]{},
+			opts:  o,
+		}
+		return g.do(key)
+	}
+}
+
+type call[O interface{}] struct {
+	done    chan struct{}
+	cancel  context.CancelFunc
+	waiters int
+	expires time.Time
+
+	response O
+	err      error
+}
+
+type group[O interface{}] struct {
+	next  endpoint.Endpoint[O]
+	opts  options
+	mtx   sync.Mutex
+	calls map[string]*call[O]
+}
+
+func (g *group[O]) do(key KeyFunc) endpoint.Endpoint[O] {
+	return func(ctx context.Context, request interface{}) (response O, err error) {
+		k := key(ctx, request)
+		if k == "" {
+			return g.next(ctx, request)
+		}
+
+		c := g.join(ctx, k, request)
+		select {
+		case <-c.done:
+			return c.response, c.err
+		case <-ctx.Done():
+			g.leave(k, c)
+			return response, ctx.Err()
+		}
+	}
+}
+
+// join returns the call for key k, starting a new one if there is neither an
+// in-flight call nor a retained result.
+func (g *group[O]) join(ctx context.Context, k string, request interface{}) *call[O] {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+
+	if c, ok := g.calls[k]; ok {
+		select {
+		case <-c.done:
+			if g.opts.timeNow().Before(c.expires) {
+				return c
+			}
+			delete(g.calls, k)
+		default:
+			c.waiters++
+			return c
+		}
+	}
+
+	callCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	c := &call[O]{
+		done:    make(chan struct{}),
+		cancel:  cancel,
+		waiters: 1,
+	}
+	g.calls[k] = c
+	go g.run(callCtx, k, c, request)
+	return c
+}
+
+// leave is invoked when a caller gives up waiting. When the last waiter of an
+// in-flight call leaves, the shared call is canceled.
+func (g *group[O]) leave(k string, c *call[O]) {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+
+	select {
+	case <-c.done:
+		return
+	default:
+	}
+	c.waiters--
+	if c.waiters > 0 {
+		return
+	}
+	c.cancel()
+	g.forget(k, c) // late arrivals should not join a canceled call
+}
+
+// run makes the shared call. A panic of the next endpoint fails the call with
+// a *recovery.PanicError, since no caller's goroutine would recover it.
+func (g *group[O]) run(ctx context.Context, k string, c *call[O], request interface{}) {
+	defer c.cancel()
+	defer g.finish(k, c)
+	defer func() {
+		if r := recover(); r != nil {
+			c.err = recovery.NewPanicError(r)
+		}
+	}()
+	c.response, c.err = g.next(ctx, request)
+}
+
+// finish wakes up the waiters of c, and retains its result if configured.
+func (g *group[O]) finish(k string, c *call[O]) {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+	close(c.done)
+	if g.opts.ttl <= 0 || c.err != nil {
+		g.forget(k, c)
+		return
+	}
+	c.expires = g.opts.timeNow().Add(g.opts.ttl)
+	time.AfterFunc(g.opts.ttl, func() {
+		g.mtx.Lock()
+		defer g.mtx.Unlock()
+		g.forget(k, c)
+	})
+}
+
+// forget removes c from the group, unless it has already been replaced.
+// The caller must hold the mutex.
+func (g *group[O]) forget(k string, c *call[O]) {
+	if g.calls[k] == c {
+		delete(g.calls, k)
+	}
+}
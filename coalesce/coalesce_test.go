@@ -0,0 +1,238 @@
+package coalesce_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/tnnyio/yoroi/coalesce"
+	"github.com/tnnyio/yoroi/endpoint"
+	"github.com/tnnyio/yoroi/recovery"
+)
+
+func requestKey(_ context.Context, request interface{}) string {
+	s, _ := request.(string)
+	return s
+}
+
+// blockingEndpoint counts its invocations and blocks until release is closed.
+func blockingEndpoint(calls *int64, release <-chan struct{}) endpoint.Endpoint[string] {
+	return func(ctx context.Context, request interface{}) (string, error) {
+		atomic.AddInt64(calls, 1)
+		select {
+		case <-release:
+			return "response:" + request.(string), nil
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+	}
+}
+
+func TestCoalesceSharesInFlightCall(t *testing.T) {
+	var (
+		calls   int64
+		release = make(chan struct{})
+		e       = coalesce.New[string](requestKey)(blockingEndpoint(&calls, release))
+		wg      sync.WaitGroup
+		results = make(chan string, 10)
+	)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			response, err := e(context.Background(), "a")
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			results <- response
+		}()
+	}
+	waitFor(t, func() bool { return atomic.LoadInt64(&calls) == 1 })
+	time.Sleep(10 * time.Millisecond) // let the remaining callers join
+	close(release)
+	wg.Wait()
+	close(results)
+
+	if want, have := int64(1), atomic.LoadInt64(&calls); want != have {
+		t.Errorf("calls: want %d, have %d", want, have)
+	}
+	for response := range results {
+		if want, have := "response:a", response; want != have {
+			t.Errorf("want %q, have %q", want, have)
+		}
+	}
+}
+
+func TestCoalesceDistinctKeys(t *testing.T) {
+	var (
+		calls   int64
+		release = make(chan struct{})
+		e       = coalesce.New[string](requestKey)(blockingEndpoint(&calls, release))
+	)
+	close(release)
+	for _, req := range []string{"a", "b", "c"} {
+		if _, err := e(context.Background(), req); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if want, have := int64(3), atomic.LoadInt64(&calls); want != have {
+		t.Errorf("calls: want %d, have %d", want, have)
+	}
+}
+
+func TestCoalesceEmptyKeyBypasses(t *testing.T) {
+	var (
+		calls   int64
+		release = make(chan struct{})
+		e       = coalesce.New[string](func(context.Context, interface{}) string { return "" })(blockingEndpoint(&calls, release))
+		wg      sync.WaitGroup
+	)
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() { defer wg.Done(); e(context.Background(), "a") }()
+	}
+	waitFor(t, func() bool { return atomic.LoadInt64(&calls) == 3 })
+	close(release)
+	wg.Wait()
+}
+
+func TestCoalesceCallerCancellation(t *testing.T) {
+	var (
+		calls   int64
+		release = make(chan struct{})
+		e       = coalesce.New[string](requestKey)(blockingEndpoint(&calls, release))
+		done    = make(chan error, 1)
+	)
+
+	// The first caller starts the shared call and then gives up.
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		_, err := e(ctx, "a")
+		done <- err
+	}()
+	waitFor(t, func() bool { return atomic.LoadInt64(&calls) == 1 })
+
+	// A second caller joins the same call.
+	second := make(chan string, 1)
+	go func() {
+		response, err := e(context.Background(), "a")
+		if err != nil {
+			t.Errorf("second caller: unexpected error: %v", err)
+		}
+		second <- response
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	cancel()
+	if want, have := context.Canceled, <-done; !errors.Is(have, want) {
+		t.Errorf("first caller: want %v, have %v", want, have)
+	}
+
+	close(release)
+	if want, have := "response:a", <-second; want != have {
+		t.Errorf("second caller: want %q, have %q", want, have)
+	}
+	if want, have := int64(1), atomic.LoadInt64(&calls); want != have {
+		t.Errorf("calls: want %d, have %d", want, have)
+	}
+}
+
+func TestCoalesceLastWaiterCancelsCall(t *testing.T) {
+	var (
+		calls    int64
+		canceled = make(chan struct{})
+		e        = coalesce.New[string](requestKey)(func(ctx context.Context, request interface{}) (string, error) {
+			atomic.AddInt64(&calls, 1)
+			<-ctx.Done()
+			close(canceled)
+			return "", ctx.Err()
+		})
+	)
+	ctx, cancel := context.WithCancel(context.Background())
+	go e(ctx, "a")
+	waitFor(t, func() bool { return atomic.LoadInt64(&calls) == 1 })
+	cancel()
+
+	select {
+	case <-canceled:
+	case <-time.After(time.Second):
+		t.Fatal("shared call was not canceled after the last waiter left")
+	}
+}
+
+func TestCoalesceSharedResultTTL(t *testing.T) {
+	var (
+		calls int64
+		e     = coalesce.New[int64](requestKey, coalesce.SharedResultTTL(time.Hour))(func(context.Context, interface{}) (int64, error) {
+			return atomic.AddInt64(&calls, 1), nil
+		})
+	)
+	for i := 0; i < 3; i++ {
+		response, err := e(context.Background(), "a")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want, have := int64(1), response; want != have {
+			t.Errorf("want %d, have %d", want, have)
+		}
+	}
+}
+
+func TestCoalesceErrorsAreNotRetained(t *testing.T) {
+	var (
+		calls int64
+		e     = coalesce.New[int64](requestKey, coalesce.SharedResultTTL(time.Hour))(func(context.Context, interface{}) (int64, error) {
+			return atomic.AddInt64(&calls, 1), errors.New("fail")
+		})
+	)
+	e(context.Background(), "a")
+	e(context.Background(), "a")
+	if want, have := int64(2), atomic.LoadInt64(&calls); want != have {
+		t.Errorf("calls: want %d, have %d", want, have)
+	}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for condition")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestCoalescePanic(t *testing.T) {
+	var (
+		release = make(chan struct{})
+		started = make(chan struct{})
+		e       = coalesce.New[string](requestKey)(func(context.Context, interface{}) (string, error) {
+			close(started)
+			<-release
+			panic("boom")
+		})
+		errs = make(chan error, 2)
+	)
+	go func() { _, err := e(context.Background(), "a"); errs <- err }()
+	<-started
+	go func() { _, err := e(context.Background(), "a"); errs <- err }()
+	time.Sleep(10 * time.Millisecond) // let the second caller join
+	close(release)
+
+	// Every waiter fails with the panic, and the process survives.
+	for i := 0; i < 2; i++ {
+		select {
+		case err := <-errs:
+			var perr *recovery.PanicError
+			if !errors.As(err, &perr) || perr.Value != "boom" {
+				t.Errorf("want a PanicError, have %v", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("waiter not woken up")
+		}
+	}
+}
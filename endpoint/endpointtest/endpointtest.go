@@ -0,0 +1,220 @@
+// Package endpointtest provides helpers for testing services built on
+// endpoints: a recording endpoint, a scripted fake, middleware ordering
+// assertions, and round trips through each transport's server and client.
+package endpointtest
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tnnyio/yoroi/endpoint"
+)
+
+// Call is a single invocation of an endpoint.
+type Call struct {
+	Ctx     context.Context
+	Request interface{}
+}
+
+// Recorder is an endpoint that records its calls before passing them to the
+// next endpoint. It's safe for concurrent use.
+type Recorder[O interface{}] struct {
+	next  endpoint.Endpoint[O]
+	mtx   sync.Mutex
+	calls []Call
+}
+
+// NewRecorder returns a Recorder calling next. If next is nil, the recorder
+// returns the zero value of O and no error.
+func NewRecorder[O interface{}](next endpoint.Endpoint[O]) *Recorder[O] {
+	return &Recorder[O]{next: next}
+}
+
+// Endpoint implements endpoint.Endpoint.
+func (r *Recorder[O]) Endpoint(ctx context.Context, request interface{}) (response O, err error) {
+	r.mtx.Lock()
+	r.calls = append(r.calls, Call{Ctx: ctx, Request: request})
+	r.mtx.Unlock()
+	if r.next == nil {
+		return response, nil
+	}
+	return r.next(ctx, request)
+}
+
+// Calls returns the recorded calls, in the order they were made.
+func (r *Recorder[O]) Calls() []Call {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	return append([]Call(nil), r.calls...)
+}
+
+// Requests returns the requests of the recorded calls.
+func (r *Recorder[O]) Requests() []interface{} {
+	calls := r.Calls()
+	requests := make([]interface{}, len(calls))
+	for i, c := range calls {
+		requests[i] = c.Request
+	}
+	return requests
+}
+
+// Last returns the most recent call, and false if there was none.
+func (r *Recorder[O]) Last() (Call, bool) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	if len(r.calls) == 0 {
+		return Call{}, false
+	}
+	return r.calls[len(r.calls)-1], true
+}
+
+// Reset forgets the recorded calls.
+func (r *Recorder[O]) Reset() {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.calls = nil
+}
+
+// ErrExhausted is returned by a Fake whose script is exhausted.
+var ErrExhausted = errors.New("endpointtest: no more scripted responses")
+
+// Step is a scripted outcome of a Fake. The fake waits for Latency, or until
+// the context is done, before returning Response and Err.
+type Step[O interface{}] struct {
+	Response O
+	Err      error
+	Latency  time.Duration
+}
+
+// Fake is an endpoint that returns scripted outcomes, in the order they were
+// queued. Calls are recorded as with a Recorder. It's safe for concurrent
+// use.
+type Fake[O interface{}] struct {
+	Recorder[O]
+	mtx    sync.Mutex
+	steps  []Step[O]
+	repeat bool
+}
+
+// NewFake returns a Fake with the given script.
+func NewFake[O interface{}](steps ...Step[O]) *Fake[O] {
+	return &Fake[O]{steps: steps}
+}
+
+// Push queues more outcomes.
+func (f *Fake[O]) Push(steps ...Step[O]) *Fake[O] {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	f.steps = append(f.steps, steps...)
+	return f
+}
+
+// Return queues a successful response.
+func (f *Fake[O]) Return(response O) *Fake[O] {
+	return f.Push(Step[O]{Response: response})
+}
+
+// Fail queues an error.
+func (f *Fake[O]) Fail(err error) *Fake[O] {
+	return f.Push(Step[O]{Err: err})
+}
+
+// Repeat makes the fake keep returning the last outcome of its script
+// instead of ErrExhausted.
+func (f *Fake[O]) Repeat() *Fake[O] {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	f.repeat = true
+	return f
+}
+
+// Remaining returns the number of outcomes left in the script.
+func (f *Fake[O]) Remaining() int {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	return len(f.steps)
+}
+
+// Endpoint implements endpoint.Endpoint.
+func (f *Fake[O]) Endpoint(ctx context.Context, request interface{}) (response O, err error) {
+	f.Recorder.Endpoint(ctx, request)
+
+	f.mtx.Lock()
+	if len(f.steps) == 0 {
+		f.mtx.Unlock()
+		return response, ErrExhausted
+	}
+	step := f.steps[0]
+	if len(f.steps) > 1 || !f.repeat {
+		f.steps = f.steps[1:]
+	}
+	f.mtx.Unlock()
+
+	if step.Latency > 0 {
+		t := time.NewTimer(step.Latency)
+		defer t.Stop()
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return response, ctx.Err()
+		}
+	}
+	return step.Response, step.Err
+}
+
+// Order records the order in which middlewares are entered and left.
+type Order struct {
+	mtx    sync.Mutex
+	events []string
+}
+
+// Mark returns a middleware that records name in the Order when a call
+// enters it, and name followed by "/done" when the call leaves it.
+func Mark[O interface{}](o *Order, name string) endpoint.Middleware[O] {
+	return func(next endpoint.Endpoint[O]) endpoint.Endpoint[O] {
+		return func(ctx context.Context, request interface{}) (O, error) {
+			o.record(name)
+			defer o.record(name + "/done")
+			return next(ctx, request)
+		}
+	}
+}
+
+func (o *Order) record(event string) {
+	o.mtx.Lock()
+	defer o.mtx.Unlock()
+	o.events = append(o.events, event)
+}
+
+// Events returns the recorded events.
+func (o *Order) Events() []string {
+	o.mtx.Lock()
+	defer o.mtx.Unlock()
+	return append([]string(nil), o.events...)
+}
+
+// Entered returns the names of the middlewares in the order they were
+// entered.
+func (o *Order) Entered() []string {
+	var names []string
+	for _, e := range o.Events() {
+		if !strings.HasSuffix(e, "/done") {
+			names = append(names, e)
+		}
+	}
+	return names
+}
+
+// Assert fails the test if the middlewares weren't entered in the given
+// order.
+func (o *Order) Assert(t testing.TB, want ...string) {
+	t.Helper()
+	if have := o.Entered(); !reflect.DeepEqual(want, have) && (len(want) > 0 || len(have) > 0) {
+		t.Errorf("middleware order: want %v, have %v", want, have)
+	}
+}
@@ -0,0 +1,175 @@
+package endpointtest_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	fh "github.com/valyala/fasthttp"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+
+	"github.com/tnnyio/yoroi/endpoint"
+	"github.com/tnnyio/yoroi/endpoint/endpointtest"
+	fasthttptransport "github.com/tnnyio/yoroi/transport/fasthttp"
+	httptransport "github.com/tnnyio/yoroi/transport/http"
+	"github.com/tnnyio/yoroi/transport/http/jsonrpc"
+)
+
+func upper(_ context.Context, request interface{}) (string, error) {
+	return strings.ToUpper(request.(string)), nil
+}
+
+type key struct{}
+
+func TestRecorder(t *testing.T) {
+	r := endpointtest.NewRecorder[string](upper)
+	ctx := context.WithValue(context.Background(), key{}, "v")
+	if response, _ := r.Endpoint(ctx, "a"); response != "A" {
+		t.Errorf("want A, have %q", response)
+	}
+	r.Endpoint(context.Background(), "b")
+
+	if want, have := []interface{}{"a", "b"}, r.Requests(); !reflect.DeepEqual(want, have) {
+		t.Errorf("requests: want %v, have %v", want, have)
+	}
+	if call := r.Calls()[0]; call.Ctx.Value(key{}) != "v" {
+		t.Error("context not recorded")
+	}
+	r.Reset()
+	if _, ok := r.Last(); ok {
+		t.Error("want no call after Reset")
+	}
+}
+
+func TestFake(t *testing.T) {
+	errBoom := errors.New("boom")
+	f := endpointtest.NewFake[string]().Return("one").Fail(errBoom).Push(endpointtest.Step[string]{Response: "slow", Latency: time.Second})
+
+	if response, err := f.Endpoint(context.Background(), nil); response != "one" || err != nil {
+		t.Errorf("step 1: have %q, %v", response, err)
+	}
+	if _, err := f.Endpoint(context.Background(), nil); err != errBoom {
+		t.Errorf("step 2: want %v, have %v", errBoom, err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	if _, err := f.Endpoint(ctx, nil); err != context.DeadlineExceeded {
+		t.Errorf("step 3: want %v, have %v", context.DeadlineExceeded, err)
+	}
+	if _, err := f.Endpoint(context.Background(), nil); err != endpointtest.ErrExhausted {
+		t.Errorf("exhausted: want %v, have %v", endpointtest.ErrExhausted, err)
+	}
+	if want, have := 4, len(f.Calls()); want != have {
+		t.Errorf("calls: want %d, have %d", want, have)
+	}
+
+	f = endpointtest.NewFake[string]().Return("again").Repeat()
+	for i := 0; i < 3; i++ {
+		if response, _ := f.Endpoint(context.Background(), nil); response != "again" {
+			t.Errorf("repeat %d: have %q", i, response)
+		}
+	}
+}
+
+func TestOrder(t *testing.T) {
+	var o endpointtest.Order
+	e := endpoint.Chain(
+		endpointtest.Mark[string](&o, "outer"),
+		endpointtest.Mark[string](&o, "inner"),
+	)(upper)
+	e(context.Background(), "x")
+
+	o.Assert(t, "outer", "inner")
+	if want, have := []string{"outer", "inner", "inner/done", "outer/done"}, o.Events(); !reflect.DeepEqual(want, have) {
+		t.Errorf("events: want %v, have %v", want, have)
+	}
+}
+
+func TestHTTP(t *testing.T) {
+	e := endpointtest.HTTP(t, upper, endpointtest.HTTPPair[string, string]{
+		DecodeRequest: func(_ context.Context, r *http.Request) (string, error) {
+			b, err := io.ReadAll(r.Body)
+			return string(b), err
+		},
+		EncodeResponse: httptransport.EncodeJSONResponse[string],
+		EncodeRequest: func(_ context.Context, r *http.Request, request string) error {
+			r.Body = io.NopCloser(strings.NewReader(request))
+			return nil
+		},
+		DecodeResponse: func(_ context.Context, r *http.Response) (response string, err error) {
+			err = json.NewDecoder(r.Body).Decode(&response)
+			return response, err
+		},
+	})
+	roundTrip(t, e)
+}
+
+func TestFastHTTP(t *testing.T) {
+	e := endpointtest.FastHTTP(t, upper, endpointtest.FastHTTPPair[string, string]{
+		DecodeRequest:  func(ctx *fh.RequestCtx) (string, error) { return string(ctx.PostBody()), nil },
+		EncodeResponse: fasthttptransport.EncodeJSONResponse[string],
+		EncodeRequest: func(r *fh.Request, request string) error {
+			r.SetBodyString(request)
+			return nil
+		},
+		DecodeResponse: func(r *fh.Response) (response string, err error) {
+			err = json.Unmarshal(r.Body(), &response)
+			return response, err
+		},
+	})
+	roundTrip(t, e)
+}
+
+func TestGRPC(t *testing.T) {
+	e := endpointtest.GRPC(t, upper, endpointtest.GRPCPair[string, string]{
+		Request: &wrapperspb.StringValue{},
+		Reply:   &wrapperspb.StringValue{},
+		DecodeRequest: func(_ context.Context, req interface{}) (string, error) {
+			return req.(*wrapperspb.StringValue).GetValue(), nil
+		},
+		EncodeResponse: func(_ context.Context, response string) (interface{}, error) {
+			return wrapperspb.String(response), nil
+		},
+		EncodeRequest: func(_ context.Context, request string) (interface{}, error) {
+			return wrapperspb.String(request), nil
+		},
+		DecodeResponse: func(_ context.Context, reply interface{}) (string, error) {
+			return reply.(*wrapperspb.StringValue).GetValue(), nil
+		},
+	})
+	roundTrip(t, e)
+}
+
+func TestJSONRPC(t *testing.T) {
+	e := endpointtest.JSONRPC[string, string](t, "upper", jsonrpc.EndpointCodec{
+		Endpoint: func(ctx context.Context, request interface{}) (interface{}, error) {
+			return upper(ctx, request)
+		},
+		Decode: func(_ context.Context, params json.RawMessage) (request interface{}, err error) {
+			var s string
+			err = json.Unmarshal(params, &s)
+			return s, err
+		},
+		Encode: func(_ context.Context, response interface{}) (json.RawMessage, error) {
+			return json.Marshal(response)
+		},
+	})
+	roundTrip(t, e)
+}
+
+func roundTrip(t *testing.T, e endpoint.Endpoint[string]) {
+	t.Helper()
+	response, err := e(context.Background(), "hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, have := "HELLO", response; want != have {
+		t.Errorf("want %q, have %q", want, have)
+	}
+}
@@ -0,0 +1,159 @@
+package endpointtest
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	fh "github.com/valyala/fasthttp"
+	"github.com/valyala/fasthttp/fasthttputil"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/tnnyio/yoroi/endpoint"
+	fasthttptransport "github.com/tnnyio/yoroi/transport/fasthttp"
+	grpctransport "github.com/tnnyio/yoroi/transport/grpc"
+	httptransport "github.com/tnnyio/yoroi/transport/http"
+	"github.com/tnnyio/yoroi/transport/http/jsonrpc"
+)
+
+// HTTPPair holds the codecs and options of an HTTP server and client.
+type HTTPPair[I, O interface{}] struct {
+	Method         string // defaults to POST
+	DecodeRequest  httptransport.DecodeRequestFunc[I]
+	EncodeResponse httptransport.EncodeResponseFunc[O]
+	EncodeRequest  httptransport.EncodeRequestFunc[I]
+	DecodeResponse httptransport.DecodeResponseFunc[O]
+	ServerOptions  []httptransport.ServerOption[I, O]
+	ClientOptions  []httptransport.ClientOption[I, O]
+}
+
+// HTTP serves e with an HTTP server on a local listener, and returns the
+// endpoint of a client calling it. The server is closed when the test ends.
+func HTTP[I, O interface{}](t testing.TB, e endpoint.Endpoint[O], p HTTPPair[I, O]) endpoint.Endpoint[O] {
+	t.Helper()
+	server := httptest.NewServer(httptransport.NewServer(e, p.DecodeRequest, p.EncodeResponse, p.ServerOptions...))
+	t.Cleanup(server.Close)
+	method := p.Method
+	if method == "" {
+		method = "POST"
+	}
+	return httptransport.NewClient(method, mustParseURL(t, server.URL), p.EncodeRequest, p.DecodeResponse, p.ClientOptions...).Endpoint()
+}
+
+// FastHTTPPair holds the codecs and options of a fasthttp server and client.
+type FastHTTPPair[I, O interface{}] struct {
+	Method         string // defaults to POST
+	Path           string // defaults to /
+	DecodeRequest  fasthttptransport.DecodeRequestFunc[I]
+	EncodeResponse fasthttptransport.EncodeResponseFunc[O]
+	EncodeRequest  fasthttptransport.EncodeRequestFunc[I]
+	DecodeResponse fasthttptransport.DecodeResponseFunc[O]
+	ServerOptions  []fasthttptransport.ServerOption[I, O]
+	ClientOptions  []fasthttptransport.ClientOption[I, O]
+}
+
+// FastHTTP serves e with a fasthttp server on an in-memory listener, and
+// returns the endpoint of a client calling it. The listener is closed when
+// the test ends.
+func FastHTTP[I, O interface{}](t testing.TB, e endpoint.Endpoint[O], p FastHTTPPair[I, O]) endpoint.Endpoint[O] {
+	t.Helper()
+	ln := fasthttputil.NewInmemoryListener()
+	t.Cleanup(func() { ln.Close() })
+	go fh.Serve(ln, fasthttptransport.NewServer(e, p.DecodeRequest, p.EncodeResponse, p.ServerOptions...))
+
+	method, path := p.Method, p.Path
+	if method == "" {
+		method = "POST"
+	}
+	if path == "" {
+		path = "/"
+	}
+	c := &fh.Client{Dial: func(string) (net.Conn, error) { return ln.Dial() }}
+	options := append([]fasthttptransport.ClientOption[I, O]{fasthttptransport.SetClient[I, O](c.Do)}, p.ClientOptions...)
+	client := fasthttptransport.NewClient(method, fasthttptransport.URI{Host: "endpointtest", Path: path}, p.EncodeRequest, p.DecodeResponse, options...)
+	return func(ctx context.Context, request interface{}) (O, error) {
+		i, ok := request.(I)
+		if !ok && request != nil {
+			var o O
+			return o, fmt.Errorf("endpointtest: unexpected request type %T", request)
+		}
+		return client.Call(ctx, i)
+	}
+}
+
+// GRPCPair holds the codecs and options of a gRPC server and client. Request
+// and Reply are zero values of the protobuf messages exchanged on the wire.
+type GRPCPair[I, O interface{}] struct {
+	Request        proto.Message
+	Reply          proto.Message
+	DecodeRequest  grpctransport.DecodeRequestFunc[I]
+	EncodeResponse grpctransport.EncodeResponseFunc[O]
+	EncodeRequest  grpctransport.EncodeRequestFunc[I]
+	DecodeResponse grpctransport.DecodeResponseFunc[O]
+	ServerOptions  []grpctransport.ServerOption[I, O]
+	ClientOptions  []grpctransport.ClientOption[I, O]
+}
+
+// GRPC serves e as the unary method endpointtest.RoundTrip/Call of a gRPC
+// server on an in-memory listener, and returns the endpoint of a client
+// calling it. The server is stopped when the test ends.
+func GRPC[I, O interface{}](t testing.TB, e endpoint.Endpoint[O], p GRPCPair[I, O]) endpoint.Endpoint[O] {
+	t.Helper()
+	var (
+		handler = grpctransport.NewServer(e, p.DecodeRequest, p.EncodeResponse, p.ServerOptions...)
+		server  = grpc.NewServer()
+		ln      = bufconn.Listen(1 << 20)
+	)
+	server.RegisterService(&grpc.ServiceDesc{
+		ServiceName: "endpointtest.RoundTrip",
+		HandlerType: (*interface{})(nil),
+		Methods: []grpc.MethodDesc{{
+			MethodName: "Call",
+			Handler: func(_ interface{}, ctx context.Context, dec func(interface{}) error, _ grpc.UnaryServerInterceptor) (interface{}, error) {
+				req := p.Request.ProtoReflect().New().Interface()
+				if err := dec(req); err != nil {
+					return nil, err
+				}
+				_, resp, err := handler.ServeGRPC(ctx, req)
+				return resp, err
+			},
+		}},
+	}, struct{}{})
+	go server.Serve(ln)
+	t.Cleanup(server.Stop)
+
+	cc, err := grpc.Dial("bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return ln.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("endpointtest: dial gRPC server: %v", err)
+	}
+	t.Cleanup(func() { cc.Close() })
+	return grpctransport.NewClient(cc, "endpointtest.RoundTrip", "Call", p.EncodeRequest, p.DecodeResponse, p.Reply, p.ClientOptions...).Endpoint()
+}
+
+// JSONRPC serves the codecs under method with a JSON RPC server on a local
+// listener, and returns the endpoint of a client calling that method. The
+// server is closed when the test ends.
+func JSONRPC[I, O interface{}](t testing.TB, method string, codec jsonrpc.EndpointCodec, options ...jsonrpc.ClientOption[I, O]) endpoint.Endpoint[O] {
+	t.Helper()
+	server := httptest.NewServer(jsonrpc.NewServer(jsonrpc.EndpointCodecMap{method: codec}))
+	t.Cleanup(server.Close)
+	return jsonrpc.NewClient(mustParseURL(t, server.URL), method, options...).Endpoint()
+}
+
+func mustParseURL(t testing.TB, s string) *url.URL {
+	t.Helper()
+	u, err := url.Parse(s)
+	if err != nil {
+		t.Fatalf("endpointtest: %v", err)
+	}
+	return u
+}
@@ -16,12 +16,14 @@
 package main
 
 import (
+	"context"
 	"log"
 	"net"
 	"os"
 
 	logYoroi "github.com/tnnyio/log"
 	"github.com/tnnyio/yoroi/example/src/proto"
+	"github.com/tnnyio/yoroi/lifecycle"
 	"google.golang.org/grpc"
 )
 
@@ -38,9 +40,14 @@ func main() {
 		logger.Log("Error", err)
 		os.Exit(1)
 	}
-	defer server.GracefulStop()
 
 	proto.RegisterGreetServiceServer(server, NewGreetServiceBinding(svc))
 	logger.Log("Message", "stating gRPC server", "Address", hostPort)
-	logger.Log(server.Serve(sc))
+
+	g := lifecycle.New(logger)
+	g.Add(lifecycle.GRPCServer("grpc", server, sc))
+	if err := g.Run(context.Background()); err != nil {
+		logger.Log("Error", err)
+		os.Exit(1)
+	}
 }
@@ -0,0 +1,88 @@
+package health
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+// DefaultWatchInterval is the default interval at which the gRPC health
+// server re-evaluates the status of watched services.
+const DefaultWatchInterval = 5 * time.Second
+
+// GRPCServer implements the standard gRPC health protocol. The empty service
+// name reports readiness, and any other name reports the check registered
+// under that name.
+type GRPCServer struct {
+	healthpb.UnimplementedHealthServer
+	health   *Health
+	interval time.Duration
+}
+
+// NewGRPCServer returns a gRPC health server for h, which re-evaluates
+// watched services every interval. If interval isn't positive,
+// DefaultWatchInterval is used. Register it with
+// grpc_health_v1.RegisterHealthServer.
+func NewGRPCServer(h *Health, interval time.Duration) *GRPCServer {
+	if interval <= 0 {
+		interval = DefaultWatchInterval
+	}
+	return &GRPCServer{health: h, interval: interval}
+}
+
+var _ healthpb.HealthServer = (*GRPCServer)(nil)
+
+// Check implements grpc_health_v1.HealthServer.
+func (s *GRPCServer) Check(ctx context.Context, req *healthpb.HealthCheckRequest) (*healthpb.HealthCheckResponse, error) {
+	st, ok := s.status(ctx, req.GetService())
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "unknown service %q", req.GetService())
+	}
+	return &healthpb.HealthCheckResponse{Status: st}, nil
+}
+
+// Watch implements grpc_health_v1.HealthServer. It sends the status of the
+// service immediately, and then whenever it changes.
+func (s *GRPCServer) Watch(req *healthpb.HealthCheckRequest, stream healthpb.Health_WatchServer) error {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	last := healthpb.HealthCheckResponse_ServingStatus(-1)
+	for {
+		st, ok := s.status(stream.Context(), req.GetService())
+		if !ok {
+			st = healthpb.HealthCheckResponse_SERVICE_UNKNOWN
+		}
+		if st != last {
+			if err := stream.Send(&healthpb.HealthCheckResponse{Status: st}); err != nil {
+				return err
+			}
+			last = st
+		}
+		select {
+		case <-ticker.C:
+		case <-stream.Context().Done():
+			return status.FromContextError(stream.Context().Err()).Err()
+		}
+	}
+}
+
+func (s *GRPCServer) status(ctx context.Context, service string) (healthpb.HealthCheckResponse_ServingStatus, bool) {
+	var st Status
+	if service == "" {
+		st = s.health.Readiness(ctx).Status
+	} else {
+		result, ok := s.health.Check(ctx, service)
+		if !ok {
+			return healthpb.HealthCheckResponse_SERVICE_UNKNOWN, false
+		}
+		st = result.Status
+	}
+	if st == StatusUp {
+		return healthpb.HealthCheckResponse_SERVING, true
+	}
+	return healthpb.HealthCheckResponse_NOT_SERVING, true
+}
@@ -0,0 +1,233 @@
+// Package health aggregates named health checks into liveness and readiness
+// reports, and serves them over HTTP and the standard gRPC health protocol.
+//
+// Liveness tells whether the process is working at all, and should only
+// depend on checks that a restart would fix. Readiness tells whether the
+// instance should receive traffic, and depends on every check, typically
+// including downstream dependencies. Reports can also be pushed periodically
+// to service discovery systems, see Pusher.
+package health
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultTimeout is the default timeout of a check.
+const DefaultTimeout = 5 * time.Second
+
+// Status is the health status of a check or a report.
+type Status string
+
+// Health statuses.
+const (
+	StatusUp   Status = "up"
+	StatusDown Status = "down"
+)
+
+// Checker checks the health of a component. A nil error means healthy.
+type Checker interface {
+	Check(ctx context.Context) error
+}
+
+// CheckerFunc is an adapter to use ordinary functions as Checkers.
+type CheckerFunc func(ctx context.Context) error
+
+// Check implements Checker.
+func (f CheckerFunc) Check(ctx context.Context) error { return f(ctx) }
+
+// Result is the outcome of a single check.
+type Result struct {
+	Status    Status        `json:"status"`
+	Error     string        `json:"error,omitempty"`
+	Duration  time.Duration `json:"duration"`
+	CheckedAt time.Time     `json:"checked_at"`
+}
+
+// Report is the aggregated outcome of a set of checks. Its status is down if
+// any of the checks is down.
+type Report struct {
+	Status Status            `json:"status"`
+	Checks map[string]Result `json:"checks,omitempty"`
+}
+
+// Failed returns the names of the checks that are down, sorted.
+func (r Report) Failed() []string {
+	var names []string
+	for name, result := range r.Checks {
+		if result.Status != StatusUp {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// CheckOption sets an optional parameter for checks.
+type CheckOption func(*check)
+
+// Timeout sets the maximum duration of the check. A check that doesn't
+// return in time is reported down. By default, DefaultTimeout is used.
+func Timeout(d time.Duration) CheckOption {
+	return func(c *check) { c.timeout = d }
+}
+
+// Cache reuses the result of the check for d, instead of running the check
+// for every report. By default, results are not cached.
+func Cache(d time.Duration) CheckOption {
+	return func(c *check) { c.cache = d }
+}
+
+// Liveness makes the check part of the liveness report, in addition to the
+// readiness report.
+func Liveness() CheckOption {
+	return func(c *check) { c.liveness = true }
+}
+
+type check struct {
+	checker  Checker
+	timeout  time.Duration
+	cache    time.Duration
+	liveness bool
+
+	mtx  sync.Mutex
+	last Result
+}
+
+// Health is a set of named checks. It's safe for concurrent use.
+type Health struct {
+	mtx      sync.RWMutex
+	checks   map[string]*check
+	notReady bool
+	timeNow  func() time.Time
+}
+
+// New returns an empty Health, which reports up.
+func New() *Health {
+	return &Health{
+		checks:  map[string]*check{},
+		timeNow: time.Now,
+	}
+}
+
+// Register adds a named check, replacing any check with the same name.
+func (h *Health) Register(name string, checker Checker, opts ...CheckOption) {
+	c := &check{checker: checker, timeout: DefaultTimeout}
+	for _, opt := range opts {
+		opt(c)
+	}
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	h.checks[name] = c
+}
+
+// Deregister removes a named check.
+func (h *Health) Deregister(name string) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	delete(h.checks, name)
+}
+
+// SetReady overrides readiness: while ready is false, readiness reports
+// down regardless of the checks. It's typically used to drain traffic before
+// shutting down.
+func (h *Health) SetReady(ready bool) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	h.notReady = !ready
+}
+
+// Liveness runs the checks registered with the Liveness option.
+func (h *Health) Liveness(ctx context.Context) Report {
+	return h.report(ctx, func(c *check) bool { return c.liveness })
+}
+
+// Readiness runs every check.
+func (h *Health) Readiness(ctx context.Context) Report {
+	report := h.report(ctx, func(*check) bool { return true })
+	h.mtx.RLock()
+	defer h.mtx.RUnlock()
+	if h.notReady {
+		report.Status = StatusDown
+	}
+	return report
+}
+
+// Check runs the named check, and returns false if there is no such check.
+func (h *Health) Check(ctx context.Context, name string) (Result, bool) {
+	h.mtx.RLock()
+	c, ok := h.checks[name]
+	h.mtx.RUnlock()
+	if !ok {
+		return Result{}, false
+	}
+	return h.run(ctx, c), true
+}
+
+func (h *Health) report(ctx context.Context, include func(*check) bool) Report {
+	h.mtx.RLock()
+	checks := make(map[string]*check, len(h.checks))
+	for name, c := range h.checks {
+		if include(c) {
+			checks[name] = c
+		}
+	}
+	h.mtx.RUnlock()
+
+	var (
+		wg      sync.WaitGroup
+		mtx     sync.Mutex
+		results = make(map[string]Result, len(checks))
+	)
+	for name, c := range checks {
+		wg.Add(1)
+		go func(name string, c *check) {
+			defer wg.Done()
+			result := h.run(ctx, c)
+			mtx.Lock()
+			results[name] = result
+			mtx.Unlock()
+		}(name, c)
+	}
+	wg.Wait()
+
+	report := Report{Status: StatusUp, Checks: results}
+	for _, result := range results {
+		if result.Status != StatusUp {
+			report.Status = StatusDown
+		}
+	}
+	return report
+}
+
+func (h *Health) run(ctx context.Context, c *check) Result {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	begin := h.timeNow()
+	if c.cache > 0 && !c.last.CheckedAt.IsZero() && begin.Sub(c.last.CheckedAt) < c.cache {
+		return c.last
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	errc := make(chan error, 1)
+	go func() { errc <- c.checker.Check(ctx) }()
+
+	var err error
+	select {
+	case err = <-errc:
+	case <-ctx.Done():
+		err = fmt.Errorf("check timed out: %w", ctx.Err())
+	}
+
+	result := Result{Status: StatusUp, Duration: h.timeNow().Sub(begin), CheckedAt: begin}
+	if err != nil {
+		result.Status, result.Error = StatusDown, err.Error()
+	}
+	c.last = result
+	return result
+}
@@ -0,0 +1,221 @@
+package health_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/tnnyio/log"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/tnnyio/yoroi/health"
+)
+
+var (
+	up   = health.CheckerFunc(func(context.Context) error { return nil })
+	down = health.CheckerFunc(func(context.Context) error { return errors.New("unreachable") })
+)
+
+func TestReports(t *testing.T) {
+	h := health.New()
+	h.Register("process", up, health.Liveness())
+	h.Register("db", down)
+
+	live := h.Liveness(context.Background())
+	if want, have := health.StatusUp, live.Status; want != have {
+		t.Errorf("liveness: want %s, have %s", want, have)
+	}
+	if _, ok := live.Checks["db"]; ok {
+		t.Error("liveness: readiness check included")
+	}
+
+	ready := h.Readiness(context.Background())
+	if want, have := health.StatusDown, ready.Status; want != have {
+		t.Errorf("readiness: want %s, have %s", want, have)
+	}
+	if want, have := []string{"db"}, ready.Failed(); !reflect.DeepEqual(want, have) {
+		t.Errorf("failed: want %v, have %v", want, have)
+	}
+	if want, have := "unreachable", ready.Checks["db"].Error; want != have {
+		t.Errorf("error: want %q, have %q", want, have)
+	}
+
+	h.Deregister("db")
+	if want, have := health.StatusUp, h.Readiness(context.Background()).Status; want != have {
+		t.Errorf("after Deregister: want %s, have %s", want, have)
+	}
+	h.SetReady(false)
+	if want, have := health.StatusDown, h.Readiness(context.Background()).Status; want != have {
+		t.Errorf("after SetReady(false): want %s, have %s", want, have)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	h := health.New()
+	h.Register("stuck", health.CheckerFunc(func(context.Context) error {
+		<-release // ignores its context
+		return nil
+	}), health.Timeout(10*time.Millisecond))
+
+	result, _ := h.Check(context.Background(), "stuck")
+	if want, have := health.StatusDown, result.Status; want != have {
+		t.Errorf("want %s, have %s", want, have)
+	}
+}
+
+func TestCache(t *testing.T) {
+	var calls int32
+	h := health.New()
+	h.Register("counted", health.CheckerFunc(func(context.Context) error {
+		atomic.AddInt32(&calls, 1)
+		return nil
+	}), health.Cache(time.Hour))
+
+	for i := 0; i < 3; i++ {
+		h.Readiness(context.Background())
+	}
+	if want, have := int32(1), atomic.LoadInt32(&calls); want != have {
+		t.Errorf("want %d calls, have %d", want, have)
+	}
+}
+
+func TestHTTP(t *testing.T) {
+	h := health.New()
+	h.Register("process", up, health.Liveness())
+	h.Register("db", down)
+
+	for _, tc := range []struct {
+		handler http.Handler
+		code    int
+		status  health.Status
+	}{
+		{health.LivenessHandler(h), http.StatusOK, health.StatusUp},
+		{health.ReadinessHandler(h), http.StatusServiceUnavailable, health.StatusDown},
+	} {
+		rec := httptest.NewRecorder()
+		tc.handler.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+		if want, have := tc.code, rec.Code; want != have {
+			t.Errorf("code: want %d, have %d", want, have)
+		}
+		var report health.Report
+		if err := json.NewDecoder(rec.Body).Decode(&report); err != nil {
+			t.Fatal(err)
+		}
+		if want, have := tc.status, report.Status; want != have {
+			t.Errorf("status: want %s, have %s", want, have)
+		}
+	}
+}
+
+func TestGRPC(t *testing.T) {
+	h := health.New()
+	h.Register("db", down)
+
+	var (
+		server = grpc.NewServer()
+		ln     = bufconn.Listen(1 << 16)
+	)
+	healthpb.RegisterHealthServer(server, health.NewGRPCServer(h, 10*time.Millisecond))
+	go server.Serve(ln)
+	defer server.Stop()
+
+	cc, err := grpc.Dial("bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return ln.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cc.Close()
+	client := healthpb.NewHealthClient(cc)
+	ctx := context.Background()
+
+	resp, err := client.Check(ctx, &healthpb.HealthCheckRequest{Service: "db"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, have := healthpb.HealthCheckResponse_NOT_SERVING, resp.Status; want != have {
+		t.Errorf("db: want %s, have %s", want, have)
+	}
+	if _, err := client.Check(ctx, &healthpb.HealthCheckRequest{Service: "nope"}); status.Code(err) != codes.NotFound {
+		t.Errorf("unknown service: want NotFound, have %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	stream, err := client.Watch(ctx, &healthpb.HealthCheckRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp, err = stream.Recv(); err != nil || resp.Status != healthpb.HealthCheckResponse_NOT_SERVING {
+		t.Fatalf("first watch status: have %v, %v", resp, err)
+	}
+	h.Deregister("db")
+	if resp, err = stream.Recv(); err != nil || resp.Status != healthpb.HealthCheckResponse_SERVING {
+		t.Fatalf("second watch status: have %v, %v", resp, err)
+	}
+}
+
+func TestPusher(t *testing.T) {
+	var (
+		mtx      sync.Mutex
+		statuses []health.Status
+		reporter = health.ReporterFunc(func(_ context.Context, r health.Report) error {
+			mtx.Lock()
+			defer mtx.Unlock()
+			statuses = append(statuses, r.Status)
+			return nil
+		})
+		h = health.New()
+		p = health.NewPusher(h, time.Hour, log.NewNopLogger(), reporter)
+	)
+	p.Start()
+	h.SetReady(false)
+	p.Stop()
+
+	mtx.Lock()
+	defer mtx.Unlock()
+	if want, have := []health.Status{health.StatusUp, health.StatusDown}, statuses; !reflect.DeepEqual(want, have) {
+		t.Errorf("want %v, have %v", want, have)
+	}
+}
+
+func TestPusherDefaultInterval(t *testing.T) {
+	for _, interval := range []time.Duration{0, -time.Second} {
+		var (
+			mtx      sync.Mutex
+			statuses []health.Status
+			reporter = health.ReporterFunc(func(_ context.Context, r health.Report) error {
+				mtx.Lock()
+				defer mtx.Unlock()
+				statuses = append(statuses, r.Status)
+				return nil
+			})
+			h = health.New()
+			p = health.NewPusher(h, interval, log.NewNopLogger(), reporter)
+		)
+		p.Start()
+		p.Stop()
+
+		mtx.Lock()
+		if want, have := []health.Status{health.StatusUp, health.StatusUp}, statuses; !reflect.DeepEqual(want, have) {
+			t.Errorf("%v: want %v, have %v", interval, want, have)
+		}
+		mtx.Unlock()
+	}
+}
@@ -0,0 +1,31 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+)
+
+// LivenessHandler returns an http.Handler serving the liveness report as
+// JSON, with status 200 when up and 503 when down.
+func LivenessHandler(h *Health) http.Handler {
+	return reportHandler(h.Liveness)
+}
+
+// ReadinessHandler returns an http.Handler serving the readiness report as
+// JSON, with status 200 when up and 503 when down.
+func ReadinessHandler(h *Health) http.Handler {
+	return reportHandler(h.Readiness)
+}
+
+func reportHandler(report func(context.Context) Report) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rep := report(r.Context())
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		if rep.Status != StatusUp {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(rep)
+	})
+}
@@ -0,0 +1,107 @@
+package health
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/tnnyio/log"
+)
+
+// DefaultPushInterval is the default interval at which a Pusher reports
+// readiness.
+const DefaultPushInterval = 10 * time.Second
+
+// Reporter receives readiness reports, typically to forward them to a
+// service discovery system.
+type Reporter interface {
+	Report(ctx context.Context, report Report) error
+}
+
+// ReporterFunc is an adapter to use ordinary functions as Reporters.
+type ReporterFunc func(ctx context.Context, report Report) error
+
+// Report implements Reporter.
+func (f ReporterFunc) Report(ctx context.Context, report Report) error { return f(ctx, report) }
+
+// Pusher periodically evaluates readiness and pushes the report to
+// reporters. Reports are pushed at every interval, not only on changes, so
+// that reporters can refresh TTL-based checks.
+type Pusher struct {
+	health    *Health
+	interval  time.Duration
+	reporters []Reporter
+	logger    log.Logger
+
+	mtx   sync.Mutex
+	quitc chan chan struct{}
+}
+
+// NewPusher returns a Pusher reporting the readiness of h every interval. If
+// interval isn't positive, DefaultPushInterval is used. Errors returned by
+// reporters are logged.
+func NewPusher(h *Health, interval time.Duration, logger log.Logger, reporters ...Reporter) *Pusher {
+	if interval <= 0 {
+		interval = DefaultPushInterval
+	}
+	return &Pusher{
+		health:    h,
+		interval:  interval,
+		reporters: reporters,
+		logger:    logger,
+	}
+}
+
+// Start pushes a first report, and starts pushing periodically. Calling
+// Start on a started Pusher has no effect.
+func (p *Pusher) Start() {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	if p.quitc != nil {
+		return
+	}
+	p.push()
+	p.quitc = make(chan chan struct{})
+	go p.loop(p.quitc)
+}
+
+// Stop stops pushing reports, after pushing a last one. It's typically
+// called after SetReady(false), to report the instance down before it goes
+// away.
+func (p *Pusher) Stop() {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	if p.quitc == nil {
+		return
+	}
+	q := make(chan struct{})
+	p.quitc <- q
+	<-q
+	p.quitc = nil
+	p.push()
+}
+
+func (p *Pusher) loop(quitc chan chan struct{}) {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			p.push()
+		case q := <-quitc:
+			close(q)
+			return
+		}
+	}
+}
+
+func (p *Pusher) push() {
+	ctx, cancel := context.WithTimeout(context.Background(), p.interval)
+	defer cancel()
+	report := p.health.Readiness(ctx)
+	for _, r := range p.reporters {
+		if err := r.Report(ctx, report); err != nil {
+			p.logger.Log("during", "health push", "err", err)
+		}
+	}
+}
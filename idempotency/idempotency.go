@@ -0,0 +1,251 @@
+// Package idempotency makes retried requests safe, by replaying the outcome
+// of the first request carrying the same idempotency key.
+//
+// HTTPToContext reads the Idempotency-Key header into the request context,
+// and the middleware returned by New stores the response or error of the
+// first request per key, and replays it for every duplicate within the TTL.
+//
+//	store := idempotency.NewMemoryStore[Payment]()
+//	e = idempotency.New[Payment](store)(e)
+//	handler := httptransport.NewServer(e, dec, enc,
+//		httptransport.ServerBefore[Request, Payment](idempotency.HTTPToContext),
+//	)
+//
+// A duplicate that arrives while the first request is still in flight is
+// rejected with ErrInFlight (409 Conflict), unless WaitInFlight is set. A key
+// reused for a different request is rejected with ErrMismatch (422
+// Unprocessable Entity).
+package idempotency
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/tnnyio/log"
+	"github.com/tnnyio/yoroi/endpoint"
+)
+
+// HeaderName is the header carrying the idempotency key.
+const HeaderName = "Idempotency-Key"
+
+// DefaultTTL is the default time records are kept.
+const DefaultTTL = 24 * time.Hour
+
+// Error is returned by the middleware when a request can't be served because
+// of its idempotency key. It is encoded by the HTTP transports with its
+// status code.
+type Error struct {
+	Code    int
+	Message string
+}
+
+// Error implements error.
+func (e Error) Error() string { return e.Message }
+
+// StatusCode implements the StatusCoder interfaces of the HTTP transports.
+func (e Error) StatusCode() int { return e.Code }
+
+var (
+	// ErrInFlight is returned for a duplicate of a request that is still
+	// being processed.
+	ErrInFlight = Error{http.StatusConflict, "a request with the same idempotency key is in progress"}
+
+	// ErrMismatch is returned when an idempotency key is reused for a
+	// different request.
+	ErrMismatch = Error{http.StatusUnprocessableEntity, "idempotency key was used for a different request"}
+
+	// ErrMissingKey is returned when Required is set and a request carries
+	// no idempotency key.
+	ErrMissingKey = Error{http.StatusBadRequest, "missing idempotency key"}
+)
+
+type contextKey int
+
+const keyContextKey contextKey = 0
+
+// ContextWithKey returns a context carrying the idempotency key.
+func ContextWithKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, keyContextKey, key)
+}
+
+// KeyFromContext returns the idempotency key of ctx, if any.
+func KeyFromContext(ctx context.Context) (string, bool) {
+	key, ok := ctx.Value(keyContextKey).(string)
+	return key, ok && key != ""
+}
+
+// HTTPToContext moves the Idempotency-Key header from the request into the
+// context. It can be used as an HTTP transport RequestFunc, e.g. with
+// ServerBefore.
+func HTTPToContext(ctx context.Context, r *http.Request) context.Context {
+	if key := r.Header.Get(HeaderName); key != "" {
+		return ContextWithKey(ctx, key)
+	}
+	return ctx
+}
+
+// FingerprintFunc identifies a request, to detect keys that are reused for
+// different requests.
+type FingerprintFunc func(ctx context.Context, request interface{}) string
+
+// DefaultFingerprint is the SHA-256 hash of the JSON encoding of the request.
+// Requests that can't be encoded as JSON are formatted with %#v instead.
+func DefaultFingerprint(_ context.Context, request interface{}) string {
+	b, err := json.Marshal(request)
+	if err != nil {
+		b = []byte(fmt.Sprintf("%#v", request))
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// Option sets an optional parameter for the idempotency middleware.
+type Option func(*options)
+
+// TTL sets how long the outcome of a request is kept and replayed. By
+// default, DefaultTTL is used.
+func TTL(d time.Duration) Option {
+	return func(o *options) { o.ttl = d }
+}
+
+// Fingerprint sets the function identifying requests. By default,
+// DefaultFingerprint is used.
+func Fingerprint(f FingerprintFunc) Option {
+	return func(o *options) { o.fingerprint = f }
+}
+
+// WaitInFlight makes duplicates of a request that is still in flight wait for
+// its outcome, polling the store at the given interval, instead of failing
+// with ErrInFlight.
+func WaitInFlight(interval time.Duration) Option {
+	return func(o *options) { o.poll = interval }
+}
+
+// Required makes requests without an idempotency key fail with
+// ErrMissingKey. By default, they are passed through.
+func Required() Option {
+	return func(o *options) { o.required = true }
+}
+
+// Logger sets the logger of the store errors that can't be returned to the
+// caller, such as failing to record the outcome of a successful request. By
+// default, they aren't logged.
+func Logger(logger log.Logger) Option {
+	return func(o *options) { o.logger = logger }
+}
+
+type options struct {
+	ttl         time.Duration
+	fingerprint FingerprintFunc
+	poll        time.Duration
+	required    bool
+	logger      log.Logger
+	timeNow     func() time.Time
+}
+
+// New returns an endpoint.Middleware that stores the outcome of the first
+// request for each idempotency key in the store, and replays it for later
+// requests with the same key.
+//
+// The outcome is stored whenever the endpoint returns, even if the context
+// of the first request is done by then, as the side effects of the endpoint
+// may have happened. If the endpoint panics, or if its outcome can't be
+// stored, its record is deleted rather than completed, so that the client
+// can retry.
+func New[O interface{}](store Store[O], opts ...Option) endpoint.Middleware[O] {
+	o := options{
+		ttl:         DefaultTTL,
+		fingerprint: DefaultFingerprint,
+		logger:      log.NewNopLogger(),
+		timeNow:     time.Now,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return func(next endpoint.Endpoint[O]) endpoint.Endpoint[O] {
+		return func(ctx context.Context, request interface{}) (response O, err error) {
+			key, ok := KeyFromContext(ctx)
+			if !ok {
+				if o.required {
+					return response, ErrMissingKey
+				}
+				return next(ctx, request)
+			}
+
+			fp := o.fingerprint(ctx, request)
+			existing, exists, err := store.Reserve(ctx, key, Record[O]{
+				Fingerprint: fp,
+				Expires:     o.timeNow().Add(o.ttl),
+			})
+			if err != nil {
+				return response, err
+			}
+			if exists {
+				return replay(ctx, store, key, fp, existing, o)
+			}
+
+			// Release the reservation unless the outcome is stored, including
+			// when next panics.
+			var stored bool
+			defer func() {
+				if stored {
+					return
+				}
+				if derr := store.Delete(context.WithoutCancel(ctx), key); derr != nil {
+					o.logger.Log("key", key, "during", "delete", "err", derr)
+				}
+			}()
+
+			response, err = next(ctx, request)
+			if serr := store.Set(context.WithoutCancel(ctx), key, Record[O]{
+				Fingerprint: fp,
+				Completed:   true,
+				Response:    response,
+				Err:         err,
+				Expires:     o.timeNow().Add(o.ttl),
+			}); serr != nil {
+				o.logger.Log("key", key, "during", "set", "err", serr)
+				return response, err
+			}
+			stored = true
+			return response, err
+		}
+	}
+}
+
+// replay returns the outcome recorded in r, waiting for it to complete if
+// the middleware is configured to.
+func replay[O interface{}](ctx context.Context, store Store[O], key, fp string, r Record[O], o options) (response O, err error) {
+	for {
+		if r.Fingerprint != fp {
+			return response, ErrMismatch
+		}
+		if r.Completed {
+			return r.Response, r.Err
+		}
+		if o.poll <= 0 {
+			return response, ErrInFlight
+		}
+
+		select {
+		case <-ctx.Done():
+			return response, ctx.Err()
+		case <-time.After(o.poll):
+		}
+
+		var found bool
+		if r, found, err = store.Get(ctx, key); err != nil {
+			return response, err
+		}
+		if !found {
+			// The first request gave up; report the conflict and let the
+			// client retry rather than running the request here.
+			return response, ErrInFlight
+		}
+	}
+}
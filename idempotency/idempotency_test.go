@@ -0,0 +1,249 @@
+package idempotency_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/tnnyio/log"
+	"github.com/tnnyio/yoroi/endpoint"
+	"github.com/tnnyio/yoroi/idempotency"
+	httptransport "github.com/tnnyio/yoroi/transport/http"
+)
+
+type payment struct {
+	Amount int `json:"amount"`
+}
+
+// counter returns an endpoint answering with the number of times it was
+// called, and the error returned by fail, if any.
+func counter(calls *int32, fail func() error) endpoint.Endpoint[int32] {
+	return func(context.Context, interface{}) (int32, error) {
+		n := atomic.AddInt32(calls, 1)
+		if fail != nil {
+			return n, fail()
+		}
+		return n, nil
+	}
+}
+
+func withKey(key string) context.Context {
+	return idempotency.ContextWithKey(context.Background(), key)
+}
+
+func TestReplay(t *testing.T) {
+	var (
+		calls int32
+		e     = idempotency.New[int32](idempotency.NewMemoryStore[int32]())(counter(&calls, nil))
+	)
+	for i := 0; i < 3; i++ {
+		response, err := e(withKey("a"), payment{Amount: 10})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want, have := int32(1), response; want != have {
+			t.Errorf("call %d: want response %d, have %d", i, want, have)
+		}
+	}
+	if response, _ := e(withKey("b"), payment{Amount: 10}); response != 2 {
+		t.Errorf("other key: want response 2, have %d", response)
+	}
+}
+
+func TestReplayError(t *testing.T) {
+	var (
+		calls   int32
+		errFail = errors.New("card declined")
+		e       = idempotency.New[int32](idempotency.NewMemoryStore[int32]())(counter(&calls, func() error { return errFail }))
+	)
+	for i := 0; i < 2; i++ {
+		if _, err := e(withKey("a"), payment{Amount: 10}); err != errFail {
+			t.Errorf("call %d: want %v, have %v", i, errFail, err)
+		}
+	}
+	if want, have := int32(1), atomic.LoadInt32(&calls); want != have {
+		t.Errorf("calls: want %d, have %d", want, have)
+	}
+}
+
+func TestNoKey(t *testing.T) {
+	var calls int32
+	e := idempotency.New[int32](idempotency.NewMemoryStore[int32]())(counter(&calls, nil))
+	e(context.Background(), payment{})
+	e(context.Background(), payment{})
+	if want, have := int32(2), atomic.LoadInt32(&calls); want != have {
+		t.Errorf("calls: want %d, have %d", want, have)
+	}
+
+	e = idempotency.New[int32](idempotency.NewMemoryStore[int32](), idempotency.Required())(counter(&calls, nil))
+	if _, err := e(context.Background(), payment{}); err != idempotency.ErrMissingKey {
+		t.Errorf("want %v, have %v", idempotency.ErrMissingKey, err)
+	}
+}
+
+func TestMismatch(t *testing.T) {
+	var calls int32
+	e := idempotency.New[int32](idempotency.NewMemoryStore[int32]())(counter(&calls, nil))
+	e(withKey("a"), payment{Amount: 10})
+	if _, err := e(withKey("a"), payment{Amount: 20}); err != idempotency.ErrMismatch {
+		t.Errorf("want %v, have %v", idempotency.ErrMismatch, err)
+	}
+}
+
+func TestInFlight(t *testing.T) {
+	var (
+		started = make(chan struct{})
+		release = make(chan struct{})
+		slow    = func(context.Context, interface{}) (string, error) {
+			close(started)
+			<-release
+			return "done", nil
+		}
+		store = idempotency.NewMemoryStore[string]()
+	)
+	go idempotency.New[string](store)(slow)(withKey("a"), payment{})
+	<-started
+
+	_, err := idempotency.New[string](store)(slow)(withKey("a"), payment{})
+	if err != idempotency.ErrInFlight {
+		t.Fatalf("want %v, have %v", idempotency.ErrInFlight, err)
+	}
+	var sc httptransport.StatusCoder
+	if !errors.As(err, &sc) || sc.StatusCode() != http.StatusConflict {
+		t.Errorf("want status %d", http.StatusConflict)
+	}
+
+	result := make(chan string)
+	go func() {
+		response, _ := idempotency.New[string](store, idempotency.WaitInFlight(time.Millisecond))(slow)(withKey("a"), payment{})
+		result <- response
+	}()
+	close(release)
+	if want, have := "done", <-result; want != have {
+		t.Errorf("waiting duplicate: want %q, have %q", want, have)
+	}
+}
+
+func TestCanceledFirstRequest(t *testing.T) {
+	var (
+		calls       int32
+		ctx, cancel = context.WithCancel(withKey("a"))
+		e           = idempotency.New[int32](idempotency.NewMemoryStore[int32]())(func(ctx context.Context, request interface{}) (int32, error) {
+			// The payment goes through, but the client is gone by then.
+			defer cancel()
+			return counter(&calls, nil)(ctx, request)
+		})
+	)
+	if response, err := e(ctx, payment{}); response != 1 || err != nil {
+		t.Fatalf("want response 1, have %d (%v)", response, err)
+	}
+	if response, err := e(withKey("a"), payment{}); response != 1 || err != nil {
+		t.Errorf("retry: want the stored response 1, have %d (%v)", response, err)
+	}
+	if want, have := int32(1), atomic.LoadInt32(&calls); want != have {
+		t.Errorf("calls: want %d, have %d", want, have)
+	}
+}
+
+func TestPanickingFirstRequest(t *testing.T) {
+	var (
+		calls int32
+		store = idempotency.NewMemoryStore[int32]()
+		e     = idempotency.New[int32](store)(func(ctx context.Context, request interface{}) (int32, error) {
+			if atomic.AddInt32(&calls, 1) == 1 {
+				panic("boom")
+			}
+			return calls, nil
+		})
+	)
+	func() {
+		defer func() { recover() }()
+		e(withKey("a"), payment{})
+	}()
+	if want, have := 0, store.Len(); want != have {
+		t.Fatalf("records: want %d, have %d", want, have)
+	}
+	if response, err := e(withKey("a"), payment{}); response != 2 || err != nil {
+		t.Errorf("retry: want response 2, have %d (%v)", response, err)
+	}
+}
+
+// failingStore fails to store outcomes.
+type failingStore struct {
+	*idempotency.MemoryStore[int32]
+}
+
+func (failingStore) Set(context.Context, string, idempotency.Record[int32]) error {
+	return errors.New("store unavailable")
+}
+
+func TestFailedSet(t *testing.T) {
+	var (
+		calls  int32
+		store  = failingStore{idempotency.NewMemoryStore[int32]()}
+		logged int32
+		logger = log.LoggerFunc(func(...interface{}) error { atomic.AddInt32(&logged, 1); return nil })
+		e      = idempotency.New[int32](store, idempotency.Logger(logger))(counter(&calls, nil))
+	)
+	if response, err := e(withKey("a"), payment{}); response != 1 || err != nil {
+		t.Errorf("want response 1, have %d (%v)", response, err)
+	}
+	if want, have := int32(1), atomic.LoadInt32(&logged); want != have {
+		t.Errorf("logged errors: want %d, have %d", want, have)
+	}
+
+	// The reservation is released, rather than stuck in flight.
+	if want, have := 0, store.Len(); want != have {
+		t.Fatalf("records: want %d, have %d", want, have)
+	}
+}
+
+func TestTTL(t *testing.T) {
+	var calls int32
+	e := idempotency.New[int32](idempotency.NewMemoryStore[int32](), idempotency.TTL(10*time.Millisecond))(counter(&calls, nil))
+	e(withKey("a"), payment{})
+	time.Sleep(20 * time.Millisecond)
+	if response, _ := e(withKey("a"), payment{}); response != 2 {
+		t.Errorf("after TTL: want response 2, have %d", response)
+	}
+}
+
+func TestHTTPServer(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(httptransport.NewServer(
+		idempotency.New[int32](idempotency.NewMemoryStore[int32]())(counter(&calls, nil)),
+		func(_ context.Context, r *http.Request) (interface{}, error) {
+			return payment{Amount: len(r.URL.Query().Get("amount"))}, nil
+		},
+		httptransport.EncodeJSONResponse[int32],
+		httptransport.ServerBefore[interface{}, int32](idempotency.HTTPToContext),
+	))
+	defer server.Close()
+
+	post := func(key, amount string) (int, string) {
+		req, _ := http.NewRequest("POST", server.URL+"?amount="+amount, nil)
+		req.Header.Set(idempotency.HeaderName, key)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, strings.TrimSpace(string(b))
+	}
+
+	for i := 0; i < 2; i++ {
+		if code, body := post("k", "10"); code != http.StatusOK || body != "1" {
+			t.Errorf("request %d: want 200 1, have %d %s", i, code, body)
+		}
+	}
+	if code, _ := post("k", "1000"); code != http.StatusUnprocessableEntity {
+		t.Errorf("mismatch: want %d, have %d", http.StatusUnprocessableEntity, code)
+	}
+}
@@ -0,0 +1,125 @@
+package idempotency
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Record is the state of an idempotency key.
+type Record[O interface{}] struct {
+	// Fingerprint identifies the request that first used the key.
+	Fingerprint string
+
+	// Completed is false while the first request is in flight. Once it is
+	// true, Response and Err hold its outcome.
+	Completed bool
+	Response  O
+	Err       error
+
+	// Expires is the time after which the key may be reused. Stores may
+	// evict the record once it has passed.
+	Expires time.Time
+}
+
+// Store is the storage backend of the idempotency middleware.
+// Implementations must be safe for concurrent use, and Reserve must be
+// atomic, even across processes for shared stores.
+type Store[O interface{}] interface {
+	// Reserve stores r for key, unless an unexpired record already exists,
+	// in which case that record is returned with exists set to true.
+	Reserve(ctx context.Context, key string, r Record[O]) (existing Record[O], exists bool, err error)
+
+	// Get returns the record for key, and whether it was found.
+	Get(ctx context.Context, key string) (Record[O], bool, error)
+
+	// Set stores the record for key, replacing any previous record.
+	Set(ctx context.Context, key string, r Record[O]) error
+
+	// Delete removes the record for key, if any.
+	Delete(ctx context.Context, key string) error
+}
+
+// sweepInterval is how often MemoryStore drops expired records.
+const sweepInterval = time.Minute
+
+// MemoryStore is an in-memory Store. Expired records are dropped on access,
+// and periodically swept when new keys are reserved.
+type MemoryStore[O interface{}] struct {
+	mtx       sync.Mutex
+	records   map[string]Record[O]
+	nextSweep time.Time
+	timeNow   func() time.Time
+}
+
+// NewMemoryStore returns an empty MemoryStore.
+func NewMemoryStore[O interface{}]() *MemoryStore[O] {
+	return &MemoryStore[O]{
+		records: map[string]Record[O]{},
+		timeNow: time.Now,
+	}
+}
+
+// Reserve implements Store.
+func (s *MemoryStore[O]) Reserve(_ context.Context, key string, r Record[O]) (Record[O], bool, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	now := s.timeNow()
+	if now.After(s.nextSweep) {
+		for k, existing := range s.records {
+			if now.After(existing.Expires) {
+				delete(s.records, k)
+			}
+		}
+		s.nextSweep = now.Add(sweepInterval)
+	}
+	if existing, ok := s.get(key, now); ok {
+		return existing, true, nil
+	}
+	s.records[key] = r
+	return Record[O]{}, false, nil
+}
+
+// Get implements Store.
+func (s *MemoryStore[O]) Get(_ context.Context, key string) (Record[O], bool, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	r, ok := s.get(key, s.timeNow())
+	return r, ok, nil
+}
+
+// Set implements Store.
+func (s *MemoryStore[O]) Set(_ context.Context, key string, r Record[O]) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.records[key] = r
+	return nil
+}
+
+// Delete implements Store.
+func (s *MemoryStore[O]) Delete(_ context.Context, key string) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	delete(s.records, key)
+	return nil
+}
+
+// Len returns the number of records currently held by the store.
+func (s *MemoryStore[O]) Len() int {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return len(s.records)
+}
+
+func (s *MemoryStore[O]) get(key string, now time.Time) (Record[O], bool) {
+	r, ok := s.records[key]
+	if !ok {
+		return r, false
+	}
+	if now.After(r.Expires) {
+		delete(s.records, key)
+		return Record[O]{}, false
+	}
+	return r, true
+}
@@ -0,0 +1,135 @@
+// Package instrumentation records rate, errors and duration (RED) metrics for
+// endpoints and transports.
+//
+// Instruments are created once per service from a provider.Provider, and
+// shared between the endpoint middleware and the transport options in this
+// package. All of them record into the same three metrics, distinguished by
+// the following labels.
+//
+//	layer    where the call was observed, e.g. "endpoint", "http_server" or
+//	         "grpc_client"
+//	method   the endpoint name, the HTTP method, or the gRPC or JSON RPC method
+//	route    the HTTP route given to the transport option, if any
+//	code     the HTTP status code, the gRPC code, or the JSON RPC error code
+//
+// For example,
+//
+//	ins := instrumentation.New(provider.NewPrometheusProvider("shop", "orders"))
+//	e = instrumentation.Endpoint[Response](ins, "create_order")(e)
+//	handler := httptransport.NewServer(e, dec, enc,
+//		instrumentation.HTTPServer[Request, Response](ins, "/orders"),
+//	)
+package instrumentation
+
+import (
+	"context"
+	"time"
+
+	"github.com/tnnyio/yoroi/endpoint"
+	"github.com/tnnyio/yoroi/metrics"
+	"github.com/tnnyio/yoroi/metrics/provider"
+)
+
+// Metric names.
+const (
+	RequestsName = "requests_total"
+	ErrorsName   = "request_errors_total"
+	DurationName = "request_duration_seconds"
+)
+
+// Label names.
+const (
+	LabelLayer  = "layer"
+	LabelMethod = "method"
+	LabelRoute  = "route"
+	LabelCode   = "code"
+)
+
+// LabelNames are the label names of every metric, in the order in which
+// their values are passed to With.
+var LabelNames = []string{LabelLayer, LabelMethod, LabelRoute, LabelCode}
+
+// Code label values of endpoints.
+const (
+	CodeOK    = "OK"
+	CodeError = "error"
+)
+
+// DefaultBuckets is the default number of buckets of the duration histogram,
+// for the providers that use it.
+const DefaultBuckets = 50
+
+// Instruments holds the metrics recorded by the middleware and transport
+// options of this package.
+type Instruments struct {
+	requests metrics.Counter
+	errors   metrics.Counter
+	duration metrics.Histogram
+}
+
+// Option sets an optional parameter for Instruments.
+type Option func(*options)
+
+// Buckets sets the number of buckets of the duration histogram. By default,
+// DefaultBuckets is used.
+func Buckets(n int) Option {
+	return func(o *options) { o.buckets = n }
+}
+
+type options struct {
+	buckets int
+}
+
+// New creates the metrics of the Instruments with the provider. It must be
+// called only once per provider, since some backends, like Prometheus,
+// refuse to register the same metric twice. If the provider implements
+// provider.LabeledProvider, the metrics are created with the LabelNames.
+func New(p provider.Provider, opts ...Option) *Instruments {
+	o := options{buckets: DefaultBuckets}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if lp, ok := p.(provider.LabeledProvider); ok {
+		return &Instruments{
+			requests: lp.NewLabeledCounter(RequestsName, LabelNames),
+			errors:   lp.NewLabeledCounter(ErrorsName, LabelNames),
+			duration: lp.NewLabeledHistogram(DurationName, o.buckets, LabelNames),
+		}
+	}
+	return &Instruments{
+		requests: p.NewCounter(RequestsName),
+		errors:   p.NewCounter(ErrorsName),
+		duration: p.NewHistogram(DurationName, o.buckets),
+	}
+}
+
+// Observe records a single call. The duration is only recorded if it's
+// positive, i.e. if the start of the call is known.
+func (ins *Instruments) Observe(layer, method, route, code string, failed bool, took time.Duration) {
+	lvs := []string{LabelLayer, layer, LabelMethod, method, LabelRoute, route, LabelCode, code}
+	ins.requests.With(lvs...).Add(1)
+	if failed {
+		ins.errors.With(lvs...).Add(1)
+	}
+	if took > 0 {
+		ins.duration.With(lvs...).Observe(took.Seconds())
+	}
+}
+
+// Endpoint returns an endpoint.Middleware that records every call to the
+// endpoint with the given name, with the layer "endpoint". The code is
+// CodeError if the endpoint returned an error, and CodeOK otherwise.
+func Endpoint[O interface{}](ins *Instruments, name string) endpoint.Middleware[O] {
+	return func(next endpoint.Endpoint[O]) endpoint.Endpoint[O] {
+		return func(ctx context.Context, request interface{}) (O, error) {
+			begin := time.Now()
+			response, err := next(ctx, request)
+			code := CodeOK
+			if err != nil {
+				code = CodeError
+			}
+			ins.Observe("endpoint", name, "", code, err != nil, time.Since(begin))
+			return response, err
+		}
+	}
+}
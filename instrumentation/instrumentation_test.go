@@ -0,0 +1,244 @@
+package instrumentation_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	stdprometheus "github.com/prometheus/client_golang/prometheus"
+	fh "github.com/valyala/fasthttp"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/tnnyio/yoroi/endpoint"
+	"github.com/tnnyio/yoroi/instrumentation"
+	"github.com/tnnyio/yoroi/metrics"
+	"github.com/tnnyio/yoroi/metrics/provider"
+	fasthttptransport "github.com/tnnyio/yoroi/transport/fasthttp"
+	"github.com/tnnyio/yoroi/transport/fasthttp/fasthttptest"
+	grpctransport "github.com/tnnyio/yoroi/transport/grpc"
+	httptransport "github.com/tnnyio/yoroi/transport/http"
+	"github.com/tnnyio/yoroi/transport/http/jsonrpc"
+)
+
+// recorder is a Provider that records the values of its metrics per name and
+// label values.
+type recorder struct {
+	mtx    sync.Mutex
+	values map[string]float64
+}
+
+func newRecorder() *recorder { return &recorder{values: map[string]float64{}} }
+
+func (r *recorder) add(name string, lvs []string, delta float64) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.values[key(name, lvs...)] += delta
+}
+
+func (r *recorder) get(name string, lvs ...string) float64 {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	return r.values[key(name, lvs...)]
+}
+
+func key(name string, lvs ...string) string {
+	return name + "{" + strings.Join(lvs, ",") + "}"
+}
+
+func (r *recorder) NewCounter(name string) metrics.Counter { return metric{r, name, nil} }
+func (r *recorder) NewGauge(string) metrics.Gauge          { panic("not implemented") }
+func (r *recorder) NewHistogram(name string, _ int) metrics.Histogram {
+	return histogram{metric{r, name, nil}}
+}
+func (r *recorder) Stop() {}
+
+type metric struct {
+	r    *recorder
+	name string
+	lvs  []string
+}
+
+func (m metric) With(lvs ...string) metrics.Counter { return metric{m.r, m.name, labelValues(lvs)} }
+func (m metric) Add(delta float64)                  { m.r.add(m.name, m.lvs, delta) }
+
+// labelValues drops the label names of the label name-value pairs.
+func labelValues(lvs []string) []string {
+	var values []string
+	for i := 1; i < len(lvs); i += 2 {
+		values = append(values, lvs[i])
+	}
+	return values
+}
+
+// histogram counts its observations.
+type histogram struct{ metric }
+
+func (h histogram) Observe(float64) { h.Add(1) }
+
+func (h histogram) With(lvs ...string) metrics.Histogram {
+	return histogram{metric{h.r, h.name, labelValues(lvs)}}
+}
+
+func newInstruments() (*recorder, *instrumentation.Instruments) {
+	r := newRecorder()
+	return r, instrumentation.New(r)
+}
+
+func assertCall(t *testing.T, r *recorder, failed bool, lvs ...string) {
+	t.Helper()
+	want := map[string]float64{instrumentation.RequestsName: 1, instrumentation.DurationName: 1}
+	if failed {
+		want[instrumentation.ErrorsName] = 1
+	}
+	for _, name := range []string{instrumentation.RequestsName, instrumentation.ErrorsName, instrumentation.DurationName} {
+		if want, have := want[name], r.get(name, lvs...); want != have {
+			t.Errorf("%s: want %v, have %v (recorded %v)", key(name, lvs...), want, have, r.values)
+		}
+	}
+}
+
+func TestEndpoint(t *testing.T) {
+	r, ins := newInstruments()
+	e := instrumentation.Endpoint[string](ins, "greet")(func(_ context.Context, request interface{}) (string, error) {
+		if request == nil {
+			return "", errors.New("no request")
+		}
+		return "hello", nil
+	})
+
+	e(context.Background(), struct{}{})
+	assertCall(t, r, false, "endpoint", "greet", "", instrumentation.CodeOK)
+	e(context.Background(), nil)
+	assertCall(t, r, true, "endpoint", "greet", "", instrumentation.CodeError)
+}
+
+func TestHTTP(t *testing.T) {
+	r, ins := newInstruments()
+	server := httptest.NewServer(httptransport.NewServer(
+		func(context.Context, interface{}) (string, error) { return "", errors.New("boom") },
+		func(context.Context, *http.Request) (interface{}, error) { return nil, nil },
+		httptransport.EncodeJSONResponse[string],
+		instrumentation.HTTPServer[interface{}, string](ins, "/greet"),
+	))
+	defer server.Close()
+
+	u, _ := url.Parse(server.URL + "/greet")
+	client := httptransport.NewClient(
+		"GET", u,
+		func(context.Context, *http.Request, interface{}) error { return nil },
+		func(context.Context, *http.Response) (string, error) { return "", nil },
+		instrumentation.HTTPClient[interface{}, string](ins, "/greet"),
+	)
+	if _, err := client.Endpoint()(context.Background(), nil); err != nil {
+		t.Fatal(err)
+	}
+	server.Close() // waits for the finalizer
+
+	assertCall(t, r, true, "http_server", "GET", "/greet", "500")
+	assertCall(t, r, true, "http_client", "GET", "/greet", "500")
+}
+
+func TestFastHTTP(t *testing.T) {
+	r, ins := newInstruments()
+	server := fasthttptest.FastServer(t, fasthttptransport.NewServer(
+		func(context.Context, interface{}) (interface{}, error) { return nil, nil },
+		func(*fh.RequestCtx) (interface{}, error) { return nil, nil },
+		func(*fh.RequestCtx, interface{}) error { return nil },
+		instrumentation.FastHTTPServer[interface{}, interface{}](ins, "/greet"),
+	))
+	defer server.Close()
+
+	client := fasthttptransport.NewClient[interface{}, interface{}](
+		"POST",
+		fasthttptransport.URI{Host: server.URL, Path: "/greet"},
+		func(*fh.Request, interface{}) error { return nil },
+		func(*fh.Response) (interface{}, error) { return nil, nil },
+		instrumentation.FastHTTPClient[interface{}, interface{}](ins, "/greet"),
+	)
+	if _, err := client.Call(context.Background(), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	assertCall(t, r, false, "fasthttp_server", "POST", "/greet", "200")
+	assertCall(t, r, false, "fasthttp_client", "POST", "/greet", "200")
+}
+
+func TestGRPCServer(t *testing.T) {
+	r, ins := newInstruments()
+	server := grpctransport.NewServer(
+		func(context.Context, interface{}) (string, error) {
+			return "", status.Error(codes.NotFound, "no such greeting")
+		},
+		func(context.Context, interface{}) (interface{}, error) { return nil, nil },
+		func(_ context.Context, response string) (interface{}, error) { return response, nil },
+		instrumentation.GRPCServer[interface{}, string](ins),
+	)
+	ctx := context.WithValue(context.Background(), grpctransport.ContextKeyRequestMethod, "/greeter/Greet")
+	server.ServeGRPC(ctx, nil)
+
+	assertCall(t, r, true, "grpc_server", "/greeter/Greet", "", "NotFound")
+}
+
+func TestJSONRPC(t *testing.T) {
+	r, ins := newInstruments()
+	server := httptest.NewServer(jsonrpc.NewServer(
+		jsonrpc.EndpointCodecMap{
+			"greet": jsonrpc.EndpointCodec{
+				Endpoint: endpoint.Nop,
+				Decode:   func(context.Context, json.RawMessage) (interface{}, error) { return nil, nil },
+				Encode:   func(context.Context, interface{}) (json.RawMessage, error) { return json.RawMessage(`"hello"`), nil },
+			},
+		},
+		instrumentation.JSONRPCServer(ins, "/rpc"),
+	))
+	defer server.Close()
+
+	u, _ := url.Parse(server.URL + "/rpc")
+	for _, method := range []string{"greet", "shout"} {
+		client := jsonrpc.NewClient[interface{}, string](u, method, instrumentation.JSONRPCClient[interface{}, string](ins, "/rpc"))
+		client.Endpoint()(context.Background(), struct{}{})
+	}
+	server.Close() // waits for the finalizer
+
+	assertCall(t, r, false, "jsonrpc_server", "greet", "/rpc", instrumentation.CodeOK)
+	assertCall(t, r, false, "jsonrpc_client", "greet", "/rpc", instrumentation.CodeOK)
+	assertCall(t, r, true, "jsonrpc_server", "shout", "/rpc", "-32601")
+	assertCall(t, r, true, "jsonrpc_client", "shout", "/rpc", "-32601")
+}
+
+// prometheusInstruments are created once, as Prometheus metrics can only be
+// registered once.
+var prometheusInstruments = sync.OnceValue(func() *instrumentation.Instruments {
+	return instrumentation.New(provider.NewPrometheusProvider("instrumentation", "test"))
+})
+
+func TestPrometheusLabels(t *testing.T) {
+	ins := prometheusInstruments()
+	ins.Observe("endpoint", "greet", "", instrumentation.CodeOK, false, 0)
+
+	families, err := stdprometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, family := range families {
+		if family.GetName() != "instrumentation_test_"+instrumentation.RequestsName {
+			continue
+		}
+		labels := map[string]string{}
+		for _, pair := range family.GetMetric()[0].GetLabel() {
+			labels[pair.GetName()] = pair.GetValue()
+		}
+		if want, have := "greet", labels[instrumentation.LabelMethod]; want != have {
+			t.Errorf("method: want %q, have %q", want, have)
+		}
+		return
+	}
+	t.Fatal("requests metric not registered")
+}
@@ -0,0 +1,282 @@
+package instrumentation
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	fh "github.com/valyala/fasthttp"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	fasthttptransport "github.com/tnnyio/yoroi/transport/fasthttp"
+	grpctransport "github.com/tnnyio/yoroi/transport/grpc"
+	httptransport "github.com/tnnyio/yoroi/transport/http"
+	"github.com/tnnyio/yoroi/transport/http/jsonrpc"
+)
+
+// call tracks a request from the before to the finalizer functions of a
+// transport.
+type call struct {
+	mtx    sync.Mutex
+	begin  time.Time
+	method string
+	code   string
+	err    error
+}
+
+type callKeyType struct{}
+
+var callKey callKeyType
+
+func startCall(ctx context.Context) context.Context {
+	return context.WithValue(ctx, callKey, &call{begin: time.Now()})
+}
+
+// callFrom returns the call of ctx, or a call of unknown duration if the
+// request failed before it was started.
+func callFrom(ctx context.Context) *call {
+	if c, ok := ctx.Value(callKey).(*call); ok {
+		return c
+	}
+	return &call{}
+}
+
+func (c *call) set(f func(c *call)) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	f(c)
+}
+
+func (c *call) took() time.Duration {
+	if c.begin.IsZero() {
+		return 0
+	}
+	return time.Since(c.begin)
+}
+
+// HTTPServer returns a ServerOption that records every request served under
+// the given route, with the layer "http_server". Requests answered with a
+// status code of 500 or above are counted as errors.
+func HTTPServer[I, O interface{}](ins *Instruments, route string) httptransport.ServerOption[I, O] {
+	serverBefore := httptransport.ServerBefore[I, O](
+		func(ctx context.Context, _ *http.Request) context.Context { return startCall(ctx) },
+	)
+
+	serverFinalizer := httptransport.ServerFinalizer[I, O](
+		func(ctx context.Context, code int, r *http.Request) {
+			ins.Observe("http_server", r.Method, route, strconv.Itoa(code), code >= http.StatusInternalServerError, callFrom(ctx).took())
+		},
+	)
+
+	return func(s *httptransport.Server[I, O]) {
+		serverBefore(s)
+		serverFinalizer(s)
+	}
+}
+
+// HTTPClient returns a ClientOption that records every request sent to the
+// given route, with the layer "http_client". Requests that fail, or that are
+// answered with a status code of 500 or above, are counted as errors. The
+// code is empty if no response was received.
+func HTTPClient[I, O interface{}](ins *Instruments, route string) httptransport.ClientOption[I, O] {
+	clientBefore := httptransport.ClientBefore[I, O](
+		func(ctx context.Context, r *http.Request) context.Context {
+			ctx = startCall(ctx)
+			callFrom(ctx).method = r.Method
+			return ctx
+		},
+	)
+
+	clientAfter := httptransport.ClientAfter[I, O](
+		func(ctx context.Context, r *http.Response) context.Context {
+			callFrom(ctx).set(func(c *call) { c.code = strconv.Itoa(r.StatusCode) })
+			return ctx
+		},
+	)
+
+	clientFinalizer := httptransport.ClientFinalizer[I, O](
+		func(ctx context.Context, err error) {
+			c := callFrom(ctx)
+			c.mtx.Lock()
+			defer c.mtx.Unlock()
+			code, _ := strconv.Atoi(c.code)
+			ins.Observe("http_client", c.method, route, c.code, err != nil || code >= http.StatusInternalServerError, c.took())
+		},
+	)
+
+	return func(c *httptransport.Client[I, O]) {
+		clientBefore(c)
+		clientAfter(c)
+		clientFinalizer(c)
+	}
+}
+
+// FastHTTPServer returns a ServerOption that records every request served
+// under the given route, with the layer "fasthttp_server". Requests answered
+// with a status code of 500 or above are counted as errors.
+func FastHTTPServer[I, O interface{}](ins *Instruments, route string) fasthttptransport.ServerOption[I, O] {
+	serverBefore := fasthttptransport.ServerBefore[I, O](
+		func(ctx *fh.RequestCtx) { ctx.SetUserValue(callKey, &call{begin: time.Now()}) },
+	)
+
+	serverFinalizer := fasthttptransport.ServerFinalizer[I, O](
+		func(ctx *fh.RequestCtx) {
+			code := ctx.Response.StatusCode()
+			ins.Observe("fasthttp_server", string(ctx.Method()), route, strconv.Itoa(code), code >= fh.StatusInternalServerError, callFrom(ctx).took())
+		},
+	)
+
+	return combine(serverBefore, serverFinalizer)
+}
+
+// FastHTTPClient returns a ClientOption that records every request sent to
+// the given route, with the layer "fasthttp_client". Requests that fail, or
+// that are answered with a status code of 500 or above, are counted as
+// errors. The code is empty if no response was received.
+func FastHTTPClient[I, O interface{}](ins *Instruments, route string) fasthttptransport.ClientOption[I, O] {
+	clientBefore := fasthttptransport.ClientBefore[I, O](
+		func(ctx context.Context, _ *fh.Request) context.Context { return startCall(ctx) },
+	)
+
+	clientFinalizer := fasthttptransport.ClientFinalizer[I, O](
+		func(ctx context.Context, err error) {
+			var (
+				method, _ = ctx.Value(fasthttptransport.ContextKeyRequestMethod).(string)
+				code      string
+				failed    = err != nil
+			)
+			if header, ok := ctx.Value(fasthttptransport.ContextKeyResponseHeaders).(*fh.ResponseHeader); ok {
+				code = strconv.Itoa(header.StatusCode())
+				failed = failed || header.StatusCode() >= fh.StatusInternalServerError
+			}
+			ins.Observe("fasthttp_client", method, route, code, failed, callFrom(ctx).took())
+		},
+	)
+
+	return combine(clientBefore, clientFinalizer)
+}
+
+// GRPCServer returns a ServerOption that records every request, with the
+// layer "grpc_server". The method is taken from the context key populated by
+// grpctransport.Interceptor. Requests that return an error are counted as
+// errors.
+func GRPCServer[I, O interface{}](ins *Instruments) grpctransport.ServerOption[I, O] {
+	serverBefore := grpctransport.ServerBefore[I, O](
+		func(ctx context.Context, _ metadata.MD) context.Context { return startCall(ctx) },
+	)
+
+	serverFinalizer := grpctransport.ServerFinalizer[I, O](
+		func(ctx context.Context, err error) {
+			method, _ := ctx.Value(grpctransport.ContextKeyRequestMethod).(string)
+			ins.Observe("grpc_server", method, "", status.Code(err).String(), err != nil, callFrom(ctx).took())
+		},
+	)
+
+	return func(s *grpctransport.Server[I, O]) {
+		serverBefore(s)
+		serverFinalizer(s)
+	}
+}
+
+// GRPCClient returns a ClientOption that records every request, with the
+// layer "grpc_client". Requests that return an error are counted as errors.
+func GRPCClient[I, O interface{}](ins *Instruments) grpctransport.ClientOption[I, O] {
+	clientBefore := grpctransport.ClientBefore[I, O](
+		func(ctx context.Context, _ *metadata.MD) context.Context { return startCall(ctx) },
+	)
+
+	clientFinalizer := grpctransport.ClientFinalizer[I, O](
+		func(ctx context.Context, err error) {
+			method, _ := ctx.Value(grpctransport.ContextKeyRequestMethod).(string)
+			ins.Observe("grpc_client", method, "", status.Code(err).String(), err != nil, callFrom(ctx).took())
+		},
+	)
+
+	return func(c *grpctransport.Client[I, O]) {
+		clientBefore(c)
+		clientFinalizer(c)
+	}
+}
+
+// JSONRPCServer returns a ServerOption that records every request served
+// under the given route, with the layer "jsonrpc_server". Requests answered
+// with a JSON RPC error are counted as errors, and labelled with its code.
+func JSONRPCServer(ins *Instruments, route string) jsonrpc.ServerOption {
+	serverBefore := jsonrpc.ServerBefore(
+		func(ctx context.Context, _ *http.Request) context.Context { return startCall(ctx) },
+	)
+
+	serverErrorEncoder := jsonrpc.ServerErrorEncoderMiddleware(JSONRPCErrorEncoder)
+
+	serverFinalizer := jsonrpc.ServerFinalizer(
+		func(ctx context.Context, _ int, _ *http.Request) {
+			method, _ := ctx.Value(jsonrpc.ContextKeyRequestMethod).(string)
+			c := callFrom(ctx)
+			c.mtx.Lock()
+			defer c.mtx.Unlock()
+			ins.Observe("jsonrpc_server", method, route, jsonrpcCode(c.err), c.err != nil, c.took())
+		},
+	)
+
+	return func(s *jsonrpc.Server) {
+		serverBefore(s)
+		serverErrorEncoder(s)
+		serverFinalizer(s)
+	}
+}
+
+// JSONRPCErrorEncoder wraps a JSON RPC error encoder, so that the errors it
+// encodes are observed by JSONRPCServer. JSONRPCServer applies it to the
+// error encoder of the server, whichever option sets it.
+func JSONRPCErrorEncoder(next httptransport.ErrorEncoder) httptransport.ErrorEncoder {
+	return func(ctx context.Context, err error, w http.ResponseWriter) {
+		callFrom(ctx).set(func(c *call) { c.err = err })
+		next(ctx, err, w)
+	}
+}
+
+// JSONRPCClient returns a ClientOption that records every request sent to the
+// given route, with the layer "jsonrpc_client". Requests that fail are
+// counted as errors, and labelled with the JSON RPC error code.
+func JSONRPCClient[I, O interface{}](ins *Instruments, route string) jsonrpc.ClientOption[I, O] {
+	clientBefore := jsonrpc.ClientBefore[I, O](
+		func(ctx context.Context, _ *http.Request) context.Context { return startCall(ctx) },
+	)
+
+	clientFinalizer := jsonrpc.ClientFinalizer[I, O](
+		func(ctx context.Context, err error) {
+			method, _ := ctx.Value(jsonrpc.ContextKeyRequestMethod).(string)
+			ins.Observe("jsonrpc_client", method, route, jsonrpcCode(err), err != nil, callFrom(ctx).took())
+		},
+	)
+
+	return func(c *jsonrpc.Client[I, O]) {
+		clientBefore(c)
+		clientFinalizer(c)
+	}
+}
+
+// jsonrpcCode returns the JSON RPC error code of err, as encoded by
+// jsonrpc.DefaultErrorEncoder, or CodeOK if err is nil.
+func jsonrpcCode(err error) string {
+	if err == nil {
+		return CodeOK
+	}
+	if coder, ok := err.(jsonrpc.ErrorCoder); ok {
+		return strconv.Itoa(coder.ErrorCode())
+	}
+	return strconv.Itoa(jsonrpc.InternalError)
+}
+
+// combine returns an option applying all of opts. It allows combining the
+// options of transports whose type is not exported.
+func combine[T ~func(S), S interface{}](opts ...T) T {
+	return func(s S) {
+		for _, opt := range opts {
+			opt(s)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+
+	"github.com/valyala/fasthttp"
+	"google.golang.org/grpc"
+
+	"github.com/tnnyio/yoroi/health"
+	"github.com/tnnyio/yoroi/sd"
+)
+
+// HTTPServer returns a component serving srv on ln, and shutting it down
+// gracefully.
+func HTTPServer(name string, srv *http.Server, ln net.Listener) Component {
+	return Component{
+		Name: name,
+		Run: func() error {
+			if err := srv.Serve(ln); !errors.Is(err, http.ErrServerClosed) {
+				return err
+			}
+			return nil
+		},
+		Stop: srv.Shutdown,
+	}
+}
+
+// FastHTTPServer returns a component serving srv on ln, and shutting it down
+// gracefully.
+func FastHTTPServer(name string, srv *fasthttp.Server, ln net.Listener) Component {
+	return Component{
+		Name: name,
+		Run:  func() error { return srv.Serve(ln) },
+		Stop: srv.ShutdownWithContext,
+	}
+}
+
+// GRPCServer returns a component serving srv on ln, and stopping it
+// gracefully. If the graceful stop doesn't complete in time, pending RPCs
+// are canceled.
+func GRPCServer(name string, srv *grpc.Server, ln net.Listener) Component {
+	return Component{
+		Name: name,
+		Run:  func() error { return srv.Serve(ln) },
+		Stop: func(ctx context.Context) error {
+			done := make(chan struct{})
+			go func() {
+				srv.GracefulStop()
+				close(done)
+			}()
+			select {
+			case <-done:
+				return nil
+			case <-ctx.Done():
+				srv.Stop()
+				return ctx.Err()
+			}
+		},
+	}
+}
+
+// Registrar returns a component registering the instance when the group
+// starts, and deregistering it in the drain phase.
+func Registrar(name string, r sd.Registrar) Component {
+	return Component{
+		Name: name,
+		Start: func(context.Context) error {
+			r.Register()
+			return nil
+		},
+		Drain: func(context.Context) error {
+			r.Deregister()
+			return nil
+		},
+	}
+}
+
+// Readiness returns a component marking h as not ready in the drain phase.
+func Readiness(name string, h *health.Health) Component {
+	return Component{
+		Name: name,
+		Drain: func(context.Context) error {
+			h.SetReady(false)
+			return nil
+		},
+	}
+}
+
+// Loop returns a component running fn until it's stopped, e.g. the SendLoop
+// method of the statsd, dogstatsd, influxstatsd and graphite emitters. fn
+// must return when its context is canceled.
+func Loop(name string, fn func(ctx context.Context)) Component {
+	ctx, cancel := context.WithCancel(context.Background())
+	return Component{
+		Name: name,
+		Run: func() error {
+			fn(ctx)
+			return nil
+		},
+		Stop: func(context.Context) error {
+			cancel()
+			return nil
+		},
+	}
+}
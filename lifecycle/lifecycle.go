@@ -0,0 +1,214 @@
+// Package lifecycle runs the components of a process, such as servers,
+// registrars and background loops, and shuts them down in order.
+//
+// Components are started in the order they were added. The group runs until
+// its context is done, it receives a termination signal, or the Run function
+// of a component returns. It then shuts down in two phases. In the drain
+// phase, components stop attracting traffic, e.g. registrars deregister and
+// readiness reports down, and the group waits for a delay so that clients
+// notice. In the stop phase, components are stopped in the reverse order
+// they were added. Each step is bounded by a timeout.
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/tnnyio/log"
+)
+
+// Default timings of a Group.
+const (
+	DefaultDrainDelay  = 5 * time.Second
+	DefaultStopTimeout = 10 * time.Second
+)
+
+// Component is a part of the process whose lifetime is managed by a Group.
+// Every function is optional.
+type Component struct {
+	Name string
+
+	// Start is called when the group starts, before Run. It should not
+	// block. An error aborts the start of the group.
+	Start func(ctx context.Context) error
+
+	// Run runs the component, and blocks until it's stopped or fails. If Run
+	// returns while the group is running, the group shuts down.
+	Run func() error
+
+	// Drain is called in the drain phase, before any component is stopped.
+	Drain func(ctx context.Context) error
+
+	// Stop makes Run return, and releases the resources of the component.
+	Stop func(ctx context.Context) error
+}
+
+// Option sets an optional parameter for groups.
+type Option func(*Group)
+
+// DrainDelay sets how long the group waits between the drain and the stop
+// phases. By default, DefaultDrainDelay is used.
+func DrainDelay(d time.Duration) Option {
+	return func(g *Group) { g.drainDelay = d }
+}
+
+// StopTimeout bounds the duration of each Drain and Stop call, and of the
+// wait for Run functions to return after the stop phase. By default,
+// DefaultStopTimeout is used.
+func StopTimeout(d time.Duration) Option {
+	return func(g *Group) { g.stopTimeout = d }
+}
+
+// Signals sets the signals that trigger a shutdown. A second signal skips
+// the drain delay. By default, SIGINT and SIGTERM are used.
+func Signals(signals ...os.Signal) Option {
+	return func(g *Group) { g.signals = signals }
+}
+
+// Group manages the lifetime of a set of components.
+type Group struct {
+	components  []Component
+	logger      log.Logger
+	drainDelay  time.Duration
+	stopTimeout time.Duration
+	signals     []os.Signal
+}
+
+// New returns an empty Group.
+func New(logger log.Logger, opts ...Option) *Group {
+	g := &Group{
+		logger:      logger,
+		drainDelay:  DefaultDrainDelay,
+		stopTimeout: DefaultStopTimeout,
+		signals:     []os.Signal{syscall.SIGINT, syscall.SIGTERM},
+	}
+	for _, opt := range opts {
+		opt(g)
+	}
+	return g
+}
+
+// Add adds components to the group. It must not be called after Run.
+func (g *Group) Add(components ...Component) {
+	g.components = append(g.components, components...)
+}
+
+type exit struct {
+	name string
+	err  error
+}
+
+// Run starts the components and blocks until the group has shut down. It
+// returns the error that caused the shutdown, if any, joined with the errors
+// of the shutdown itself. A shutdown caused by ctx or a signal isn't an
+// error.
+func (g *Group) Run(ctx context.Context) error {
+	sigc := make(chan os.Signal, 2)
+	if len(g.signals) > 0 {
+		signal.Notify(sigc, g.signals...)
+		defer signal.Stop(sigc)
+	}
+
+	var (
+		exits   = make(chan exit, len(g.components))
+		running sync.WaitGroup
+		started int
+		cause   error
+	)
+	for _, c := range g.components {
+		if c.Start != nil {
+			if err := c.Start(ctx); err != nil {
+				cause = fmt.Errorf("%s: start: %w", c.Name, err)
+				break
+			}
+		}
+		started++
+		if c.Run != nil {
+			running.Add(1)
+			go func(c Component) {
+				defer running.Done()
+				exits <- exit{c.Name, c.Run()}
+			}(c)
+		}
+	}
+
+	if cause == nil {
+		select {
+		case <-ctx.Done():
+			g.logger.Log("msg", "shutting down", "reason", ctx.Err())
+		case sig := <-sigc:
+			g.logger.Log("msg", "shutting down", "signal", sig)
+		case e := <-exits:
+			g.logger.Log("msg", "shutting down", "component", e.name, "err", e.err)
+			if e.err != nil {
+				cause = fmt.Errorf("%s: %w", e.name, e.err)
+			} else {
+				cause = fmt.Errorf("%s: exited", e.name)
+			}
+		}
+	} else {
+		g.logger.Log("msg", "shutting down", "err", cause)
+	}
+
+	errs := []error{cause}
+	components := g.components[:started]
+	errs = append(errs, g.phase(components, "drain", func(c Component) func(context.Context) error { return c.Drain })...)
+	if g.drainDelay > 0 {
+		t := time.NewTimer(g.drainDelay)
+		select {
+		case <-t.C:
+		case sig := <-sigc:
+			g.logger.Log("msg", "skipping drain delay", "signal", sig)
+		}
+		t.Stop()
+	}
+	errs = append(errs, g.phase(components, "stop", func(c Component) func(context.Context) error { return c.Stop })...)
+
+	done := make(chan struct{})
+	go func() { running.Wait(); close(done) }()
+	select {
+	case <-done:
+	case <-time.After(g.stopTimeout):
+		errs = append(errs, errors.New("components still running after stop timeout"))
+	}
+	return errors.Join(errs...)
+}
+
+// phase calls the given function of the components, in reverse order.
+func (g *Group) phase(components []Component, name string, fn func(Component) func(context.Context) error) []error {
+	var errs []error
+	for i := len(components) - 1; i >= 0; i-- {
+		c := components[i]
+		f := fn(c)
+		if f == nil {
+			continue
+		}
+		err := g.bounded(f)
+		if err != nil {
+			g.logger.Log("component", c.Name, "during", name, "err", err)
+			errs = append(errs, fmt.Errorf("%s: %s: %w", c.Name, name, err))
+		}
+	}
+	return errs
+}
+
+// bounded calls f, and gives up waiting for it after the stop timeout, in
+// case it ignores its context.
+func (g *Group) bounded(f func(context.Context) error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), g.stopTimeout)
+	defer cancel()
+	errc := make(chan error, 1)
+	go func() { errc <- f(ctx) }()
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,157 @@
+package lifecycle_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tnnyio/log"
+
+	"github.com/tnnyio/yoroi/lifecycle"
+)
+
+type events struct {
+	mtx  sync.Mutex
+	list []string
+}
+
+func (e *events) add(event string) {
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+	e.list = append(e.list, event)
+}
+
+func (e *events) get() []string {
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+	return append([]string(nil), e.list...)
+}
+
+// component records its lifecycle in e, and runs until stopped.
+func component(e *events, name string) lifecycle.Component {
+	stop := make(chan struct{})
+	return lifecycle.Component{
+		Name:  name,
+		Start: func(context.Context) error { e.add(name + " start"); return nil },
+		Run:   func() error { <-stop; return nil },
+		Drain: func(context.Context) error { e.add(name + " drain"); return nil },
+		Stop:  func(context.Context) error { e.add(name + " stop"); close(stop); return nil },
+	}
+}
+
+func newGroup() *lifecycle.Group {
+	return lifecycle.New(log.NewNopLogger(), lifecycle.Signals(), lifecycle.DrainDelay(0), lifecycle.StopTimeout(time.Second))
+}
+
+func TestOrder(t *testing.T) {
+	var e events
+	g := newGroup()
+	g.Add(component(&e, "a"), component(&e, "b"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan error)
+	go func() { result <- g.Run(ctx) }()
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	if err := <-result; err != nil {
+		t.Fatalf("want no error, have %v", err)
+	}
+
+	want := []string{"a start", "b start", "b drain", "a drain", "b stop", "a stop"}
+	if have := e.get(); !reflect.DeepEqual(want, have) {
+		t.Errorf("want %v, have %v", want, have)
+	}
+}
+
+func TestRunError(t *testing.T) {
+	var (
+		e       events
+		errFail = errors.New("listener closed")
+		g       = newGroup()
+	)
+	g.Add(component(&e, "a"), lifecycle.Component{
+		Name: "failing",
+		Run:  func() error { return errFail },
+	})
+
+	err := g.Run(context.Background())
+	if !errors.Is(err, errFail) {
+		t.Fatalf("want %v, have %v", errFail, err)
+	}
+	if want, have := []string{"a start", "a drain", "a stop"}, e.get(); !reflect.DeepEqual(want, have) {
+		t.Errorf("want %v, have %v", want, have)
+	}
+}
+
+func TestStartError(t *testing.T) {
+	var (
+		e       events
+		errFail = errors.New("no database")
+		g       = newGroup()
+	)
+	g.Add(component(&e, "a"), lifecycle.Component{
+		Name:  "failing",
+		Start: func(context.Context) error { return errFail },
+	}, component(&e, "never"))
+
+	if err := g.Run(context.Background()); !errors.Is(err, errFail) {
+		t.Fatalf("want %v, have %v", errFail, err)
+	}
+	if want, have := []string{"a start", "a drain", "a stop"}, e.get(); !reflect.DeepEqual(want, have) {
+		t.Errorf("want %v, have %v", want, have)
+	}
+}
+
+func TestStopTimeout(t *testing.T) {
+	g := lifecycle.New(log.NewNopLogger(), lifecycle.Signals(), lifecycle.DrainDelay(0), lifecycle.StopTimeout(10*time.Millisecond))
+	block := make(chan struct{})
+	defer close(block)
+	g.Add(lifecycle.Component{
+		Name: "stuck",
+		Run:  func() error { <-block; return nil },
+		Stop: func(context.Context) error { <-block; return nil },
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := g.Run(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("want %v, have %v", context.DeadlineExceeded, err)
+	}
+	if err == nil || !strings.Contains(err.Error(), "still running") {
+		t.Errorf("want still running error, have %v", err)
+	}
+}
+
+func TestHTTPServer(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := newGroup()
+	g.Add(lifecycle.HTTPServer("http", &http.Server{Handler: http.NotFoundHandler()}, ln))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan error)
+	go func() { result <- g.Run(ctx) }()
+
+	resp, err := http.Get("http://" + ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	cancel()
+	if err := <-result; err != nil {
+		t.Fatalf("want no error, have %v", err)
+	}
+	if _, err := http.Get("http://" + ln.Addr().String()); err == nil {
+		t.Error("want server stopped")
+	}
+}
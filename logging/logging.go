@@ -0,0 +1,191 @@
+// Package logging writes one structured log line per call.
+//
+// The middleware returned by New can be used on its own, in which case it
+// logs the endpoint name, the duration and the error of every call. When the
+// endpoint is served by a transport that was configured with one of the
+// server options in this package, such as HTTPServer or GRPCServer, the
+// middleware only records its details in the request context, and the
+// transport writes a single line when the request is finalized. That line
+// also carries the transport, the HTTP method and path or the gRPC method,
+// the status code, and the response size.
+//
+//	logger := log.NewLogfmtLogger(os.Stderr)
+//	e = logging.New[Response](logger, "create_user")(e)
+//	handler := httptransport.NewServer(e, dec, enc,
+//		logging.HTTPServer[Request, Response](logger,
+//			logging.SampleRate(0.1),
+//			logging.SlowThreshold(time.Second),
+//		),
+//	)
+//
+// Failed and slow calls are always logged, regardless of the sample rate.
+package logging
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/tnnyio/log"
+
+	"github.com/tnnyio/yoroi/endpoint"
+)
+
+// Redacted replaces the values of redacted keys.
+const Redacted = "[REDACTED]"
+
+// Option sets an optional parameter for the logging middleware and the
+// transport server options.
+type Option func(*options)
+
+// SampleRate sets the fraction, between 0 and 1, of successful calls that are
+// logged. Failed calls and calls slower than the SlowThreshold are always
+// logged. By default, every call is logged.
+func SampleRate(rate float64) Option {
+	return func(o *options) { o.sampleRate = rate }
+}
+
+// SlowThreshold marks calls that take at least d with "slow", true. Slow
+// calls are logged regardless of the SampleRate. By default, no call is
+// considered slow.
+func SlowThreshold(d time.Duration) Option {
+	return func(o *options) { o.slowThreshold = d }
+}
+
+// Redact replaces the values of the given keys with Redacted, e.g. to keep
+// credentials added with Fields out of the logs.
+func Redact(keys ...string) Option {
+	return func(o *options) {
+		for _, key := range keys {
+			o.redact[key] = struct{}{}
+		}
+	}
+}
+
+// Fields adds the key-value pairs returned by f to the log line. f is called
+// by the endpoint middleware with the decoded request, after the call
+// returned. It has no effect on the transport server options.
+func Fields(f func(ctx context.Context, request interface{}) []interface{}) Option {
+	return func(o *options) { o.fields = f }
+}
+
+type options struct {
+	sampleRate    float64
+	slowThreshold time.Duration
+	redact        map[string]struct{}
+	fields        func(ctx context.Context, request interface{}) []interface{}
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{
+		sampleRate: 1,
+		redact:     map[string]struct{}{},
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// New returns an endpoint.Middleware that logs every call to the endpoint
+// with the given name. If the request context was prepared by one of the
+// transport server options in this package, the call is logged by the
+// transport instead, as part of its line.
+func New[O interface{}](logger log.Logger, name string, opts ...Option) endpoint.Middleware[O] {
+	o := newOptions(opts)
+	return func(next endpoint.Endpoint[O]) endpoint.Endpoint[O] {
+		return func(ctx context.Context, request interface{}) (O, error) {
+			begin := time.Now()
+			response, err := next(ctx, request)
+			took := time.Since(begin)
+
+			var fields []interface{}
+			if o.fields != nil {
+				fields = o.fields(ctx, request)
+			}
+			if r, ok := ctx.Value(recordKey).(*record); ok {
+				r.set(name, err, fields)
+				return response, err
+			}
+			o.log(logger, took, false, err, append([]interface{}{"endpoint", name}, fields...))
+			return response, err
+		}
+	}
+}
+
+// log writes a line with the given key-value pairs followed by the duration
+// and the error, unless the call is sampled out. Calls that failed without an
+// error, such as HTTP responses with a server error status, are never sampled
+// out either.
+func (o *options) log(logger log.Logger, took time.Duration, failed bool, err error, keyvals []interface{}) {
+	slow := o.slowThreshold > 0 && took >= o.slowThreshold
+	if err == nil && !failed && !slow && o.sampleRate < 1 && rand.Float64() >= o.sampleRate {
+		return
+	}
+	// Copy before redacting: keyvals may share its array with the slice
+	// returned by the Fields function.
+	keyvals = append(make([]interface{}, 0, len(keyvals)+6), keyvals...)
+	for i := 0; i+1 < len(keyvals); i += 2 {
+		if key, ok := keyvals[i].(string); ok {
+			if _, redact := o.redact[key]; redact {
+				keyvals[i+1] = Redacted
+			}
+		}
+	}
+	keyvals = append(keyvals, "took", took)
+	if slow {
+		keyvals = append(keyvals, "slow", true)
+	}
+	logger.Log(append(keyvals, "err", err)...)
+}
+
+type recordKeyType struct{}
+
+var recordKey recordKeyType
+
+// record collects the details of a call that the endpoint middleware passes
+// on to the transport.
+type record struct {
+	mtx      sync.Mutex
+	begin    time.Time
+	endpoint string
+	err      error
+	encoded  error // the error encoded by the transport, if any
+	fields   []interface{}
+}
+
+func newRecord() *record {
+	return &record{begin: time.Now()}
+}
+
+func (r *record) set(endpoint string, err error, fields []interface{}) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.endpoint, r.err, r.fields = endpoint, err, fields
+}
+
+func (r *record) setEncoded(err error) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.encoded = err
+}
+
+// keyvals returns the recorded key-value pairs and error. If the transport
+// observed an error of its own, it takes precedence over the recorded one.
+func (r *record) keyvals(err error) ([]interface{}, error) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	if err == nil {
+		err = r.encoded
+	}
+	var keyvals []interface{}
+	if r.endpoint != "" {
+		keyvals = append(keyvals, "endpoint", r.endpoint)
+	}
+	keyvals = append(keyvals, r.fields...)
+	if err == nil {
+		err = r.err
+	}
+	return keyvals, err
+}
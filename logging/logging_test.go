@@ -0,0 +1,329 @@
+package logging_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	fh "github.com/valyala/fasthttp"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/tnnyio/log"
+	"github.com/tnnyio/yoroi/endpoint"
+	"github.com/tnnyio/yoroi/logging"
+	fasthttptransport "github.com/tnnyio/yoroi/transport/fasthttp"
+	grpctransport "github.com/tnnyio/yoroi/transport/grpc"
+	httptransport "github.com/tnnyio/yoroi/transport/http"
+	"github.com/tnnyio/yoroi/transport/http/jsonrpc"
+)
+
+type line map[interface{}]interface{}
+
+type recorder struct {
+	mtx   sync.Mutex
+	lines []line
+}
+
+func (r *recorder) Log(keyvals ...interface{}) error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	l := line{}
+	for i := 0; i+1 < len(keyvals); i += 2 {
+		l[keyvals[i]] = keyvals[i+1]
+	}
+	r.lines = append(r.lines, l)
+	return nil
+}
+
+var _ log.Logger = (*recorder)(nil)
+
+func (r *recorder) only(t *testing.T) line {
+	t.Helper()
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	if want, have := 1, len(r.lines); want != have {
+		t.Fatalf("lines: want %d, have %d: %v", want, have, r.lines)
+	}
+	return r.lines[0]
+}
+
+func echo(err error) endpoint.Endpoint[string] {
+	return func(context.Context, interface{}) (string, error) { return "hello", err }
+}
+
+func TestNew(t *testing.T) {
+	var (
+		rec    = &recorder{}
+		errBad = errors.New("bad")
+	)
+	logging.New[string](rec, "greet")(echo(errBad))(context.Background(), nil)
+
+	l := rec.only(t)
+	if want, have := "greet", l["endpoint"]; want != have {
+		t.Errorf("endpoint: want %v, have %v", want, have)
+	}
+	if want, have := errBad, l["err"]; want != have {
+		t.Errorf("err: want %v, have %v", want, have)
+	}
+	if _, ok := l["took"].(time.Duration); !ok {
+		t.Errorf("took: want a time.Duration, have %v", l["took"])
+	}
+}
+
+func TestSampleRate(t *testing.T) {
+	rec := &recorder{}
+	mw := logging.New[string](rec, "greet", logging.SampleRate(0))
+	for i := 0; i < 10; i++ {
+		mw(echo(nil))(context.Background(), nil)
+	}
+	if want, have := 0, len(rec.lines); want != have {
+		t.Fatalf("want %d lines, have %d", want, have)
+	}
+	mw(echo(errors.New("bad")))(context.Background(), nil)
+	rec.only(t)
+}
+
+func TestSlowThreshold(t *testing.T) {
+	rec := &recorder{}
+	slow := func(context.Context, interface{}) (string, error) {
+		time.Sleep(5 * time.Millisecond)
+		return "", nil
+	}
+	logging.New[string](rec, "greet", logging.SampleRate(0), logging.SlowThreshold(time.Millisecond))(slow)(context.Background(), nil)
+
+	if want, have := true, rec.only(t)["slow"]; want != have {
+		t.Errorf("slow: want %v, have %v", want, have)
+	}
+}
+
+func TestFieldsRedact(t *testing.T) {
+	rec := &recorder{}
+	fields := func(_ context.Context, request interface{}) []interface{} {
+		return []interface{}{"user", request, "password", "hunter2"}
+	}
+	logging.New[string](rec, "login", logging.Fields(fields), logging.Redact("password"))(echo(nil))(context.Background(), "gopher")
+
+	l := rec.only(t)
+	if want, have := "gopher", l["user"]; want != have {
+		t.Errorf("user: want %v, have %v", want, have)
+	}
+	if want, have := logging.Redacted, l["password"]; want != have {
+		t.Errorf("password: want %v, have %v", want, have)
+	}
+}
+
+func TestRedactCopiesFields(t *testing.T) {
+	rec := &recorder{}
+	shared := make([]interface{}, 0, 8)
+	shared = append(shared, "password", "hunter2")
+	fields := func(context.Context, interface{}) []interface{} { return shared }
+	logging.New[string](rec, "login", logging.Fields(fields), logging.Redact("password"))(echo(nil))(context.Background(), "gopher")
+
+	if want, have := "hunter2", shared[1]; want != have {
+		t.Errorf("caller's fields: want %v, have %v", want, have)
+	}
+}
+
+func TestHTTPServer(t *testing.T) {
+	var (
+		rec     = &recorder{}
+		e       = logging.New[string](rec, "greet")(echo(nil))
+		handler = httptransport.NewServer(
+			e,
+			func(context.Context, *http.Request) (interface{}, error) { return nil, nil },
+			func(_ context.Context, w http.ResponseWriter, response string) error {
+				_, err := w.Write([]byte(response))
+				return err
+			},
+			logging.HTTPServer[interface{}, string](rec),
+		)
+		w = httptest.NewRecorder()
+	)
+	handler.ServeHTTP(w, httptest.NewRequest("POST", "/greet", nil))
+
+	l := rec.only(t)
+	for key, want := range map[string]interface{}{
+		"transport": "http",
+		"method":    "POST",
+		"path":      "/greet",
+		"status":    http.StatusOK,
+		"size":      int64(len("hello")),
+		"endpoint":  "greet",
+		"err":       nil,
+	} {
+		if have := l[key]; want != have {
+			t.Errorf("%s: want %v, have %v", key, want, have)
+		}
+	}
+}
+
+func TestFastHTTPServer(t *testing.T) {
+	var (
+		rec     = &recorder{}
+		errBad  = errors.New("bad")
+		e       = logging.New[string](rec, "greet")(echo(errBad))
+		handler = fasthttptransport.NewServer(
+			e,
+			func(*fh.RequestCtx) (interface{}, error) { return nil, nil },
+			func(*fh.RequestCtx, string) error { return nil },
+			logging.FastHTTPServer[interface{}, string](rec),
+		)
+		ctx = &fh.RequestCtx{}
+	)
+	ctx.Request.Header.SetMethod("GET")
+	ctx.Request.SetRequestURI("/greet")
+	handler(ctx)
+
+	l := rec.only(t)
+	for key, want := range map[string]interface{}{
+		"transport": "fasthttp",
+		"method":    "GET",
+		"path":      "/greet",
+		"status":    http.StatusInternalServerError,
+		"endpoint":  "greet",
+		"err":       errBad,
+	} {
+		if have := l[key]; want != have {
+			t.Errorf("%s: want %v, have %v", key, want, have)
+		}
+	}
+}
+
+func TestGRPCServer(t *testing.T) {
+	var (
+		rec    = &recorder{}
+		errBad = status.Error(codes.NotFound, "no such greeting")
+		e      = logging.New[string](rec, "greet")(echo(errBad))
+		server = grpctransport.NewServer(
+			e,
+			func(context.Context, interface{}) (interface{}, error) { return nil, nil },
+			func(_ context.Context, response string) (interface{}, error) { return response, nil },
+			logging.GRPCServer[interface{}, string](rec),
+		)
+		ctx = context.WithValue(context.Background(), grpctransport.ContextKeyRequestMethod, "/greeter/Greet")
+	)
+	server.ServeGRPC(ctx, nil)
+
+	l := rec.only(t)
+	for key, want := range map[string]interface{}{
+		"transport": "grpc",
+		"method":    "/greeter/Greet",
+		"status":    "NotFound",
+		"endpoint":  "greet",
+		"err":       errBad,
+	} {
+		if have := l[key]; want != have {
+			t.Errorf("%s: want %v, have %v", key, want, have)
+		}
+	}
+}
+
+func TestJSONRPCServer(t *testing.T) {
+	var (
+		rec    = &recorder{}
+		e      = logging.New[interface{}](rec, "greet")(func(context.Context, interface{}) (interface{}, error) { return "hello", nil })
+		server = jsonrpc.NewServer(jsonrpc.EndpointCodecMap{
+			"greet": jsonrpc.EndpointCodec{
+				Endpoint: e,
+				Decode:   func(context.Context, json.RawMessage) (interface{}, error) { return nil, nil },
+				Encode:   func(_ context.Context, response interface{}) (json.RawMessage, error) { return json.Marshal(response) },
+			},
+		}, logging.JSONRPCServer(rec))
+	)
+	for _, method := range []string{"greet", "shout"} {
+		body := strings.NewReader(`{"jsonrpc": "2.0", "id": 1, "method": "` + method + `"}`)
+		server.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/rpc", body))
+	}
+
+	rec.mtx.Lock()
+	defer rec.mtx.Unlock()
+	if want, have := 2, len(rec.lines); want != have {
+		t.Fatalf("want %d lines, have %d", want, have)
+	}
+	for i, want := range []map[string]interface{}{
+		{"transport": "jsonrpc", "method": "greet", "endpoint": "greet", "err": nil, "code": nil},
+		{"transport": "jsonrpc", "method": "shout", "endpoint": nil, "code": jsonrpc.MethodNotFoundError},
+	} {
+		for key, want := range want {
+			if have := rec.lines[i][key]; want != have {
+				t.Errorf("line %d, %s: want %v, have %v", i, key, want, have)
+			}
+		}
+	}
+	if rec.lines[1]["err"] == nil {
+		t.Error("want the error of the unknown method")
+	}
+}
+
+func TestHTTPServerDecodeError(t *testing.T) {
+	var (
+		rec     = &recorder{}
+		errBad  = errors.New("malformed request")
+		handler = httptransport.NewServer(
+			logging.New[string](rec, "greet")(echo(nil)),
+			func(context.Context, *http.Request) (interface{}, error) { return nil, errBad },
+			func(context.Context, http.ResponseWriter, string) error { return nil },
+			logging.HTTPServer[interface{}, string](rec, logging.SampleRate(1e-7)),
+		)
+	)
+	const n = 20
+	for i := 0; i < n; i++ {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/greet", nil))
+	}
+
+	rec.mtx.Lock()
+	defer rec.mtx.Unlock()
+	if want, have := n, len(rec.lines); want != have {
+		t.Fatalf("want %d lines, have %d", want, have)
+	}
+	for i, l := range rec.lines {
+		if want, have := http.StatusInternalServerError, l["status"]; want != have {
+			t.Errorf("line %d, status: want %v, have %v", i, want, have)
+		}
+		if want, have := errBad, l["err"]; want != have {
+			t.Errorf("line %d, err: want %v, have %v", i, want, have)
+		}
+	}
+}
+
+func TestJSONRPCServerErrorEncoder(t *testing.T) {
+	var (
+		rec     = &recorder{}
+		encoded bool
+		server  = jsonrpc.NewServer(
+			jsonrpc.EndpointCodecMap{
+				"greet": jsonrpc.EndpointCodec{
+					Endpoint: endpoint.Nop,
+					Decode:   func(context.Context, json.RawMessage) (interface{}, error) { return nil, nil },
+					Encode:   func(context.Context, interface{}) (json.RawMessage, error) { return json.RawMessage(`"hello"`), nil },
+				},
+			},
+			logging.JSONRPCServer(rec),
+			// Set after the logging option, the error encoder is still wrapped.
+			jsonrpc.ServerErrorEncoder(func(ctx context.Context, err error, w http.ResponseWriter) {
+				encoded = true
+				jsonrpc.DefaultErrorEncoder(ctx, err, w)
+			}),
+		)
+	)
+	body := strings.NewReader(`{"jsonrpc": "2.0", "id": 1, "method": "shout"}`)
+	server.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/rpc", body))
+
+	if !encoded {
+		t.Error("want the error encoded by the error encoder of the server")
+	}
+	l := rec.only(t)
+	if want, have := jsonrpc.MethodNotFoundError, l["code"]; want != have {
+		t.Errorf("code: want %v, have %v", want, have)
+	}
+	if l["err"] == nil {
+		t.Error("want the error of the unknown method")
+	}
+}
@@ -0,0 +1,232 @@
+package logging
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	fh "github.com/valyala/fasthttp"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/tnnyio/log"
+	fasthttptransport "github.com/tnnyio/yoroi/transport/fasthttp"
+	grpctransport "github.com/tnnyio/yoroi/transport/grpc"
+	httptransport "github.com/tnnyio/yoroi/transport/http"
+	"github.com/tnnyio/yoroi/transport/http/jsonrpc"
+)
+
+// HTTPServer returns a ServerOption that logs one line per request when the
+// request is finalized. The method and path are taken from the context keys
+// populated by httptransport.PopulateRequestContext if present, and from the
+// request otherwise. The errors encoded by the server, including the ones of
+// decoding, are logged, and responses with a 5xx status are never sampled out.
+func HTTPServer[I, O interface{}](logger log.Logger, opts ...Option) httptransport.ServerOption[I, O] {
+	o := newOptions(opts)
+
+	serverBefore := httptransport.ServerBefore[I, O](
+		func(ctx context.Context, _ *http.Request) context.Context {
+			return context.WithValue(ctx, recordKey, newRecord())
+		},
+	)
+
+	serverErrorEncoder := httptransport.ServerErrorEncoderMiddleware[I, O](HTTPErrorEncoder)
+
+	serverFinalizer := httptransport.ServerFinalizer[I, O](
+		func(ctx context.Context, code int, r *http.Request) {
+			rec, ok := ctx.Value(recordKey).(*record)
+			if !ok {
+				return
+			}
+			size, _ := ctx.Value(httptransport.ContextKeyResponseSize).(int64)
+			keyvals := []interface{}{
+				"transport", "http",
+				"method", stringValue(ctx, httptransport.ContextKeyRequestMethod, r.Method),
+				"path", stringValue(ctx, httptransport.ContextKeyRequestPath, r.URL.Path),
+				"status", code,
+				"size", size,
+			}
+			fields, err := rec.keyvals(nil)
+			o.log(logger, time.Since(rec.begin), code >= http.StatusInternalServerError, err, append(keyvals, fields...))
+		},
+	)
+
+	return func(s *httptransport.Server[I, O]) {
+		serverBefore(s)
+		serverErrorEncoder(s)
+		serverFinalizer(s)
+	}
+}
+
+// FastHTTPServer returns a ServerOption that logs one line per request when
+// the request is finalized. The method and path are taken from the context
+// keys populated by fasthttptransport.PopulateRequestContext if present, and
+// from the request otherwise. The errors encoded by the server, including the
+// ones of decoding, are logged, and responses with a 5xx status are never
+// sampled out.
+func FastHTTPServer[I, O interface{}](logger log.Logger, opts ...Option) fasthttptransport.ServerOption[I, O] {
+	o := newOptions(opts)
+
+	serverBefore := fasthttptransport.ServerBefore[I, O](
+		func(ctx *fh.RequestCtx) {
+			ctx.SetUserValue(recordKey, newRecord())
+		},
+	)
+
+	serverErrorEncoder := fasthttptransport.ServerErrorEncoderMiddleware[I, O](FastHTTPErrorEncoder)
+
+	serverFinalizer := fasthttptransport.ServerFinalizer[I, O](
+		func(ctx *fh.RequestCtx) {
+			rec, ok := ctx.Value(recordKey).(*record)
+			if !ok {
+				return
+			}
+			size, _ := ctx.Value(fasthttptransport.ContextKeyResponseSize).(int64)
+			keyvals := []interface{}{
+				"transport", "fasthttp",
+				"method", stringValue(ctx, fasthttptransport.ContextKeyRequestMethod, string(ctx.Method())),
+				"path", stringValue(ctx, fasthttptransport.ContextKeyRequestPath, string(ctx.Path())),
+				"status", ctx.Response.StatusCode(),
+				"size", size,
+			}
+			fields, err := rec.keyvals(nil)
+			o.log(logger, time.Since(rec.begin), ctx.Response.StatusCode() >= http.StatusInternalServerError, err, append(keyvals, fields...))
+		},
+	)
+
+	return combine(serverBefore, serverErrorEncoder, serverFinalizer)
+}
+
+// GRPCServer returns a ServerOption that logs one line per request when the
+// request is finalized. The gRPC method is taken from the context key
+// populated by grpctransport.Interceptor, and the status code is derived from
+// the returned error.
+func GRPCServer[I, O interface{}](logger log.Logger, opts ...Option) grpctransport.ServerOption[I, O] {
+	o := newOptions(opts)
+
+	serverBefore := grpctransport.ServerBefore[I, O](
+		func(ctx context.Context, _ metadata.MD) context.Context {
+			return context.WithValue(ctx, recordKey, newRecord())
+		},
+	)
+
+	serverFinalizer := grpctransport.ServerFinalizer[I, O](
+		func(ctx context.Context, err error) {
+			rec, ok := ctx.Value(recordKey).(*record)
+			if !ok {
+				return
+			}
+			keyvals := []interface{}{
+				"transport", "grpc",
+				"method", stringValue(ctx, grpctransport.ContextKeyRequestMethod, ""),
+				"status", status.Code(err).String(),
+			}
+			fields, err := rec.keyvals(err)
+			o.log(logger, time.Since(rec.begin), false, err, append(keyvals, fields...))
+		},
+	)
+
+	return func(s *grpctransport.Server[I, O]) {
+		serverBefore(s)
+		serverFinalizer(s)
+	}
+}
+
+// JSONRPCServer returns a ServerOption that logs one line per request when
+// the request is finalized, with the JSON RPC method and the code of the
+// JSON RPC error, if any.
+func JSONRPCServer(logger log.Logger, opts ...Option) jsonrpc.ServerOption {
+	o := newOptions(opts)
+
+	serverBefore := jsonrpc.ServerBefore(
+		func(ctx context.Context, _ *http.Request) context.Context {
+			return context.WithValue(ctx, recordKey, newRecord())
+		},
+	)
+
+	serverErrorEncoder := jsonrpc.ServerErrorEncoderMiddleware(JSONRPCErrorEncoder)
+
+	serverFinalizer := jsonrpc.ServerFinalizer(
+		func(ctx context.Context, code int, _ *http.Request) {
+			rec, ok := ctx.Value(recordKey).(*record)
+			if !ok {
+				return
+			}
+			fields, err := rec.keyvals(nil)
+			keyvals := []interface{}{
+				"transport", "jsonrpc",
+				"method", stringValue(ctx, jsonrpc.ContextKeyRequestMethod, ""),
+				"status", code,
+			}
+			if err != nil {
+				errorCode := jsonrpc.InternalError
+				if coder, ok := err.(jsonrpc.ErrorCoder); ok {
+					errorCode = coder.ErrorCode()
+				}
+				keyvals = append(keyvals, "code", errorCode)
+			}
+			o.log(logger, time.Since(rec.begin), code >= http.StatusInternalServerError, err, append(keyvals, fields...))
+		},
+	)
+
+	return func(s *jsonrpc.Server) {
+		serverBefore(s)
+		serverErrorEncoder(s)
+		serverFinalizer(s)
+	}
+}
+
+// JSONRPCErrorEncoder wraps a JSON RPC error encoder, so that the errors it
+// encodes, including the ones of decoding and unknown methods, are logged by
+// JSONRPCServer. JSONRPCServer applies it to the error encoder of the server,
+// whichever option sets it.
+func JSONRPCErrorEncoder(next httptransport.ErrorEncoder) httptransport.ErrorEncoder {
+	return func(ctx context.Context, err error, w http.ResponseWriter) {
+		if rec, ok := ctx.Value(recordKey).(*record); ok {
+			rec.setEncoded(err)
+		}
+		next(ctx, err, w)
+	}
+}
+
+// HTTPErrorEncoder wraps an HTTP error encoder, so that the errors it encodes,
+// including the ones of decoding, are logged by HTTPServer. HTTPServer applies
+// it to the error encoder of the server, whichever option sets it.
+func HTTPErrorEncoder(next httptransport.ErrorEncoder) httptransport.ErrorEncoder {
+	return func(ctx context.Context, err error, w http.ResponseWriter) {
+		if rec, ok := ctx.Value(recordKey).(*record); ok {
+			rec.setEncoded(err)
+		}
+		next(ctx, err, w)
+	}
+}
+
+// FastHTTPErrorEncoder wraps a fasthttp error encoder, so that the errors it
+// encodes, including the ones of decoding, are logged by FastHTTPServer.
+// FastHTTPServer applies it to the error encoder of the server, whichever
+// option sets it.
+func FastHTTPErrorEncoder(next fasthttptransport.ErrorEncoder) fasthttptransport.ErrorEncoder {
+	return func(ctx *fh.RequestCtx, err error) {
+		if rec, ok := ctx.Value(recordKey).(*record); ok {
+			rec.setEncoded(err)
+		}
+		next(ctx, err)
+	}
+}
+
+// combine returns an option applying all of opts. It allows combining the
+// options of servers whose type is not exported.
+func combine[T ~func(S), S interface{}](opts ...T) T {
+	return func(s S) {
+		for _, opt := range opts {
+			opt(s)
+		}
+	}
+}
+
+func stringValue(ctx context.Context, key interface{}, fallback string) string {
+	if v, ok := ctx.Value(key).(string); ok && v != "" {
+		return v
+	}
+	return fallback
+}
@@ -59,5 +59,38 @@ func (p *prometheusProvider) NewHistogram(name string, _ int) metrics.Histogram
 	}, []string{})
 }
 
+// NewLabeledCounter implements LabeledProvider. It is like NewCounter, but
+// declares the given label names.
+func (p *prometheusProvider) NewLabeledCounter(name string, labelNames []string) metrics.Counter {
+	return prometheus.NewCounterFrom(stdprometheus.CounterOpts{
+		Namespace: p.namespace,
+		Subsystem: p.subsystem,
+		Name:      name,
+		Help:      name,
+	}, labelNames)
+}
+
+// NewLabeledGauge implements LabeledProvider. It is like NewGauge, but
+// declares the given label names.
+func (p *prometheusProvider) NewLabeledGauge(name string, labelNames []string) metrics.Gauge {
+	return prometheus.NewGaugeFrom(stdprometheus.GaugeOpts{
+		Namespace: p.namespace,
+		Subsystem: p.subsystem,
+		Name:      name,
+		Help:      name,
+	}, labelNames)
+}
+
+// NewLabeledHistogram implements LabeledProvider. It is like NewHistogram, but
+// declares the given label names.
+func (p *prometheusProvider) NewLabeledHistogram(name string, _ int, labelNames []string) metrics.Histogram {
+	return prometheus.NewSummaryFrom(stdprometheus.SummaryOpts{
+		Namespace: p.namespace,
+		Subsystem: p.subsystem,
+		Name:      name,
+		Help:      name,
+	}, labelNames)
+}
+
 // Stop implements Provider, but is a no-op.
 func (p *prometheusProvider) Stop() {}
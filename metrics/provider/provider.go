@@ -39,3 +39,14 @@ type Provider interface {
 	NewHistogram(name string, buckets int) metrics.Histogram
 	Stop()
 }
+
+// LabeledProvider is implemented by Providers whose backends need the label
+// names of a metric to be declared when it is constructed, such as
+// Prometheus. Metrics produced by a Provider that doesn't implement it accept
+// label values through With, or ignore them, as their backend allows.
+type LabeledProvider interface {
+	Provider
+	NewLabeledCounter(name string, labelNames []string) metrics.Counter
+	NewLabeledGauge(name string, labelNames []string) metrics.Gauge
+	NewLabeledHistogram(name string, buckets int, labelNames []string) metrics.Histogram
+}
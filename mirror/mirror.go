@@ -0,0 +1,180 @@
+// Package mirror provides a middleware that sends a copy of the traffic of an
+// endpoint to a shadow endpoint, e.g. to validate a rewrite against
+// production requests.
+//
+// Shadow calls run in the background and never affect the response of the
+// primary endpoint: their results are discarded, after optionally being
+// compared with the primary results.
+package mirror
+
+import (
+	"context"
+	"math/rand"
+	"time"
+
+	"github.com/tnnyio/log"
+
+	"github.com/tnnyio/yoroi/endpoint"
+	"github.com/tnnyio/yoroi/metrics"
+	"github.com/tnnyio/yoroi/metrics/discard"
+)
+
+// DefaultWorkers is the default maximum number of concurrent shadow calls.
+const DefaultWorkers = 10
+
+// Comparator reports whether the results of the primary and the shadow
+// endpoint match.
+type Comparator[O interface{}] func(primary O, primaryErr error, shadow O, shadowErr error) bool
+
+// Option sets an optional parameter for the mirroring middleware.
+type Option[O interface{}] func(*options[O])
+
+// Percent sets the percentage, between 0 and 100, of requests that are
+// mirrored. By default, every request is mirrored.
+func Percent[O interface{}](p float64) Option[O] {
+	return func(o *options[O]) { o.percent = p }
+}
+
+// Workers sets the maximum number of concurrent shadow calls. Requests that
+// arrive while all workers are busy are not mirrored. By default,
+// DefaultWorkers is used.
+func Workers[O interface{}](n int) Option[O] {
+	return func(o *options[O]) { o.workers = n }
+}
+
+// Timeout bounds the duration of shadow calls. By default, shadow calls run
+// without a deadline, but they are not canceled with the request either.
+func Timeout[O interface{}](d time.Duration) Option[O] {
+	return func(o *options[O]) { o.timeout = d }
+}
+
+// Compare sets a comparator that is called with the results of both
+// endpoints once they are available. Mismatches are counted and logged.
+func Compare[O interface{}](c Comparator[O]) Option[O] {
+	return func(o *options[O]) { o.compare = c }
+}
+
+// Mismatches sets a counter that is incremented whenever the comparator
+// reports a mismatch.
+func Mismatches[O interface{}](c metrics.Counter) Option[O] {
+	return func(o *options[O]) { o.mismatches = c }
+}
+
+// Dropped sets a counter that is incremented whenever a sampled request is
+// not mirrored because all workers are busy.
+func Dropped[O interface{}](c metrics.Counter) Option[O] {
+	return func(o *options[O]) { o.dropped = c }
+}
+
+// Logger sets the logger that mismatches are reported to. By default,
+// nothing is logged.
+func Logger[O interface{}](logger log.Logger) Option[O] {
+	return func(o *options[O]) { o.logger = logger }
+}
+
+type options[O interface{}] struct {
+	percent    float64
+	workers    int
+	timeout    time.Duration
+	compare    Comparator[O]
+	mismatches metrics.Counter
+	dropped    metrics.Counter
+	logger     log.Logger
+}
+
+type result[O interface{}] struct {
+	response O
+	err      error
+}
+
+// New returns an endpoint.Middleware that mirrors requests to the shadow
+// endpoint. The shadow endpoint is called concurrently with the next
+// endpoint, with a context that carries the values of the request context
+// but isn't canceled with it.
+func New[O interface{}](shadow endpoint.Endpoint[O], opts ...Option[O]) endpoint.Middleware[O] {
+	o := options[O]{
+		percent:    100,
+		workers:    DefaultWorkers,
+		mismatches: discard.NewCounter(),
+		dropped:    discard.NewCounter(),
+		logger:     log.NewNopLogger(),
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	sem := make(chan struct{}, o.workers)
+
+	return func(next endpoint.Endpoint[O]) endpoint.Endpoint[O] {
+		return func(ctx context.Context, request interface{}) (O, error) {
+			if o.percent <= 0 || (o.percent < 100 && rand.Float64()*100 >= o.percent) {
+				return next(ctx, request)
+			}
+			select {
+			case sem <- struct{}{}:
+			default:
+				o.dropped.Add(1)
+				return next(ctx, request)
+			}
+
+			var primary chan result[O]
+			if o.compare != nil {
+				primary = make(chan result[O], 1)
+			}
+			go func() {
+				defer func() { <-sem }()
+				defer func() {
+					// A panicking shadow must not take the process down.
+					if r := recover(); r != nil {
+						o.logger.Log("msg", "shadow endpoint panicked", "panic", r)
+					}
+				}()
+				o.mirror(ctx, shadow, request, primary)
+			}()
+
+			var (
+				response O
+				err      error
+				returned bool
+			)
+			if primary != nil {
+				// Close primary if next panics, so that the shadow goroutine
+				// doesn't wait for a result forever.
+				defer func() {
+					if returned {
+						primary <- result[O]{response, err}
+					} else {
+						close(primary)
+					}
+				}()
+			}
+			response, err = next(ctx, request)
+			returned = true
+			return response, err
+		}
+	}
+}
+
+func (o *options[O]) mirror(ctx context.Context, shadow endpoint.Endpoint[O], request interface{}, primary <-chan result[O]) {
+	ctx = context.WithoutCancel(ctx)
+	if o.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, o.timeout)
+		defer cancel()
+	}
+	response, err := shadow(ctx, request)
+	if primary == nil {
+		return
+	}
+	p, ok := <-primary
+	if !ok {
+		return // the primary endpoint panicked
+	}
+	if !o.compare(p.response, p.err, response, err) {
+		o.mismatches.Add(1)
+		o.logger.Log(
+			"msg", "shadow response mismatch",
+			"primary", p.response, "primary_err", p.err,
+			"shadow", response, "shadow_err", err,
+		)
+	}
+}
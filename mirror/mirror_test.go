@@ -0,0 +1,200 @@
+package mirror_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/tnnyio/log"
+
+	"github.com/tnnyio/yoroi/metrics/generic"
+	"github.com/tnnyio/yoroi/mirror"
+)
+
+func primary(_ context.Context, request interface{}) (string, error) {
+	return request.(string), nil
+}
+
+func TestMirror(t *testing.T) {
+	calls := make(chan interface{}, 1)
+	shadow := func(ctx context.Context, request interface{}) (string, error) {
+		calls <- request
+		return "", errors.New("ignored")
+	}
+	e := mirror.New[string](shadow)(primary)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	response, err := e(ctx, "hello")
+	cancel()
+	if err != nil || response != "hello" {
+		t.Fatalf("want primary response, have %q (%v)", response, err)
+	}
+	select {
+	case request := <-calls:
+		if want, have := "hello", request; want != have {
+			t.Errorf("shadow request: want %v, have %v", want, have)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("shadow endpoint not called")
+	}
+}
+
+func TestPercent(t *testing.T) {
+	shadow := func(context.Context, interface{}) (string, error) {
+		t.Error("shadow endpoint called")
+		return "", nil
+	}
+	e := mirror.New[string](shadow, mirror.Percent[string](0))(primary)
+	for i := 0; i < 10; i++ {
+		e(context.Background(), "hello")
+	}
+}
+
+func TestWorkers(t *testing.T) {
+	var (
+		release = make(chan struct{})
+		started = make(chan struct{}, 10)
+		shadow  = func(context.Context, interface{}) (string, error) {
+			started <- struct{}{}
+			<-release
+			return "", nil
+		}
+		dropped = generic.NewCounter("dropped")
+		e       = mirror.New[string](shadow, mirror.Workers[string](2), mirror.Dropped[string](dropped))(primary)
+	)
+	defer close(release)
+
+	for i := 0; i < 5; i++ {
+		e(context.Background(), "hello")
+	}
+	if want, have := 3.0, dropped.Value(); want != have {
+		t.Errorf("dropped: want %v, have %v", want, have)
+	}
+}
+
+type lines chan []interface{}
+
+func (l lines) Log(keyvals ...interface{}) error {
+	l <- keyvals
+	return nil
+}
+
+var _ log.Logger = lines(nil)
+
+func TestCompare(t *testing.T) {
+	var (
+		shadow = func(_ context.Context, request interface{}) (string, error) {
+			if request == "different" {
+				return "other", nil
+			}
+			return request.(string), nil
+		}
+		mismatches = generic.NewCounter("mismatches")
+		logged     = make(lines, 2)
+		compared   = make(chan bool, 2)
+		compare    = func(p string, pErr error, s string, sErr error) bool {
+			match := p == s && pErr == sErr
+			compared <- match
+			return match
+		}
+		e = mirror.New[string](shadow,
+			mirror.Compare[string](compare),
+			mirror.Mismatches[string](mismatches),
+			mirror.Logger[string](logged),
+		)(primary)
+	)
+
+	e(context.Background(), "same")
+	if !<-compared {
+		t.Error("want match")
+	}
+	e(context.Background(), "different")
+	if <-compared {
+		t.Error("want mismatch")
+	}
+
+	keyvals := <-logged
+	want := []interface{}{
+		"msg", "shadow response mismatch",
+		"primary", "different", "primary_err", nil,
+		"shadow", "other", "shadow_err", nil,
+	}
+	if !reflect.DeepEqual(want, keyvals) {
+		t.Errorf("log: want %v, have %v", want, keyvals)
+	}
+	if want, have := 1.0, mismatches.Value(); want != have {
+		t.Errorf("mismatches: want %v, have %v", want, have)
+	}
+}
+
+func TestPanickingShadow(t *testing.T) {
+	var (
+		calls  = make(chan struct{}, 10)
+		shadow = func(context.Context, interface{}) (string, error) {
+			calls <- struct{}{}
+			panic("boom")
+		}
+		e = mirror.New[string](shadow, mirror.Workers[string](1))(primary)
+	)
+	if response, err := e(context.Background(), "hello"); err != nil || response != "hello" {
+		t.Fatalf("want primary response, have %q (%v)", response, err)
+	}
+	waitShadow(t, calls)
+
+	// The worker is released after the panic.
+	waitMirrored(t, e, calls)
+}
+
+func TestPanickingPrimary(t *testing.T) {
+	var (
+		calls  = make(chan struct{}, 10)
+		shadow = func(_ context.Context, request interface{}) (string, error) {
+			calls <- struct{}{}
+			return request.(string), nil
+		}
+		compare = func(string, error, string, error) bool { return true }
+		e       = mirror.New[string](shadow, mirror.Workers[string](1), mirror.Compare[string](compare))
+		panicky = e(func(context.Context, interface{}) (string, error) { panic("boom") })
+		healthy = e(primary)
+	)
+	func() {
+		defer func() {
+			if r := recover(); r != "boom" {
+				t.Errorf("want the panic of the primary, have %v", r)
+			}
+		}()
+		panicky(context.Background(), "hello")
+	}()
+	waitShadow(t, calls)
+
+	// The worker isn't stuck waiting for the primary result.
+	waitMirrored(t, healthy, calls)
+}
+
+// waitMirrored calls e until a request is mirrored to the shadow endpoint.
+func waitMirrored(t *testing.T, e func(context.Context, interface{}) (string, error), calls <-chan struct{}) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for {
+		e(context.Background(), "hello")
+		select {
+		case <-calls:
+			return
+		case <-time.After(10 * time.Millisecond):
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("request not mirrored")
+		}
+	}
+}
+
+func waitShadow(t *testing.T, calls <-chan struct{}) {
+	t.Helper()
+	select {
+	case <-calls:
+	case <-time.After(time.Second):
+		t.Fatal("shadow endpoint not called")
+	}
+}
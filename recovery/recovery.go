@@ -0,0 +1,76 @@
+// Package recovery turns panics in endpoints into errors.
+//
+// Without recovery, a panic in an endpoint crashes the goroutine serving the
+// request. The middleware in this package converts it into a *PanicError,
+// which the transport servers report through their transport.ErrorHandler
+// and encode like any other error: DefaultErrorEncoder answers with a 500,
+// and gRPC clients receive codes.Internal. To also protect the decode and
+// encode phases, use the ServerRecover option of the respective transport.
+package recovery
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/tnnyio/yoroi/endpoint"
+)
+
+// PanicError is returned in place of a response when a panic was recovered.
+type PanicError struct {
+	// Value is the value passed to panic.
+	Value interface{}
+
+	// Stack is the stack trace of the goroutine that panicked, as formatted
+	// by runtime/debug.Stack.
+	Stack []byte
+}
+
+// NewPanicError returns a PanicError for the recovered value v, capturing the
+// current stack. It is meant to be called from the deferred function that
+// recovered v, so that the stack still includes the panicking frames.
+func NewPanicError(v interface{}) *PanicError {
+	return &PanicError{Value: v, Stack: debug.Stack()}
+}
+
+// Error implements error. The stack is deliberately left out, so that it is
+// not leaked to clients by error encoders.
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.Value)
+}
+
+// Unwrap returns the panic value if it is an error, so that errors.Is and
+// errors.As see through a PanicError.
+func (e *PanicError) Unwrap() error {
+	err, _ := e.Value.(error)
+	return err
+}
+
+// StatusCode implements the StatusCoder interfaces of the HTTP transports.
+func (e *PanicError) StatusCode() int {
+	return http.StatusInternalServerError
+}
+
+// GRPCStatus makes gRPC servers answer with codes.Internal.
+func (e *PanicError) GRPCStatus() *status.Status {
+	return status.New(codes.Internal, e.Error())
+}
+
+// New returns an endpoint.Middleware that recovers panics in the next
+// endpoint, and returns them as a *PanicError.
+func New[O interface{}]() endpoint.Middleware[O] {
+	return func(next endpoint.Endpoint[O]) endpoint.Endpoint[O] {
+		return func(ctx context.Context, request interface{}) (response O, err error) {
+			defer func() {
+				if v := recover(); v != nil {
+					err = NewPanicError(v)
+				}
+			}()
+			return next(ctx, request)
+		}
+	}
+}
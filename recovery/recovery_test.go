@@ -0,0 +1,65 @@
+package recovery_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/tnnyio/yoroi/recovery"
+)
+
+func TestRecoverValue(t *testing.T) {
+	e := recovery.New[string]()(func(context.Context, interface{}) (string, error) {
+		panic("boom")
+	})
+
+	_, err := e(context.Background(), struct{}{})
+
+	var perr *recovery.PanicError
+	if !errors.As(err, &perr) {
+		t.Fatalf("want *recovery.PanicError, have %T", err)
+	}
+	if want, have := "boom", perr.Value; want != have {
+		t.Errorf("value: want %v, have %v", want, have)
+	}
+	if want, have := "panic: boom", perr.Error(); want != have {
+		t.Errorf("error: want %q, have %q", want, have)
+	}
+	if !strings.Contains(string(perr.Stack), "recovery_test.TestRecoverValue") {
+		t.Errorf("stack doesn't include the panicking frame:\n%s", perr.Stack)
+	}
+	if want, have := http.StatusInternalServerError, perr.StatusCode(); want != have {
+		t.Errorf("status code: want %d, have %d", want, have)
+	}
+	if want, have := codes.Internal, status.Code(err); want != have {
+		t.Errorf("gRPC code: want %s, have %s", want, have)
+	}
+}
+
+func TestRecoverError(t *testing.T) {
+	e := recovery.New[string]()(func(context.Context, interface{}) (string, error) {
+		panic(io.ErrUnexpectedEOF)
+	})
+	if _, err := e(context.Background(), struct{}{}); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("want error wrapping %v, have %v", io.ErrUnexpectedEOF, err)
+	}
+}
+
+func TestNoPanic(t *testing.T) {
+	e := recovery.New[string]()(func(context.Context, interface{}) (string, error) {
+		return "ok", nil
+	})
+	response, err := e(context.Background(), struct{}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, have := "ok", response; want != have {
+		t.Errorf("want %q, have %q", want, have)
+	}
+}
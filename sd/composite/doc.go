@@ -0,0 +1,4 @@
+// Package composite provides an Instancer merging the instances of several
+// other Instancers, such as the same service registered in two discovery
+// systems during a migration.
+package composite
@@ -0,0 +1,202 @@
+package composite
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/tnnyio/log"
+	"github.com/tnnyio/yoroi/sd"
+	"github.com/tnnyio/yoroi/sd/internal/instance"
+)
+
+// SourceError is the error of one of the sources of an Instancer.
+type SourceError struct {
+	Source int // index of the source
+	Err    error
+}
+
+func (e *SourceError) Error() string {
+	return fmt.Sprintf("source %d: %v", e.Source, e.Err)
+}
+
+func (e *SourceError) Unwrap() error {
+	return e.Err
+}
+
+// Policy decides the error of the merged set of instances from the errors of
+// the sources, which are SourceErrors for the failing sources and nil for
+// the others.
+type Policy func(errs []error) error
+
+// AnyError is a Policy failing the merged set as soon as a source fails.
+func AnyError(errs []error) error {
+	return errors.Join(errs...)
+}
+
+// AllErrors is a Policy failing the merged set only when every source fails.
+func AllErrors(errs []error) error {
+	for _, err := range errs {
+		if err == nil {
+			return nil
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Option sets an optional parameter of an Instancer.
+type Option func(*Instancer)
+
+// WithPolicy sets the policy deciding the error of the merged set. The
+// default is AllErrors.
+func WithPolicy(p Policy) Option {
+	return func(in *Instancer) { in.policy = p }
+}
+
+// Failover makes the Instancer use the instances of the first source that
+// has any, in the order of the sources, instead of merging them all: the
+// secondary sources are only used while the primary one is empty.
+func Failover() Option {
+	return func(in *Instancer) { in.failover = true }
+}
+
+// Instancer merges the instances of its sources, without duplicates. A
+// failing source keeps contributing the last instances it published, and
+// the policy decides whether its error fails the merged set. The metadata of
+// an instance is the one of the first source publishing it, and the merged
+// set is stale when a source contributing instances is.
+type Instancer struct {
+	cache    *instance.Cache
+	sources  []sd.Instancer
+	chans    []chan sd.Event
+	policy   Policy
+	failover bool
+	logger   log.Logger
+	wg       sync.WaitGroup
+
+	mtx    sync.Mutex
+	states []sd.Event // last instances and error of each source
+}
+
+// NewInstancer returns an Instancer merging the instances of the sources.
+// Stop deregisters it from the sources, but doesn't stop them.
+func NewInstancer(logger log.Logger, sources []sd.Instancer, options ...Option) *Instancer {
+	in := &Instancer{
+		cache:   instance.NewCache(),
+		sources: sources,
+		chans:   make([]chan sd.Event, len(sources)),
+		policy:  AllErrors,
+		logger:  logger,
+		states:  make([]sd.Event, len(sources)),
+	}
+	for _, option := range options {
+		option(in)
+	}
+
+	// Instancers send their current state on Register: receive it before
+	// publishing the first merged set.
+	for i, src := range sources {
+		in.chans[i] = make(chan sd.Event, 1)
+		src.Register(in.chans[i])
+		in.receive(i, <-in.chans[i])
+	}
+	in.publish()
+
+	for i := range sources {
+		in.wg.Add(1)
+		go in.loop(i)
+	}
+	return in
+}
+
+// Stop deregisters the Instancer from its sources.
+func (in *Instancer) Stop() {
+	for i, src := range in.sources {
+		src.Deregister(in.chans[i])
+		close(in.chans[i])
+	}
+	in.wg.Wait()
+	in.cache.Stop()
+}
+
+// Errors returns the last error of each source, nil for the sources that
+// aren't failing.
+func (in *Instancer) Errors() []error {
+	in.mtx.Lock()
+	defer in.mtx.Unlock()
+	errs := make([]error, len(in.states))
+	for i, state := range in.states {
+		errs[i] = state.Err
+	}
+	return errs
+}
+
+func (in *Instancer) loop(i int) {
+	defer in.wg.Done()
+	for event := range in.chans[i] {
+		in.receive(i, event)
+		in.publish()
+	}
+}
+
+// receive records the event of source i.
+func (in *Instancer) receive(i int, event sd.Event) {
+	in.mtx.Lock()
+	defer in.mtx.Unlock()
+	if event.Err != nil {
+		in.logger.Log("source", i, "err", event.Err)
+		if event.Instances == nil {
+			// Keep the last ones.
+			event.Instances, event.Metadata = in.states[i].Instances, in.states[i].Metadata
+		}
+		event.Err = &SourceError{Source: i, Err: event.Err}
+	}
+	in.states[i] = event
+}
+
+// publish sends the merged set of instances to the subscribers.
+func (in *Instancer) publish() {
+	in.mtx.Lock()
+	defer in.mtx.Unlock()
+
+	var (
+		seen      = map[string]bool{}
+		instances []string
+		metadata  map[string]sd.Metadata
+		errs      = make([]error, len(in.states))
+		stale     bool
+	)
+	for i, state := range in.states {
+		errs[i] = state.Err
+		if in.failover && len(instances) > 0 {
+			continue
+		}
+		stale = stale || state.Stale && len(state.Instances) > 0
+		for _, instance := range state.Instances {
+			if seen[instance] {
+				continue
+			}
+			seen[instance] = true
+			instances = append(instances, instance)
+			if md, ok := state.Metadata[instance]; ok {
+				if metadata == nil {
+					metadata = map[string]sd.Metadata{}
+				}
+				metadata[instance] = md
+			}
+		}
+	}
+	sort.Strings(instances)
+	in.cache.Update(sd.Event{Instances: instances, Metadata: metadata, Err: in.policy(errs), Stale: stale})
+}
+
+// Register implements Instancer.
+func (in *Instancer) Register(ch chan<- sd.Event) {
+	in.cache.Register(ch)
+}
+
+// Deregister implements Instancer.
+func (in *Instancer) Deregister(ch chan<- sd.Event) {
+	in.cache.Deregister(ch)
+}
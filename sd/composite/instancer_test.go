@@ -0,0 +1,182 @@
+package composite_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/tnnyio/log"
+	"github.com/tnnyio/yoroi/sd"
+	"github.com/tnnyio/yoroi/sd/composite"
+	"github.com/tnnyio/yoroi/sd/internal/instance"
+)
+
+var _ sd.Instancer = (*composite.Instancer)(nil) // API check
+
+var errDown = errors.New("down")
+
+func TestMerge(t *testing.T) {
+	consul, kubernetes := instance.NewCache(), instance.NewCache()
+	consul.Update(sd.Event{Instances: []string{"10.0.0.1:80", "10.0.0.2:80"}})
+	kubernetes.Update(sd.Event{Instances: []string{"10.0.0.2:80", "10.0.0.3:80"}})
+
+	in := composite.NewInstancer(log.NewNopLogger(), []sd.Instancer{consul, kubernetes})
+	defer in.Stop()
+	ch := register(in)
+	expect(t, ch, "[10.0.0.1:80 10.0.0.2:80 10.0.0.3:80]", false)
+
+	kubernetes.Update(sd.Event{Instances: []string{"10.0.0.3:80", "10.0.0.4:80"}})
+	expect(t, ch, "[10.0.0.1:80 10.0.0.2:80 10.0.0.3:80 10.0.0.4:80]", false)
+	consul.Update(sd.Event{Instances: []string{}})
+	expect(t, ch, "[10.0.0.3:80 10.0.0.4:80]", false)
+}
+
+func TestAllErrors(t *testing.T) {
+	a, b := instance.NewCache(), instance.NewCache()
+	a.Update(sd.Event{Instances: []string{"a"}})
+	b.Update(sd.Event{Instances: []string{"b"}})
+
+	in := composite.NewInstancer(log.NewNopLogger(), []sd.Instancer{a, b})
+	defer in.Stop()
+	ch := register(in)
+	expect(t, ch, "[a b]", false)
+
+	// The failing source keeps its last instances.
+	b.Update(sd.Event{Err: errDown})
+	waitErrors(t, in, "[<nil> source 1: down]")
+	if want, have := "[a b] <nil>", current(in); want != have {
+		t.Errorf("want %s, have %s", want, have)
+	}
+	a.Update(sd.Event{Instances: []string{"a2"}})
+	expect(t, ch, "[a2 b]", false)
+
+	a.Update(sd.Event{Err: errDown})
+	event := expect(t, ch, "[a2 b]", true)
+	if !errors.Is(event.Err, errDown) {
+		t.Errorf("want %v, have %v", errDown, event.Err)
+	}
+
+	b.Update(sd.Event{Instances: []string{"b2"}})
+	expect(t, ch, "[a2 b2]", false)
+}
+
+func TestAnyError(t *testing.T) {
+	a, b := instance.NewCache(), instance.NewCache()
+	a.Update(sd.Event{Instances: []string{"a"}})
+	b.Update(sd.Event{Instances: []string{"b"}})
+
+	in := composite.NewInstancer(log.NewNopLogger(), []sd.Instancer{a, b}, composite.WithPolicy(composite.AnyError))
+	defer in.Stop()
+	ch := register(in)
+	expect(t, ch, "[a b]", false)
+
+	b.Update(sd.Event{Err: errDown})
+	event := expect(t, ch, "[a b]", true)
+	var sourceErr *composite.SourceError
+	if !errors.As(event.Err, &sourceErr) || sourceErr.Source != 1 {
+		t.Errorf("want an error of source 1, have %v", event.Err)
+	}
+	if want, have := "source 1: down", event.Err.Error(); want != have {
+		t.Errorf("want %q, have %q", want, have)
+	}
+}
+
+func TestFailover(t *testing.T) {
+	primary, secondary := instance.NewCache(), instance.NewCache()
+	secondary.Update(sd.Event{Instances: []string{"s1", "s2"}})
+
+	in := composite.NewInstancer(log.NewNopLogger(), []sd.Instancer{primary, secondary}, composite.Failover())
+	defer in.Stop()
+	ch := register(in)
+	expect(t, ch, "[s1 s2]", false)
+
+	primary.Update(sd.Event{Instances: []string{"p1"}})
+	expect(t, ch, "[p1]", false)
+
+	// A failing primary keeps its last instances.
+	primary.Update(sd.Event{Err: errDown})
+	waitErrors(t, in, "[source 0: down <nil>]")
+	if want, have := "[p1] <nil>", current(in); want != have {
+		t.Errorf("want %s, have %s", want, have)
+	}
+	secondary.Update(sd.Event{Instances: []string{"s3"}})
+	primary.Update(sd.Event{Instances: []string{"p2"}})
+	expect(t, ch, "[p2]", false)
+
+	primary.Update(sd.Event{Instances: []string{}})
+	expect(t, ch, "[s3]", false)
+}
+
+func TestStale(t *testing.T) {
+	primary, secondary := instance.NewCache(), instance.NewCache()
+	primary.Update(sd.Event{Instances: []string{"p1"}})
+	secondary.Update(sd.Event{Instances: []string{"s1"}, Stale: true})
+
+	// A stale source skipped by failover doesn't make the set stale.
+	in := composite.NewInstancer(log.NewNopLogger(), []sd.Instancer{primary, secondary}, composite.Failover())
+	defer in.Stop()
+	ch := register(in)
+	if event := expect(t, ch, "[p1]", false); event.Stale {
+		t.Error("want live instances")
+	}
+	primary.Update(sd.Event{Instances: []string{}})
+	if event := expect(t, ch, "[s1]", false); !event.Stale {
+		t.Error("want stale instances")
+	}
+}
+
+func TestStop(t *testing.T) {
+	src := instance.NewCache()
+	in := composite.NewInstancer(log.NewNopLogger(), []sd.Instancer{src, sd.FixedInstancer{"x"}})
+	in.Stop()
+
+	// Updates after Stop don't reach the Instancer.
+	src.Update(sd.Event{Instances: []string{"y"}})
+	if want, have := "[x] <nil>", current(in); want != have {
+		t.Errorf("want %s, have %s", want, have)
+	}
+}
+
+// waitErrors waits until the errors of the sources are errs.
+func waitErrors(t *testing.T, in *composite.Instancer, errs string) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for fmt.Sprint(in.Errors()) != errs {
+		if time.Now().After(deadline) {
+			t.Fatalf("want errors %s, have %v", errs, in.Errors())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// current returns the instances and error the Instancer publishes.
+func current(in *composite.Instancer) string {
+	ch := register(in)
+	defer in.Deregister(ch)
+	event := <-ch
+	return fmt.Sprint(event.Instances, " ", event.Err)
+}
+
+func register(in *composite.Instancer) chan sd.Event {
+	ch := make(chan sd.Event, 1)
+	in.Register(ch)
+	return ch
+}
+
+// expect waits for an event with the instances, and an error or not.
+func expect(t *testing.T, ch <-chan sd.Event, instances string, failed bool) sd.Event {
+	t.Helper()
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case event := <-ch:
+			if fmt.Sprint(event.Instances) == instances && (event.Err != nil) == failed {
+				return event
+			}
+		case <-timeout:
+			t.Fatalf("no event with instances %s (error %v)", instances, failed)
+			return sd.Event{}
+		}
+	}
+}
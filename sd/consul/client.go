@@ -1,6 +1,8 @@
 package consul
 
 import (
+	"errors"
+
 	consul "github.com/hashicorp/consul/api"
 )
 
@@ -16,13 +18,47 @@ type Client interface {
 	Service(service, tag string, passingOnly bool, queryOpts *consul.QueryOptions) ([]*consul.ServiceEntry, *consul.QueryMeta, error)
 }
 
+// QueryClient is a Client able to execute prepared queries, as the one
+// returned by NewClient. It's required by the PreparedQuery option.
+type QueryClient interface {
+	Client
+
+	// Execute a prepared query, by ID or name.
+	Execute(query string, queryOpts *consul.QueryOptions) (*consul.PreparedQueryExecuteResponse, *consul.QueryMeta, error)
+}
+
+var errNoQueryClient = errors.New("client doesn't implement QueryClient")
+
+// TTLClient is a Client able to update TTL checks, as the one returned by
+// NewClient. It's required by the TTL option and by Registrar.Report.
+type TTLClient interface {
+	Client
+
+	// UpdateTTL sets the status and output of a TTL check with the local agent.
+	UpdateTTL(checkID, output, status string) error
+}
+
+var errNoTTLClient = errors.New("client doesn't implement TTLClient")
+
+// AgentClient is a Client able to list the services of the local agent, as
+// the one returned by NewClient. It's required by the Heartbeat option.
+type AgentClient interface {
+	Client
+
+	// AgentServices returns the services registered with the local agent, by
+	// ID.
+	AgentServices() (map[string]*consul.AgentService, error)
+}
+
+var errNoAgentClient = errors.New("client doesn't implement AgentClient")
+
 type client struct {
 	consul *consul.Client
 }
 
 // NewClient returns an implementation of the Client interface, wrapping a
 // concrete Consul client.
-func NewClient(c *consul.Client) Client {
+func NewClient(c *consul.Client) QueryClient {
 	return &client{consul: c}
 }
 
@@ -34,6 +70,18 @@ func (c *client) Deregister(r *consul.AgentServiceRegistration) error {
 	return c.consul.Agent().ServiceDeregister(r.ID)
 }
 
+func (c *client) UpdateTTL(checkID, output, status string) error {
+	return c.consul.Agent().UpdateTTL(checkID, output, status)
+}
+
+func (c *client) AgentServices() (map[string]*consul.AgentService, error) {
+	return c.consul.Agent().Services()
+}
+
 func (c *client) Service(service, tag string, passingOnly bool, queryOpts *consul.QueryOptions) ([]*consul.ServiceEntry, *consul.QueryMeta, error) {
 	return c.consul.Health().Service(service, tag, passingOnly, queryOpts)
 }
+
+func (c *client) Execute(query string, queryOpts *consul.QueryOptions) (*consul.PreparedQueryExecuteResponse, *consul.QueryMeta, error) {
+	return c.consul.PreparedQuery().Execute(query, queryOpts)
+}
@@ -5,6 +5,8 @@ import (
 	"errors"
 	"io"
 	"reflect"
+	"strconv"
+	"sync"
 	"testing"
 
 	stdconsul "github.com/hashicorp/consul/api"
@@ -57,7 +59,16 @@ func TestClientRegistration(t *testing.T) {
 }
 
 type testClient struct {
+	mtx     sync.Mutex
 	entries []*stdconsul.ServiceEntry
+	ttls    []ttlUpdate
+	queries []stdconsul.QueryOptions
+	down    map[string]error // by datacenter
+	calls   int              // of Register
+}
+
+type ttlUpdate struct {
+	checkID, output, status string
 }
 
 func newTestClient(entries []*stdconsul.ServiceEntry) *testClient {
@@ -66,15 +77,30 @@ func newTestClient(entries []*stdconsul.ServiceEntry) *testClient {
 	}
 }
 
-var _ Client = &testClient{}
+var (
+	_ QueryClient = &testClient{}
+	_ TTLClient   = &testClient{}
+	_ AgentClient = &testClient{}
+)
 
+// Service returns the entries of the service, in the datacenter of the
+// query if set.
 func (c *testClient) Service(service, tag string, _ bool, opts *stdconsul.QueryOptions) ([]*stdconsul.ServiceEntry, *stdconsul.QueryMeta, error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.queries = append(c.queries, *opts)
+	if err := c.down[opts.Datacenter]; err != nil {
+		return nil, nil, err
+	}
 	var results []*stdconsul.ServiceEntry
 
 	for _, entry := range c.entries {
 		if entry.Service.Service != service {
 			continue
 		}
+		if opts.Datacenter != "" && entry.Node.Datacenter != opts.Datacenter {
+			continue
+		}
 		if tag != "" {
 			tagMap := map[string]struct{}{}
 
@@ -94,6 +120,9 @@ func (c *testClient) Service(service, tag string, _ bool, opts *stdconsul.QueryO
 }
 
 func (c *testClient) Register(r *stdconsul.AgentServiceRegistration) error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.calls++
 	toAdd := registration2entry(r)
 
 	for _, entry := range c.entries {
@@ -106,7 +135,45 @@ func (c *testClient) Register(r *stdconsul.AgentServiceRegistration) error {
 	return nil
 }
 
+// UpdateTTL records the update, and fails for the checks of services that
+// aren't registered.
+func (c *testClient) UpdateTTL(checkID, output, status string) error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.ttls = append(c.ttls, ttlUpdate{checkID, output, status})
+	for _, entry := range c.entries {
+		if len(entry.Checks) == 0 && "service:"+entry.Service.ID == checkID {
+			return nil
+		}
+		for _, check := range entry.Checks {
+			if check.CheckID == checkID {
+				return nil
+			}
+		}
+	}
+	return errors.New("unknown check")
+}
+
+// AgentServices returns the services registered on the node of the local
+// agent, "some-node".
+func (c *testClient) AgentServices() (map[string]*stdconsul.AgentService, error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if err := c.down[""]; err != nil {
+		return nil, err
+	}
+	services := map[string]*stdconsul.AgentService{}
+	for _, entry := range c.entries {
+		if entry.Node.Node == "some-node" {
+			services[entry.Service.ID] = entry.Service
+		}
+	}
+	return services, nil
+}
+
 func (c *testClient) Deregister(r *stdconsul.AgentServiceRegistration) error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
 	toDelete := registration2entry(r)
 
 	var newEntries []*stdconsul.ServiceEntry
@@ -124,20 +191,99 @@ func (c *testClient) Deregister(r *stdconsul.AgentServiceRegistration) error {
 	return nil
 }
 
+// Execute runs a prepared query named after a service, returning all of its
+// entries as if they were in the datacenter "dc2".
+func (c *testClient) Execute(query string, opts *stdconsul.QueryOptions) (*stdconsul.PreparedQueryExecuteResponse, *stdconsul.QueryMeta, error) {
+	entries, meta, err := c.Service(query, "", false, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	res := &stdconsul.PreparedQueryExecuteResponse{Service: query, Datacenter: "dc2"}
+	for _, entry := range entries {
+		res.Nodes = append(res.Nodes, *entry)
+	}
+	return res, meta, nil
+}
+
+func (c *testClient) setDown(dc string, err error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if c.down == nil {
+		c.down = map[string]error{}
+	}
+	c.down[dc] = err
+}
+
+func (c *testClient) setEntries(entries []*stdconsul.ServiceEntry) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.entries = entries
+}
+
+// restart forgets the registrations, like an agent restarting without its
+// state.
+func (c *testClient) restart() {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.entries = nil
+}
+
+func (c *testClient) registrations() int {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return len(c.entries)
+}
+
+func (c *testClient) registerCalls() int {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.calls
+}
+
+func (c *testClient) lastTTL() (ttlUpdate, int) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if len(c.ttls) == 0 {
+		return ttlUpdate{}, 0
+	}
+	return c.ttls[len(c.ttls)-1], len(c.ttls)
+}
+
+// registration2entry returns the entry of a registration, whose ID defaults
+// to the service name as with Consul. Its checks are named as Consul does
+// too.
 func registration2entry(r *stdconsul.AgentServiceRegistration) *stdconsul.ServiceEntry {
+	id := r.ID
+	if id == "" {
+		id = r.Name
+	}
+	var checks stdconsul.HealthChecks
+	for _, c := range append(stdconsul.AgentServiceChecks{r.Check}, r.Checks...) {
+		if c != nil {
+			checks = append(checks, &stdconsul.HealthCheck{CheckID: c.CheckID})
+		}
+	}
+	for i, c := range checks {
+		if c.CheckID == "" {
+			c.CheckID = "service:" + id
+			if len(checks) > 1 {
+				c.CheckID += ":" + strconv.Itoa(i+1)
+			}
+		}
+	}
 	return &stdconsul.ServiceEntry{
 		Node: &stdconsul.Node{
 			Node:    "some-node",
 			Address: r.Address,
 		},
 		Service: &stdconsul.AgentService{
-			ID:      r.ID,
+			ID:      id,
 			Service: r.Name,
 			Tags:    r.Tags,
 			Port:    r.Port,
 			Address: r.Address,
 		},
-		// Checks ignored
+		Checks: checks,
 	}
 }
 
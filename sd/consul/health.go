@@ -0,0 +1,84 @@
+package consul
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	stdconsul "github.com/hashicorp/consul/api"
+
+	"github.com/tnnyio/yoroi/health"
+)
+
+var _ health.Reporter = (*Registrar)(nil)
+
+// Report implements health.Reporter, by updating the TTL check of the
+// registration: passing when the report is up, critical otherwise. The
+// registration must define a check with a TTL, in Check or Checks, and the
+// first one is updated. The client must implement TTLClient, as the one
+// returned by NewClient does.
+func (p *Registrar) Report(_ context.Context, report health.Report) error {
+	client, ok := p.client.(TTLClient)
+	if !ok {
+		return errNoTTLClient
+	}
+	status, output := reportStatus(report)
+	return client.UpdateTTL(p.checkID(), output, status)
+}
+
+// Readiness returns a HealthFunc for the TTL option reporting the readiness
+// of h, like Report does.
+func Readiness(h *health.Health) HealthFunc {
+	return func(ctx context.Context) (string, string) {
+		return reportStatus(h.Readiness(ctx))
+	}
+}
+
+func reportStatus(report health.Report) (status, output string) {
+	status, output = stdconsul.HealthPassing, string(report.Status)
+	if report.Status != health.StatusUp {
+		status = stdconsul.HealthCritical
+		if failed := report.Failed(); len(failed) > 0 {
+			output += ": " + strings.Join(failed, ", ")
+		}
+	}
+	return status, output
+}
+
+// checkID returns the ID of the first TTL check of the registration, among
+// Check and then Checks. As with Consul, a check without a CheckID is named
+// "service:" followed by the service ID, and numbered from 1 when the
+// registration has several checks.
+func (p *Registrar) checkID() string {
+	var checks stdconsul.AgentServiceChecks
+	if p.registration.Check != nil {
+		checks = append(checks, p.registration.Check)
+	}
+	for _, c := range p.registration.Checks {
+		if c != nil {
+			checks = append(checks, c)
+		}
+	}
+	id := "service:" + p.serviceID()
+	for i, c := range checks {
+		switch {
+		case c.TTL == "":
+			continue
+		case c.CheckID != "":
+			return c.CheckID
+		case len(checks) > 1:
+			return fmt.Sprintf("%s:%d", id, i+1)
+		}
+		return id
+	}
+	return id
+}
+
+// serviceID returns the ID of the registration, which Consul defaults to the
+// service name.
+func (p *Registrar) serviceID() string {
+	if p.registration.ID != "" {
+		return p.registration.ID
+	}
+	return p.registration.Name
+}
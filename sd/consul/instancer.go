@@ -3,6 +3,7 @@ package consul
 import (
 	"errors"
 	"fmt"
+	"sync"
 	"time"
 
 	consul "github.com/hashicorp/consul/api"
@@ -18,21 +19,93 @@ const defaultIndex = 0
 // errStopped notifies the loop to quit. aka stopped via quitc
 var errStopped = errors.New("quit and closed consul instancer")
 
+// InstancerOption sets an optional parameter of an Instancer.
+type InstancerOption func(*Instancer)
+
+// Datacenters makes the Instancer query the service in the datacenters,
+// instead of the one of the agent. With several datacenters, each one is
+// watched separately and their instances are merged; a failing datacenter
+// keeps contributing its last instances, with the event marked stale, and
+// the Instancer only publishes an error when every datacenter fails. The
+// datacenter of each instance is in the "datacenter" key of its metadata.
+// Without datacenters, the one of the agent is queried.
+func Datacenters(dcs ...string) InstancerOption {
+	return func(s *Instancer) {
+		if len(dcs) > 0 {
+			s.dcs = dcs
+		}
+	}
+}
+
+// Namespace makes the Instancer query the service in the namespace, with
+// Consul Enterprise.
+func Namespace(ns string) InstancerOption {
+	return func(s *Instancer) { s.namespace = ns }
+}
+
+// Partition makes the Instancer query the service in the admin partition,
+// with Consul Enterprise.
+func Partition(partition string) InstancerOption {
+	return func(s *Instancer) { s.partition = partition }
+}
+
+// Filter sets a filter expression evaluated by Consul on the service
+// entries, such as `Service.Meta.version == "2"`. It needs Consul 1.5 or
+// later, and doesn't apply to prepared queries. Without it, no filter is
+// sent to Consul.
+func Filter(expr string) InstancerOption {
+	return func(s *Instancer) { s.filter = expr }
+}
+
+// PreparedQuery makes the Instancer execute the prepared query, by ID or
+// name, instead of querying the service, for instance to fail over to other
+// datacenters. Prepared queries don't support blocking, so the query is
+// executed every interval, or DefaultInterval if it isn't positive. The
+// query decides the health of the instances: the passingOnly parameter of
+// NewInstancer is ignored, but the tags are still required. The client must
+// implement QueryClient, as the one returned by NewClient does, or the
+// Instancer only publishes an error.
+func PreparedQuery(query string, interval time.Duration) InstancerOption {
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+	return func(s *Instancer) {
+		s.query = query
+		s.queryInterval = interval
+	}
+}
+
 // Instancer yields instances for a service in Consul.
 type Instancer struct {
-	cache       *instance.Cache
-	client      Client
-	logger      log.Logger
-	service     string
-	tags        []string
-	passingOnly bool
-	quitc       chan struct{}
+	cache         *instance.Cache
+	client        Client
+	logger        log.Logger
+	service       string
+	tags          []string
+	passingOnly   bool
+	dcs           []string
+	namespace     string
+	partition     string
+	filter        string
+	query         string
+	queryInterval time.Duration
+	quitc         chan struct{}
+
+	mtx    sync.Mutex
+	states []state // by datacenter
+}
+
+// state is the last result of the query in a datacenter.
+type state struct {
+	instances []string
+	metadata  map[string]sd.Metadata
+	err       error
 }
 
 // NewInstancer returns a Consul instancer that publishes instances for the
 // requested service. It only returns instances for which all of the passed tags
 // are present.
-func NewInstancer(client Client, logger log.Logger, service string, tags []string, passingOnly bool) *Instancer {
+func NewInstancer(client Client, logger log.Logger, service string, tags []string, passingOnly bool, options ...InstancerOption) *Instancer {
 	s := &Instancer{
 		cache:       instance.NewCache(),
 		client:      client,
@@ -40,74 +113,141 @@ func NewInstancer(client Client, logger log.Logger, service string, tags []strin
 		service:     service,
 		tags:        tags,
 		passingOnly: passingOnly,
+		dcs:         []string{""}, // the datacenter of the agent
 		quitc:       make(chan struct{}),
 	}
+	for _, option := range options {
+		option(s)
+	}
+	if _, ok := client.(QueryClient); s.query != "" && !ok {
+		s.logger.Log("err", errNoQueryClient)
+		s.cache.Update(sd.Event{Err: errNoQueryClient})
+		return s
+	}
+	s.states = make([]state, len(s.dcs))
 
-	instances, index, err := s.getInstances(defaultIndex, nil)
-	if err == nil {
-		s.logger.Log("instances", len(instances))
-	} else {
-		s.logger.Log("err", err)
+	indexes := make([]uint64, len(s.dcs))
+	for i := range s.dcs {
+		instances, metadata, index, err := s.getInstances(s.dcs[i], defaultIndex, nil)
+		if err == nil {
+			s.dcLogger(i).Log("instances", len(instances))
+		} else {
+			s.dcLogger(i).Log("err", err)
+		}
+		s.states[i] = state{instances: instances, metadata: metadata, err: err}
+		indexes[i] = index
 	}
+	s.publish()
 
-	s.cache.Update(sd.Event{Instances: instances, Err: err})
-	go s.loop(index)
+	for i := range s.dcs {
+		go s.loop(i, indexes[i])
+	}
 	return s
 }
 
 // Stop terminates the instancer.
 func (s *Instancer) Stop() {
 	close(s.quitc)
+	s.cache.Stop()
+}
+
+func (s *Instancer) dcLogger(i int) log.Logger {
+	if s.dcs[i] == "" {
+		return s.logger
+	}
+	return log.With(s.logger, "datacenter", s.dcs[i])
 }
 
-func (s *Instancer) loop(lastIndex uint64) {
+func (s *Instancer) loop(i int, lastIndex uint64) {
 	var (
 		instances []string
+		metadata  map[string]sd.Metadata
 		err       error
 		d         time.Duration = 10 * time.Millisecond
 		index     uint64
+		logger    = s.dcLogger(i)
 	)
 	for {
-		instances, index, err = s.getInstances(lastIndex, s.quitc)
+		instances, metadata, index, err = s.getInstances(s.dcs[i], lastIndex, s.quitc)
 		switch {
 		case errors.Is(err, errStopped):
 			return // stopped via quitc
 		case err != nil:
-			s.logger.Log("err", err)
+			logger.Log("err", err)
 			time.Sleep(d)
 			d = conn.Exponential(d)
-			s.cache.Update(sd.Event{Err: err})
+			s.update(i, state{err: err})
 		case index == defaultIndex:
-			s.logger.Log("err", "index is not sane")
+			logger.Log("err", "index is not sane")
 			time.Sleep(d)
 			d = conn.Exponential(d)
 		case index < lastIndex:
-			s.logger.Log("err", "index is less than previous; resetting to default")
+			logger.Log("err", "index is less than previous; resetting to default")
 			lastIndex = defaultIndex
 			time.Sleep(d)
 			d = conn.Exponential(d)
 		default:
 			lastIndex = index
-			s.cache.Update(sd.Event{Instances: instances})
+			s.update(i, state{instances: instances, metadata: metadata})
 			d = 10 * time.Millisecond
 		}
 	}
 }
 
-func (s *Instancer) getInstances(lastIndex uint64, interruptc chan struct{}) ([]string, uint64, error) {
-	tag := ""
-	if len(s.tags) > 0 {
-		tag = s.tags[0]
+// update records the state of datacenter i, and publishes the merged one. A
+// failing datacenter keeps its last instances.
+func (s *Instancer) update(i int, st state) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if st.err != nil {
+		st.instances, st.metadata = s.states[i].instances, s.states[i].metadata
 	}
+	s.states[i] = st
+	s.publish()
+}
 
-	// Consul doesn't support more than one tag in its service query method.
-	// https://github.com/hashicorp/consul/issues/294
-	// Hashi suggest prepared queries, but they don't support blocking.
-	// https://www.consul.io/docs/agent/http/query.html#execute
-	// If we want blocking for efficiency, we must filter tags manually.
+// publish sends the instances of the datacenters to the subscribers, marked
+// stale if some datacenters fail, or an error if they all fail. It must be
+// called with mtx held, or before the loops start.
+func (s *Instancer) publish() {
+	if len(s.states) == 1 {
+		st := s.states[0]
+		if st.err != nil {
+			s.cache.Update(sd.Event{Err: st.err})
+			return
+		}
+		s.cache.Update(sd.Event{Instances: st.instances, Metadata: st.metadata})
+		return
+	}
 
+	var (
+		instances = []string{}
+		metadata  = map[string]sd.Metadata{}
+		errs      []error
+	)
+	for i, st := range s.states {
+		if st.err != nil {
+			errs = append(errs, fmt.Errorf("datacenter %s: %w", s.dcs[i], st.err))
+		}
+		for _, instance := range st.instances {
+			if _, ok := metadata[instance]; ok {
+				continue
+			}
+			instances = append(instances, instance)
+			metadata[instance] = st.metadata[instance]
+		}
+	}
+	if len(errs) == len(s.states) {
+		s.cache.Update(sd.Event{Err: errors.Join(errs...)})
+		return
+	}
+	s.cache.Update(sd.Event{Instances: instances, Metadata: metadata, Stale: len(errs) > 0})
+}
+
+func (s *Instancer) getInstances(dc string, lastIndex uint64, interruptc chan struct{}) ([]string, map[string]sd.Metadata, uint64, error) {
 	type response struct {
 		instances []string
+		metadata  map[string]sd.Metadata
 		index     uint64
 	}
 
@@ -117,30 +257,91 @@ func (s *Instancer) getInstances(lastIndex uint64, interruptc chan struct{}) ([]
 	)
 
 	go func() {
-		entries, meta, err := s.client.Service(s.service, tag, s.passingOnly, &consul.QueryOptions{
-			WaitIndex: lastIndex,
-		})
+		var (
+			entries    []*consul.ServiceEntry
+			datacenter = dc
+			index      uint64
+			err        error
+		)
+		if s.query == "" {
+			entries, index, err = s.getService(dc, lastIndex)
+		} else {
+			entries, datacenter, index, err = s.executeQuery(dc, lastIndex, interruptc)
+		}
 		if err != nil {
 			errc <- err
 			return
 		}
-		if len(s.tags) > 1 {
-			entries = filterEntries(entries, s.tags[1:]...)
-		}
 		resc <- response{
 			instances: makeInstances(entries),
-			index:     meta.LastIndex,
+			metadata:  makeMetadata(entries, datacenter),
+			index:     index,
 		}
 	}()
 
 	select {
 	case err := <-errc:
-		return nil, 0, err
+		return nil, nil, 0, err
 	case res := <-resc:
-		return res.instances, res.index, nil
+		return res.instances, res.metadata, res.index, nil
 	case <-interruptc:
-		return nil, 0, errStopped
+		return nil, nil, 0, errStopped
+	}
+}
+
+// getService runs a blocking query of the service in the datacenter.
+func (s *Instancer) getService(dc string, lastIndex uint64) ([]*consul.ServiceEntry, uint64, error) {
+	// Consul doesn't support more than one tag in its service query method.
+	// https://github.com/hashicorp/consul/issues/294
+	tag := ""
+	if len(s.tags) > 0 {
+		tag = s.tags[0]
+	}
+	entries, meta, err := s.client.Service(s.service, tag, s.passingOnly, &consul.QueryOptions{
+		WaitIndex:  lastIndex,
+		Datacenter: dc,
+		Namespace:  s.namespace,
+		Partition:  s.partition,
+		Filter:     s.filter,
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(s.tags) > 1 {
+		entries = filterEntries(entries, s.tags[1:]...)
+	}
+	return entries, meta.LastIndex, nil
+}
+
+// executeQuery executes the prepared query in the datacenter, after waiting
+// for the interval unless it's the first execution. It returns the
+// datacenter the instances are in, and an index increasing at every
+// execution, as prepared queries don't have one.
+func (s *Instancer) executeQuery(dc string, lastIndex uint64, interruptc chan struct{}) ([]*consul.ServiceEntry, string, uint64, error) {
+	client := s.client.(QueryClient) // checked by NewInstancer
+	if lastIndex != defaultIndex {
+		select {
+		case <-time.After(s.queryInterval):
+		case <-interruptc:
+			return nil, "", 0, errStopped
+		}
+	}
+	res, _, err := client.Execute(s.query, &consul.QueryOptions{
+		Datacenter: dc,
+		Namespace:  s.namespace,
+		Partition:  s.partition,
+	})
+	if err != nil {
+		return nil, "", 0, err
+	}
+	entries := make([]*consul.ServiceEntry, len(res.Nodes))
+	for i := range res.Nodes {
+		entries[i] = &res.Nodes[i]
 	}
+	if len(s.tags) > 0 {
+		entries = filterEntries(entries, s.tags...)
+	}
+	return entries, res.Datacenter, lastIndex + 1, nil
 }
 
 // Register implements Instancer.
@@ -177,11 +378,41 @@ ENTRIES:
 func makeInstances(entries []*consul.ServiceEntry) []string {
 	instances := make([]string, len(entries))
 	for i, entry := range entries {
-		addr := entry.Node.Address
-		if entry.Service.Address != "" {
-			addr = entry.Service.Address
-		}
-		instances[i] = fmt.Sprintf("%s:%d", addr, entry.Service.Port)
+		instances[i] = makeInstance(entry)
 	}
 	return instances
 }
+
+func makeInstance(entry *consul.ServiceEntry) string {
+	addr := entry.Node.Address
+	if entry.Service.Address != "" {
+		addr = entry.Service.Address
+	}
+	return fmt.Sprintf("%s:%d", addr, entry.Service.Port)
+}
+
+// makeMetadata returns the tags, zone and metadata of the service
+// instances. The zone is the one of the locality of the service. The
+// datacenter of the node, or dc if unknown, is added to the metadata.
+func makeMetadata(entries []*consul.ServiceEntry, dc string) map[string]sd.Metadata {
+	metadata := make(map[string]sd.Metadata, len(entries))
+	for _, entry := range entries {
+		md := sd.Metadata{Tags: entry.Service.Tags, Meta: entry.Service.Meta}
+		if entry.Service.Locality != nil {
+			md.Zone = entry.Service.Locality.Zone
+		}
+		datacenter := dc
+		if entry.Node.Datacenter != "" {
+			datacenter = entry.Node.Datacenter
+		}
+		if datacenter != "" {
+			md.Meta = make(map[string]string, len(entry.Service.Meta)+1)
+			for k, v := range entry.Service.Meta {
+				md.Meta[k] = v
+			}
+			md.Meta["datacenter"] = datacenter
+		}
+		metadata[makeInstance(entry)] = md
+	}
+	return metadata
+}
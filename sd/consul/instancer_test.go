@@ -2,8 +2,10 @@ package consul
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"reflect"
 	"testing"
 	"time"
 
@@ -52,10 +54,12 @@ var consulState = []*consul.ServiceEntry{
 			Node:    "app01.local",
 		},
 		Service: &consul.AgentService{
-			Address: "10.0.0.10",
-			ID:      "search-db-0",
-			Port:    9000,
-			Service: "search",
+			Address:  "10.0.0.10",
+			ID:       "search-db-0",
+			Port:     9000,
+			Service:  "search",
+			Meta:     map[string]string{"shard": "0"},
+			Locality: &consul.Locality{Region: "eu-west-1", Zone: "eu-west-1a"},
 			Tags: []string{
 				"db",
 			},
@@ -78,6 +82,16 @@ func TestInstancer(t *testing.T) {
 	}
 }
 
+func TestInstancerMetadata(t *testing.T) {
+	s := NewInstancer(newTestClient(consulState), log.NewNopLogger(), "search", []string{"db"}, true)
+	defer s.Stop()
+
+	state := s.cache.State()
+	if want, have := "{[db] eu-west-1a map[shard:0]}", fmt.Sprint(state.Metadata["10.0.0.10:9000"]); want != have {
+		t.Errorf("want %s, have %s", want, have)
+	}
+}
+
 func TestInstancerNoService(t *testing.T) {
 	var (
 		logger = log.NewNopLogger()
@@ -135,6 +149,120 @@ func TestInstancerAddressOverride(t *testing.T) {
 	}
 }
 
+func TestInstancerQueryOptions(t *testing.T) {
+	client := newTestClient(nil)
+	s := NewInstancer(client, log.NewNopLogger(), "search", []string{"api", "v2"}, true,
+		Datacenters("dc2"),
+		Namespace("team"),
+		Partition("part"),
+		Filter(`Service.Meta.shard == "0"`),
+	)
+	s.Stop()
+
+	client.mtx.Lock()
+	opts := client.queries[0]
+	client.mtx.Unlock()
+	want := consul.QueryOptions{
+		Datacenter: "dc2",
+		Namespace:  "team",
+		Partition:  "part",
+		Filter:     `Service.Meta.shard == "0"`,
+	}
+	if !reflect.DeepEqual(want, opts) {
+		t.Errorf("want %+v, have %+v", want, opts)
+	}
+
+	// Without the Filter option, no filter is sent, even with several tags,
+	// and the default datacenter is kept without datacenters.
+	client = newTestClient(nil)
+	s = NewInstancer(client, log.NewNopLogger(), "search", []string{"api", "v2"}, true, Datacenters())
+	s.Stop()
+	client.mtx.Lock()
+	opts = client.queries[0]
+	client.mtx.Unlock()
+	if want := (consul.QueryOptions{}); !reflect.DeepEqual(want, opts) {
+		t.Errorf("want %+v, have %+v", want, opts)
+	}
+}
+
+func TestInstancerDatacenters(t *testing.T) {
+	client := newTestClient([]*consul.ServiceEntry{
+		{Node: &consul.Node{Address: "10.0.1.1", Datacenter: "dc1"}, Service: &consul.AgentService{Service: "search", Port: 80}},
+		{Node: &consul.Node{Address: "10.0.2.1", Datacenter: "dc2"}, Service: &consul.AgentService{Service: "search", Port: 80}},
+		{Node: &consul.Node{Address: "10.0.3.1", Datacenter: "dc3"}, Service: &consul.AgentService{Service: "search", Port: 80}},
+	})
+	s := NewInstancer(client, log.NewNopLogger(), "search", nil, true, Datacenters("dc1", "dc2"))
+	defer s.Stop()
+
+	state := s.cache.State()
+	if want, have := "[10.0.1.1:80 10.0.2.1:80]", fmt.Sprint(state.Instances); want != have {
+		t.Errorf("want %s, have %s", want, have)
+	}
+	if want, have := "dc2", state.Metadata["10.0.2.1:80"].Meta["datacenter"]; want != have {
+		t.Errorf("want %s, have %s", want, have)
+	}
+
+	// A failing datacenter keeps its last instances, marked stale, until
+	// all fail.
+	errDown := errors.New("down")
+	client.setDown("dc2", errDown)
+	waitState(t, s, func(state sd.Event) bool { return state.Stale })
+	state = s.cache.State()
+	if want, have := "[10.0.1.1:80 10.0.2.1:80] <nil>", fmt.Sprint(state.Instances, " ", state.Err); want != have {
+		t.Errorf("want %s, have %s", want, have)
+	}
+	client.setDown("dc1", errDown)
+	waitState(t, s, func(state sd.Event) bool { return errors.Is(state.Err, errDown) })
+}
+
+func TestInstancerPreparedQuery(t *testing.T) {
+	client := newTestClient(consulState)
+	s := NewInstancer(client, log.NewNopLogger(), "search", []string{"api"}, true, PreparedQuery("search", time.Millisecond))
+	defer s.Stop()
+
+	state := s.cache.State()
+	if want, have := "[10.0.0.0:8000 10.0.0.1:8001]", fmt.Sprint(state.Instances); want != have {
+		t.Errorf("want %s, have %s", want, have)
+	}
+	if want, have := "dc2", state.Metadata["10.0.0.0:8000"].Meta["datacenter"]; want != have {
+		t.Errorf("want %s, have %s", want, have)
+	}
+
+	// Prepared queries are polled.
+	client.setEntries(consulState[1:])
+	waitState(t, s, func(state sd.Event) bool { return fmt.Sprint(state.Instances) == "[10.0.0.1:8001]" })
+}
+
+func TestInstancerPreparedQueryUnsupported(t *testing.T) {
+	client := struct{ Client }{newTestClient(consulState)}
+	s := NewInstancer(client, log.NewNopLogger(), "search", nil, true, PreparedQuery("search", time.Millisecond))
+	defer s.Stop()
+
+	if state := s.cache.State(); !errors.Is(state.Err, errNoQueryClient) {
+		t.Errorf("want %v, have %v", errNoQueryClient, state.Err)
+	}
+}
+
+func TestInstancerPreparedQueryInterval(t *testing.T) {
+	client := newTestClient(consulState)
+	s := NewInstancer(client, log.NewNopLogger(), "search", nil, true, PreparedQuery("search", 0))
+	defer s.Stop()
+	if want, have := DefaultInterval, s.queryInterval; want != have {
+		t.Errorf("want %v, have %v", want, have)
+	}
+}
+
+func waitState(t *testing.T, s *Instancer, ok func(sd.Event) bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !ok(s.cache.State()) {
+		if time.Now().After(deadline) {
+			t.Fatalf("unexpected state %+v", s.cache.State())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
 type eofTestClient struct {
 	client *testClient
 	eofSig chan bool
@@ -1,44 +1,193 @@
 package consul
 
 import (
+	"context"
 	"fmt"
+	"sync"
+	"time"
 
 	stdconsul "github.com/hashicorp/consul/api"
 
 	"github.com/tnnyio/log"
 )
 
+// HealthFunc returns the status of the TTL check of a registration, one of
+// api.HealthPassing, api.HealthWarning and api.HealthCritical, and its
+// output.
+type HealthFunc func(ctx context.Context) (status, output string)
+
+// DefaultInterval is the interval of TTL and Heartbeat when they're given one
+// that isn't positive.
+const DefaultInterval = 10 * time.Second
+
+// RegistrarOption sets an optional parameter of a Registrar.
+type RegistrarOption func(*Registrar)
+
+// TTL makes the Registrar update the TTL check of the registration every
+// interval, with the status returned by f, between Register and Deregister.
+// The registration must define a check with a TTL longer than the interval,
+// in Check or Checks. The first one is updated.
+// A failing update means the agent lost the registration, for instance after
+// a restart, and the Registrar registers it again. The health function is
+// given the interval to return. The client must implement TTLClient, as the
+// one returned by NewClient does, or the option is ignored.
+func TTL(interval time.Duration, f HealthFunc) RegistrarOption {
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+	return func(p *Registrar) {
+		p.ttlInterval = interval
+		p.health = f
+	}
+}
+
+// Heartbeat makes the Registrar check every interval that the registration is
+// known to the local agent, between Register and Deregister, and register it
+// again if it's missing. It's only needed without TTL, as TTL updates detect
+// a missing registration. The client must implement AgentClient, as the one
+// returned by NewClient does, or the option is ignored.
+func Heartbeat(interval time.Duration) RegistrarOption {
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+	return func(p *Registrar) { p.heartbeat = interval }
+}
+
 // Registrar registers service instance liveness information to Consul.
 type Registrar struct {
 	client       Client
 	registration *stdconsul.AgentServiceRegistration
 	logger       log.Logger
+	ttlInterval  time.Duration
+	health       HealthFunc
+	heartbeat    time.Duration
+
+	mtx   sync.Mutex
+	quitc chan chan struct{}
 }
 
 // NewRegistrar returns a Consul Registrar acting on the provided catalog
 // registration.
-func NewRegistrar(client Client, r *stdconsul.AgentServiceRegistration, logger log.Logger) *Registrar {
-	return &Registrar{
+func NewRegistrar(client Client, r *stdconsul.AgentServiceRegistration, logger log.Logger, options ...RegistrarOption) *Registrar {
+	p := &Registrar{
 		client:       client,
 		registration: r,
 		logger:       log.With(logger, "service", r.Name, "tags", fmt.Sprint(r.Tags), "address", r.Address),
 	}
+	for _, option := range options {
+		option(p)
+	}
+	if _, ok := client.(TTLClient); p.health != nil && !ok {
+		p.logger.Log("err", errNoTTLClient)
+		p.health = nil
+	}
+	if _, ok := client.(AgentClient); p.heartbeat > 0 && !ok {
+		p.logger.Log("err", errNoAgentClient)
+		p.heartbeat = 0
+	}
+	return p
 }
 
-// Register implements sd.Registrar interface.
+// Register implements sd.Registrar interface. With TTL or Heartbeat, it also
+// starts keeping the registration alive, even if it failed.
 func (p *Registrar) Register() {
-	if err := p.client.Register(p.registration); err != nil {
-		p.logger.Log("err", err)
-	} else {
-		p.logger.Log("action", "register")
+	p.mtx.Lock()
+	p.register()
+	if (p.health != nil || p.heartbeat > 0) && p.quitc == nil {
+		p.quitc = make(chan chan struct{})
+		go p.loop(p.quitc)
+	}
+	p.mtx.Unlock()
+
+	// The health function may take a while: don't block Deregister.
+	if p.health != nil {
+		p.updateTTL()
 	}
 }
 
-// Deregister implements sd.Registrar interface.
+// Deregister implements sd.Registrar interface. It stops keeping the
+// registration alive before deregistering it.
 func (p *Registrar) Deregister() {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	if p.quitc != nil {
+		q := make(chan struct{})
+		p.quitc <- q
+		<-q
+		p.quitc = nil
+	}
 	if err := p.client.Deregister(p.registration); err != nil {
 		p.logger.Log("err", err)
 	} else {
 		p.logger.Log("action", "deregister")
 	}
 }
+
+func (p *Registrar) register() bool {
+	if err := p.client.Register(p.registration); err != nil {
+		p.logger.Log("err", err)
+		return false
+	}
+	p.logger.Log("action", "register")
+	return true
+}
+
+func (p *Registrar) loop(quitc chan chan struct{}) {
+	var ttlc, heartbeatc <-chan time.Time
+	if p.health != nil {
+		ticker := time.NewTicker(p.ttlInterval)
+		defer ticker.Stop()
+		ttlc = ticker.C
+	}
+	if p.heartbeat > 0 {
+		ticker := time.NewTicker(p.heartbeat)
+		defer ticker.Stop()
+		heartbeatc = ticker.C
+	}
+	for {
+		select {
+		case <-ttlc:
+			if !p.updateTTL() && p.register() {
+				p.updateTTL()
+			}
+		case <-heartbeatc:
+			if !p.registered() {
+				p.register()
+			}
+		case q := <-quitc:
+			close(q)
+			return
+		}
+	}
+}
+
+// updateTTL updates the TTL check with the status of the health function,
+// and tells whether it succeeded.
+func (p *Registrar) updateTTL() bool {
+	ctx, cancel := context.WithTimeout(context.Background(), p.ttlInterval)
+	defer cancel()
+	status, output := p.health(ctx)
+	client := p.client.(TTLClient) // checked by NewRegistrar
+	if err := client.UpdateTTL(p.checkID(), output, status); err != nil {
+		p.logger.Log("during", "ttl update", "err", err)
+		return false
+	}
+	return true
+}
+
+// registered tells whether the local agent knows the registration. Errors
+// are taken as a no: they're likely from an agent restarting, and
+// registering again is harmless.
+func (p *Registrar) registered() bool {
+	client := p.client.(AgentClient) // checked by NewRegistrar
+	services, err := client.AgentServices()
+	if err != nil {
+		p.logger.Log("during", "heartbeat", "err", err)
+		return false
+	}
+	if _, ok := services[p.serviceID()]; ok {
+		return true
+	}
+	p.logger.Log("during", "heartbeat", "err", "registration missing")
+	return false
+}
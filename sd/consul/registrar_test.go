@@ -1,11 +1,17 @@
 package consul
 
 import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
 	"testing"
+	"time"
 
 	stdconsul "github.com/hashicorp/consul/api"
 
 	"github.com/tnnyio/log"
+	"github.com/tnnyio/yoroi/health"
 )
 
 func TestRegistrar(t *testing.T) {
@@ -25,3 +31,286 @@ func TestRegistrar(t *testing.T) {
 		t.Errorf("want %d, have %d", want, have)
 	}
 }
+
+func TestRegistrarReport(t *testing.T) {
+	client := newTestClient(nil)
+	p := NewRegistrar(client, testRegistration, log.NewNopLogger())
+
+	p.Report(context.Background(), health.Report{Status: health.StatusUp})
+	p.Report(context.Background(), health.Report{
+		Status: health.StatusDown,
+		Checks: map[string]health.Result{
+			"db":    {Status: health.StatusDown},
+			"cache": {Status: health.StatusUp},
+		},
+	})
+
+	want := []ttlUpdate{
+		{"service:my-id", "up", stdconsul.HealthPassing},
+		{"service:my-id", "down: db", stdconsul.HealthCritical},
+	}
+	if !reflect.DeepEqual(want, client.ttls) {
+		t.Errorf("want %v, have %v", want, client.ttls)
+	}
+}
+
+func TestRegistrarNoTTLClient(t *testing.T) {
+	client := struct{ Client }{newTestClient(nil)}
+	var called bool
+	healthFunc := func(context.Context) (string, string) {
+		called = true
+		return stdconsul.HealthPassing, ""
+	}
+	p := NewRegistrar(client, testRegistration, log.NewNopLogger(), TTL(time.Millisecond, healthFunc))
+	if want, have := errNoTTLClient, p.Report(context.Background(), health.Report{Status: health.StatusUp}); want != have {
+		t.Errorf("want %v, have %v", want, have)
+	}
+
+	// The TTL option is ignored.
+	p.Register()
+	time.Sleep(10 * time.Millisecond)
+	p.Deregister()
+	if called {
+		t.Error("want the health function not called")
+	}
+}
+
+func TestRegistrarTTL(t *testing.T) {
+	client := newTestClient(nil)
+	var (
+		mtx    sync.Mutex
+		status = stdconsul.HealthWarning
+	)
+	healthFunc := func(context.Context) (string, string) {
+		mtx.Lock()
+		defer mtx.Unlock()
+		return status, "checked"
+	}
+	p := NewRegistrar(client, testRegistration, log.NewNopLogger(), TTL(time.Millisecond, healthFunc))
+
+	// The first update is sent right away.
+	p.Register()
+	client.mtx.Lock()
+	first := client.ttls[0]
+	client.mtx.Unlock()
+	if want, have := (ttlUpdate{"service:my-id", "checked", stdconsul.HealthWarning}), first; want != have {
+		t.Errorf("want %v, have %v", want, have)
+	}
+	mtx.Lock()
+	status = stdconsul.HealthCritical
+	mtx.Unlock()
+	waitTTL(t, client, func(u ttlUpdate) bool { return u.status == stdconsul.HealthCritical })
+
+	// A lost registration is registered again.
+	client.restart()
+	waitRegistrations(t, client, 1)
+
+	p.Deregister()
+	if want, have := 0, client.registrations(); want != have {
+		t.Errorf("want %d, have %d", want, have)
+	}
+	_, n := client.lastTTL()
+	time.Sleep(10 * time.Millisecond)
+	if _, have := client.lastTTL(); have != n {
+		t.Errorf("want no update after Deregister, have %d more", have-n)
+	}
+}
+
+func TestRegistrarHeartbeat(t *testing.T) {
+	client := newTestClient(nil)
+	p := NewRegistrar(client, testRegistration, log.NewNopLogger(), Heartbeat(time.Millisecond))
+	p.Register()
+	client.restart()
+	waitRegistrations(t, client, 1)
+	p.Deregister()
+
+	// Nothing is registered after Deregister returns.
+	time.Sleep(10 * time.Millisecond)
+	if want, have := 0, client.registrations(); want != have {
+		t.Errorf("want %d, have %d", want, have)
+	}
+}
+
+func TestRegistrarFailedRegister(t *testing.T) {
+	client := newTestClient(nil)
+	client.Register(testRegistration) // makes the next Register fail
+	p := NewRegistrar(client, testRegistration, log.NewNopLogger(), Heartbeat(time.Millisecond))
+	p.Register()
+	defer p.Deregister()
+
+	// The heartbeat keeps going, and registers after a restart.
+	client.restart()
+	waitRegistrations(t, client, 1)
+}
+
+func TestRegistrarTTLWithoutID(t *testing.T) {
+	client := newTestClient(nil)
+	healthFunc := func(context.Context) (string, string) { return stdconsul.HealthPassing, "" }
+	r := &stdconsul.AgentServiceRegistration{Name: "my-name"}
+	p := NewRegistrar(client, r, log.NewNopLogger(), TTL(time.Millisecond, healthFunc))
+	p.Register()
+	time.Sleep(20 * time.Millisecond)
+	p.Deregister()
+
+	if update, _ := client.lastTTL(); update.checkID != "service:my-name" {
+		t.Errorf("want the check of the service name, have %q", update.checkID)
+	}
+	if want, have := 1, client.registerCalls(); want != have {
+		t.Errorf("registrations: want %d, have %d", want, have)
+	}
+}
+
+func TestRegistrarTTLChecks(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		checks stdconsul.AgentServiceChecks
+		want   string
+	}{
+		{
+			name: "numbered",
+			checks: stdconsul.AgentServiceChecks{
+				{HTTP: "http://my-address:12345/health", Interval: "10s"},
+				{TTL: "30s"},
+			},
+			want: "service:my-id:2",
+		},
+		{
+			name:   "single",
+			checks: stdconsul.AgentServiceChecks{{TTL: "30s"}},
+			want:   "service:my-id",
+		},
+		{
+			name: "named",
+			checks: stdconsul.AgentServiceChecks{
+				{CheckID: "my-ttl", TTL: "30s"},
+				{TTL: "30s"},
+			},
+			want: "my-ttl",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			client := newTestClient(nil)
+			healthFunc := func(context.Context) (string, string) { return stdconsul.HealthPassing, "" }
+			r := *testRegistration
+			r.Checks = tc.checks
+			p := NewRegistrar(client, &r, log.NewNopLogger(), TTL(time.Millisecond, healthFunc))
+			p.Register()
+			time.Sleep(20 * time.Millisecond)
+			p.Deregister()
+
+			if update, _ := client.lastTTL(); update.checkID != tc.want {
+				t.Errorf("want check %q, have %q", tc.want, update.checkID)
+			}
+			// Failed updates would register the service again.
+			if want, have := 1, client.registerCalls(); want != have {
+				t.Errorf("registrations: want %d, have %d", want, have)
+			}
+		})
+	}
+}
+
+func TestRegistrarHeartbeatOtherNode(t *testing.T) {
+	client := newTestClient(nil)
+	p := NewRegistrar(client, testRegistration, log.NewNopLogger(), Heartbeat(time.Millisecond))
+	p.Register()
+	defer p.Deregister()
+
+	// An instance with the same ID on another node doesn't hide the loss of
+	// the local registration.
+	other := registration2entry(testRegistration)
+	other.Node.Node = "other-node"
+	client.setEntries([]*stdconsul.ServiceEntry{other})
+	waitRegistrations(t, client, 2)
+}
+
+func TestRegistrarIntervals(t *testing.T) {
+	client := newTestClient(nil)
+	healthFunc := func(context.Context) (string, string) { return stdconsul.HealthPassing, "" }
+	p := NewRegistrar(client, testRegistration, log.NewNopLogger(), TTL(0, healthFunc), Heartbeat(-time.Second))
+	if p.ttlInterval != DefaultInterval || p.heartbeat != DefaultInterval {
+		t.Errorf("want intervals of %v, have %v and %v", DefaultInterval, p.ttlInterval, p.heartbeat)
+	}
+	p.Register()
+	p.Deregister()
+}
+
+func TestRegistrarHeartbeatError(t *testing.T) {
+	client := newTestClient(nil)
+	p := NewRegistrar(client, testRegistration, log.NewNopLogger(), Heartbeat(time.Millisecond))
+	p.Register()
+	defer p.Deregister()
+
+	// An agent failing to answer after a restart gets the registration
+	// again.
+	client.restart()
+	client.setDown("", errors.New("agent restarting"))
+	waitRegistrations(t, client, 1)
+}
+
+func TestRegistrarSlowHealth(t *testing.T) {
+	client := newTestClient(nil)
+	release := make(chan struct{})
+	healthFunc := func(context.Context) (string, string) {
+		<-release
+		return stdconsul.HealthPassing, ""
+	}
+	p := NewRegistrar(client, testRegistration, log.NewNopLogger(), TTL(time.Minute, healthFunc))
+	registered := make(chan struct{})
+	go func() {
+		defer close(registered)
+		p.Register()
+	}()
+	waitRegistrations(t, client, 1)
+
+	// Deregister doesn't wait for the health function.
+	deregistered := make(chan struct{})
+	go func() {
+		defer close(deregistered)
+		p.Deregister()
+	}()
+	select {
+	case <-deregistered:
+	case <-time.After(time.Second):
+		t.Fatal("Deregister blocked by the health function")
+	}
+	close(release)
+	<-registered
+}
+
+func TestReadiness(t *testing.T) {
+	h := health.New()
+	h.Register("db", health.CheckerFunc(func(context.Context) error { return errors.New("broken") }))
+	status, output := Readiness(h)(context.Background())
+	if want, have := stdconsul.HealthCritical, status; want != have {
+		t.Errorf("want %s, have %s", want, have)
+	}
+	if want, have := "down: db", output; want != have {
+		t.Errorf("want %s, have %s", want, have)
+	}
+}
+
+func waitTTL(t *testing.T, client *testClient, ok func(ttlUpdate) bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for {
+		if u, _ := client.lastTTL(); ok(u) {
+			return
+		}
+		if time.Now().After(deadline) {
+			u, _ := client.lastTTL()
+			t.Fatalf("unexpected TTL update %v", u)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func waitRegistrations(t *testing.T, client *testClient, n int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for client.registrations() != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("want %d registrations, have %d", n, client.registrations())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
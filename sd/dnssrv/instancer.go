@@ -65,6 +65,7 @@ func NewInstancerDetailed(
 // Stop terminates the Instancer.
 func (in *Instancer) Stop() {
 	close(in.quit)
+	in.cache.Stop()
 }
 
 func (in *Instancer) loop(t *time.Ticker, lookup Lookup) {
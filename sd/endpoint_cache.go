@@ -20,6 +20,12 @@ type endpointCache struct {
 	cache              map[string]endpointCloser
 	err                error
 	endpoints          []endpoint.Endpoint[any]
+	instances          []string
+	all                []InstanceEndpoint // including the excluded instances
+	outliers           *outlierDetector   // nil without health checks and outlier detection
+	known              []string           // the instances of the last update, sorted
+	failed             map[string]*failure
+	closed             bool
 	logger             log.Logger
 	invalidateDeadline time.Time
 	timeNow            func() time.Time
@@ -32,13 +38,31 @@ type endpointCloser struct {
 
 // newEndpointCache returns a new, empty endpointCache.
 func newEndpointCache(factory Factory, logger log.Logger, options endpointerOptions) *endpointCache {
-	return &endpointCache{
+	c := &endpointCache{
 		options: options,
 		factory: factory,
 		cache:   map[string]endpointCloser{},
+		failed:  map[string]*failure{},
 		logger:  logger,
 		timeNow: time.Now,
 	}
+	c.outliers = newOutlierDetector(options, logger, func() time.Time { return c.timeNow() }, c.exclude)
+	return c
+}
+
+// close stops the health checks, outlier detection and factory retries.
+func (c *endpointCache) close() {
+	if c.outliers != nil {
+		c.outliers.stop()
+	}
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.closed = true
+	for _, f := range c.failed {
+		if f.timer != nil {
+			f.timer.Stop()
+		}
+	}
 }
 
 // Update should be invoked by clients with a complete set of current instance
@@ -87,43 +111,126 @@ func (c *endpointCache) updateCache(instances []string) {
 		service, closer, err := c.factory(instance)
 		if err != nil {
 			c.logger.Log("instance", instance, "err", err)
+			c.fail(instance, err)
 			continue
 		}
+		c.forget(instance)
+		if c.outliers != nil {
+			service = c.outliers.wrap(instance, service)
+		}
 		cache[instance] = endpointCloser{service, closer}
 	}
 
-	// Close any leftover endpoints.
+	// Close any leftover endpoints, and forget the failures of the instances
+	// that went away.
 	for _, sc := range c.cache {
 		if sc.Closer != nil {
 			sc.Closer.Close()
 		}
 	}
+	for instance := range c.failed {
+		if !contains(instances, instance) {
+			c.forget(instance)
+		}
+	}
+
+	// Swap and trigger GC for old copies.
+	c.cache = cache
+	c.assemble(instances)
+}
 
-	// Populate the slice of endpoints.
-	endpoints := make([]endpoint.Endpoint[any], 0, len(cache))
+// assemble populates the endpoints of the instances from the cache, and
+// publishes them. It must be called with mtx held.
+func (c *endpointCache) assemble(instances []string) {
+	all := make([]InstanceEndpoint, 0, len(instances))
+	present := make([]string, 0, len(instances))
 	for _, instance := range instances {
-		// A bad factory may mean an instance is not present.
-		if _, ok := cache[instance]; !ok {
+		sc, ok := c.cache[instance]
+		if !ok {
+			// A bad factory may mean an instance is not present, unless the
+			// failure is exposed.
+			if f, failed := c.failed[instance]; failed && c.options.exposeFailures {
+				all = append(all, InstanceEndpoint{Instance: instance, Endpoint: failedEndpoint(instance, f.err)})
+			}
 			continue
 		}
-		endpoints = append(endpoints, cache[instance].Endpoint)
+		all = append(all, InstanceEndpoint{Instance: instance, Endpoint: sc.Endpoint})
+		present = append(present, instance)
+	}
+	if c.outliers != nil {
+		c.outliers.sync(present)
 	}
 
-	// Swap and trigger GC for old copies.
+	c.known = instances
+	c.all = all
+	c.reportFailures()
+	c.publish()
+}
+
+// contains reports whether the sorted instances contain instance.
+func contains(instances []string, instance string) bool {
+	i := sort.SearchStrings(instances, instance)
+	return i < len(instances) && instances[i] == instance
+}
+
+// exclude publishes the endpoints again after the set of excluded instances
+// changed.
+func (c *endpointCache) exclude() {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.publish()
+}
+
+// publish populates the slices of endpoints and their instances, leaving out
+// the instances excluded by health checks and outlier detection. It must be
+// called with mtx held.
+func (c *endpointCache) publish() {
+	var excluded map[string]bool
+	if c.outliers != nil {
+		excluded = c.outliers.excluded()
+	}
+	endpoints := make([]endpoint.Endpoint[any], 0, len(c.all))
+	instances := make([]string, 0, len(c.all))
+	for _, ie := range c.all {
+		if excluded[ie.Instance] {
+			continue
+		}
+		endpoints = append(endpoints, ie.Endpoint)
+		instances = append(instances, ie.Instance)
+	}
 	c.endpoints = endpoints
-	c.cache = cache
+	c.instances = instances
 }
 
 // Endpoints yields the current set of (presumably identical) endpoints, ordered
 // lexicographically by the corresponding instance string.
 func (c *endpointCache) Endpoints() ([]endpoint.Endpoint[any], error) {
+	endpoints, _, err := c.instanceEndpoints()
+	return endpoints, err
+}
+
+// InstanceEndpoints is like Endpoints, but pairs each endpoint with the
+// instance string it was created from.
+func (c *endpointCache) InstanceEndpoints() ([]InstanceEndpoint, error) {
+	endpoints, instances, err := c.instanceEndpoints()
+	if err != nil {
+		return nil, err
+	}
+	ies := make([]InstanceEndpoint, len(endpoints))
+	for i := range endpoints {
+		ies[i] = InstanceEndpoint{Instance: instances[i], Endpoint: endpoints[i]}
+	}
+	return ies, nil
+}
+
+func (c *endpointCache) instanceEndpoints() ([]endpoint.Endpoint[any], []string, error) {
 	// in the steady state we're going to have many goroutines calling Endpoints()
 	// concurrently, so to minimize contention we use a shared R-lock.
 	c.mtx.RLock()
 
 	if c.err == nil || c.timeNow().Before(c.invalidateDeadline) {
 		defer c.mtx.RUnlock()
-		return c.endpoints, nil
+		return c.endpoints, c.instances, nil
 	}
 
 	c.mtx.RUnlock()
@@ -134,9 +241,9 @@ func (c *endpointCache) Endpoints() ([]endpoint.Endpoint[any], error) {
 
 	// re-check condition due to a race between RUnlock() and Lock().
 	if c.err == nil || c.timeNow().Before(c.invalidateDeadline) {
-		return c.endpoints, nil
+		return c.endpoints, c.instances, nil
 	}
 
 	c.updateCache(nil) // close any remaining active endpoints
-	return nil, c.err
+	return nil, nil, c.err
 }
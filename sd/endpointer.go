@@ -5,6 +5,7 @@ import (
 
 	"github.com/tnnyio/log"
 	"github.com/tnnyio/yoroi/endpoint"
+	"github.com/tnnyio/yoroi/metrics"
 )
 
 // Endpointer listens to a service discovery system and yields a set of
@@ -15,6 +16,22 @@ type Endpointer interface {
 	Endpoints() ([]endpoint.Endpoint[any], error)
 }
 
+// InstanceEndpoint is an endpoint along with the instance string it was
+// created from.
+type InstanceEndpoint struct {
+	Instance string
+	Endpoint endpoint.Endpoint[any]
+}
+
+// InstanceEndpointer is implemented by Endpointers that know the instance
+// behind each endpoint, such as DefaultEndpointer. Consumers that need to
+// tell instances apart, rather than treating them as identical, can check
+// for it.
+type InstanceEndpointer interface {
+	Endpointer
+	InstanceEndpoints() ([]InstanceEndpoint, error)
+}
+
 // FixedEndpointer yields a fixed set of endpoints.
 type FixedEndpointer []endpoint.Endpoint[any]
 
@@ -60,6 +77,21 @@ func InvalidateOnError(timeout time.Duration) EndpointerOption {
 type endpointerOptions struct {
 	invalidateOnError bool
 	invalidateTimeout time.Duration
+
+	probe              Probe
+	probeInterval      time.Duration
+	probeTimeout       time.Duration
+	ejectErrors        int
+	latencyFactor      float64
+	latencyInterval    time.Duration
+	baseEjection       time.Duration
+	maxEjection        time.Duration
+	maxEjectionPercent int
+
+	retryBase      time.Duration
+	retryMax       time.Duration
+	failures       metrics.Gauge
+	exposeFailures bool
 }
 
 // DefaultEndpointer implements an Endpointer interface.
@@ -78,13 +110,21 @@ func (de *DefaultEndpointer) receive() {
 	}
 }
 
-// Close deregisters DefaultEndpointer from the Instancer and stops the internal go-routine.
+// Close deregisters DefaultEndpointer from the Instancer and stops the internal
+// go-routines.
 func (de *DefaultEndpointer) Close() {
 	de.instancer.Deregister(de.ch)
 	close(de.ch)
+	de.cache.close()
 }
 
 // Endpoints implements Endpointer.
 func (de *DefaultEndpointer) Endpoints() ([]endpoint.Endpoint[any], error) {
 	return de.cache.Endpoints()
 }
+
+// InstanceEndpoints implements InstanceEndpointer. The endpoints are ordered
+// like the ones returned by Endpoints.
+func (de *DefaultEndpointer) InstanceEndpoints() ([]InstanceEndpoint, error) {
+	return de.cache.InstanceEndpoints()
+}
@@ -83,3 +83,32 @@ func within(d time.Duration, f func() bool) bool {
 	}
 	return false
 }
+
+func TestDefaultEndpointerInstanceEndpoints(t *testing.T) {
+	var (
+		f = func(instance string) (endpoint.Endpoint[interface{}], io.Closer, error) {
+			return endpoint.Nop, nil, nil
+		}
+		instancer = &mockInstancer{instance.NewCache()}
+	)
+	instancer.Update(sd.Event{Instances: []string{"b", "a"}})
+
+	var endpointer sd.InstanceEndpointer = sd.NewEndpointer(instancer, f, log.NewNopLogger())
+	defer endpointer.(*sd.DefaultEndpointer).Close()
+
+	var (
+		ies []sd.InstanceEndpoint
+		err error
+	)
+	if !within(time.Second, func() bool {
+		ies, err = endpointer.InstanceEndpoints()
+		return err == nil && len(ies) == 2
+	}) {
+		t.Fatalf("wanted 2 endpoints, got %d (%v)", len(ies), err)
+	}
+	for i, want := range []string{"a", "b"} {
+		if have := ies[i].Instance; want != have {
+			t.Errorf("instance %d: want %q, have %q", i, want, have)
+		}
+	}
+}
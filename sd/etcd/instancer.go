@@ -62,6 +62,7 @@ func (s *Instancer) loop() {
 // Stop terminates the Instancer.
 func (s *Instancer) Stop() {
 	close(s.quitc)
+	s.cache.Stop()
 }
 
 // Register implements Instancer.
@@ -63,6 +63,7 @@ func (s *Instancer) loop() {
 // Stop terminates the Instancer.
 func (s *Instancer) Stop() {
 	close(s.quitc)
+	s.cache.Stop()
 }
 
 // Register implements Instancer.
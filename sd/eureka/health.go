@@ -0,0 +1,32 @@
+package eureka
+
+import (
+	"context"
+
+	"github.com/hudl/fargo"
+
+	"github.com/tnnyio/yoroi/health"
+)
+
+var _ health.Reporter = (*Registrar)(nil)
+
+// Report implements health.Reporter, by setting the status of the instance
+// to UP when the report is up, and DOWN otherwise. Eureka is only called
+// when the status changes.
+func (r *Registrar) Report(_ context.Context, report health.Report) error {
+	status := fargo.UP
+	if report.Status != health.StatusUp {
+		status = fargo.DOWN
+	}
+
+	r.Lock()
+	defer r.Unlock()
+	if r.instance.Status == status {
+		return nil
+	}
+	if err := r.conn.UpdateInstanceStatus(r.instance, status); err != nil {
+		return err
+	}
+	r.instance.Status = status
+	return nil
+}
@@ -46,6 +46,7 @@ func (s *Instancer) Stop() {
 	s.quitc <- q
 	<-q
 	s.quitc = nil
+	s.cache.Stop()
 }
 
 func (s *Instancer) consume(update fargo.AppUpdate) {
@@ -56,7 +57,7 @@ func (s *Instancer) consume(update fargo.AppUpdate) {
 	}
 	instances := convertFargoAppToInstances(update.App)
 	s.logger.Log("instances", len(instances))
-	s.cache.Update(sd.Event{Instances: instances})
+	s.cache.Update(sd.Event{Instances: instances, Metadata: makeMetadata(update.App)})
 }
 
 func (s *Instancer) loop(updates <-chan fargo.AppUpdate, done chan<- struct{}) {
@@ -89,6 +90,30 @@ func convertFargoAppToInstances(app *fargo.Application) []string {
 	return instances
 }
 
+// makeMetadata returns the metadata of the instances of app: the entries of
+// their metadata, formatted as strings, and their zone. The zone is the availability
+// zone of instances in Amazon data centers, and the "zone" entry of the
+// metadata otherwise, as Spring Cloud registers it.
+func makeMetadata(app *fargo.Application) map[string]sd.Metadata {
+	metadata := make(map[string]sd.Metadata, len(app.Instances))
+	for _, inst := range app.Instances {
+		var md sd.Metadata
+		zone, _ := inst.Metadata.GetString("zone") // parses the metadata
+		if entries := inst.Metadata.GetMap(); len(entries) > 0 {
+			md.Meta = make(map[string]string, len(entries))
+			for k, v := range entries {
+				md.Meta[k] = fmt.Sprint(v)
+			}
+		}
+		if inst.DataCenterInfo.Name == fargo.Amazon {
+			zone = inst.DataCenterInfo.Metadata.AvailabilityZone
+		}
+		md.Zone = zone
+		metadata[fmt.Sprintf("%s:%d", inst.IPAddr, inst.Port)] = md
+	}
+	return metadata
+}
+
 // Register implements Instancer.
 func (s *Instancer) Register(ch chan<- sd.Event) {
 	s.cache.Register(ch)
@@ -90,3 +90,26 @@ func TestBadInstancerScheduleUpdates(t *testing.T) {
 		t.Errorf("want %d, have %d", want, have)
 	}
 }
+
+func TestMakeMetadata(t *testing.T) {
+	app := &fargo.Application{Instances: []*fargo.Instance{
+		{
+			IPAddr:         "192.168.0.1",
+			Port:           8080,
+			DataCenterInfo: fargo.DataCenterInfo{Name: fargo.MyOwn},
+			Metadata:       fargo.InstanceMetadata{Raw: []byte("<zone>b</zone><version>2</version>")},
+		},
+		{
+			IPAddr:         "192.168.0.2",
+			Port:           8080,
+			DataCenterInfo: fargo.DataCenterInfo{Name: fargo.Amazon, Metadata: fargo.AmazonMetadataType{AvailabilityZone: "eu-west-1a"}},
+		},
+	}}
+	metadata := makeMetadata(app)
+	if md := metadata["192.168.0.1:8080"]; md.Zone != "b" || md.Meta["version"] != "2" {
+		t.Errorf("want zone b and version 2, have %+v", md)
+	}
+	if want, have := "eu-west-1a", metadata["192.168.0.2:8080"].Zone; want != have {
+		t.Errorf("want zone %s, have %s", want, have)
+	}
+}
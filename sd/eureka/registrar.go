@@ -21,6 +21,7 @@ type fargoConnection interface {
 	DeregisterInstance(instance *fargo.Instance) error
 	ReregisterInstance(instance *fargo.Instance) error
 	HeartBeatInstance(instance *fargo.Instance) error
+	UpdateInstanceStatus(instance *fargo.Instance, status fargo.StatusType) error
 	ScheduleAppUpdates(name string, await bool, done <-chan struct{}) <-chan fargo.AppUpdate
 	GetApp(name string) (*fargo.Application, error)
 }
@@ -1,8 +1,14 @@
 package eureka
 
 import (
+	"context"
+	"reflect"
 	"testing"
 	"time"
+
+	"github.com/hudl/fargo"
+
+	"github.com/tnnyio/yoroi/health"
 )
 
 func TestRegistrar(t *testing.T) {
@@ -100,3 +106,20 @@ func TestExpiredInstance(t *testing.T) {
 		t.Errorf("want %d, have %d", want, have)
 	}
 }
+
+func TestRegistrarReport(t *testing.T) {
+	var (
+		connection = &testConnection{}
+		instance   = *instanceTest1
+		registrar  = NewRegistrar(connection, &instance, loggerTest)
+		ctx        = context.Background()
+	)
+	registrar.Report(ctx, health.Report{Status: health.StatusUp})
+	registrar.Report(ctx, health.Report{Status: health.StatusDown})
+	registrar.Report(ctx, health.Report{Status: health.StatusDown})
+	registrar.Report(ctx, health.Report{Status: health.StatusUp})
+
+	if want, have := []fargo.StatusType{fargo.DOWN, fargo.UP}, connection.statuses; !reflect.DeepEqual(want, have) {
+		t.Errorf("want %v, have %v", want, have)
+	}
+}
@@ -14,6 +14,7 @@ import (
 type testConnection struct {
 	mu        sync.RWMutex
 	instances []*fargo.Instance
+	statuses  []fargo.StatusType
 
 	errApplication error
 	errHeartbeat   error
@@ -76,6 +77,13 @@ func (c *testConnection) HeartBeatInstance(i *fargo.Instance) error {
 	return c.errHeartbeat
 }
 
+func (c *testConnection) UpdateInstanceStatus(i *fargo.Instance, status fargo.StatusType) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.statuses = append(c.statuses, status)
+	return nil
+}
+
 func (c *testConnection) DeregisterInstance(i *fargo.Instance) error {
 	if c.errDeregister != nil {
 		return c.errDeregister
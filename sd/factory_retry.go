@@ -0,0 +1,147 @@
+package sd
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/tnnyio/yoroi/endpoint"
+	"github.com/tnnyio/yoroi/metrics"
+)
+
+// FactoryError is returned by the endpoints of instances for which the
+// Factory failed, when the Endpointer exposes them with ExposeFailures.
+type FactoryError struct {
+	Instance string
+	Err      error
+}
+
+func (e *FactoryError) Error() string {
+	return fmt.Sprintf("instance %s: %v", e.Instance, e.Err)
+}
+
+func (e *FactoryError) Unwrap() error {
+	return e.Err
+}
+
+// FactoryRetry returns an EndpointerOption retrying in the background the
+// instances for which the Factory failed. The first retry happens after base,
+// and the delay doubles after each failure, up to max. By default, or with a
+// base of zero, failed instances are only retried on the next discovery
+// event.
+func FactoryRetry(base, max time.Duration) EndpointerOption {
+	return func(opts *endpointerOptions) {
+		opts.retryBase = base
+		opts.retryMax = max
+	}
+}
+
+// FactoryFailures returns an EndpointerOption setting the gauge to the number
+// of instances for which the Factory is failing.
+func FactoryFailures(g metrics.Gauge) EndpointerOption {
+	return func(opts *endpointerOptions) {
+		opts.failures = g
+	}
+}
+
+// ExposeFailures returns an EndpointerOption exposing the instances for
+// which the Factory failed as endpoints returning a FactoryError, instead of
+// leaving them out, until the Factory succeeds.
+func ExposeFailures() EndpointerOption {
+	return func(opts *endpointerOptions) {
+		opts.exposeFailures = true
+	}
+}
+
+// failure is an instance for which the Factory failed.
+type failure struct {
+	err   error
+	delay time.Duration
+	timer *time.Timer
+}
+
+// fail records that the Factory failed for the instance, and schedules a
+// retry. It must be called with mtx held.
+func (c *endpointCache) fail(instance string, err error) {
+	f, ok := c.failed[instance]
+	if !ok {
+		f = &failure{}
+		c.failed[instance] = f
+	}
+	f.err = err
+	base, max := c.retryDelays()
+	if base <= 0 || c.closed {
+		return
+	}
+	switch {
+	case f.delay == 0:
+		f.delay = base
+	case f.delay < max:
+		f.delay *= 2
+		if f.delay > max {
+			f.delay = max
+		}
+	}
+	if f.timer != nil {
+		f.timer.Stop()
+	}
+	f.timer = time.AfterFunc(f.delay, func() { c.retry(instance, f) })
+}
+
+// forget forgets the failure of the instance. It must be called with mtx
+// held.
+func (c *endpointCache) forget(instance string) {
+	if f, ok := c.failed[instance]; ok {
+		if f.timer != nil {
+			f.timer.Stop()
+		}
+		delete(c.failed, instance)
+	}
+}
+
+// retry calls the Factory again for a failed instance.
+func (c *endpointCache) retry(instance string, f *failure) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if c.closed || c.failed[instance] != f {
+		return // gone from discovery, or retried by an update
+	}
+
+	service, closer, err := c.factory(instance)
+	if err != nil {
+		c.logger.Log("instance", instance, "err", err, "retry", f.delay)
+		c.fail(instance, err)
+		return
+	}
+	c.logger.Log("instance", instance, "recovered", true)
+	c.forget(instance)
+	if c.outliers != nil {
+		service = c.outliers.wrap(instance, service)
+	}
+	c.cache[instance] = endpointCloser{service, closer}
+	c.assemble(c.known)
+}
+
+func (c *endpointCache) retryDelays() (base, max time.Duration) {
+	base, max = c.options.retryBase, c.options.retryMax
+	if max < base {
+		max = base
+	}
+	return base, max
+}
+
+// reportFailures updates the gauge of failed instances. It must be called
+// with mtx held.
+func (c *endpointCache) reportFailures() {
+	if c.options.failures != nil {
+		c.options.failures.Set(float64(len(c.failed)))
+	}
+}
+
+// failedEndpoint returns the endpoint exposing a failed instance.
+func failedEndpoint(instance string, err error) endpoint.Endpoint[any] {
+	ferr := &FactoryError{Instance: instance, Err: err}
+	return func(context.Context, interface{}) (interface{}, error) {
+		return nil, ferr
+	}
+}
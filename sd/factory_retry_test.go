@@ -0,0 +1,170 @@
+package sd
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tnnyio/log"
+	"github.com/tnnyio/yoroi/endpoint"
+	"github.com/tnnyio/yoroi/metrics/generic"
+)
+
+var errFactory = errors.New("factory failed")
+
+// failing returns a factory failing for the broken instances, and counting
+// its calls.
+func failing() (Factory, *failingFactory) {
+	f := &failingFactory{broken: map[string]bool{}, calls: map[string]int{}}
+	return func(instance string) (endpoint.Endpoint[any], io.Closer, error) {
+		f.mtx.Lock()
+		defer f.mtx.Unlock()
+		f.calls[instance]++
+		if f.broken[instance] {
+			return nil, nil, errFactory
+		}
+		return endpoint.Nop, nil, nil
+	}, f
+}
+
+type failingFactory struct {
+	mtx    sync.Mutex
+	broken map[string]bool
+	calls  map[string]int
+}
+
+func (f *failingFactory) set(instance string, broken bool) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	f.broken[instance] = broken
+}
+
+func (f *failingFactory) count(instance string) int {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	return f.calls[instance]
+}
+
+func TestFactoryRetry(t *testing.T) {
+	factory, f := failing()
+	f.set("b", true)
+	gauge := generic.NewGauge("failures")
+	cache := newEndpointCache(factory, log.NewNopLogger(), endpointerOptions{
+		retryBase: time.Millisecond,
+		retryMax:  5 * time.Millisecond,
+		failures:  gauge,
+	})
+	defer cache.close()
+
+	cache.Update(Event{Instances: []string{"a", "b"}})
+	assertInstances(t, cache, "[a]")
+	if want, have := 1.0, gauge.Value(); want != have {
+		t.Errorf("failures: want %v, have %v", want, have)
+	}
+
+	// The failed instance is retried in the background, without an update.
+	deadline := time.Now().Add(time.Second)
+	for f.count("b") < 3 {
+		if time.Now().After(deadline) {
+			t.Fatalf("want at least 3 factory calls, have %d", f.count("b"))
+		}
+		time.Sleep(time.Millisecond)
+	}
+	f.set("b", false)
+	waitInstances(t, cache, "[a b]")
+	if want, have := 0.0, gauge.Value(); want != have {
+		t.Errorf("failures: want %v, have %v", want, have)
+	}
+	if want, have := 1, f.count("a"); want != have {
+		t.Errorf("a: want %d factory call, have %d", want, have)
+	}
+}
+
+func TestFactoryRetryGone(t *testing.T) {
+	factory, f := failing()
+	f.set("b", true)
+	gauge := generic.NewGauge("failures")
+	cache := newEndpointCache(factory, log.NewNopLogger(), endpointerOptions{
+		retryBase: 5 * time.Millisecond,
+		failures:  gauge,
+	})
+	defer cache.close()
+
+	cache.Update(Event{Instances: []string{"a", "b"}})
+	cache.Update(Event{Instances: []string{"a"}})
+	if want, have := 0.0, gauge.Value(); want != have {
+		t.Errorf("failures: want %v, have %v", want, have)
+	}
+
+	// Instances gone from discovery are not retried.
+	calls := f.count("b")
+	time.Sleep(20 * time.Millisecond)
+	if have := f.count("b"); have != calls {
+		t.Errorf("want %d factory calls, have %d", calls, have)
+	}
+	assertInstances(t, cache, "[a]")
+}
+
+// Retries are disabled by default.
+func TestFactoryRetryDisabled(t *testing.T) {
+	factory, f := failing()
+	f.set("b", true)
+	cache := newEndpointCache(factory, log.NewNopLogger(), endpointerOptions{})
+	defer cache.close()
+
+	cache.Update(Event{Instances: []string{"a", "b"}})
+	time.Sleep(10 * time.Millisecond)
+	if want, have := 1, f.count("b"); want != have {
+		t.Errorf("want %d factory call, have %d", want, have)
+	}
+
+	// The next update still retries it.
+	f.set("b", false)
+	cache.Update(Event{Instances: []string{"a", "b"}})
+	assertInstances(t, cache, "[a b]")
+}
+
+func TestExposeFailures(t *testing.T) {
+	factory, f := failing()
+	f.set("b", true)
+	cache := newEndpointCache(factory, log.NewNopLogger(), endpointerOptions{
+		retryBase:      time.Millisecond,
+		exposeFailures: true,
+	})
+	defer cache.close()
+
+	cache.Update(Event{Instances: []string{"a", "b"}})
+	assertInstances(t, cache, "[a b]")
+	ies, _ := cache.InstanceEndpoints()
+	_, err := ies[1].Endpoint(context.Background(), nil)
+	var ferr *FactoryError
+	if !errors.As(err, &ferr) || ferr.Instance != "b" || !errors.Is(err, errFactory) {
+		t.Errorf("want a FactoryError for b, have %v", err)
+	}
+
+	f.set("b", false)
+	deadline := time.Now().Add(time.Second)
+	for {
+		ies, _ := cache.InstanceEndpoints()
+		if _, err := ies[1].Endpoint(context.Background(), nil); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("instance b not recovered")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestEndpointerFactoryRetry(t *testing.T) {
+	factory, f := failing()
+	f.set("b", true)
+	endpointer := NewEndpointer(FixedInstancer{"a", "b"}, factory, log.NewNopLogger(), FactoryRetry(time.Millisecond, time.Millisecond))
+	defer endpointer.Close()
+	waitInstances(t, endpointer.cache, "[a]")
+	f.set("b", false)
+	waitInstances(t, endpointer.cache, "[a b]")
+}
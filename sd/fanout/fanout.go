@@ -0,0 +1,256 @@
+// Package fanout calls every endpoint yielded by an sd.Endpointer, rather
+// than one chosen by a load balancer, and aggregates the results.
+//
+// It is meant for operations that must reach every instance of a service,
+// such as cache invalidation or sharded queries.
+package fanout
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/tnnyio/yoroi/endpoint"
+	"github.com/tnnyio/yoroi/recovery"
+	"github.com/tnnyio/yoroi/sd"
+	"github.com/tnnyio/yoroi/sd/lb"
+)
+
+// Response is the outcome of the call to a single instance.
+type Response[O interface{}] struct {
+	// Instance is the instance string the endpoint was created from, if the
+	// Endpointer is an sd.InstanceEndpointer, and the position of the
+	// endpoint otherwise.
+	Instance string
+
+	Response O
+	Err      error
+	Took     time.Duration
+}
+
+// Aggregate is the result of a fan-out call.
+type Aggregate[O interface{}] struct {
+	// Responses holds one response per endpoint, in the order of the
+	// endpoints.
+	Responses []Response[O]
+
+	// Merged is the result of the merge function over the successful
+	// responses, if one was set with Merge.
+	Merged O
+}
+
+// Succeeded returns the successful responses.
+func (a Aggregate[O]) Succeeded() []Response[O] {
+	return a.filter(func(r Response[O]) bool { return r.Err == nil })
+}
+
+// Failed returns the failed responses.
+func (a Aggregate[O]) Failed() []Response[O] {
+	return a.filter(func(r Response[O]) bool { return r.Err != nil })
+}
+
+func (a Aggregate[O]) filter(keep func(Response[O]) bool) []Response[O] {
+	var rs []Response[O]
+	for _, r := range a.Responses {
+		if keep(r) {
+			rs = append(rs, r)
+		}
+	}
+	return rs
+}
+
+// QuorumError is returned along with the Aggregate when fewer endpoints
+// succeeded than the quorum requires.
+type QuorumError struct {
+	Required  int
+	Succeeded int
+	Errors    []error
+}
+
+// Error implements error.
+func (e QuorumError) Error() string {
+	msg := fmt.Sprintf("fanout: %d of %d required calls succeeded", e.Succeeded, e.Required)
+	if len(e.Errors) > 0 {
+		msg += fmt.Sprintf(" (first error: %v)", e.Errors[0])
+	}
+	return msg
+}
+
+// Unwrap returns the errors of the failed calls.
+func (e QuorumError) Unwrap() []error { return e.Errors }
+
+// QuorumFunc returns how many of total calls must succeed.
+type QuorumFunc func(total int) int
+
+// All requires every call to succeed.
+func All(total int) int { return total }
+
+// Majority requires more than half of the calls to succeed.
+func Majority(total int) int { return total/2 + 1 }
+
+// AtLeast requires at least n calls to succeed, or all of them if there are
+// fewer than n endpoints.
+func AtLeast(n int) QuorumFunc {
+	return func(total int) int {
+		if n > total {
+			return total
+		}
+		return n
+	}
+}
+
+// Option sets an optional parameter for fan-out endpoints.
+type Option[O interface{}] func(*options[O])
+
+// Concurrency limits the number of concurrent calls. By default, all
+// endpoints are called at once.
+func Concurrency[O interface{}](n int) Option[O] {
+	return func(o *options[O]) { o.concurrency = n }
+}
+
+// Timeout bounds the duration of each individual call. By default, calls are
+// only bounded by the request context.
+func Timeout[O interface{}](d time.Duration) Option[O] {
+	return func(o *options[O]) { o.timeout = d }
+}
+
+// Quorum sets how many calls must succeed for the fan-out call to succeed.
+// By default, All is used.
+func Quorum[O interface{}](q QuorumFunc) Option[O] {
+	return func(o *options[O]) { o.quorum = q }
+}
+
+// Merge sets a function that combines the successful responses into the
+// Merged field of the Aggregate, once the quorum is met. An error returned
+// by merge is returned by the fan-out endpoint.
+func Merge[O interface{}](merge func(responses []O) (O, error)) Option[O] {
+	return func(o *options[O]) { o.merge = merge }
+}
+
+type options[O interface{}] struct {
+	concurrency int
+	timeout     time.Duration
+	quorum      QuorumFunc
+	merge       func([]O) (O, error)
+}
+
+// New returns an endpoint that calls every endpoint of the Endpointer with
+// the request, and returns their responses as an Aggregate. Responses that
+// aren't of type O are reported as errors of their call.
+//
+// The Aggregate is returned even when the call fails because the quorum was
+// not met, along with a QuorumError. If the Endpointer yields no endpoints,
+// lb.ErrNoEndpoints is returned.
+func New[O interface{}](endpointer sd.Endpointer, opts ...Option[O]) endpoint.Endpoint[Aggregate[O]] {
+	o := options[O]{quorum: All}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return func(ctx context.Context, request interface{}) (Aggregate[O], error) {
+		var agg Aggregate[O]
+		endpoints, err := instanceEndpoints(endpointer)
+		if err != nil {
+			return agg, err
+		}
+		if len(endpoints) == 0 {
+			return agg, lb.ErrNoEndpoints
+		}
+
+		agg.Responses = make([]Response[O], len(endpoints))
+		var (
+			wg  sync.WaitGroup
+			sem chan struct{}
+		)
+		if o.concurrency > 0 {
+			sem = make(chan struct{}, o.concurrency)
+		}
+		for i, ie := range endpoints {
+			agg.Responses[i].Instance = ie.Instance
+			if sem != nil {
+				select {
+				case sem <- struct{}{}:
+				case <-ctx.Done():
+					agg.Responses[i].Err = ctx.Err()
+					continue
+				}
+			}
+			wg.Add(1)
+			go func(r *Response[O], e endpoint.Endpoint[any]) {
+				defer wg.Done()
+				if sem != nil {
+					defer func() { <-sem }()
+				}
+				o.call(ctx, r, e, request)
+			}(&agg.Responses[i], ie.Endpoint)
+		}
+		wg.Wait()
+
+		var (
+			succeeded []O
+			errs      []error
+		)
+		for _, r := range agg.Responses {
+			if r.Err != nil {
+				errs = append(errs, r.Err)
+				continue
+			}
+			succeeded = append(succeeded, r.Response)
+		}
+		if required := o.quorum(len(endpoints)); len(succeeded) < required {
+			return agg, QuorumError{Required: required, Succeeded: len(succeeded), Errors: errs}
+		}
+		if o.merge != nil {
+			if agg.Merged, err = o.merge(succeeded); err != nil {
+				return agg, err
+			}
+		}
+		return agg, nil
+	}
+}
+
+// call makes the call to a single instance. A panic of the endpoint fails the
+// call with a *recovery.PanicError, since no caller's goroutine would recover
+// it.
+func (o *options[O]) call(ctx context.Context, r *Response[O], e endpoint.Endpoint[any], request interface{}) {
+	if o.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, o.timeout)
+		defer cancel()
+	}
+	begin := time.Now()
+	defer func() {
+		if v := recover(); v != nil {
+			r.Took = time.Since(begin)
+			r.Err = recovery.NewPanicError(v)
+		}
+	}()
+	response, err := e(ctx, request)
+	r.Took = time.Since(begin)
+	if err != nil {
+		r.Err = err
+		return
+	}
+	typed, ok := response.(O)
+	if !ok && response != nil {
+		r.Err = fmt.Errorf("fanout: unexpected response type %T", response)
+		return
+	}
+	r.Response = typed
+}
+
+func instanceEndpoints(endpointer sd.Endpointer) ([]sd.InstanceEndpoint, error) {
+	if ie, ok := endpointer.(sd.InstanceEndpointer); ok {
+		return ie.InstanceEndpoints()
+	}
+	endpoints, err := endpointer.Endpoints()
+	if err != nil {
+		return nil, err
+	}
+	ies := make([]sd.InstanceEndpoint, len(endpoints))
+	for i, e := range endpoints {
+		ies[i] = sd.InstanceEndpoint{Instance: strconv.Itoa(i), Endpoint: e}
+	}
+	return ies, nil
+}
@@ -0,0 +1,165 @@
+package fanout_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/tnnyio/log"
+
+	"github.com/tnnyio/yoroi/endpoint"
+	"github.com/tnnyio/yoroi/recovery"
+	"github.com/tnnyio/yoroi/sd"
+	"github.com/tnnyio/yoroi/sd/fanout"
+	"github.com/tnnyio/yoroi/sd/lb"
+)
+
+var errDown = errors.New("down")
+
+// factory returns endpoints answering with their instance string, and
+// failing for instances prefixed with "down".
+func factory(instance string) (endpoint.Endpoint[any], io.Closer, error) {
+	return func(context.Context, interface{}) (interface{}, error) {
+		if strings.HasPrefix(instance, "down") {
+			return nil, errDown
+		}
+		return instance, nil
+	}, nil, nil
+}
+
+func endpointer(instances ...string) *sd.DefaultEndpointer {
+	return sd.NewEndpointer(sd.FixedInstancer(instances), factory, log.NewNopLogger())
+}
+
+func TestFanout(t *testing.T) {
+	e := fanout.New[string](endpointer("b", "a", "c"))
+	agg, err := e(context.Background(), "hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, have := 3, len(agg.Responses); want != have {
+		t.Fatalf("responses: want %d, have %d", want, have)
+	}
+	for _, r := range agg.Responses {
+		if r.Instance != r.Response {
+			t.Errorf("instance %q: have response %q", r.Instance, r.Response)
+		}
+	}
+}
+
+func TestQuorum(t *testing.T) {
+	ep := endpointer("a", "b", "down")
+
+	agg, err := fanout.New[string](ep)(context.Background(), nil)
+	var qerr fanout.QuorumError
+	if !errors.As(err, &qerr) {
+		t.Fatalf("want QuorumError, have %v", err)
+	}
+	if want, have := 3, qerr.Required; want != have {
+		t.Errorf("required: want %d, have %d", want, have)
+	}
+	if !errors.Is(err, errDown) {
+		t.Errorf("want error to wrap %v", errDown)
+	}
+	if want, have := 1, len(agg.Failed()); want != have {
+		t.Errorf("failed: want %d, have %d", want, have)
+	}
+
+	if _, err := fanout.New[string](ep, fanout.Quorum[string](fanout.Majority))(context.Background(), nil); err != nil {
+		t.Errorf("majority: want no error, have %v", err)
+	}
+	if _, err := fanout.New[string](ep, fanout.Quorum[string](fanout.AtLeast(3)))(context.Background(), nil); err == nil {
+		t.Error("at least 3: want error")
+	}
+}
+
+func TestPanic(t *testing.T) {
+	panicking := func(instance string) (endpoint.Endpoint[any], io.Closer, error) {
+		if instance == "panic" {
+			return func(context.Context, interface{}) (interface{}, error) { panic("boom") }, nil, nil
+		}
+		return factory(instance)
+	}
+	ep := sd.NewEndpointer(sd.FixedInstancer{"a", "panic"}, panicking, log.NewNopLogger())
+
+	agg, err := fanout.New[string](ep, fanout.Quorum[string](fanout.AtLeast(1)))(context.Background(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	failed := agg.Failed()
+	if want, have := 1, len(failed); want != have {
+		t.Fatalf("failed: want %d, have %d", want, have)
+	}
+	var perr *recovery.PanicError
+	if !errors.As(failed[0].Err, &perr) {
+		t.Fatalf("want *recovery.PanicError, have %v", failed[0].Err)
+	}
+	if want, have := "boom", perr.Value; want != have {
+		t.Errorf("panic value: want %v, have %v", want, have)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	merge := func(responses []string) (string, error) {
+		sort.Strings(responses)
+		return strings.Join(responses, ","), nil
+	}
+	e := fanout.New[string](endpointer("c", "down", "a"),
+		fanout.Quorum[string](fanout.AtLeast(2)),
+		fanout.Merge[string](merge),
+	)
+	agg, err := e(context.Background(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, have := "a,c", agg.Merged; want != have {
+		t.Errorf("merged: want %q, have %q", want, have)
+	}
+}
+
+func TestConcurrencyAndTimeout(t *testing.T) {
+	var (
+		active, peak int32
+		slow         = func(ctx context.Context, _ interface{}) (interface{}, error) {
+			n := atomic.AddInt32(&active, 1)
+			defer atomic.AddInt32(&active, -1)
+			for {
+				p := atomic.LoadInt32(&peak)
+				if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+					break
+				}
+			}
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}
+		endpoints = sd.FixedEndpointer{slow, slow, slow, slow}
+		e         = fanout.New[string](endpoints,
+			fanout.Concurrency[string](2),
+			fanout.Timeout[string](10*time.Millisecond),
+			fanout.Quorum[string](fanout.AtLeast(0)),
+		)
+	)
+	agg, err := e(context.Background(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, have := int32(2), atomic.LoadInt32(&peak); want != have {
+		t.Errorf("peak concurrency: want %d, have %d", want, have)
+	}
+	for i, r := range agg.Responses {
+		if r.Err != context.DeadlineExceeded {
+			t.Errorf("response %d: want %v, have %v", i, context.DeadlineExceeded, r.Err)
+		}
+	}
+}
+
+func TestNoEndpoints(t *testing.T) {
+	if _, err := fanout.New[string](sd.FixedEndpointer{})(context.Background(), nil); err != lb.ErrNoEndpoints {
+		t.Errorf("want %v, have %v", lb.ErrNoEndpoints, err)
+	}
+}
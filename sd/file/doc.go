@@ -0,0 +1,3 @@
+// Package file provides an Instancer implementation reading instances from a
+// JSON or YAML file, for local development and static environments.
+package file
@@ -0,0 +1,261 @@
+package file
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/tnnyio/log"
+	"github.com/tnnyio/yoroi/sd"
+	"github.com/tnnyio/yoroi/sd/internal/instance"
+)
+
+// Entry is an instance listed in the file.
+//
+// In JSON:
+//
+//	{"instances": [{"address": "10.0.0.1:8080", "weight": 2, "tags": ["v2"], "zone": "a"}]}
+//
+// In YAML:
+//
+//	instances:
+//	  - address: 10.0.0.1:8080
+//	    weight: 2
+//	    tags: [v2]
+//	    zone: a
+//
+// The weight defaults to 1. An instance with weight 0 is drained: it stays in
+// the file but is not published. The tags and zone are published as the
+// metadata of the instance.
+type Entry struct {
+	Address string
+	Weight  int
+	Tags    []string
+	Zone    string
+}
+
+type entry struct {
+	Address string   `json:"address" yaml:"address"`
+	Weight  *int     `json:"weight" yaml:"weight"`
+	Tags    []string `json:"tags" yaml:"tags"`
+	Zone    string   `json:"zone" yaml:"zone"`
+}
+
+type document struct {
+	Instances []entry `json:"instances" yaml:"instances"`
+}
+
+// Instancer yields the instances listed in a file. Files ending in .yaml or
+// .yml are read as YAML, and other files as JSON.
+//
+// On every tick of the refresh ticker, the file is checked for changes by its
+// modification time and size, and only read and parsed when they changed. As
+// a rewrite within the granularity of the modification time may keep both,
+// a file modified shortly before it was last read is read again, and parsed
+// if the hash of its content changed.
+//
+// When the file can't be read or parsed, the error is published with the
+// last good set of instances, which stays in use until the file is fixed.
+type Instancer struct {
+	cache  *instance.Cache
+	path   string
+	tags   []string
+	logger log.Logger
+	quit   chan struct{}
+
+	mtx     sync.RWMutex
+	entries []Entry
+
+	// The state of the file last read, or zero if it couldn't be. It's only
+	// used by the loop.
+	modTime time.Time
+	size    int64
+	read    time.Time // when the file was last read
+	sum     [sha256.Size]byte
+}
+
+// mtimeGranularity bounds the granularity of modification times across
+// common file systems. Files modified less than that before they were read
+// may be rewritten without their modification time changing.
+const mtimeGranularity = 2 * time.Second
+
+// NewInstancer returns a file Instancer checking the file for changes every
+// interval. It only publishes the instances having all of the tags.
+func NewInstancer(path string, interval time.Duration, logger log.Logger, tags ...string) *Instancer {
+	return NewInstancerDetailed(path, time.NewTicker(interval), logger, tags...)
+}
+
+// NewInstancerDetailed is the same as NewInstancer, but allows users to
+// provide an explicit refresh ticker instead of an interval.
+func NewInstancerDetailed(path string, refresh *time.Ticker, logger log.Logger, tags ...string) *Instancer {
+	in := &Instancer{
+		cache:  instance.NewCache(),
+		path:   path,
+		tags:   tags,
+		logger: log.With(logger, "path", path),
+		quit:   make(chan struct{}),
+	}
+	in.reload()
+	go in.loop(refresh)
+	return in
+}
+
+// Stop terminates the Instancer.
+func (in *Instancer) Stop() {
+	close(in.quit)
+	in.cache.Stop()
+}
+
+// Entries returns the last good set of entries having the tags of the
+// Instancer, including the drained ones, for balancers using the weights.
+func (in *Instancer) Entries() []Entry {
+	in.mtx.RLock()
+	defer in.mtx.RUnlock()
+	return append([]Entry(nil), in.entries...)
+}
+
+func (in *Instancer) loop(t *time.Ticker) {
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			in.reload()
+
+		case <-in.quit:
+			return
+		}
+	}
+}
+
+// reload reads the file if it changed since it was last read.
+func (in *Instancer) reload() {
+	fi, err := os.Stat(in.path)
+	if err != nil {
+		in.forget()
+		in.fail(err)
+		return
+	}
+	if fi.ModTime().Equal(in.modTime) && fi.Size() == in.size && in.read.Sub(in.modTime) > mtimeGranularity {
+		return
+	}
+
+	read := time.Now()
+	b, err := os.ReadFile(in.path)
+	if err != nil {
+		in.forget()
+		in.fail(err)
+		return
+	}
+	in.modTime, in.size, in.read = fi.ModTime(), fi.Size(), read
+	sum := sha256.Sum256(b)
+	if sum == in.sum {
+		return
+	}
+	in.sum = sum
+
+	entries, err := in.parse(b)
+	if err != nil {
+		in.fail(err)
+		return
+	}
+	in.mtx.Lock()
+	in.entries = entries
+	in.mtx.Unlock()
+
+	instances, metadata := publishable(entries)
+	in.logger.Log("instances", len(instances))
+	in.cache.Update(sd.Event{Instances: instances, Metadata: metadata})
+}
+
+// forget clears the state of the file last read, so that it's read again once
+// it's back.
+func (in *Instancer) forget() {
+	in.modTime, in.size, in.read, in.sum = time.Time{}, 0, time.Time{}, [sha256.Size]byte{}
+}
+
+// fail publishes err with the last good set of instances.
+func (in *Instancer) fail(err error) {
+	in.logger.Log("err", err)
+	in.mtx.RLock()
+	instances, metadata := publishable(in.entries)
+	in.mtx.RUnlock()
+	in.cache.Update(sd.Event{Instances: instances, Metadata: metadata, Err: err})
+}
+
+func (in *Instancer) parse(b []byte) ([]Entry, error) {
+	var (
+		doc document
+		err error
+	)
+	switch strings.ToLower(filepath.Ext(in.path)) {
+	case ".yaml", ".yml":
+		dec := yaml.NewDecoder(bytes.NewReader(b))
+		dec.KnownFields(true)
+		err = dec.Decode(&doc)
+	default:
+		dec := json.NewDecoder(bytes.NewReader(b))
+		dec.DisallowUnknownFields()
+		err = dec.Decode(&doc)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", in.path, err)
+	}
+
+	var (
+		entries = make([]Entry, 0, len(doc.Instances))
+		seen    = map[string]bool{}
+	)
+	for i, e := range doc.Instances {
+		if _, _, err := net.SplitHostPort(e.Address); err != nil {
+			return nil, fmt.Errorf("%s: instance %d: %w", in.path, i, err)
+		}
+		if seen[e.Address] {
+			return nil, fmt.Errorf("%s: instance %d: duplicate address %s", in.path, i, e.Address)
+		}
+		seen[e.Address] = true
+		weight := 1
+		if e.Weight != nil {
+			weight = *e.Weight
+		}
+		if weight < 0 {
+			return nil, fmt.Errorf("%s: instance %d: negative weight %d", in.path, i, weight)
+		}
+		if (sd.Metadata{Tags: e.Tags}).HasTags(in.tags...) {
+			entries = append(entries, Entry{Address: e.Address, Weight: weight, Tags: e.Tags, Zone: e.Zone})
+		}
+	}
+	return entries, nil
+}
+
+// Register implements Instancer.
+func (in *Instancer) Register(ch chan<- sd.Event) {
+	in.cache.Register(ch)
+}
+
+// Deregister implements Instancer.
+func (in *Instancer) Deregister(ch chan<- sd.Event) {
+	in.cache.Deregister(ch)
+}
+
+func publishable(entries []Entry) ([]string, map[string]sd.Metadata) {
+	var (
+		instances []string
+		metadata  = make(map[string]sd.Metadata, len(entries))
+	)
+	for _, e := range entries {
+		if e.Weight > 0 {
+			instances = append(instances, e.Address)
+			metadata[e.Address] = sd.Metadata{Tags: e.Tags, Zone: e.Zone}
+		}
+	}
+	return instances, metadata
+}
@@ -0,0 +1,213 @@
+package file
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/tnnyio/log"
+	"github.com/tnnyio/yoroi/sd"
+)
+
+var _ sd.Instancer = (*Instancer)(nil) // API check
+
+const jsonInstances = `{"instances": [
+	{"address": "10.0.0.1:8080", "weight": 2, "tags": ["api", "v1"], "zone": "a"},
+	{"address": "10.0.0.2:8080", "tags": ["api", "v2"], "zone": "b"},
+	{"address": "10.0.0.3:8080", "weight": 0, "tags": ["api"]}
+]}`
+
+const yamlInstances = `instances:
+  - address: 10.0.0.1:8080
+    weight: 2
+    tags: [api, v1]
+  - address: "[fd00::2]:8080"
+    tags: [api, v2]
+    zone: b
+`
+
+func TestInstancerJSON(t *testing.T) {
+	path := write(t, "instances.json", jsonInstances)
+	in := NewInstancer(path, time.Hour, log.NewNopLogger(), "api")
+	defer in.Stop()
+
+	state := in.cache.State()
+	if state.Err != nil {
+		t.Fatal(state.Err)
+	}
+	if want, have := "[10.0.0.1:8080 10.0.0.2:8080]", fmt.Sprint(state.Instances); want != have {
+		t.Errorf("want %s, have %s", want, have)
+	}
+	if want, have := "[{10.0.0.1:8080 2 [api v1] a} {10.0.0.2:8080 1 [api v2] b} {10.0.0.3:8080 0 [api] }]", fmt.Sprint(in.Entries()); want != have {
+		t.Errorf("want %s, have %s", want, have)
+	}
+}
+
+func TestInstancerYAML(t *testing.T) {
+	path := write(t, "instances.yaml", yamlInstances)
+	in := NewInstancer(path, time.Hour, log.NewNopLogger(), "v2")
+	defer in.Stop()
+
+	state := in.cache.State()
+	if state.Err != nil {
+		t.Fatal(state.Err)
+	}
+	if want, have := "[[fd00::2]:8080]", fmt.Sprint(state.Instances); want != have {
+		t.Errorf("want %s, have %s", want, have)
+	}
+	if want, have := "map[[fd00::2]:8080:{[api v2] b map[]}]", fmt.Sprint(state.Metadata); want != have {
+		t.Errorf("want %s, have %s", want, have)
+	}
+}
+
+func TestInstancerReload(t *testing.T) {
+	path := write(t, "instances.json", `{"instances": [{"address": "10.0.0.1:8080"}]}`)
+	ticker := time.NewTicker(time.Millisecond)
+	in := NewInstancerDetailed(path, ticker, log.NewNopLogger())
+	defer in.Stop()
+
+	ch := make(chan sd.Event, 1)
+	in.Register(ch)
+	defer in.Deregister(ch)
+	expect(t, ch, "[10.0.0.1:8080]", false)
+
+	rewrite(t, path, `{"instances": [{"address": "10.0.0.1:8080"}, {"address": "10.0.0.2:8080"}]}`)
+	expect(t, ch, "[10.0.0.1:8080 10.0.0.2:8080]", false)
+
+	// A broken file keeps the last good set.
+	rewrite(t, path, `{"instances": [{"address": "10.0.0.3"}]}`)
+	expect(t, ch, "[10.0.0.1:8080 10.0.0.2:8080]", true)
+
+	rewrite(t, path, `{"instances": [{"address": "10.0.0.3:8080"}]}`)
+	expect(t, ch, "[10.0.0.3:8080]", false)
+
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	expect(t, ch, "[10.0.0.3:8080]", true)
+
+	rewrite(t, path, `{"instances": [{"address": "10.0.0.4:8080"}]}`)
+	expect(t, ch, "[10.0.0.4:8080]", false)
+}
+
+func TestInstancerSameSizeAndTime(t *testing.T) {
+	path := write(t, "instances.json", `{"instances": [{"address": "10.0.0.1:8080"}]}`)
+	ticker := time.NewTicker(time.Millisecond)
+	in := NewInstancerDetailed(path, ticker, log.NewNopLogger())
+	defer in.Stop()
+
+	ch := make(chan sd.Event, 1)
+	in.Register(ch)
+	defer in.Deregister(ch)
+	expect(t, ch, "[10.0.0.1:8080]", false)
+
+	// A rewrite within the granularity of the modification time.
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(`{"instances": [{"address": "10.0.0.2:8080"}]}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, fi.ModTime(), fi.ModTime()); err != nil {
+		t.Fatal(err)
+	}
+	expect(t, ch, "[10.0.0.2:8080]", false)
+}
+
+func TestInstancerUnchangedStat(t *testing.T) {
+	path := write(t, "instances.json", `{"instances": [{"address": "10.0.0.1:8080"}]}`)
+	old := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(path, old, old); err != nil {
+		t.Fatal(err)
+	}
+	ticker := time.NewTicker(time.Millisecond)
+	in := NewInstancerDetailed(path, ticker, log.NewNopLogger())
+	defer in.Stop()
+
+	ch := make(chan sd.Event, 1)
+	in.Register(ch)
+	defer in.Deregister(ch)
+	expect(t, ch, "[10.0.0.1:8080]", false)
+
+	// The file isn't read again while its modification time and size are
+	// those of an old file.
+	if err := os.WriteFile(path, []byte(`{"instances": [{"address": "10.0.0.2:8080"}]}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, old, old); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if want, have := "[10.0.0.1:8080]", fmt.Sprint(in.cache.State().Instances); want != have {
+		t.Errorf("want %s, have %s", want, have)
+	}
+
+	rewrite(t, path, `{"instances": [{"address": "10.0.0.3:8080"}]}`)
+	expect(t, ch, "[10.0.0.3:8080]", false)
+}
+
+func TestInstancerInvalid(t *testing.T) {
+	for name, content := range map[string]string{
+		"syntax.json":    `{"instances": [`,
+		"unknown.json":   `{"instances": [{"addr": "10.0.0.1:8080"}]}`,
+		"port.json":      `{"instances": [{"address": "10.0.0.1"}]}`,
+		"duplicate.yaml": "instances:\n  - address: 10.0.0.1:8080\n  - address: 10.0.0.1:8080\n",
+		"weight.yaml":    "instances:\n  - address: 10.0.0.1:8080\n    weight: -1\n",
+	} {
+		in := NewInstancer(write(t, name, content), time.Hour, log.NewNopLogger())
+		if in.cache.State().Err == nil {
+			t.Errorf("%s: want error", name)
+		}
+		in.Stop()
+	}
+
+	in := NewInstancer(filepath.Join(t.TempDir(), "missing.json"), time.Hour, log.NewNopLogger())
+	defer in.Stop()
+	if err := in.cache.State().Err; !os.IsNotExist(err) {
+		t.Errorf("want not exist error, have %v", err)
+	}
+}
+
+func write(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// rewrite changes the file, and makes sure its modification time changes
+// on file systems with a coarse resolution.
+func rewrite(t *testing.T, path, content string) {
+	t.Helper()
+	fi, err := os.Stat(path)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err == nil {
+		mtime := fi.ModTime().Add(time.Second)
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// expect waits for an event with the instances, and an error or not.
+func expect(t *testing.T, ch <-chan sd.Event, instances string, failed bool) {
+	t.Helper()
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case event := <-ch:
+			if fmt.Sprint(event.Instances) == instances && (event.Err != nil) == failed {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("no event with instances %s (error %v)", instances, failed)
+		}
+	}
+}
@@ -12,6 +12,40 @@ package sd
 type Event struct {
 	Instances []string
 	Err       error
+
+	// Metadata optionally describes the instances, by instance string.
+	// Backends fill it in with what they know about the instances, and an
+	// instance may have none.
+	Metadata map[string]Metadata
+
+	// Stale marks instances that don't come from the backend itself, but
+	// from a copy of an earlier state, such as a snapshot served while the
+	// backend is unreachable.
+	Stale bool
+}
+
+// Metadata describes an instance.
+type Metadata struct {
+	Tags []string
+	Zone string
+	Meta map[string]string
+}
+
+// HasTags tells whether the instance has all of the tags.
+func (md Metadata) HasTags(tags ...string) bool {
+	for _, want := range tags {
+		var found bool
+		for _, tag := range md.Tags {
+			if tag == want {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
 }
 
 // Instancer listens to a service discovery system and notifies registered
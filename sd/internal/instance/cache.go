@@ -11,9 +11,11 @@ import (
 // Cache keeps track of resource instances provided to it via Update method
 // and implements the Instancer interface
 type Cache struct {
-	mtx   sync.RWMutex
-	state sd.Event
-	reg   registry
+	mtx     sync.RWMutex
+	state   sd.Event
+	version uint64 // of the state
+	reg     registry
+	stopped bool
 }
 
 // NewCache creates a new Cache.
@@ -35,6 +37,7 @@ func (c *Cache) Update(event sd.Event) {
 	}
 
 	c.state = event
+	c.version++
 	c.reg.broadcast(event)
 }
 
@@ -47,44 +50,144 @@ func (c *Cache) State() sd.Event {
 	return eventCopy
 }
 
-// Stop implements Instancer. Since the cache is just a plain-old store of data,
-// Stop is a no-op.
-func (c *Cache) Stop() {}
+// Stop implements Instancer. The cache is a plain-old store of data, but
+// Stop ends the delivery of pending events to the registered channels.
+// Later updates aren't delivered to them anymore.
+func (c *Cache) Stop() {
+	c.mtx.Lock()
+	reg := c.reg
+	c.reg = registry{}
+	c.stopped = true
+	c.mtx.Unlock()
+	for _, sub := range reg {
+		sub.stop()
+	}
+}
 
-// Register implements Instancer.
+// Register implements Instancer. The current state is sent to the channel
+// before Register returns, as well as every later update. Delivery of
+// updates doesn't block the cache: when the channel isn't ready, a newer
+// state replaces the pending one, so that a slow subscriber skips
+// intermediate states but always gets the latest.
 func (c *Cache) Register(ch chan<- sd.Event) {
+	c.mtx.RLock()
+	event, version := copyEvent(c.state), c.version
+	c.mtx.RUnlock()
+	// always push the current state to new channels, outside the lock: the
+	// channel may not be ready yet.
+	ch <- event
+
 	c.mtx.Lock()
 	defer c.mtx.Unlock()
+	if c.stopped {
+		return
+	}
 	c.reg.register(ch)
-	event := c.state
-	eventCopy := copyEvent(event)
-	// always push the current state to new channels
-	ch <- eventCopy
+	if c.version != version {
+		// The state changed during the send.
+		c.reg[ch].offer(c.state)
+	}
 }
 
-// Deregister implements Instancer.
+// Deregister implements Instancer. No event is sent to the channel once
+// Deregister returns, so it may be closed.
 func (c *Cache) Deregister(ch chan<- sd.Event) {
 	c.mtx.Lock()
-	defer c.mtx.Unlock()
-	c.reg.deregister(ch)
+	sub := c.reg.deregister(ch)
+	c.mtx.Unlock()
+	// Wait outside the lock: the subscriber may be sending the last state.
+	if sub != nil {
+		sub.stop()
+	}
 }
 
 // registry is not goroutine-safe.
-type registry map[chan<- sd.Event]struct{}
+type registry map[chan<- sd.Event]*subscriber
 
 func (r registry) broadcast(event sd.Event) {
-	for c := range r {
-		eventCopy := copyEvent(event)
-		c <- eventCopy
+	for _, sub := range r {
+		sub.offer(event)
 	}
 }
 
 func (r registry) register(c chan<- sd.Event) {
-	r[c] = struct{}{}
+	if _, ok := r[c]; !ok {
+		r[c] = &subscriber{ch: c, quit: make(chan struct{})}
+	}
 }
 
-func (r registry) deregister(c chan<- sd.Event) {
+// deregister removes the subscriber of c, and returns it to be stopped, or
+// nil if c isn't registered.
+func (r registry) deregister(c chan<- sd.Event) *subscriber {
+	sub := r[c]
 	delete(r, c)
+	return sub
+}
+
+// subscriber delivers events to a channel. Events the channel is ready for
+// are sent right away. Otherwise, a goroutine sends them, holding at most one
+// pending event, and exits once it's delivered: idle subscribers have no
+// goroutine.
+type subscriber struct {
+	ch      chan<- sd.Event
+	mtx     sync.Mutex
+	pending *sd.Event
+	sending bool // whether the goroutine is running
+	stopped bool
+	quit    chan struct{}
+	wg      sync.WaitGroup
+}
+
+// offer sends the event, or replaces the pending one, without blocking.
+func (s *subscriber) offer(event sd.Event) {
+	eventCopy := copyEvent(event)
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	switch {
+	case s.stopped:
+	case s.sending:
+		s.pending = &eventCopy
+	default:
+		select {
+		case s.ch <- eventCopy:
+		default:
+			s.pending = &eventCopy
+			s.sending = true
+			s.wg.Add(1)
+			go s.loop()
+		}
+	}
+}
+
+// stop terminates the delivery, and waits until no send is in progress.
+func (s *subscriber) stop() {
+	s.mtx.Lock()
+	if !s.stopped {
+		s.stopped = true
+		close(s.quit)
+	}
+	s.mtx.Unlock()
+	s.wg.Wait()
+}
+
+func (s *subscriber) loop() {
+	defer s.wg.Done()
+	for {
+		s.mtx.Lock()
+		event := s.pending
+		s.pending = nil
+		if event == nil {
+			s.sending = false
+			s.mtx.Unlock()
+			return
+		}
+		s.mtx.Unlock()
+		select {
+		case s.ch <- *event:
+		case <-s.quit:
+			return
+		}
+	}
 }
 
 // copyEvent does a deep copy on sd.Event
@@ -92,6 +195,25 @@ func copyEvent(e sd.Event) sd.Event {
 	// observers all need their own copy of event
 	// because they can directly modify event.Instances
 	// for example, by calling sort.Strings
+	if e.Metadata != nil {
+		metadata := make(map[string]sd.Metadata, len(e.Metadata))
+		for instance, md := range e.Metadata {
+			if md.Tags != nil {
+				tags := make([]string, len(md.Tags))
+				copy(tags, md.Tags)
+				md.Tags = tags
+			}
+			if md.Meta != nil {
+				meta := make(map[string]string, len(md.Meta))
+				for k, v := range md.Meta {
+					meta[k] = v
+				}
+				md.Meta = meta
+			}
+			metadata[instance] = md
+		}
+		e.Metadata = metadata
+	}
 	if e.Instances == nil {
 		return e
 	}
@@ -74,8 +74,8 @@ func TestRegistry(t *testing.T) {
 		t.Fatalf("want: %v, have: %v", want, have)
 	}
 
-	reg.deregister(c1)
-	reg.deregister(c2)
+	reg.deregister(c1).stop()
+	reg.deregister(c2).stop()
 	close(c1)
 	close(c2)
 	// if deregister didn't work, broadcast would panic on closed channels
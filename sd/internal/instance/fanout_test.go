@@ -0,0 +1,243 @@
+package instance
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tnnyio/yoroi/sd"
+)
+
+// These tests are meant to be run with the race detector enabled: -race.
+
+func TestSlowSubscriberDoesNotBlock(t *testing.T) {
+	cache := NewCache()
+	stuck := make(chan sd.Event, 1) // never read
+	cache.Register(stuck)
+	defer cache.Deregister(stuck)
+
+	fast := make(chan sd.Event, 1)
+	cache.Register(fast)
+	defer cache.Deregister(fast)
+	<-fast
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			cache.Update(event(i))
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("updates blocked by a slow subscriber")
+	}
+	expectLatest(t, fast, event(99))
+}
+
+func TestLatestValueWins(t *testing.T) {
+	cache := NewCache()
+	slow := make(chan sd.Event, 1)
+	cache.Register(slow)
+	defer cache.Deregister(slow)
+
+	for i := 0; i < 100; i++ {
+		cache.Update(event(i))
+	}
+	<-slow // the initial state
+
+	// The subscriber gets the event it was being sent, then the latest one,
+	// skipping the ones in between.
+	var received int
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case e := <-slow:
+			received++
+			if fmt.Sprint(e.Instances) == fmt.Sprint(event(99).Instances) {
+				if received > 2 {
+					t.Errorf("want at most 2 events, have %d", received)
+				}
+				return
+			}
+		case <-timeout:
+			t.Fatal("latest event not received")
+		}
+	}
+}
+
+func TestDeregisterStuckSubscriber(t *testing.T) {
+	cache := NewCache()
+	cache.Update(event(0))
+	stuck := make(chan sd.Event, 1)
+	cache.Register(stuck)
+	cache.Update(event(1))
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		cache.Deregister(stuck)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Deregister blocked by a stuck subscriber")
+	}
+
+	// Nothing is sent after Deregister returns: a send would panic.
+	close(stuck)
+	cache.Update(event(2))
+}
+
+func TestRegisterWhileUpdating(t *testing.T) {
+	cache := NewCache()
+	cache.Update(event(0))
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				cache.Update(event(i*100 + j))
+			}
+		}(i)
+	}
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				ch := make(chan sd.Event, 1+j%2)
+				cache.Register(ch)
+				e := <-ch
+				e.Instances[0] = "modified" // subscribers own their copy
+				cache.Deregister(ch)
+				close(ch)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Every subscriber converges on the final state.
+	final := cache.State()
+	ch := make(chan sd.Event, 1)
+	cache.Register(ch)
+	defer cache.Deregister(ch)
+	expectLatest(t, ch, final)
+}
+
+func TestRegisterDeliversState(t *testing.T) {
+	cache := NewCache()
+	cache.Update(event(1))
+	ch := make(chan sd.Event, 1)
+	cache.Register(ch)
+	defer cache.Deregister(ch)
+	select {
+	case e := <-ch:
+		if want, have := fmt.Sprint(event(1).Instances), fmt.Sprint(e.Instances); want != have {
+			t.Errorf("want %s, have %s", want, have)
+		}
+	default:
+		t.Fatal("state not delivered by Register")
+	}
+}
+
+func TestRegisterDoesNotBlock(t *testing.T) {
+	cache := NewCache()
+	cache.Update(event(0))
+	ch := make(chan sd.Event) // not read until the updates are done
+	registered := make(chan struct{})
+	go func() {
+		defer close(registered)
+		cache.Register(ch)
+	}()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		cache.Update(event(1))
+		cache.State()
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("updates blocked by Register")
+	}
+
+	// The subscriber gets the state it was being sent, then the newer one.
+	expectLatest(t, ch, event(1))
+	<-registered
+	cache.Deregister(ch)
+}
+
+func TestStopEndsDelivery(t *testing.T) {
+	before := runtime.NumGoroutine()
+	cache := NewCache()
+	for i := 0; i < 10; i++ {
+		cache.Register(make(chan sd.Event, 1)) // never read, nor deregistered
+	}
+	cache.Update(event(1))
+	if runtime.NumGoroutine() <= before {
+		t.Fatal("want goroutines delivering the update")
+	}
+
+	cache.Stop()
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before {
+		if time.Now().After(deadline) {
+			t.Fatalf("want %d goroutines, have %d", before, runtime.NumGoroutine())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestIdleSubscribers(t *testing.T) {
+	before := runtime.NumGoroutine()
+	cache := NewCache()
+	ch := make(chan sd.Event, 1)
+	cache.Register(ch)
+	defer cache.Deregister(ch)
+	<-ch
+	cache.Update(event(1))
+	<-ch
+	if have := runtime.NumGoroutine(); have > before {
+		t.Errorf("want %d goroutines, have %d", before, have)
+	}
+}
+
+func TestMetadataCopied(t *testing.T) {
+	cache := NewCache()
+	cache.Update(sd.Event{
+		Instances: []string{"10.0.0.1:80"},
+		Metadata:  map[string]sd.Metadata{"10.0.0.1:80": {Tags: []string{"api"}, Meta: map[string]string{"k": "v"}}},
+	})
+	e := cache.State()
+	e.Metadata["10.0.0.1:80"].Tags[0] = "modified"
+	e.Metadata["10.0.0.1:80"].Meta["k"] = "modified"
+	if want, have := "{[api]  map[k:v]}", fmt.Sprint(cache.State().Metadata["10.0.0.1:80"]); want != have {
+		t.Errorf("want %s, have %s", want, have)
+	}
+}
+
+func event(i int) sd.Event {
+	return sd.Event{Instances: []string{fmt.Sprintf("10.0.0.%d:80", i)}}
+}
+
+// expectLatest reads events until the instances of want.
+func expectLatest(t *testing.T, ch <-chan sd.Event, want sd.Event) {
+	t.Helper()
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case e := <-ch:
+			if fmt.Sprint(e.Instances) == fmt.Sprint(want.Instances) {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("did not receive %v", want.Instances)
+		}
+	}
+}
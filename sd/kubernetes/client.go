@@ -0,0 +1,174 @@
+package kubernetes
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// Client is a wrapper around the EndpointSlice API of the Kubernetes API
+// server.
+type Client interface {
+	// EndpointSlices lists the EndpointSlices of the service.
+	EndpointSlices(ctx context.Context, namespace, service string) (*EndpointSliceList, error)
+
+	// WatchEndpointSlices watches the EndpointSlices of the service for
+	// changes after resourceVersion. The watch ends when ctx is canceled,
+	// or when the API server closes it.
+	WatchEndpointSlices(ctx context.Context, namespace, service, resourceVersion string) (Watcher, error)
+}
+
+// Watcher yields the events of a watch.
+type Watcher interface {
+	// Next blocks until the next event. It returns io.EOF when the watch
+	// ends normally.
+	Next() (WatchEvent, error)
+
+	// Close terminates the watch.
+	Close() error
+}
+
+// serviceNameLabel is the label of the EndpointSlices of a service.
+const serviceNameLabel = "kubernetes.io/service-name"
+
+// watchTimeout bounds a single watch request, in seconds. The API server
+// ends the watch after it, and the Instancer starts another one.
+const watchTimeout = "300"
+
+type client struct {
+	host  string
+	http  *http.Client
+	token func() (string, error)
+}
+
+// NewClient returns a Client for the API server at host, such as
+// https://10.0.0.1:443. The token, if not empty, is sent as a bearer token.
+func NewClient(host string, httpClient *http.Client, token string) Client {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return &client{
+		host:  strings.TrimSuffix(host, "/"),
+		http:  httpClient,
+		token: func() (string, error) { return token, nil },
+	}
+}
+
+// Paths of the service account credentials mounted in pods.
+const (
+	serviceAccountToken = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+	serviceAccountCA    = "/var/run/secrets/kubernetes.io/serviceaccount/ca.crt"
+)
+
+// NewInClusterClient returns a Client for the API server of the cluster the
+// process runs in, authenticated with the service account of the pod. The
+// token is read again for every request, as kubelet rotates it.
+func NewInClusterClient() (Client, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, errors.New("not running in a Kubernetes cluster")
+	}
+	ca, err := os.ReadFile(serviceAccountCA)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("no certificates in %s", serviceAccountCA)
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+	return &client{
+		host: "https://" + net.JoinHostPort(host, port),
+		http: &http.Client{Transport: transport},
+		token: func() (string, error) {
+			b, err := os.ReadFile(serviceAccountToken)
+			return strings.TrimSpace(string(b)), err
+		},
+	}, nil
+}
+
+func (c *client) EndpointSlices(ctx context.Context, namespace, service string) (*EndpointSliceList, error) {
+	resp, err := c.get(ctx, namespace, url.Values{
+		"labelSelector": {serviceNameLabel + "=" + service},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var list EndpointSliceList
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, err
+	}
+	return &list, nil
+}
+
+func (c *client) WatchEndpointSlices(ctx context.Context, namespace, service, resourceVersion string) (Watcher, error) {
+	resp, err := c.get(ctx, namespace, url.Values{
+		"labelSelector":       {serviceNameLabel + "=" + service},
+		"watch":               {"true"},
+		"resourceVersion":     {resourceVersion},
+		"allowWatchBookmarks": {"true"},
+		"timeoutSeconds":      {watchTimeout},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &watcher{body: resp.Body, dec: json.NewDecoder(resp.Body)}, nil
+}
+
+// get requests the EndpointSlices of the namespace, and returns the
+// response if its status is 200 OK.
+func (c *client) get(ctx context.Context, namespace string, query url.Values) (*http.Response, error) {
+	u := c.host + "/apis/discovery.k8s.io/v1/namespaces/" + url.PathEscape(namespace) + "/endpointslices?" + query.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	token, err := c.token()
+	if err != nil {
+		return nil, err
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		status := &Status{Code: resp.StatusCode, Reason: resp.Status}
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<16))
+		json.Unmarshal(body, status)
+		return nil, status
+	}
+	return resp, nil
+}
+
+type watcher struct {
+	body io.ReadCloser
+	dec  *json.Decoder
+}
+
+func (w *watcher) Next() (WatchEvent, error) {
+	var event WatchEvent
+	err := w.dec.Decode(&event)
+	return event, err
+}
+
+func (w *watcher) Close() error {
+	return w.body.Close()
+}
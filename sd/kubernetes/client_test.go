@@ -0,0 +1,112 @@
+package kubernetes
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/tnnyio/log"
+)
+
+// apiServer is a fake API server holding the EndpointSlices of one service.
+func apiServer(t *testing.T, list EndpointSliceList, events ...WatchEvent) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if want, have := "/apis/discovery.k8s.io/v1/namespaces/default/endpointslices", r.URL.Path; want != have {
+			http.NotFound(w, r)
+			return
+		}
+		if want, have := "Bearer secret", r.Header.Get("Authorization"); want != have {
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(Status{Code: http.StatusUnauthorized, Reason: "Unauthorized", Message: "bad token"})
+			return
+		}
+		if want, have := "kubernetes.io/service-name=search", r.URL.Query().Get("labelSelector"); want != have {
+			t.Errorf("labelSelector: want %s, have %s", want, have)
+		}
+		enc := json.NewEncoder(w)
+		if r.URL.Query().Get("watch") == "" {
+			enc.Encode(list)
+			return
+		}
+		if want, have := list.Metadata.ResourceVersion, r.URL.Query().Get("resourceVersion"); want != have {
+			t.Errorf("resourceVersion: want %s, have %s", want, have)
+		}
+		for _, event := range events {
+			enc.Encode(event)
+			w.(http.Flusher).Flush()
+		}
+	}))
+}
+
+func TestClient(t *testing.T) {
+	list := EndpointSliceList{
+		Metadata: ListMeta{ResourceVersion: "1"},
+		Items:    []EndpointSlice{slice("a", "1", endpoint("10.0.0.1", ready))},
+	}
+	added, _ := json.Marshal(slice("b", "2", endpoint("10.0.1.1", ready)))
+	server := apiServer(t, list, WatchEvent{Type: Added, Object: added})
+	defer server.Close()
+
+	client := NewClient(server.URL, nil, "secret")
+	have, err := client.EndpointSlices(context.Background(), "default", "search")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, have := "1", have.Metadata.ResourceVersion; want != have {
+		t.Errorf("want version %s, have %s", want, have)
+	}
+	instances, _ := makeInstances(map[string]EndpointSlice{"a": have.Items[0]}, "http")
+	if want, have := []string{"10.0.0.1:8080"}, instances; fmtInstances(want) != fmtInstances(have) {
+		t.Errorf("want %v, have %v", want, have)
+	}
+
+	w, err := client.WatchEndpointSlices(context.Background(), "default", "search", "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	event, err := w.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, have := Added, event.Type; want != have {
+		t.Errorf("want %s, have %s", want, have)
+	}
+	if _, err := w.Next(); !errors.Is(err, io.EOF) {
+		t.Errorf("want EOF, have %v", err)
+	}
+}
+
+func TestClientStatus(t *testing.T) {
+	server := apiServer(t, EndpointSliceList{})
+	defer server.Close()
+
+	_, err := NewClient(server.URL, nil, "wrong").EndpointSlices(context.Background(), "default", "search")
+	var status *Status
+	if !errors.As(err, &status) {
+		t.Fatalf("want a Status, have %v", err)
+	}
+	if want, have := http.StatusUnauthorized, status.Code; want != have {
+		t.Errorf("want code %d, have %d", want, have)
+	}
+	if want, have := "bad token", err.Error(); want != have {
+		t.Errorf("want %q, have %q", want, have)
+	}
+}
+
+func TestInstancerAPIServer(t *testing.T) {
+	list := EndpointSliceList{
+		Metadata: ListMeta{ResourceVersion: "1"},
+		Items:    []EndpointSlice{slice("a", "1", endpoint("10.0.0.1", ready))},
+	}
+	server := apiServer(t, list)
+	defer server.Close()
+
+	s := NewInstancer(NewClient(server.URL, nil, "secret"), log.NewNopLogger(), "default", "search", "http")
+	defer s.Stop()
+	expect(t, s, "10.0.0.1:8080")
+}
@@ -0,0 +1,4 @@
+// Package kubernetes provides an Instancer implementation for Kubernetes
+// EndpointSlices. It talks to the API server over its REST and watch
+// protocol, so it doesn't depend on client-go.
+package kubernetes
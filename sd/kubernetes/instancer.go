@@ -0,0 +1,274 @@
+package kubernetes
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/tnnyio/log"
+	"github.com/tnnyio/yoroi/sd"
+	"github.com/tnnyio/yoroi/sd/internal/instance"
+	"github.com/tnnyio/yoroi/util/conn"
+)
+
+// Instancer yields instances for a port of a Kubernetes service, from its
+// EndpointSlices. The slices are watched, and changes update the
+// subscribers.
+//
+// Instances are the addresses of the ready endpoints. When no endpoint is
+// ready, the endpoints that are terminating but still serving are used
+// instead, as kube-proxy does, so that requests drain during a rollout. The
+// metadata of an instance has the zone of the endpoint, and its node name
+// under the "node" key.
+type Instancer struct {
+	cache     *instance.Cache
+	client    Client
+	logger    log.Logger
+	namespace string
+	service   string
+	port      string
+	cancel    context.CancelFunc
+	done      chan struct{}
+
+	// slices are the EndpointSlices of the service by name, and version is
+	// the resource version they were watched up to. Both are only used by
+	// the loop.
+	slices  map[string]EndpointSlice
+	version string
+}
+
+// NewInstancer returns a Kubernetes Instancer that publishes the instances
+// of the named port of the service in namespace. An empty port selects the
+// only port of a service with a single unnamed port.
+func NewInstancer(client Client, logger log.Logger, namespace, service, port string) *Instancer {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &Instancer{
+		cache:     instance.NewCache(),
+		client:    client,
+		logger:    log.With(logger, "namespace", namespace, "service", service, "port", port),
+		namespace: namespace,
+		service:   service,
+		port:      port,
+		cancel:    cancel,
+		done:      make(chan struct{}),
+	}
+
+	err := s.list(ctx)
+	if err != nil {
+		s.logger.Log("err", err)
+		s.cache.Update(sd.Event{Err: err})
+	} else {
+		s.update()
+	}
+	go s.loop(ctx, err == nil)
+	return s
+}
+
+// Stop terminates the Instancer.
+func (s *Instancer) Stop() {
+	s.cancel()
+	<-s.done
+	s.cache.Stop()
+}
+
+func (s *Instancer) loop(ctx context.Context, listed bool) {
+	defer close(s.done)
+
+	d := 10 * time.Millisecond
+	for {
+		var (
+			err      error
+			received bool
+		)
+		if !listed {
+			err = s.list(ctx)
+			if err == nil {
+				s.update()
+			}
+		}
+		if err == nil {
+			received, err = s.watch(ctx)
+		}
+		switch {
+		case ctx.Err() != nil:
+			return // stopped
+		case err == nil && received:
+			// The API server ended the watch: continue from the last
+			// version seen.
+			d = 10 * time.Millisecond
+			listed = true
+			continue
+		case err == nil:
+			// The watch ended without any event: wait before watching
+			// again, in case the API server, or a proxy, keeps ending
+			// watches right away.
+			listed = true
+		case isExpired(err):
+			// The version is too old to watch from: list again.
+			s.logger.Log("during", "Watch", "err", err)
+			listed = false
+			continue
+		default:
+			s.logger.Log("err", err)
+			s.cache.Update(sd.Event{Err: err})
+			listed = false
+		}
+
+		select {
+		case <-time.After(d):
+			d = conn.Exponential(d)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// list replaces the known slices with the current ones.
+func (s *Instancer) list(ctx context.Context) error {
+	list, err := s.client.EndpointSlices(ctx, s.namespace, s.service)
+	if err != nil {
+		return err
+	}
+	s.slices = make(map[string]EndpointSlice, len(list.Items))
+	for _, slice := range list.Items {
+		s.slices[slice.Metadata.Name] = slice
+	}
+	s.version = list.Metadata.ResourceVersion
+	return nil
+}
+
+// watch applies the changes to the slices until the watch ends, and tells
+// whether it received any event. The error is nil if the API server ended
+// the watch normally.
+func (s *Instancer) watch(ctx context.Context) (received bool, err error) {
+	w, err := s.client.WatchEndpointSlices(ctx, s.namespace, s.service, s.version)
+	if err != nil {
+		return false, err
+	}
+	defer w.Close()
+
+	for {
+		event, err := w.Next()
+		if errors.Is(err, io.EOF) {
+			return received, nil
+		}
+		if err != nil {
+			return received, err
+		}
+		received = true
+
+		if event.Type == Error {
+			status := &Status{}
+			if err := json.Unmarshal(event.Object, status); err != nil {
+				return received, err
+			}
+			return received, status
+		}
+
+		var slice EndpointSlice
+		if err := json.Unmarshal(event.Object, &slice); err != nil {
+			return received, err
+		}
+		if slice.Metadata.ResourceVersion != "" {
+			s.version = slice.Metadata.ResourceVersion
+		}
+		switch event.Type {
+		case Added, Modified:
+			s.slices[slice.Metadata.Name] = slice
+		case Deleted:
+			delete(s.slices, slice.Metadata.Name)
+		default:
+			continue // bookmark
+		}
+		s.update()
+	}
+}
+
+// update publishes the instances of the known slices.
+func (s *Instancer) update() {
+	instances, metadata := makeInstances(s.slices, s.port)
+	s.logger.Log("instances", len(instances))
+	s.cache.Update(sd.Event{Instances: instances, Metadata: metadata})
+}
+
+// Register implements Instancer.
+func (s *Instancer) Register(ch chan<- sd.Event) {
+	s.cache.Register(ch)
+}
+
+// Deregister implements Instancer.
+func (s *Instancer) Deregister(ch chan<- sd.Event) {
+	s.cache.Deregister(ch)
+}
+
+func makeInstances(slices map[string]EndpointSlice, port string) ([]string, map[string]sd.Metadata) {
+	var (
+		ready, terminating     []string
+		readyMD, terminatingMD = map[string]sd.Metadata{}, map[string]sd.Metadata{}
+	)
+	for _, slice := range slices {
+		p, ok := findPort(slice.Ports, port)
+		if !ok {
+			continue
+		}
+		for _, e := range slice.Endpoints {
+			c := e.Conditions
+			// The addresses of an endpoint are fungible: use the first one.
+			if len(e.Addresses) == 0 {
+				continue
+			}
+			// An endpoint can be in several slices while they are updated:
+			// publish it once.
+			instance := net.JoinHostPort(e.Addresses[0], p)
+			switch {
+			case isTrue(c.Ready, true):
+				if _, ok := readyMD[instance]; !ok {
+					ready = append(ready, instance)
+					readyMD[instance] = makeMetadata(e)
+				}
+			case isTrue(c.Serving, true) && isTrue(c.Terminating, false):
+				if _, ok := terminatingMD[instance]; !ok {
+					terminating = append(terminating, instance)
+					terminatingMD[instance] = makeMetadata(e)
+				}
+			}
+		}
+	}
+	if len(ready) == 0 {
+		return terminating, terminatingMD
+	}
+	return ready, readyMD
+}
+
+func makeMetadata(e Endpoint) sd.Metadata {
+	var md sd.Metadata
+	if e.Zone != nil {
+		md.Zone = *e.Zone
+	}
+	if e.NodeName != nil {
+		md.Meta = map[string]string{"node": *e.NodeName}
+	}
+	return md
+}
+
+func findPort(ports []EndpointPort, name string) (string, bool) {
+	for _, p := range ports {
+		if p.Port == nil {
+			continue
+		}
+		if (p.Name == nil && name == "") || (p.Name != nil && *p.Name == name) {
+			return strconv.Itoa(int(*p.Port)), true
+		}
+	}
+	return "", false
+}
+
+func isExpired(err error) bool {
+	var status *Status
+	return errors.As(err, &status) && status.Code == http.StatusGone
+}
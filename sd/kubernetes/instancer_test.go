@@ -0,0 +1,307 @@
+package kubernetes
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tnnyio/log"
+	"github.com/tnnyio/yoroi/sd"
+)
+
+var _ sd.Instancer = (*Instancer)(nil) // API check
+
+func TestInstancer(t *testing.T) {
+	client := newTestClient(slice("a", "1", endpoint("10.0.0.1", ready), endpoint("10.0.0.2", notReady)))
+	s := NewInstancer(client, log.NewNopLogger(), "default", "search", "http")
+	defer s.Stop()
+
+	state := expect(t, s, "10.0.0.1:8080")
+	if state.Err != nil {
+		t.Fatal(state.Err)
+	}
+	if want, have := "1", client.watchVersion(t); want != have {
+		t.Errorf("watch version: want %s, have %s", want, have)
+	}
+
+	client.send(Added, slice("b", "2", endpoint("10.0.1.1", ready)))
+	expect(t, s, "10.0.0.1:8080", "10.0.1.1:8080")
+
+	client.send(Modified, slice("a", "3", endpoint("10.0.0.1", ready), endpoint("10.0.0.2", ready)))
+	expect(t, s, "10.0.0.1:8080", "10.0.0.2:8080", "10.0.1.1:8080")
+
+	client.send(Deleted, slice("b", "4"))
+	expect(t, s, "10.0.0.1:8080", "10.0.0.2:8080")
+}
+
+func TestInstancerTerminating(t *testing.T) {
+	client := newTestClient(slice("a", "1",
+		endpoint("10.0.0.1", ready),
+		endpoint("10.0.0.2", terminating),
+		endpoint("10.0.0.3", stopping),
+	))
+	s := NewInstancer(client, log.NewNopLogger(), "default", "search", "http")
+	defer s.Stop()
+	expect(t, s, "10.0.0.1:8080")
+	client.watchVersion(t)
+
+	// With no ready endpoint left, the serving terminating ones are used.
+	client.send(Modified, slice("a", "2",
+		endpoint("10.0.0.1", notReady),
+		endpoint("10.0.0.2", terminating),
+		endpoint("10.0.0.3", stopping),
+	))
+	expect(t, s, "10.0.0.2:8080")
+}
+
+func TestInstancerWatchEnds(t *testing.T) {
+	client := newTestClient(slice("a", "1", endpoint("10.0.0.1", ready)))
+	s := NewInstancer(client, log.NewNopLogger(), "default", "search", "http")
+	defer s.Stop()
+	client.watchVersion(t)
+
+	client.send(Bookmark, EndpointSlice{Metadata: ObjectMeta{ResourceVersion: "5"}})
+	client.end(nil)
+
+	if want, have := "5", client.watchVersion(t); want != have {
+		t.Errorf("want watch from %s, have %s", want, have)
+	}
+	if want, have := 1, client.lists(); want != have {
+		t.Errorf("want %d list, have %d", want, have)
+	}
+}
+
+func TestInstancerEmptyWatches(t *testing.T) {
+	client := newTestClient(slice("a", "1", endpoint("10.0.0.1", ready)))
+	s := NewInstancer(client, log.NewNopLogger(), "default", "search", "http")
+	defer s.Stop()
+	client.watchVersion(t)
+
+	// Watches ending without any event are retried with a backoff.
+	for i := 0; i < 3; i++ {
+		begin := time.Now()
+		client.end(nil)
+		client.watchVersion(t)
+		if elapsed := time.Since(begin); elapsed < 10*time.Millisecond {
+			t.Fatalf("watch %d: want a delay, have %v", i, elapsed)
+		}
+	}
+	if want, have := 1, client.lists(); want != have {
+		t.Errorf("want %d list, have %d", want, have)
+	}
+}
+
+func TestInstancerExpired(t *testing.T) {
+	client := newTestClient(slice("a", "1", endpoint("10.0.0.1", ready)))
+	s := NewInstancer(client, log.NewNopLogger(), "default", "search", "http")
+	defer s.Stop()
+	client.watchVersion(t)
+
+	client.setSlices(slice("a", "7", endpoint("10.0.0.2", ready)))
+	client.sendObject(Error, &Status{Code: http.StatusGone, Reason: "Expired"})
+
+	expect(t, s, "10.0.0.2:8080")
+	if want, have := "7", client.watchVersion(t); want != have {
+		t.Errorf("want watch from %s, have %s", want, have)
+	}
+}
+
+func TestInstancerError(t *testing.T) {
+	client := newTestClient(slice("a", "1", endpoint("10.0.0.1", ready)))
+	s := NewInstancer(client, log.NewNopLogger(), "default", "search", "http")
+	defer s.Stop()
+	client.watchVersion(t)
+
+	ch := make(chan sd.Event, 1)
+	s.Register(ch)
+	defer s.Deregister(ch)
+	errBroken := errors.New("connection reset")
+	client.end(errBroken)
+	for event := range ch {
+		if event.Err != nil {
+			if !errors.Is(event.Err, errBroken) {
+				t.Fatalf("want %v, have %v", errBroken, event.Err)
+			}
+			break
+		}
+	}
+
+	// The instancer lists and watches again.
+	client.watchVersion(t)
+	expect(t, s, "10.0.0.1:8080")
+}
+
+func TestMakeInstances(t *testing.T) {
+	zone, node := "eu-west-1a", "node-1"
+	unnamed := EndpointSlice{
+		Endpoints: []Endpoint{{Addresses: []string{"fd00::1"}, Zone: &zone, NodeName: &node}},
+		Ports:     []EndpointPort{{Port: int32p(80)}},
+	}
+	instances, metadata := makeInstances(map[string]EndpointSlice{"u": unnamed}, "")
+	if want, have := `["[fd00::1]:80"]`, fmtInstances(instances); want != have {
+		t.Errorf("want %s, have %s", want, have)
+	}
+	if md := metadata["[fd00::1]:80"]; md.Zone != zone || md.Meta["node"] != node {
+		t.Errorf("want zone %s and node %s, have %+v", zone, node, md)
+	}
+	if have, _ := makeInstances(map[string]EndpointSlice{"u": unnamed}, "http"); len(have) != 0 {
+		t.Errorf("want no instances for a missing port, have %v", have)
+	}
+
+	// An endpoint moving between slices is published once.
+	instances, _ = makeInstances(map[string]EndpointSlice{
+		"a": slice("a", "1", endpoint("10.0.0.1", ready), endpoint("10.0.0.2", ready)),
+		"b": slice("b", "2", endpoint("10.0.0.2", ready)),
+	}, "http")
+	sort.Strings(instances)
+	if want, have := `["10.0.0.1:8080","10.0.0.2:8080"]`, fmtInstances(instances); want != have {
+		t.Errorf("want %s, have %s", want, have)
+	}
+}
+
+// expect waits until the instancer publishes the instances.
+func expect(t *testing.T, s *Instancer, instances ...string) sd.Event {
+	t.Helper()
+	want := fmtInstances(instances)
+	deadline := time.Now().Add(time.Second)
+	for {
+		state := s.cache.State()
+		if fmtInstances(state.Instances) == want {
+			return state
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("want %s, have %s (err %v)", want, fmtInstances(state.Instances), state.Err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func fmtInstances(instances []string) string {
+	b, _ := json.Marshal(instances)
+	return string(b)
+}
+
+var (
+	yes, no     = true, false
+	ready       = EndpointConditions{}
+	notReady    = EndpointConditions{Ready: &no, Serving: &no}
+	terminating = EndpointConditions{Ready: &no, Serving: &yes, Terminating: &yes}
+	stopping    = EndpointConditions{Ready: &no, Serving: &no, Terminating: &yes}
+)
+
+func endpoint(addr string, c EndpointConditions) Endpoint {
+	return Endpoint{Addresses: []string{addr}, Conditions: c}
+}
+
+func slice(name, version string, endpoints ...Endpoint) EndpointSlice {
+	http := "http"
+	return EndpointSlice{
+		Metadata:  ObjectMeta{Name: name, ResourceVersion: version},
+		Endpoints: endpoints,
+		Ports:     []EndpointPort{{Name: &http, Port: int32p(8080)}, {Port: int32p(9090)}},
+	}
+}
+
+func int32p(i int32) *int32 { return &i }
+
+// testClient is a Client serving a fixed list, and watches fed by the test.
+type testClient struct {
+	mtx      sync.Mutex
+	slices   []EndpointSlice
+	nlists   int
+	watchers chan *testWatcher
+	current  *testWatcher // the last watch, only used by the test
+}
+
+func newTestClient(slices ...EndpointSlice) *testClient {
+	return &testClient{slices: slices, watchers: make(chan *testWatcher, 1)}
+}
+
+func (c *testClient) EndpointSlices(ctx context.Context, namespace, service string) (*EndpointSliceList, error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.nlists++
+	list := &EndpointSliceList{Items: c.slices}
+	if n := len(c.slices); n > 0 {
+		list.Metadata.ResourceVersion = c.slices[n-1].Metadata.ResourceVersion
+	}
+	return list, nil
+}
+
+func (c *testClient) WatchEndpointSlices(ctx context.Context, namespace, service, resourceVersion string) (Watcher, error) {
+	w := &testWatcher{ctx: ctx, version: resourceVersion, events: make(chan WatchEvent), errc: make(chan error, 1)}
+	select {
+	case c.watchers <- w:
+		return w, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (c *testClient) setSlices(slices ...EndpointSlice) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.slices = slices
+}
+
+func (c *testClient) lists() int {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.nlists
+}
+
+// watchVersion waits for the next watch, and returns the version it starts
+// from.
+func (c *testClient) watchVersion(t *testing.T) string {
+	t.Helper()
+	select {
+	case c.current = <-c.watchers:
+		return c.current.version
+	case <-time.After(time.Second):
+		t.Fatal("no watch")
+		return ""
+	}
+}
+
+func (c *testClient) send(typ string, slice EndpointSlice) {
+	c.sendObject(typ, slice)
+}
+
+func (c *testClient) sendObject(typ string, object interface{}) {
+	b, _ := json.Marshal(object)
+	c.current.events <- WatchEvent{Type: typ, Object: b}
+}
+
+// end ends the current watch with err, or normally if err is nil.
+func (c *testClient) end(err error) {
+	if err == nil {
+		err = io.EOF
+	}
+	c.current.errc <- err
+}
+
+type testWatcher struct {
+	ctx     context.Context
+	version string
+	events  chan WatchEvent
+	errc    chan error
+}
+
+func (w *testWatcher) Next() (WatchEvent, error) {
+	select {
+	case event := <-w.events:
+		return event, nil
+	case err := <-w.errc:
+		return WatchEvent{}, err
+	case <-w.ctx.Done():
+		return WatchEvent{}, w.ctx.Err()
+	}
+}
+
+func (w *testWatcher) Close() error { return nil }
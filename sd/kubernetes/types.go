@@ -0,0 +1,94 @@
+package kubernetes
+
+import "encoding/json"
+
+// The subset of the discovery.k8s.io/v1 API used by the Instancer.
+
+// ObjectMeta is the metadata of an object.
+type ObjectMeta struct {
+	Name            string            `json:"name"`
+	Namespace       string            `json:"namespace,omitempty"`
+	ResourceVersion string            `json:"resourceVersion,omitempty"`
+	Labels          map[string]string `json:"labels,omitempty"`
+}
+
+// ListMeta is the metadata of a list.
+type ListMeta struct {
+	ResourceVersion string `json:"resourceVersion,omitempty"`
+}
+
+// EndpointSlice is a subset of the endpoints of a service.
+type EndpointSlice struct {
+	Metadata    ObjectMeta     `json:"metadata"`
+	AddressType string         `json:"addressType"`
+	Endpoints   []Endpoint     `json:"endpoints"`
+	Ports       []EndpointPort `json:"ports"`
+}
+
+// EndpointSliceList is the result of listing EndpointSlices.
+type EndpointSliceList struct {
+	Metadata ListMeta        `json:"metadata"`
+	Items    []EndpointSlice `json:"items"`
+}
+
+// Endpoint is a backend of a service, usually a pod.
+type Endpoint struct {
+	Addresses  []string           `json:"addresses"`
+	Conditions EndpointConditions `json:"conditions"`
+	NodeName   *string            `json:"nodeName,omitempty"`
+	Zone       *string            `json:"zone,omitempty"`
+}
+
+// EndpointConditions are the conditions of an endpoint. A nil condition is
+// unknown: the API server treats unknown ready and serving conditions as
+// true, and an unknown terminating condition as false.
+type EndpointConditions struct {
+	Ready       *bool `json:"ready,omitempty"`
+	Serving     *bool `json:"serving,omitempty"`
+	Terminating *bool `json:"terminating,omitempty"`
+}
+
+// EndpointPort is a port of the endpoints of a slice. The name is empty for
+// the only port of a service.
+type EndpointPort struct {
+	Name     *string `json:"name,omitempty"`
+	Port     *int32  `json:"port,omitempty"`
+	Protocol *string `json:"protocol,omitempty"`
+}
+
+// Watch event types.
+const (
+	Added    = "ADDED"
+	Modified = "MODIFIED"
+	Deleted  = "DELETED"
+	Bookmark = "BOOKMARK"
+	Error    = "ERROR"
+)
+
+// WatchEvent is a change to an EndpointSlice. The object of an Error event
+// is a Status.
+type WatchEvent struct {
+	Type   string          `json:"type"`
+	Object json.RawMessage `json:"object"`
+}
+
+// Status is the error returned by the API server.
+type Status struct {
+	Code    int    `json:"code"`
+	Reason  string `json:"reason"`
+	Message string `json:"message"`
+}
+
+func (s *Status) Error() string {
+	if s.Message != "" {
+		return s.Message
+	}
+	return s.Reason
+}
+
+func isTrue(b *bool, unknown bool) bool {
+	if b == nil {
+		return unknown
+	}
+	return *b
+}
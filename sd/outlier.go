@@ -0,0 +1,407 @@
+package sd
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/tnnyio/log"
+	"github.com/tnnyio/yoroi/endpoint"
+)
+
+// Probe actively checks the health of an instance, returning an error if it
+// is unhealthy. Package sd/probe provides HTTP, TCP and gRPC probes.
+type Probe func(ctx context.Context, instance string) error
+
+// HealthCheck returns an EndpointerOption probing every instance each
+// interval, bounding each probe with the timeout. An instance failing its
+// probe is ejected until it passes one again. The interval defaults to 10
+// seconds, and the timeout to the interval.
+func HealthCheck(probe Probe, interval, timeout time.Duration) EndpointerOption {
+	return func(opts *endpointerOptions) {
+		opts.probe = probe
+		opts.probeInterval = interval
+		opts.probeTimeout = timeout
+	}
+}
+
+// EjectOnErrors returns an EndpointerOption ejecting an instance after n
+// consecutive errors returned by its endpoint.
+func EjectOnErrors(n int) EndpointerOption {
+	return func(opts *endpointerOptions) {
+		opts.ejectErrors = n
+	}
+}
+
+// EjectOnLatency returns an EndpointerOption ejecting latency outliers.
+// Every interval, the instances whose mean latency is more than factor times
+// the median of the mean latencies of all instances are ejected. Only the
+// instances serving at least 5 requests in the interval are compared, and
+// only when there are 3 of them or more. The interval defaults to 10 seconds.
+func EjectOnLatency(factor float64, interval time.Duration) EndpointerOption {
+	return func(opts *endpointerOptions) {
+		opts.latencyFactor = factor
+		opts.latencyInterval = interval
+	}
+}
+
+// EjectionTime returns an EndpointerOption setting how long instances stay
+// ejected by EjectOnErrors and EjectOnLatency. The first ejection lasts base,
+// and the time doubles each time the instance is ejected again within max of
+// its readmission, up to max. The defaults are 30 seconds and 5 minutes.
+func EjectionTime(base, max time.Duration) EndpointerOption {
+	return func(opts *endpointerOptions) {
+		opts.baseEjection = base
+		opts.maxEjection = max
+	}
+}
+
+// MaxEjectionPercent returns an EndpointerOption capping the share of
+// instances that may be ejected at once, whether by health checks or
+// outlier detection. The default is 50. At least one instance is always
+// kept: when too many are unhealthy, the ones ejected last are used anyway.
+func MaxEjectionPercent(percent int) EndpointerOption {
+	return func(opts *endpointerOptions) {
+		opts.maxEjectionPercent = percent
+	}
+}
+
+const (
+	defaultBaseEjection       = 30 * time.Second
+	defaultMaxEjection        = 5 * time.Minute
+	defaultMaxEjectionPercent = 50
+	defaultInterval           = 10 * time.Second
+
+	minOutlierRequests  = 5
+	minOutlierInstances = 3
+)
+
+// outlierDetector tracks the health of instances, and tells which ones to
+// leave out of the endpoints.
+type outlierDetector struct {
+	opts     endpointerOptions
+	logger   log.Logger
+	now      func() time.Time
+	onChange func() // called without holding mtx
+
+	mtx       sync.Mutex
+	instances map[string]*instanceHealth
+	quit      chan struct{}
+	wg        sync.WaitGroup
+}
+
+type instanceHealth struct {
+	unhealthy bool      // failed its last probe
+	until     time.Time // ejected by outlier detection until then
+	since     time.Time // excluded since then
+	ejections int       // consecutive ejections
+	errors    int       // consecutive errors
+	readmit   *time.Timer
+
+	// Latencies of the current interval.
+	latency  time.Duration
+	requests int
+}
+
+func (h *instanceHealth) stopReadmit() {
+	if h.readmit != nil {
+		h.readmit.Stop()
+		h.readmit = nil
+	}
+}
+
+func (h *instanceHealth) excluded(now time.Time) bool {
+	return h.unhealthy || now.Before(h.until)
+}
+
+// newOutlierDetector returns nil unless the options enable health checks
+// or outlier detection.
+func newOutlierDetector(opts endpointerOptions, logger log.Logger, now func() time.Time, onChange func()) *outlierDetector {
+	if opts.probe == nil && opts.ejectErrors <= 0 && opts.latencyFactor <= 0 {
+		return nil
+	}
+	if opts.baseEjection <= 0 {
+		opts.baseEjection = defaultBaseEjection
+	}
+	if opts.maxEjection < opts.baseEjection {
+		opts.maxEjection = defaultMaxEjection
+		if opts.maxEjection < opts.baseEjection {
+			opts.maxEjection = opts.baseEjection
+		}
+	}
+	if opts.maxEjectionPercent <= 0 {
+		opts.maxEjectionPercent = defaultMaxEjectionPercent
+	}
+	if opts.probeInterval <= 0 {
+		opts.probeInterval = defaultInterval
+	}
+	if opts.probeTimeout <= 0 {
+		opts.probeTimeout = opts.probeInterval
+	}
+	if opts.latencyInterval <= 0 {
+		opts.latencyInterval = defaultInterval
+	}
+
+	d := &outlierDetector{
+		opts:      opts,
+		logger:    logger,
+		now:       now,
+		onChange:  onChange,
+		instances: map[string]*instanceHealth{},
+		quit:      make(chan struct{}),
+	}
+	if opts.probe != nil {
+		d.wg.Add(1)
+		go d.every(opts.probeInterval, d.probe)
+	}
+	if opts.latencyFactor > 0 {
+		d.wg.Add(1)
+		go d.every(opts.latencyInterval, d.ejectSlow)
+	}
+	return d
+}
+
+func (d *outlierDetector) every(interval time.Duration, f func()) {
+	defer d.wg.Done()
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			f()
+		case <-d.quit:
+			return
+		}
+	}
+}
+
+// stop terminates the health checks and outlier detection, and stops the
+// pending readmissions.
+func (d *outlierDetector) stop() {
+	close(d.quit)
+	d.wg.Wait()
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	for _, h := range d.instances {
+		h.stopReadmit()
+	}
+}
+
+// sync starts tracking new instances, and forgets the ones that are gone.
+func (d *outlierDetector) sync(instances []string) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	present := make(map[string]*instanceHealth, len(instances))
+	for _, instance := range instances {
+		h, ok := d.instances[instance]
+		if !ok {
+			h = &instanceHealth{}
+		}
+		present[instance] = h
+	}
+	for instance, h := range d.instances {
+		if _, ok := present[instance]; !ok {
+			h.stopReadmit()
+		}
+	}
+	d.instances = present
+}
+
+// excluded returns the instances to leave out of the endpoints, at most the
+// allowed share of them.
+func (d *outlierDetector) excluded() map[string]bool {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	now := d.now()
+	var candidates []string
+	for instance, h := range d.instances {
+		if h.excluded(now) {
+			candidates = append(candidates, instance)
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return d.instances[candidates[i]].since.Before(d.instances[candidates[j]].since)
+	})
+	if n := d.allowed(); len(candidates) > n {
+		candidates = candidates[:n]
+	}
+	excluded := make(map[string]bool, len(candidates))
+	for _, instance := range candidates {
+		excluded[instance] = true
+	}
+	return excluded
+}
+
+// allowed returns how many instances may be excluded at once. It must be
+// called with mtx held.
+func (d *outlierDetector) allowed() int {
+	n := len(d.instances) * d.opts.maxEjectionPercent / 100
+	if n > len(d.instances)-1 {
+		n = len(d.instances) - 1
+	}
+	if n < 0 {
+		n = 0
+	}
+	return n
+}
+
+// canExclude tells whether another instance may be excluded. It must be
+// called with mtx held.
+func (d *outlierDetector) canExclude(now time.Time) bool {
+	var excluded int
+	for _, h := range d.instances {
+		if h.excluded(now) {
+			excluded++
+		}
+	}
+	return excluded < d.allowed()
+}
+
+// eject ejects the instance, and reports whether it was. It must be called
+// with mtx held.
+func (d *outlierDetector) eject(instance string, h *instanceHealth, reason string) bool {
+	now := d.now()
+	if h.excluded(now) || !d.canExclude(now) {
+		return false
+	}
+	if !h.until.IsZero() && now.Sub(h.until) < d.opts.maxEjection {
+		h.ejections++
+	} else {
+		h.ejections = 1
+	}
+	duration := d.opts.baseEjection
+	for i := 1; i < h.ejections && duration < d.opts.maxEjection; i++ {
+		duration *= 2
+	}
+	if duration > d.opts.maxEjection {
+		duration = d.opts.maxEjection
+	}
+	h.since, h.until, h.errors = now, now.Add(duration), 0
+	d.logger.Log("instance", instance, "ejected", reason, "for", duration)
+	select {
+	case <-d.quit:
+		// Stopped: nothing left to readmit the instance into.
+	default:
+		h.stopReadmit()
+		h.readmit = time.AfterFunc(duration, d.onChange)
+	}
+	return true
+}
+
+// wrap returns the endpoint of the instance, recording the outcome of its
+// calls when outlier detection is enabled.
+func (d *outlierDetector) wrap(instance string, e endpoint.Endpoint[any]) endpoint.Endpoint[any] {
+	if d.opts.ejectErrors <= 0 && d.opts.latencyFactor <= 0 {
+		return e
+	}
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		begin := time.Now()
+		response, err := e(ctx, request)
+		d.record(instance, time.Since(begin), err)
+		return response, err
+	}
+}
+
+func (d *outlierDetector) record(instance string, took time.Duration, err error) {
+	d.mtx.Lock()
+	h, ok := d.instances[instance]
+	if !ok {
+		d.mtx.Unlock()
+		return
+	}
+	h.latency += took
+	h.requests++
+	var ejected bool
+	if err == nil {
+		h.errors = 0
+	} else if h.errors++; d.opts.ejectErrors > 0 && h.errors >= d.opts.ejectErrors {
+		ejected = d.eject(instance, h, "errors")
+	}
+	d.mtx.Unlock()
+	if ejected {
+		d.onChange()
+	}
+}
+
+// ejectSlow ejects the latency outliers of the last interval.
+func (d *outlierDetector) ejectSlow() {
+	d.mtx.Lock()
+	type mean struct {
+		instance string
+		latency  time.Duration
+	}
+	var means []mean
+	for instance, h := range d.instances {
+		if h.requests >= minOutlierRequests {
+			means = append(means, mean{instance, h.latency / time.Duration(h.requests)})
+		}
+		h.latency, h.requests = 0, 0
+	}
+	var ejected bool
+	if len(means) >= minOutlierInstances {
+		sort.Slice(means, func(i, j int) bool { return means[i].latency < means[j].latency })
+		threshold := time.Duration(float64(means[len(means)/2].latency) * d.opts.latencyFactor)
+		// Slowest first, in case the cap stops the ejections.
+		for i := len(means) - 1; i >= 0 && means[i].latency > threshold; i-- {
+			if d.eject(means[i].instance, d.instances[means[i].instance], "latency") {
+				ejected = true
+			}
+		}
+	}
+	d.mtx.Unlock()
+	if ejected {
+		d.onChange()
+	}
+}
+
+// probe runs the health check of every instance.
+func (d *outlierDetector) probe() {
+	d.mtx.Lock()
+	instances := make([]string, 0, len(d.instances))
+	for instance := range d.instances {
+		instances = append(instances, instance)
+	}
+	d.mtx.Unlock()
+
+	var (
+		wg      sync.WaitGroup
+		results = make([]error, len(instances))
+	)
+	for i, instance := range instances {
+		wg.Add(1)
+		go func(i int, instance string) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), d.opts.probeTimeout)
+			defer cancel()
+			results[i] = d.opts.probe(ctx, instance)
+		}(i, instance)
+	}
+	wg.Wait()
+
+	d.mtx.Lock()
+	var (
+		now     = d.now()
+		changed bool
+	)
+	for i, instance := range instances {
+		h, ok := d.instances[instance]
+		if !ok || h.unhealthy == (results[i] != nil) {
+			continue
+		}
+		if results[i] != nil {
+			d.logger.Log("instance", instance, "health", "down", "err", results[i])
+			if !h.excluded(now) {
+				h.since = now
+			}
+		} else {
+			d.logger.Log("instance", instance, "health", "up")
+		}
+		h.unhealthy = results[i] != nil
+		changed = true
+	}
+	d.mtx.Unlock()
+	if changed {
+		d.onChange()
+	}
+}
@@ -0,0 +1,245 @@
+package sd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tnnyio/log"
+	"github.com/tnnyio/yoroi/endpoint"
+)
+
+var errBroken = errors.New("broken")
+
+// flaky returns a factory whose endpoints fail for the broken instances,
+// and wait for the configured latency.
+func flaky() (Factory, *flakiness) {
+	f := &flakiness{broken: map[string]bool{}, latency: map[string]time.Duration{}}
+	return func(instance string) (endpoint.Endpoint[any], io.Closer, error) {
+		return func(context.Context, interface{}) (interface{}, error) {
+			f.mtx.Lock()
+			broken, latency := f.broken[instance], f.latency[instance]
+			f.mtx.Unlock()
+			time.Sleep(latency)
+			if broken {
+				return nil, errBroken
+			}
+			return instance, nil
+		}, nil, nil
+	}, f
+}
+
+type flakiness struct {
+	mtx     sync.Mutex
+	broken  map[string]bool
+	latency map[string]time.Duration
+}
+
+func (f *flakiness) set(instance string, broken bool, latency time.Duration) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	f.broken[instance], f.latency[instance] = broken, latency
+}
+
+func TestEjectOnErrors(t *testing.T) {
+	factory, flakiness := flaky()
+	flakiness.set("b", true, 0)
+	cache := newEndpointCache(factory, log.NewNopLogger(), endpointerOptions{
+		ejectErrors:  3,
+		baseEjection: 50 * time.Millisecond,
+		maxEjection:  time.Second,
+	})
+	defer cache.close()
+	cache.Update(Event{Instances: []string{"a", "b", "c"}})
+
+	callAll(t, cache, 2)
+	assertInstances(t, cache, "[a b c]")
+	callAll(t, cache, 1)
+	assertInstances(t, cache, "[a c]")
+
+	// Readmitted after the ejection time, and ejected for twice as long on
+	// the next errors.
+	waitInstances(t, cache, "[a b c]")
+	callAll(t, cache, 3)
+	assertInstances(t, cache, "[a c]")
+	time.Sleep(60 * time.Millisecond)
+	assertInstances(t, cache, "[a c]")
+	waitInstances(t, cache, "[a b c]")
+}
+
+func TestEjectOnErrorsReset(t *testing.T) {
+	factory, flakiness := flaky()
+	cache := newEndpointCache(factory, log.NewNopLogger(), endpointerOptions{ejectErrors: 2})
+	defer cache.close()
+	cache.Update(Event{Instances: []string{"a", "b"}})
+
+	for i := 0; i < 3; i++ {
+		flakiness.set("a", true, 0)
+		callAll(t, cache, 1)
+		flakiness.set("a", false, 0)
+		callAll(t, cache, 1)
+	}
+	assertInstances(t, cache, "[a b]")
+}
+
+func TestStopReadmissions(t *testing.T) {
+	var (
+		mtx     sync.Mutex
+		changes int
+	)
+	d := newOutlierDetector(endpointerOptions{
+		ejectErrors:        1,
+		baseEjection:       20 * time.Millisecond,
+		maxEjectionPercent: 100,
+	}, log.NewNopLogger(), time.Now, func() {
+		mtx.Lock()
+		defer mtx.Unlock()
+		changes++
+	})
+	d.sync([]string{"a", "b", "c"})
+	d.record("a", 0, errBroken) // ejected, then dropped
+	d.record("b", 0, errBroken) // ejected, then stopped
+	d.sync([]string{"b", "c"})
+	d.stop()
+
+	time.Sleep(50 * time.Millisecond)
+	mtx.Lock()
+	defer mtx.Unlock()
+	if want, have := 2, changes; want != have {
+		t.Errorf("changes: want %d (the ejections only), have %d", want, have)
+	}
+}
+
+func TestMaxEjectionPercent(t *testing.T) {
+	factory, flakiness := flaky()
+	for _, instance := range []string{"a", "b", "c", "d"} {
+		flakiness.set(instance, true, 0)
+	}
+	cache := newEndpointCache(factory, log.NewNopLogger(), endpointerOptions{ejectErrors: 1})
+	defer cache.close()
+	cache.Update(Event{Instances: []string{"a", "b", "c", "d"}})
+
+	callAll(t, cache, 1)
+	assertInstances(t, cache, "[c d]")
+
+	// A single instance is never ejected.
+	single := newEndpointCache(factory, log.NewNopLogger(), endpointerOptions{ejectErrors: 1, maxEjectionPercent: 100})
+	defer single.close()
+	single.Update(Event{Instances: []string{"a"}})
+	callAll(t, single, 3)
+	assertInstances(t, single, "[a]")
+}
+
+func TestEjectOnLatency(t *testing.T) {
+	factory, flakiness := flaky()
+	for _, instance := range []string{"a", "b", "c"} {
+		flakiness.set(instance, false, time.Millisecond)
+	}
+	flakiness.set("d", false, 20*time.Millisecond)
+	cache := newEndpointCache(factory, log.NewNopLogger(), endpointerOptions{
+		latencyFactor:   3,
+		latencyInterval: 300 * time.Millisecond,
+	})
+	defer cache.close()
+	cache.Update(Event{Instances: []string{"a", "b", "c", "d"}})
+
+	callAll(t, cache, minOutlierRequests)
+	waitInstances(t, cache, "[a b c]")
+}
+
+func TestHealthCheck(t *testing.T) {
+	var (
+		mtx       sync.Mutex
+		unhealthy = map[string]bool{"b": true}
+	)
+	probe := func(ctx context.Context, instance string) error {
+		mtx.Lock()
+		defer mtx.Unlock()
+		if unhealthy[instance] {
+			return errBroken
+		}
+		return nil
+	}
+	factory, _ := flaky()
+	cache := newEndpointCache(factory, log.NewNopLogger(), endpointerOptions{
+		probe:         probe,
+		probeInterval: 5 * time.Millisecond,
+	})
+	defer cache.close()
+	cache.Update(Event{Instances: []string{"a", "b", "c"}})
+
+	waitInstances(t, cache, "[a c]")
+	mtx.Lock()
+	unhealthy["b"], unhealthy["c"] = false, true
+	mtx.Unlock()
+	waitInstances(t, cache, "[a b]")
+
+	// All unhealthy: only the first to fail stays out.
+	mtx.Lock()
+	unhealthy["a"], unhealthy["b"] = true, true
+	mtx.Unlock()
+	waitInstances(t, cache, "[a b]")
+}
+
+func TestEndpointerHealthCheck(t *testing.T) {
+	probe := func(ctx context.Context, instance string) error {
+		if instance == "b" {
+			return errBroken
+		}
+		return nil
+	}
+	factory, _ := flaky()
+	endpointer := NewEndpointer(FixedInstancer{"a", "b"}, factory, log.NewNopLogger(), HealthCheck(probe, 5*time.Millisecond, time.Second))
+	defer endpointer.Close()
+	waitInstances(t, endpointer.cache, "[a]")
+}
+
+// callAll calls every endpoint n times.
+func callAll(t *testing.T, cache *endpointCache, n int) {
+	t.Helper()
+	endpoints, err := cache.Endpoints()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < n; i++ {
+		for _, e := range endpoints {
+			e(context.Background(), nil)
+		}
+	}
+}
+
+func assertInstances(t *testing.T, cache *endpointCache, want string) {
+	t.Helper()
+	ies, _ := cache.InstanceEndpoints()
+	if have := fmtInstanceEndpoints(ies); want != have {
+		t.Fatalf("want %s, have %s", want, have)
+	}
+}
+
+func waitInstances(t *testing.T, cache *endpointCache, want string) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for {
+		ies, _ := cache.InstanceEndpoints()
+		have := fmtInstanceEndpoints(ies)
+		if want == have {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("want %s, have %s", want, have)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func fmtInstanceEndpoints(ies []InstanceEndpoint) string {
+	instances := make([]string, len(ies))
+	for i, ie := range ies {
+		instances[i] = ie.Instance
+	}
+	return fmt.Sprint(instances)
+}
@@ -0,0 +1,3 @@
+// Package probe provides health check probes for the HealthCheck option of
+// sd.NewEndpointer.
+package probe
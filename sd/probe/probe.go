@@ -0,0 +1,83 @@
+package probe
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+
+	"github.com/tnnyio/yoroi/sd"
+)
+
+// HTTP returns a probe sending a GET request for path to the instance,
+// which is healthy if the response status is 2xx. Instances that aren't
+// URLs, such as host:port, are reached over plain HTTP. If client is nil,
+// http.DefaultClient is used.
+func HTTP(client *http.Client, path string) sd.Probe {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return func(ctx context.Context, instance string) error {
+		if !strings.Contains(instance, "://") {
+			instance = "http://" + instance
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(instance, "/")+path, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16)) // reuse the connection
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return fmt.Errorf("health check: %s", resp.Status)
+		}
+		return nil
+	}
+}
+
+// TCP returns a probe opening a TCP connection to the instance, which is
+// healthy if the connection succeeds.
+func TCP() sd.Probe {
+	return func(ctx context.Context, instance string) error {
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, "tcp", instance)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+}
+
+// GRPC returns a probe calling the standard gRPC health service of the
+// instance for service, which is healthy if it reports SERVING. The empty
+// service is the health of the server as a whole. Without dial options, the
+// connection is insecure.
+func GRPC(service string, options ...grpc.DialOption) sd.Probe {
+	if len(options) == 0 {
+		options = []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}
+	}
+	return func(ctx context.Context, instance string) error {
+		cc, err := grpc.DialContext(ctx, instance, options...)
+		if err != nil {
+			return err
+		}
+		defer cc.Close()
+		resp, err := healthpb.NewHealthClient(cc).Check(ctx, &healthpb.HealthCheckRequest{Service: service})
+		if err != nil {
+			return err
+		}
+		if resp.Status != healthpb.HealthCheckResponse_SERVING {
+			return fmt.Errorf("health check: %s", resp.Status)
+		}
+		return nil
+	}
+}
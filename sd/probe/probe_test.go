@@ -0,0 +1,82 @@
+package probe_test
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+
+	"github.com/tnnyio/yoroi/sd/probe"
+)
+
+func TestHTTP(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/healthz" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	if err := probe.HTTP(nil, "/healthz")(ctx, strings.TrimPrefix(server.URL, "http://")); err != nil {
+		t.Errorf("host:port: %v", err)
+	}
+	if err := probe.HTTP(server.Client(), "/healthz")(ctx, server.URL); err != nil {
+		t.Errorf("URL: %v", err)
+	}
+	if err := probe.HTTP(nil, "/ready")(ctx, server.URL); err == nil || !strings.Contains(err.Error(), "503") {
+		t.Errorf("want 503 error, have %v", err)
+	}
+}
+
+func TestTCP(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	if err := probe.TCP()(context.Background(), addr); err != nil {
+		t.Error(err)
+	}
+	ln.Close()
+	if err := probe.TCP()(context.Background(), addr); err == nil {
+		t.Error("want error once closed")
+	}
+}
+
+func TestGRPC(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var (
+		server = grpc.NewServer()
+		hs     = health.NewServer()
+	)
+	healthpb.RegisterHealthServer(server, hs)
+	go server.Serve(ln)
+	defer server.Stop()
+
+	hs.SetServingStatus("search", healthpb.HealthCheckResponse_SERVING)
+	hs.SetServingStatus("index", healthpb.HealthCheckResponse_NOT_SERVING)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	addr := ln.Addr().String()
+	if err := probe.GRPC("search")(ctx, addr); err != nil {
+		t.Errorf("search: %v", err)
+	}
+	if err := probe.GRPC("index")(ctx, addr); err == nil || !strings.Contains(err.Error(), "NOT_SERVING") {
+		t.Errorf("index: want NOT_SERVING error, have %v", err)
+	}
+	if err := probe.GRPC("unknown")(ctx, addr); err == nil {
+		t.Error("unknown: want error")
+	}
+}
@@ -0,0 +1,9 @@
+// Package snapshot provides an Instancer keeping the last good set of
+// instances of another Instancer on disk, so that a service starting while
+// its discovery backend is down still has endpoints.
+//
+//	instancer := snapshot.NewInstancer(consulInstancer,
+//		"/var/lib/myservice/users.json", logger,
+//		snapshot.MaxAge(6*time.Hour),
+//	)
+package snapshot
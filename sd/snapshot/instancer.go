@@ -0,0 +1,261 @@
+package snapshot
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/tnnyio/log"
+	"github.com/tnnyio/yoroi/sd"
+	"github.com/tnnyio/yoroi/sd/internal/instance"
+)
+
+// DefaultMaxAge is the age past which a snapshot isn't served, unless set
+// with MaxAge.
+const DefaultMaxAge = time.Hour
+
+// Option sets an optional parameter of an Instancer.
+type Option func(*Instancer)
+
+// MaxAge sets the age past which a snapshot isn't served anymore. The age
+// is the time since the snapshot was written.
+func MaxAge(d time.Duration) Option {
+	return func(in *Instancer) { in.maxAge = d }
+}
+
+// Instancer passes through the events of another Instancer, writing every
+// good set of instances to a snapshot file. When the source fails before
+// publishing any good set, the Instancer serves the snapshot instead, marked
+// stale, until the source recovers or the snapshot gets older than the
+// maximum age. At that point, it publishes an empty set of instances, so
+// that Endpointers drop the snapshot even without InvalidateOnError, then the
+// errors of the source.
+//
+// Sets the source marks stale are passed through, but not written: they
+// aren't fresher than the snapshot.
+type Instancer struct {
+	cache  *instance.Cache
+	src    sd.Instancer
+	path   string
+	maxAge time.Duration
+	logger log.Logger
+	ch     chan sd.Event
+	wg     sync.WaitGroup
+
+	mtx    sync.Mutex
+	live   bool        // whether the source published a good set
+	loaded bool        // whether the snapshot was read
+	stale  bool        // whether the snapshot is served
+	err    error       // last error of the source
+	expiry *time.Timer // end of the snapshot, while served
+}
+
+// snapshot is the content of a snapshot file.
+type snapshot struct {
+	Time      time.Time           `json:"time"`
+	Instances []string            `json:"instances"`
+	Metadata  map[string]metadata `json:"metadata,omitempty"`
+}
+
+type metadata struct {
+	Tags []string          `json:"tags,omitempty"`
+	Zone string            `json:"zone,omitempty"`
+	Meta map[string]string `json:"meta,omitempty"`
+}
+
+// NewInstancer returns an Instancer keeping the last good set of instances
+// of src in the file at path. Stop deregisters it from src, but doesn't stop
+// src.
+func NewInstancer(src sd.Instancer, path string, logger log.Logger, options ...Option) *Instancer {
+	in := &Instancer{
+		cache:  instance.NewCache(),
+		src:    src,
+		path:   path,
+		maxAge: DefaultMaxAge,
+		logger: logger,
+		ch:     make(chan sd.Event, 1),
+	}
+	for _, option := range options {
+		option(in)
+	}
+
+	// Instancers send their current state on Register: publish it, or the
+	// snapshot, before returning.
+	src.Register(in.ch)
+	in.receive(<-in.ch)
+
+	in.wg.Add(1)
+	go in.loop()
+	return in
+}
+
+// Stop deregisters the Instancer from its source.
+func (in *Instancer) Stop() {
+	in.src.Deregister(in.ch)
+	close(in.ch)
+	in.wg.Wait()
+
+	in.mtx.Lock()
+	defer in.mtx.Unlock()
+	if in.expiry != nil {
+		in.expiry.Stop()
+	}
+	in.cache.Stop()
+}
+
+// Stale tells whether the Instancer is serving the snapshot.
+func (in *Instancer) Stale() bool {
+	in.mtx.Lock()
+	defer in.mtx.Unlock()
+	return in.stale
+}
+
+func (in *Instancer) loop() {
+	defer in.wg.Done()
+	for event := range in.ch {
+		in.receive(event)
+	}
+}
+
+// receive publishes an event of the source, or the snapshot in its place.
+func (in *Instancer) receive(event sd.Event) {
+	in.mtx.Lock()
+	defer in.mtx.Unlock()
+
+	if event.Err == nil {
+		if in.stale {
+			in.logger.Log("path", in.path, "snapshot", "replaced")
+			in.expiry.Stop()
+			in.stale = false
+		}
+		in.live = true
+		in.cache.Update(event)
+		if event.Stale {
+			return // keep the time of the snapshot
+		}
+		if err := in.write(event); err != nil {
+			in.logger.Log("path", in.path, "err", err)
+		}
+		return
+	}
+
+	in.logger.Log("err", event.Err)
+	in.err = event.Err
+	switch {
+	case in.live:
+		in.cache.Update(event) // subscribers know the last good set
+	case in.stale:
+		// keep serving the snapshot
+	case !in.loaded:
+		in.loaded = true
+		if in.serve() {
+			return
+		}
+		fallthrough
+	default:
+		in.cache.Update(event)
+	}
+}
+
+// serve publishes the snapshot, if there's a fresh one, and tells whether it
+// did. It must be called with mtx held.
+func (in *Instancer) serve() bool {
+	s, err := in.read()
+	if err != nil {
+		if !os.IsNotExist(err) {
+			in.logger.Log("path", in.path, "err", err)
+		}
+		return false
+	}
+	age := time.Since(s.Time)
+	if age >= in.maxAge {
+		in.logger.Log("path", in.path, "snapshot", "expired", "age", age)
+		return false
+	}
+
+	in.logger.Log("path", in.path, "snapshot", "served", "age", age, "instances", len(s.Instances))
+	in.stale = true
+	in.expiry = time.AfterFunc(in.maxAge-age, in.expire)
+	event := sd.Event{Instances: s.Instances, Stale: true}
+	if len(s.Metadata) > 0 {
+		event.Metadata = make(map[string]sd.Metadata, len(s.Metadata))
+		for instance, md := range s.Metadata {
+			event.Metadata[instance] = sd.Metadata{Tags: md.Tags, Zone: md.Zone, Meta: md.Meta}
+		}
+	}
+	in.cache.Update(event)
+	return true
+}
+
+// expire stops serving the snapshot, publishing an empty set of instances.
+// An error event wouldn't do: Endpointers keep their endpoints on errors by
+// default.
+func (in *Instancer) expire() {
+	in.mtx.Lock()
+	defer in.mtx.Unlock()
+	if !in.stale {
+		return
+	}
+	in.logger.Log("path", in.path, "snapshot", "expired", "err", in.err)
+	in.stale = false
+	in.cache.Update(sd.Event{Instances: []string{}})
+}
+
+func (in *Instancer) read() (snapshot, error) {
+	var s snapshot
+	data, err := os.ReadFile(in.path)
+	if err != nil {
+		return s, err
+	}
+	err = json.Unmarshal(data, &s)
+	return s, err
+}
+
+// write replaces the snapshot atomically: readers see either the previous
+// snapshot or the new one, even if the process dies while writing.
+func (in *Instancer) write(event sd.Event) error {
+	s := snapshot{Time: time.Now().UTC(), Instances: event.Instances}
+	if s.Instances == nil {
+		s.Instances = []string{}
+	}
+	if len(event.Metadata) > 0 {
+		s.Metadata = make(map[string]metadata, len(event.Metadata))
+		for instance, md := range event.Metadata {
+			s.Metadata[instance] = metadata{Tags: md.Tags, Zone: md.Zone, Meta: md.Meta}
+		}
+	}
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(in.path), filepath.Base(in.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // fails once renamed
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), in.path)
+}
+
+// Register implements Instancer.
+func (in *Instancer) Register(ch chan<- sd.Event) {
+	in.cache.Register(ch)
+}
+
+// Deregister implements Instancer.
+func (in *Instancer) Deregister(ch chan<- sd.Event) {
+	in.cache.Deregister(ch)
+}
@@ -0,0 +1,224 @@
+package snapshot_test
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tnnyio/log"
+	"github.com/tnnyio/yoroi/endpoint"
+	"github.com/tnnyio/yoroi/sd"
+	"github.com/tnnyio/yoroi/sd/internal/instance"
+	"github.com/tnnyio/yoroi/sd/snapshot"
+)
+
+var _ sd.Instancer = (*snapshot.Instancer)(nil) // API check
+
+var errDown = errors.New("backend down")
+
+func source(event sd.Event) *instance.Cache {
+	src := instance.NewCache()
+	src.Update(event)
+	return src
+}
+
+func TestSnapshotWritten(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+	src := source(sd.Event{
+		Instances: []string{"10.0.0.1:80", "10.0.0.2:80"},
+		Metadata:  map[string]sd.Metadata{"10.0.0.1:80": {Tags: []string{"api"}, Zone: "a"}},
+	})
+	in := snapshot.NewInstancer(src, path, log.NewNopLogger())
+	if event := current(in); event.Stale || event.Err != nil {
+		t.Errorf("want a live event, have %+v", event)
+	}
+	in.Stop()
+
+	// A new Instancer starting while the backend is down serves it.
+	in = snapshot.NewInstancer(source(sd.Event{Err: errDown}), path, log.NewNopLogger())
+	defer in.Stop()
+	event := current(in)
+	if want, have := "[10.0.0.1:80 10.0.0.2:80]", fmt.Sprint(event.Instances); want != have {
+		t.Errorf("want %s, have %s", want, have)
+	}
+	if want, have := "{[api] a map[]}", fmt.Sprint(event.Metadata["10.0.0.1:80"]); want != have {
+		t.Errorf("want %s, have %s", want, have)
+	}
+	if !event.Stale || event.Err != nil || !in.Stale() {
+		t.Errorf("want a stale event without error, have %+v", event)
+	}
+}
+
+func TestSnapshotUpdated(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+	src := source(sd.Event{Instances: []string{"10.0.0.1:80"}})
+	in := snapshot.NewInstancer(src, path, log.NewNopLogger())
+	src.Update(sd.Event{Instances: []string{"10.0.0.2:80"}})
+	waitFor(t, in, func(event sd.Event) bool { return len(event.Instances) == 1 && event.Instances[0] == "10.0.0.2:80" })
+	src.Update(sd.Event{Err: errDown}) // not written
+	waitFor(t, in, func(event sd.Event) bool { return event.Err != nil })
+	in.Stop()
+
+	in = snapshot.NewInstancer(source(sd.Event{Err: errDown}), path, log.NewNopLogger())
+	defer in.Stop()
+	if want, have := "[10.0.0.2:80]", fmt.Sprint(current(in).Instances); want != have {
+		t.Errorf("want %s, have %s", want, have)
+	}
+
+	// No temporary file is left behind.
+	files, _ := filepath.Glob(filepath.Join(filepath.Dir(path), "*"))
+	if len(files) != 1 {
+		t.Errorf("want 1 file, have %v", files)
+	}
+}
+
+func TestLiveReplacesSnapshot(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+	writeSnapshot(t, path, time.Now(), "10.0.0.1:80")
+	src := source(sd.Event{Err: errDown})
+	in := snapshot.NewInstancer(src, path, log.NewNopLogger())
+	defer in.Stop()
+
+	// Errors keep the snapshot served.
+	src.Update(sd.Event{Err: errors.New("still down")})
+	if event := current(in); !event.Stale || event.Err != nil {
+		t.Errorf("want the snapshot, have %+v", event)
+	}
+
+	src.Update(sd.Event{Instances: []string{"10.0.0.3:80"}})
+	event := waitFor(t, in, func(event sd.Event) bool { return !event.Stale })
+	if want, have := "[10.0.0.3:80]", fmt.Sprint(event.Instances); want != have {
+		t.Errorf("want %s, have %s", want, have)
+	}
+	if in.Stale() {
+		t.Error("want live instances")
+	}
+
+	// Once live, errors pass through.
+	src.Update(sd.Event{Err: errDown})
+	waitFor(t, in, func(event sd.Event) bool { return errors.Is(event.Err, errDown) })
+}
+
+func TestSnapshotTooOld(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+	writeSnapshot(t, path, time.Now().Add(-2*time.Hour), "10.0.0.1:80")
+	in := snapshot.NewInstancer(source(sd.Event{Err: errDown}), path, log.NewNopLogger())
+	defer in.Stop()
+	if event := current(in); !errors.Is(event.Err, errDown) || event.Stale {
+		t.Errorf("want %v, have %+v", errDown, event)
+	}
+}
+
+func TestSnapshotExpires(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+	writeSnapshot(t, path, time.Now(), "10.0.0.1:80")
+	src := source(sd.Event{Err: errDown})
+	in := snapshot.NewInstancer(src, path, log.NewNopLogger(), snapshot.MaxAge(50*time.Millisecond))
+	defer in.Stop()
+	if event := current(in); !event.Stale {
+		t.Errorf("want the snapshot, have %+v", event)
+	}
+	event := waitFor(t, in, func(event sd.Event) bool { return !event.Stale })
+	if event.Err != nil || event.Instances == nil || len(event.Instances) != 0 {
+		t.Errorf("want no instances, have %+v", event)
+	}
+
+	// Then the errors of the source pass through.
+	src.Update(sd.Event{Err: errors.New("still down")})
+	waitFor(t, in, func(event sd.Event) bool { return event.Err != nil })
+}
+
+func TestSnapshotExpiresEndpointer(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+	writeSnapshot(t, path, time.Now(), "10.0.0.1:80")
+	in := snapshot.NewInstancer(source(sd.Event{Err: errDown}), path, log.NewNopLogger(), snapshot.MaxAge(50*time.Millisecond))
+	defer in.Stop()
+	factory := func(string) (endpoint.Endpoint[any], io.Closer, error) { return endpoint.Nop, nil, nil }
+	endpointer := sd.NewEndpointer(in, factory, log.NewNopLogger())
+	defer endpointer.Close()
+
+	// With the default options, the endpoints of the snapshot are dropped
+	// once it expires.
+	waitEndpoints(t, endpointer, 1)
+	waitEndpoints(t, endpointer, 0)
+}
+
+func TestStaleNotWritten(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+	at := time.Now().Add(-time.Minute).UTC()
+	writeSnapshot(t, path, at, "10.0.0.1:80")
+	src := source(sd.Event{Instances: []string{"10.0.0.2:80"}, Stale: true})
+	in := snapshot.NewInstancer(src, path, log.NewNopLogger())
+	defer in.Stop()
+	if event := current(in); !event.Stale || fmt.Sprint(event.Instances) != "[10.0.0.2:80]" {
+		t.Errorf("want the stale set of the source, have %+v", event)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := at.Format(time.RFC3339Nano); !strings.Contains(string(data), want) {
+		t.Errorf("want the snapshot of %s, have %s", want, data)
+	}
+}
+
+func TestNoSnapshot(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+	in := snapshot.NewInstancer(source(sd.Event{Err: errDown}), path, log.NewNopLogger())
+	defer in.Stop()
+	if event := current(in); !errors.Is(event.Err, errDown) {
+		t.Errorf("want %v, have %+v", errDown, event)
+	}
+}
+
+func writeSnapshot(t *testing.T, path string, at time.Time, instance string) {
+	t.Helper()
+	data := fmt.Sprintf(`{"time": %q, "instances": [%q]}`, at.UTC().Format(time.RFC3339Nano), instance)
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// waitEndpoints waits until the Endpointer has n endpoints.
+func waitEndpoints(t *testing.T, endpointer *sd.DefaultEndpointer, n int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for {
+		if endpoints, _ := endpointer.Endpoints(); len(endpoints) == n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("want %d endpoints", n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// current returns the event the Instancer publishes.
+func current(in sd.Instancer) sd.Event {
+	ch := make(chan sd.Event, 1)
+	in.Register(ch)
+	defer in.Deregister(ch)
+	return <-ch
+}
+
+// waitFor returns the first event the Instancer publishes matching ok.
+func waitFor(t *testing.T, in sd.Instancer, ok func(sd.Event) bool) sd.Event {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for {
+		if event := current(in); ok(event) {
+			return event
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("timeout waiting for event")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
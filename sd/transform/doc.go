@@ -0,0 +1,17 @@
+// Package transform provides an Instancer applying filter and map functions
+// to the instances of another Instancer, before they reach an sd.Factory.
+//
+// Filtering on the metadata of the instances, such as tags and zones, works
+// the same for every backend that publishes it:
+//
+//	instancer := transform.NewInstancer(consulInstancer,
+//		transform.Tags("api"),
+//		transform.Zone("eu-west-1a"),
+//		transform.Port("9090"),
+//	)
+//
+// The consul, eureka, file and kubernetes Instancers publish metadata, as
+// does a snapshot Instancer wrapping one of them. The dnssrv, etcd, etcdv3
+// and zk Instancers don't: Tags and Zone drop all of their instances, and
+// only Filter, Map and Port apply to them.
+package transform
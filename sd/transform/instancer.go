@@ -0,0 +1,147 @@
+package transform
+
+import (
+	"net"
+	"sort"
+	"sync"
+
+	"github.com/tnnyio/yoroi/sd"
+	"github.com/tnnyio/yoroi/sd/internal/instance"
+)
+
+// Func transforms an instance and its metadata. It returns the new instance
+// and metadata, and false to drop the instance.
+type Func func(instance string, md sd.Metadata) (string, sd.Metadata, bool)
+
+// Filter returns a Func keeping the instances for which keep returns true.
+func Filter(keep func(instance string, md sd.Metadata) bool) Func {
+	return func(instance string, md sd.Metadata) (string, sd.Metadata, bool) {
+		return instance, md, keep(instance, md)
+	}
+}
+
+// Map returns a Func rewriting the instances with f.
+func Map(f func(instance string) string) Func {
+	return func(instance string, md sd.Metadata) (string, sd.Metadata, bool) {
+		return f(instance), md, true
+	}
+}
+
+// Tags returns a Func keeping the instances having all of the tags. It
+// drops the instances without metadata: see the package documentation for
+// the backends publishing it.
+func Tags(tags ...string) Func {
+	return Filter(func(_ string, md sd.Metadata) bool {
+		return md.HasTags(tags...)
+	})
+}
+
+// Zone returns a Func keeping the instances in one of the zones. It drops
+// the instances without metadata, like Tags.
+func Zone(zones ...string) Func {
+	return Filter(func(_ string, md sd.Metadata) bool {
+		for _, zone := range zones {
+			if md.Zone == zone {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// Port returns a Func replacing the port of host:port instances. Other
+// instances are dropped.
+func Port(port string) Func {
+	return func(instance string, md sd.Metadata) (string, sd.Metadata, bool) {
+		host, _, err := net.SplitHostPort(instance)
+		if err != nil {
+			return instance, md, false
+		}
+		return net.JoinHostPort(host, port), md, true
+	}
+}
+
+// Instancer applies functions to the instances of another Instancer. The
+// functions run in order on every instance of every event: an instance
+// dropped by one doesn't reach the next. Instances rewritten to the same
+// string are merged, keeping the metadata of the first one.
+type Instancer struct {
+	cache *instance.Cache
+	src   sd.Instancer
+	fns   []Func
+	ch    chan sd.Event
+	wg    sync.WaitGroup
+}
+
+// NewInstancer returns an Instancer applying the functions to the
+// instances of src. Stop deregisters it from src, but doesn't stop src.
+func NewInstancer(src sd.Instancer, fns ...Func) *Instancer {
+	in := &Instancer{
+		cache: instance.NewCache(),
+		src:   src,
+		fns:   fns,
+		ch:    make(chan sd.Event, 1),
+	}
+
+	// Instancers send their current state on Register: publish it before
+	// returning.
+	src.Register(in.ch)
+	in.cache.Update(in.apply(<-in.ch))
+
+	in.wg.Add(1)
+	go in.loop()
+	return in
+}
+
+// Stop deregisters the Instancer from its source.
+func (in *Instancer) Stop() {
+	in.src.Deregister(in.ch)
+	close(in.ch)
+	in.wg.Wait()
+	in.cache.Stop()
+}
+
+func (in *Instancer) loop() {
+	defer in.wg.Done()
+	for event := range in.ch {
+		in.cache.Update(in.apply(event))
+	}
+}
+
+// apply returns the event with the transformed instances.
+func (in *Instancer) apply(event sd.Event) sd.Event {
+	if event.Instances == nil {
+		return event
+	}
+	var (
+		instances = make([]string, 0, len(event.Instances))
+		metadata  = make(map[string]sd.Metadata, len(event.Instances))
+	)
+INSTANCES:
+	for _, instance := range event.Instances {
+		md := event.Metadata[instance]
+		for _, f := range in.fns {
+			var keep bool
+			if instance, md, keep = f(instance, md); !keep {
+				continue INSTANCES
+			}
+		}
+		if _, ok := metadata[instance]; ok {
+			continue
+		}
+		instances = append(instances, instance)
+		metadata[instance] = md
+	}
+	sort.Strings(instances)
+	return sd.Event{Instances: instances, Metadata: metadata, Err: event.Err, Stale: event.Stale}
+}
+
+// Register implements Instancer.
+func (in *Instancer) Register(ch chan<- sd.Event) {
+	in.cache.Register(ch)
+}
+
+// Deregister implements Instancer.
+func (in *Instancer) Deregister(ch chan<- sd.Event) {
+	in.cache.Deregister(ch)
+}
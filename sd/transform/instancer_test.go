@@ -0,0 +1,118 @@
+package transform_test
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tnnyio/yoroi/sd"
+	"github.com/tnnyio/yoroi/sd/internal/instance"
+	"github.com/tnnyio/yoroi/sd/transform"
+)
+
+var _ sd.Instancer = (*transform.Instancer)(nil) // API check
+
+var metadata = map[string]sd.Metadata{
+	"10.0.0.1:8080": {Tags: []string{"api", "v1"}, Zone: "a"},
+	"10.0.0.2:8080": {Tags: []string{"api", "v2"}, Zone: "b"},
+	"10.0.0.3:8080": {Tags: []string{"db"}, Zone: "a"},
+}
+
+func newSource() *instance.Cache {
+	src := instance.NewCache()
+	src.Update(sd.Event{Instances: []string{"10.0.0.1:8080", "10.0.0.2:8080", "10.0.0.3:8080", "10.0.0.4:8080"}, Metadata: metadata})
+	return src
+}
+
+func TestFuncs(t *testing.T) {
+	for name, tc := range map[string]struct {
+		fns  []transform.Func
+		want string
+	}{
+		"none":        {nil, "[10.0.0.1:8080 10.0.0.2:8080 10.0.0.3:8080 10.0.0.4:8080]"},
+		"tags":        {[]transform.Func{transform.Tags("api")}, "[10.0.0.1:8080 10.0.0.2:8080]"},
+		"all tags":    {[]transform.Func{transform.Tags("api", "v2")}, "[10.0.0.2:8080]"},
+		"zone":        {[]transform.Func{transform.Zone("a")}, "[10.0.0.1:8080 10.0.0.3:8080]"},
+		"zones":       {[]transform.Func{transform.Zone("a", "b")}, "[10.0.0.1:8080 10.0.0.2:8080 10.0.0.3:8080]"},
+		"tags, zone":  {[]transform.Func{transform.Tags("api"), transform.Zone("a")}, "[10.0.0.1:8080]"},
+		"port":        {[]transform.Func{transform.Zone("a"), transform.Port("9090")}, "[10.0.0.1:9090 10.0.0.3:9090]"},
+		"port merged": {[]transform.Func{transform.Map(func(string) string { return "10.0.0.9:8080" }), transform.Port("9090")}, "[10.0.0.9:9090]"},
+		"filter": {[]transform.Func{transform.Filter(func(instance string, _ sd.Metadata) bool {
+			return !strings.HasPrefix(instance, "10.0.0.1:")
+		})}, "[10.0.0.2:8080 10.0.0.3:8080 10.0.0.4:8080]"},
+	} {
+		in := transform.NewInstancer(newSource(), tc.fns...)
+		if have := current(in).Instances; fmt.Sprint(have) != tc.want {
+			t.Errorf("%s: want %s, have %v", name, tc.want, have)
+		}
+		in.Stop()
+	}
+}
+
+func TestMetadata(t *testing.T) {
+	in := transform.NewInstancer(newSource(), transform.Zone("b"), transform.Port("9090"))
+	defer in.Stop()
+
+	// The metadata follows the rewritten instances, and can be filtered on
+	// by a wrapping Instancer.
+	event := current(in)
+	if want, have := "{[api v2] b map[]}", fmt.Sprint(event.Metadata["10.0.0.2:9090"]); want != have {
+		t.Errorf("want %s, have %s", want, have)
+	}
+	wrapped := transform.NewInstancer(in, transform.Tags("v1"))
+	defer wrapped.Stop()
+	if have := current(wrapped).Instances; len(have) != 0 {
+		t.Errorf("want no instances, have %v", have)
+	}
+}
+
+func TestUpdates(t *testing.T) {
+	src := newSource()
+	in := transform.NewInstancer(src, transform.Tags("api"))
+	defer in.Stop()
+	ch := make(chan sd.Event, 1)
+	in.Register(ch)
+	defer in.Deregister(ch)
+	<-ch
+
+	src.Update(sd.Event{Instances: []string{"10.0.0.2:8080", "10.0.0.3:8080"}, Metadata: metadata})
+	select {
+	case event := <-ch:
+		if want, have := "[10.0.0.2:8080]", fmt.Sprint(event.Instances); want != have {
+			t.Errorf("want %s, have %s", want, have)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no update")
+	}
+
+	src.Update(sd.Event{Instances: []string{"10.0.0.1:8080"}, Metadata: metadata, Stale: true})
+	select {
+	case event := <-ch:
+		if !event.Stale {
+			t.Error("want stale instances")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no update")
+	}
+
+	errDown := errors.New("down")
+	src.Update(sd.Event{Err: errDown})
+	select {
+	case event := <-ch:
+		if !errors.Is(event.Err, errDown) {
+			t.Errorf("want %v, have %v", errDown, event.Err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no update")
+	}
+}
+
+// current returns the event the Instancer publishes.
+func current(in sd.Instancer) sd.Event {
+	ch := make(chan sd.Event, 1)
+	in.Register(ch)
+	defer in.Deregister(ch)
+	return <-ch
+}
@@ -77,6 +77,7 @@ func (s *Instancer) loop(eventc <-chan zk.Event) {
 // Stop terminates the Instancer.
 func (s *Instancer) Stop() {
 	close(s.quitc)
+	s.cache.Stop()
 }
 
 // Register implements Instancer.
@@ -17,6 +17,8 @@ type client[I, O interface{}] struct {
 	do             FastClient
 	req            CreateRequestFunc[I]
 	dec            DecodeResponseFunc[O]
+	before         []ClientRequestFunc
+	finalizer      []ClientFinalizerFunc
 	bufferedStream bool
 }
 
@@ -51,11 +53,33 @@ func NewClient[I, O interface{}](method string, url URI, enc EncodeRequestFunc[I
 }
 
 func (c *client[I, O]) Call(ctx context.Context, i I) (o O, err error) {
-	req, err := c.req(fasthttp.AcquireRequest(), i)
+	var (
+		req  = fasthttp.AcquireRequest()
+		resp *fasthttp.Response
+	)
+	if c.finalizer != nil {
+		defer func() {
+			ctx = context.WithValue(ctx, ContextKeyRequestMethod, string(req.Header.Method()))
+			ctx = context.WithValue(ctx, ContextKeyRequestPath, string(req.URI().Path()))
+			if resp != nil {
+				ctx = context.WithValue(ctx, ContextKeyResponseHeaders, &resp.Header)
+				ctx = context.WithValue(ctx, ContextKeyResponseSize, int64(len(resp.Body())))
+			}
+			for _, f := range c.finalizer {
+				f(ctx, err)
+			}
+		}()
+	}
+
+	req, err = c.req(req, i)
 	if err != nil {
 		return
 	}
 
+	for _, f := range c.before {
+		ctx = f(ctx, req)
+	}
+
 	if c.bufferedStream {
 		var i interface{} = i
 		stream, ok := i.(io.Reader)
@@ -65,19 +89,49 @@ func (c *client[I, O]) Call(ctx context.Context, i I) (o O, err error) {
 		req.SetBodyStream(stream, -1)
 	}
 
-	resp := fasthttp.AcquireResponse()
-	if err = c.do(req, resp); err != nil {
+	r := fasthttp.AcquireResponse()
+	if err = c.do(req, r); err != nil {
 		return
 	}
+	resp = r
 	return c.dec(resp)
 }
 
 // ClientOption sets an optional parameter for clients.
 type ClientOption[I, O interface{}] func(*client[I, O])
 
+// SetClient sets the function used to send requests. By default, fasthttp.Do
+// is used.
+func SetClient[I, O interface{}](do FastClient) ClientOption[I, O] {
+	return func(c *client[I, O]) { c.do = do }
+}
+
+// ClientBefore adds one or more ClientRequestFuncs to be applied to the outgoing
+// request before it's sent.
+func ClientBefore[I, O interface{}](before ...ClientRequestFunc) ClientOption[I, O] {
+	return func(c *client[I, O]) { c.before = append(c.before, before...) }
+}
+
+// ClientFinalizer adds one or more ClientFinalizerFuncs to be executed at the
+// end of every request. Finalizers are executed in the order in which they
+// were added. By default, no finalizer is registered.
+func ClientFinalizer[I, O interface{}](f ...ClientFinalizerFunc) ClientOption[I, O] {
+	return func(c *client[I, O]) { c.finalizer = append(c.finalizer, f...) }
+}
+
 // BufferedStream sets whether the HTTP response body is left open, allowing it
 // to be read from later. Useful for transporting a file as a buffered stream.
 // That body has to be drained and closed to properly end the request.
 func BufferedStream[I, O interface{}](buffered bool) ClientOption[I, O] {
 	return func(c *client[I, O]) { c.bufferedStream = buffered }
 }
+
+// ClientFinalizerFunc can be used to perform work at the end of a client HTTP
+// request, after the response is returned. The principal intended use is for
+// error logging. The request method and path, and the response headers and
+// size, are provided in the context under the ContextKeyRequestMethod,
+// ContextKeyRequestPath, ContextKeyResponseHeaders and ContextKeyResponseSize
+// keys. The response headers are of type *fasthttp.ResponseHeader, which also
+// carries the status code. Note: err may be nil, and there may be no response
+// values depending on when an error occurs.
+type ClientFinalizerFunc func(ctx context.Context, err error)
@@ -59,3 +59,70 @@ func TestFastHttpClient(t *testing.T) {
 	}
 
 }
+
+func TestFastHttpClientFinalizer(t *testing.T) {
+	type ctxKey struct{}
+
+	handler := fastTransport.NewServer(
+		func(context.Context, interface{}) (interface{}, error) { return nil, nil },
+		func(*fh.RequestCtx) (interface{}, error) { return nil, nil },
+		func(ctx *fh.RequestCtx, _ interface{}) error {
+			ctx.SetStatusCode(fh.StatusAccepted)
+			ctx.SetBodyString("hello")
+			return nil
+		},
+	)
+	server := fasthttptest.FastServer(t, handler)
+	defer server.Close()
+
+	var (
+		beforeCalled bool
+		finalizerCtx context.Context
+		finalizerErr error
+	)
+	client := fastTransport.NewClient[Req, Res](
+		"POST",
+		fastTransport.URI{Host: server.URL, Path: "/greet"},
+		func(*fasthttp.Request, Req) error { return nil },
+		func(*fasthttp.Response) (Res, error) { return nil, nil },
+		fastTransport.ClientBefore[Req, Res](func(ctx context.Context, _ *fh.Request) context.Context {
+			beforeCalled = true
+			return context.WithValue(ctx, ctxKey{}, "before")
+		}),
+		fastTransport.ClientFinalizer[Req, Res](func(ctx context.Context, err error) {
+			finalizerCtx, finalizerErr = ctx, err
+		}),
+	)
+	if _, err := client.Call(context.Background(), struct{}{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if !beforeCalled {
+		t.Error("ClientBefore not called")
+	}
+	if finalizerCtx == nil {
+		t.Fatal("ClientFinalizer not called")
+	}
+	if finalizerErr != nil {
+		t.Errorf("unexpected error: %v", finalizerErr)
+	}
+	if want, have := "before", finalizerCtx.Value(ctxKey{}); want != have {
+		t.Errorf("context value: want %v, have %v", want, have)
+	}
+	if want, have := "POST", finalizerCtx.Value(fastTransport.ContextKeyRequestMethod); want != have {
+		t.Errorf("method: want %v, have %v", want, have)
+	}
+	if want, have := "/greet", finalizerCtx.Value(fastTransport.ContextKeyRequestPath); want != have {
+		t.Errorf("path: want %v, have %v", want, have)
+	}
+	header, ok := finalizerCtx.Value(fastTransport.ContextKeyResponseHeaders).(*fh.ResponseHeader)
+	if !ok {
+		t.Fatalf("want *fasthttp.ResponseHeader, have %T", finalizerCtx.Value(fastTransport.ContextKeyResponseHeaders))
+	}
+	if want, have := fh.StatusAccepted, header.StatusCode(); want != have {
+		t.Errorf("status: want %d, have %d", want, have)
+	}
+	if want, have := int64(len("hello")), finalizerCtx.Value(fastTransport.ContextKeyResponseSize); want != have {
+		t.Errorf("size: want %v, have %v", want, have)
+	}
+}
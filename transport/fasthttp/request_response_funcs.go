@@ -1,6 +1,7 @@
 package fasthttp
 
 import (
+	"context"
 	"net/http"
 
 	fh "github.com/valyala/fasthttp"
@@ -17,6 +18,11 @@ type RequestFunc func(*fh.RequestCtx)
 // servers, after invoking the endpoint but prior to writing a response.
 type ServerResponseFunc func(*fh.RequestCtx)
 
+// ClientRequestFunc may take information from a request context and use it to
+// manipulate an outgoing request. ClientRequestFuncs are only executed in
+// clients, after creating the request but prior to sending it.
+type ClientRequestFunc func(context.Context, *fh.Request) context.Context
+
 // ClientResponseFunc may take information from an HTTP request and make the
 // response available for consumption. ClientResponseFuncs are only executed in
 // clients, after a request has been made, but prior to it being decoded.
@@ -6,6 +6,7 @@ import (
 
 	"github.com/tnnyio/log"
 	"github.com/tnnyio/yoroi/endpoint"
+	"github.com/tnnyio/yoroi/recovery"
 	"github.com/tnnyio/yoroi/transport"
 	fh "github.com/valyala/fasthttp"
 )
@@ -18,8 +19,10 @@ type server[I, O interface{}] struct {
 	before       []RequestFunc
 	after        []ServerResponseFunc
 	errorEncoder ErrorEncoder
+	errorWraps   []ErrorEncoderMiddleware
 	finalizer    []ServerFinalizerFunc
 	errorHandler transport.ErrorHandler
+	recover      bool
 }
 
 // NewServer constructs a new server, which implements http.Handler and wraps
@@ -40,6 +43,9 @@ func NewServer[I, O interface{}](
 	for _, option := range options {
 		option(s)
 	}
+	for i := len(s.errorWraps) - 1; i >= 0; i-- {
+		s.errorEncoder = s.errorWraps[i](s.errorEncoder)
+	}
 	return func(ctx *fh.RequestCtx) {
 		if len(s.finalizer) > 0 {
 			defer func() {
@@ -51,6 +57,16 @@ func NewServer[I, O interface{}](
 			}()
 		}
 
+		if s.recover {
+			defer func() {
+				if v := recover(); v != nil {
+					err := recovery.NewPanicError(v)
+					s.errorHandler.Handle(ctx, err)
+					s.errorEncoder(ctx, err)
+				}
+			}()
+		}
+
 		for _, before := range s.before {
 			before(ctx)
 		}
@@ -104,6 +120,17 @@ func ServerErrorEncoder[I, O interface{}](ee ErrorEncoder) ServerOption[I, O] {
 	return func(s *server[I, O]) { s.errorEncoder = ee }
 }
 
+// ErrorEncoderMiddleware wraps an ErrorEncoder, for instance to observe the
+// errors it encodes.
+type ErrorEncoderMiddleware func(ErrorEncoder) ErrorEncoder
+
+// ServerErrorEncoderMiddleware adds middlewares wrapping the ErrorEncoder,
+// whichever option sets it, so that several options can observe the encoded
+// errors. The first middleware added is the outermost.
+func ServerErrorEncoderMiddleware[I, O interface{}](mw ...ErrorEncoderMiddleware) ServerOption[I, O] {
+	return func(s *server[I, O]) { s.errorWraps = append(s.errorWraps, mw...) }
+}
+
 // ServerErrorLogger is used to log non-terminal errors. By default, no errors
 // are logged. This is intended as a diagnostic measure. Finer-grained control
 // of error handling, including logging in more detail, should be performed in a
@@ -129,6 +156,14 @@ func ServerFinalizer[I, O interface{}](f ...ServerFinalizerFunc) ServerOption[I,
 	return func(s *server[I, O]) { s.finalizer = append(s.finalizer, f...) }
 }
 
+// ServerRecover makes the server recover panics in any phase of the request,
+// from the ServerBefore functions to the encoder. A recovered panic is turned
+// into a *recovery.PanicError, handled by the ErrorHandler and encoded by the
+// ErrorEncoder. By default, panics are not recovered.
+func ServerRecover[I, O interface{}]() ServerOption[I, O] {
+	return func(s *server[I, O]) { s.recover = true }
+}
+
 // ErrorEncoder is responsible for encoding an error to the ResponseWriter.
 // Users are encouraged to use custom ErrorEncoders to encode HTTP errors to
 // their clients, and will likely want to pass and check for their own error
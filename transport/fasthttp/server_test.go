@@ -10,6 +10,8 @@ import (
 	"time"
 
 	"github.com/tnnyio/yoroi/endpoint"
+	"github.com/tnnyio/yoroi/recovery"
+	"github.com/tnnyio/yoroi/transport"
 	fastTransport "github.com/tnnyio/yoroi/transport/fasthttp"
 	"github.com/tnnyio/yoroi/transport/fasthttp/fasthttptest"
 	fh "github.com/valyala/fasthttp"
@@ -86,6 +88,93 @@ func TestServerErrorEncoder(t *testing.T) {
 	}
 }
 
+func TestServerErrorEncoderMiddleware(t *testing.T) {
+	errTeapot := errors.New("teapot")
+	var observed []string
+	observe := func(name string) fastTransport.ErrorEncoderMiddleware {
+		return func(next fastTransport.ErrorEncoder) fastTransport.ErrorEncoder {
+			return func(ctx *fh.RequestCtx, err error) {
+				observed = append(observed, name+": "+err.Error())
+				next(ctx, err)
+			}
+		}
+	}
+	// The middlewares wrap the encoder set after them too.
+	handler := fastTransport.NewServer(
+		func(context.Context, interface{}) (interface{}, error) { return struct{}{}, nil },
+		func(*fh.RequestCtx) (interface{}, error) { return struct{}{}, errTeapot },
+		func(*fh.RequestCtx, interface{}) error { return nil },
+		fastTransport.ServerErrorEncoderMiddleware[interface{}, interface{}](observe("a")),
+		fastTransport.ServerErrorEncoderMiddleware[interface{}, interface{}](observe("b")),
+		fastTransport.ServerErrorEncoder[interface{}, interface{}](func(ctx *fh.RequestCtx, err error) { ctx.SetStatusCode(http.StatusTeapot) }),
+	)
+	server := fasthttptest.FastServer(t, handler)
+	defer server.Close()
+
+	resp, _ := http.Get(server.URL)
+	if want, have := http.StatusTeapot, resp.StatusCode; want != have {
+		t.Errorf("want %d, have %d", want, have)
+	}
+	if want, have := "a: teapot, b: teapot", strings.Join(observed, ", "); want != have {
+		t.Errorf("want %s, have %s", want, have)
+	}
+}
+
+func TestServerRecover(t *testing.T) {
+	for _, testcase := range []struct {
+		name string
+		dec  fastTransport.DecodeRequestFunc[interface{}]
+		e    endpoint.Endpoint[interface{}]
+		enc  fastTransport.EncodeResponseFunc[interface{}]
+	}{
+		{
+			name: "decode",
+			dec:  func(*fh.RequestCtx) (interface{}, error) { panic("decode") },
+			e:    endpoint.Nop,
+			enc:  func(*fh.RequestCtx, interface{}) error { return nil },
+		},
+		{
+			name: "endpoint",
+			dec:  func(*fh.RequestCtx) (interface{}, error) { return struct{}{}, nil },
+			e:    func(context.Context, interface{}) (interface{}, error) { panic("endpoint") },
+			enc:  func(*fh.RequestCtx, interface{}) error { return nil },
+		},
+		{
+			name: "encode",
+			dec:  func(*fh.RequestCtx) (interface{}, error) { return struct{}{}, nil },
+			e:    endpoint.Nop,
+			enc:  func(*fh.RequestCtx, interface{}) error { panic("encode") },
+		},
+	} {
+		t.Run(testcase.name, func(t *testing.T) {
+			handled := make(chan error, 1)
+			handler := fastTransport.NewServer(
+				testcase.e, testcase.dec, testcase.enc,
+				fastTransport.ServerRecover[interface{}, interface{}](),
+				fastTransport.ServerErrorHandler[interface{}, interface{}](transport.ErrorHandlerFunc(func(_ context.Context, err error) {
+					handled <- err
+				})),
+			)
+			server := fasthttptest.FastServer(t, handler)
+			defer server.Close()
+			resp, err := http.Get(server.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want, have := http.StatusInternalServerError, resp.StatusCode; want != have {
+				t.Errorf("want %d, have %d", want, have)
+			}
+			var perr *recovery.PanicError
+			if err := <-handled; !errors.As(err, &perr) {
+				t.Fatalf("want *recovery.PanicError handled, have %v", err)
+			}
+			if want, have := testcase.name, perr.Value; want != have {
+				t.Errorf("want %v, have %v", want, have)
+			}
+		})
+	}
+}
+
 func TestServerHappyPath(t *testing.T) {
 	step, response := testServer(t)
 	step()
@@ -8,6 +8,7 @@ import (
 
 	"github.com/tnnyio/log"
 	"github.com/tnnyio/yoroi/endpoint"
+	"github.com/tnnyio/yoroi/recovery"
 	"github.com/tnnyio/yoroi/transport"
 )
 
@@ -27,6 +28,7 @@ type Server[I, O interface{}] struct {
 	after        []ServerResponseFunc
 	finalizer    []ServerFinalizerFunc
 	errorHandler transport.ErrorHandler
+	recover      bool
 }
 
 // NewServer constructs a new server, which implements wraps the provided
@@ -86,6 +88,14 @@ func ServerFinalizer[I, O interface{}](f ...ServerFinalizerFunc) ServerOption[I,
 	return func(s *Server[I, O]) { s.finalizer = append(s.finalizer, f...) }
 }
 
+// ServerRecover makes the server recover panics in any phase of the request,
+// from the ServerBefore functions to the encoder. A recovered panic is turned
+// into a *recovery.PanicError, handled by the ErrorHandler and returned to
+// the client with codes.Internal. By default, panics are not recovered.
+func ServerRecover[I, O interface{}]() ServerOption[I, O] {
+	return func(s *Server[I, O]) { s.recover = true }
+}
+
 // ServeGRPC implements the Handler interface.
 func (s Server[I, O]) ServeGRPC(ctx context.Context, req interface{}) (retctx context.Context, resp interface{}, err error) {
 	// Retrieve gRPC metadata.
@@ -102,6 +112,16 @@ func (s Server[I, O]) ServeGRPC(ctx context.Context, req interface{}) (retctx co
 		}()
 	}
 
+	if s.recover {
+		defer func() {
+			if v := recover(); v != nil {
+				perr := recovery.NewPanicError(v)
+				s.errorHandler.Handle(ctx, perr)
+				retctx, resp, err = ctx, nil, perr
+			}
+		}()
+	}
+
 	for _, f := range s.before {
 		ctx = f(ctx, md)
 	}
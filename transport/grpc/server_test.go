@@ -0,0 +1,75 @@
+package grpc_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/tnnyio/yoroi/endpoint"
+	"github.com/tnnyio/yoroi/recovery"
+	"github.com/tnnyio/yoroi/transport"
+	grpctransport "github.com/tnnyio/yoroi/transport/grpc"
+)
+
+func TestServerRecover(t *testing.T) {
+	for _, testcase := range []struct {
+		name string
+		dec  grpctransport.DecodeRequestFunc[interface{}]
+		e    endpoint.Endpoint[interface{}]
+		enc  grpctransport.EncodeResponseFunc[interface{}]
+	}{
+		{
+			name: "decode",
+			dec:  func(context.Context, interface{}) (interface{}, error) { panic("decode") },
+			e:    endpoint.Nop,
+			enc:  func(context.Context, interface{}) (interface{}, error) { return struct{}{}, nil },
+		},
+		{
+			name: "endpoint",
+			dec:  func(context.Context, interface{}) (interface{}, error) { return struct{}{}, nil },
+			e:    func(context.Context, interface{}) (interface{}, error) { panic("endpoint") },
+			enc:  func(context.Context, interface{}) (interface{}, error) { return struct{}{}, nil },
+		},
+		{
+			name: "encode",
+			dec:  func(context.Context, interface{}) (interface{}, error) { return struct{}{}, nil },
+			e:    endpoint.Nop,
+			enc:  func(context.Context, interface{}) (interface{}, error) { panic("encode") },
+		},
+	} {
+		t.Run(testcase.name, func(t *testing.T) {
+			var handled, finalized error
+			server := grpctransport.NewServer(
+				testcase.e, testcase.dec, testcase.enc,
+				grpctransport.ServerRecover[interface{}, interface{}](),
+				grpctransport.ServerErrorHandler[interface{}, interface{}](transport.ErrorHandlerFunc(func(_ context.Context, err error) {
+					handled = err
+				})),
+				grpctransport.ServerFinalizer[interface{}, interface{}](func(_ context.Context, err error) {
+					finalized = err
+				}),
+			)
+
+			_, resp, err := server.ServeGRPC(context.Background(), struct{}{})
+			if resp != nil {
+				t.Errorf("want nil response, have %v", resp)
+			}
+			if want, have := codes.Internal, status.Code(err); want != have {
+				t.Errorf("want %s, have %s", want, have)
+			}
+			var perr *recovery.PanicError
+			if !errors.As(handled, &perr) {
+				t.Fatalf("want *recovery.PanicError handled, have %v", handled)
+			}
+			if want, have := testcase.name, perr.Value; want != have {
+				t.Errorf("want %v, have %v", want, have)
+			}
+			if want, have := err, finalized; want != have {
+				t.Errorf("finalizer: want %v, have %v", want, have)
+			}
+		})
+	}
+}
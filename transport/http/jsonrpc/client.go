@@ -28,7 +28,7 @@ type Client[I, O interface{}] struct {
 	dec            DecodeResponseFunc[O]
 	before         []httpTransport.RequestFunc
 	after          []httpTransport.ClientResponseFunc
-	finalizer      httpTransport.ClientFinalizerFunc
+	finalizer      []httpTransport.ClientFinalizerFunc
 	requestID      RequestIDGenerator
 	bufferedStream bool
 }
@@ -100,10 +100,11 @@ func ClientAfter[I, O interface{}](after ...httpTransport.ClientResponseFunc) Cl
 	return func(c *Client[I, O]) { c.after = append(c.after, after...) }
 }
 
-// ClientFinalizer is executed at the end of every HTTP request.
-// By default, no finalizer is registered.
-func ClientFinalizer[I, O interface{}](f httpTransport.ClientFinalizerFunc) ClientOption[I, O] {
-	return func(c *Client[I, O]) { c.finalizer = f }
+// ClientFinalizer adds one or more ClientFinalizerFuncs to be executed at the
+// end of every HTTP request. Finalizers are executed in the order in which they
+// were added. By default, no finalizer is registered.
+func ClientFinalizer[I, O interface{}](f ...httpTransport.ClientFinalizerFunc) ClientOption[I, O] {
+	return func(c *Client[I, O]) { c.finalizer = append(c.finalizer, f...) }
 }
 
 // ClientRequestEncoder sets the func used to encode the request params to JSON.
@@ -145,13 +146,15 @@ func (c Client[I, O]) Endpoint() endpoint.Endpoint[O] {
 		var (
 			resp *http.Response
 		)
-		if c.finalizer != nil {
+		if len(c.finalizer) > 0 {
 			defer func() {
 				if resp != nil {
 					ctx = context.WithValue(ctx, httpTransport.ContextKeyResponseHeaders, resp.Header)
 					ctx = context.WithValue(ctx, httpTransport.ContextKeyResponseSize, resp.ContentLength)
 				}
-				c.finalizer(ctx, err)
+				for _, f := range c.finalizer {
+					f(ctx, err)
+				}
 			}()
 		}
 
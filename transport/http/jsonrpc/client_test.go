@@ -238,6 +238,39 @@ func TestClientHappyPath(t *testing.T) {
 	}
 }
 
+func TestMultipleClientFinalizer(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`{"jsonrpc":"2.0", "result":"boogaloo"}`))
+	}))
+	defer server.Close()
+
+	var calls []int
+	sut := jsonrpc.NewClient[interface{}, interface{}](
+		mustParse(server.URL),
+		"add",
+		jsonrpc.ClientFinalizer[interface{}, interface{}](func(context.Context, error) { calls = append(calls, 1) }),
+		jsonrpc.ClientFinalizer[interface{}, interface{}](
+			func(context.Context, error) { calls = append(calls, 2) },
+			func(context.Context, error) { calls = append(calls, 3) },
+		),
+	)
+
+	if _, err := sut.Endpoint()(context.Background(), struct{}{}); err != nil {
+		t.Fatal(err)
+	}
+	if want, have := 3, len(calls); want != have {
+		t.Fatalf("want %d finalizer calls, have %d", want, have)
+	}
+	for i, call := range calls {
+		if call != i+1 {
+			t.Fatalf("want finalizers called in order, have %v", calls)
+		}
+	}
+}
+
 func TestCanUseDefaults(t *testing.T) {
 	t.Parallel()
 
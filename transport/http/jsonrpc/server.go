@@ -8,6 +8,7 @@ import (
 	"net/http"
 
 	"github.com/tnnyio/log"
+	"github.com/tnnyio/yoroi/recovery"
 	httpTransport "github.com/tnnyio/yoroi/transport/http"
 )
 
@@ -22,8 +23,10 @@ type Server struct {
 	beforeCodec  []RequestFunc
 	after        []httpTransport.ServerResponseFunc
 	errorEncoder httpTransport.ErrorEncoder
-	finalizer    httpTransport.ServerFinalizerFunc
+	errorWraps   []ErrorEncoderMiddleware
+	finalizer    []httpTransport.ServerFinalizerFunc
 	logger       log.Logger
+	recover      bool
 }
 
 // NewServer constructs a new server, which implements http.Server.
@@ -39,6 +42,9 @@ func NewServer(
 	for _, option := range options {
 		option(s)
 	}
+	for i := len(s.errorWraps) - 1; i >= 0; i-- {
+		s.errorEncoder = s.errorWraps[i](s.errorEncoder)
+	}
 	return s
 }
 
@@ -73,6 +79,17 @@ func ServerErrorEncoder(ee httpTransport.ErrorEncoder) ServerOption {
 	return func(s *Server) { s.errorEncoder = ee }
 }
 
+// ErrorEncoderMiddleware wraps an ErrorEncoder, for instance to observe the
+// errors it encodes.
+type ErrorEncoderMiddleware func(httpTransport.ErrorEncoder) httpTransport.ErrorEncoder
+
+// ServerErrorEncoderMiddleware adds middlewares wrapping the ErrorEncoder,
+// whichever option sets it, so that several options can observe the encoded
+// errors. The first middleware added is the outermost.
+func ServerErrorEncoderMiddleware(mw ...ErrorEncoderMiddleware) ServerOption {
+	return func(s *Server) { s.errorWraps = append(s.errorWraps, mw...) }
+}
+
 // ServerErrorLogger is used to log non-terminal errors. By default, no errors
 // are logged. This is intended as a diagnostic measure. Finer-grained control
 // of error handling, including logging in more detail, should be performed in a
@@ -82,10 +99,20 @@ func ServerErrorLogger(logger log.Logger) ServerOption {
 	return func(s *Server) { s.logger = logger }
 }
 
-// ServerFinalizer is executed at the end of every HTTP request.
-// By default, no finalizer is registered.
-func ServerFinalizer(f httpTransport.ServerFinalizerFunc) ServerOption {
-	return func(s *Server) { s.finalizer = f }
+// ServerFinalizer adds one or more ServerFinalizerFuncs to be executed at the
+// end of every HTTP request. Finalizers are executed in the order in which they
+// were added. By default, no finalizer is registered.
+func ServerFinalizer(f ...httpTransport.ServerFinalizerFunc) ServerOption {
+	return func(s *Server) { s.finalizer = append(s.finalizer, f...) }
+}
+
+// ServerRecover makes the server recover panics in any phase of the request,
+// from the ServerBefore functions to the encoder. A recovered panic is turned
+// into a *recovery.PanicError, logged and encoded by the ErrorEncoder as an
+// internal error. By default, panics are not recovered. Panics with
+// http.ErrAbortHandler are always propagated.
+func ServerRecover() ServerOption {
+	return func(s *Server) { s.recover = true }
 }
 
 // ServeHTTP implements http.Handler.
@@ -98,12 +125,29 @@ func (s Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 	ctx := r.Context()
 
-	if s.finalizer != nil {
+	if len(s.finalizer) > 0 {
 		iw := &interceptingWriter{w, http.StatusOK}
-		defer func() { s.finalizer(ctx, iw.code, r) }()
+		defer func() {
+			for _, f := range s.finalizer {
+				f(ctx, iw.code, r)
+			}
+		}()
 		w = iw
 	}
 
+	if s.recover {
+		defer func() {
+			if v := recover(); v != nil {
+				if v == http.ErrAbortHandler {
+					panic(v)
+				}
+				err := recovery.NewPanicError(v)
+				s.logger.Log("err", err)
+				s.errorEncoder(ctx, err, w)
+			}
+		}()
+	}
+
 	for _, f := range s.before {
 		ctx = f(ctx, r)
 	}
@@ -176,6 +220,8 @@ func (s Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 // The Error() string of the error will be used as the response error message.
 // If the error implements ErrorCoder, the provided code will be set on the
 // response error.
+// If the error implements ErrorDataer, the provided data will be set on the
+// response error.
 // If the error implements Headerer, the given headers will be set.
 func DefaultErrorEncoder(ctx context.Context, err error, w http.ResponseWriter) {
 	w.Header().Set("Content-Type", ContentType)
@@ -192,6 +238,9 @@ func DefaultErrorEncoder(ctx context.Context, err error, w http.ResponseWriter)
 	if sc, ok := err.(ErrorCoder); ok {
 		e.Code = sc.ErrorCode()
 	}
+	if d, ok := err.(ErrorDataer); ok {
+		e.Data = d.ErrorData()
+	}
 
 	w.WriteHeader(http.StatusOK)
 
@@ -215,6 +264,13 @@ type ErrorCoder interface {
 	ErrorCode() int
 }
 
+// ErrorDataer is checked by DefaultErrorEncoder. If an error value implements
+// ErrorDataer, the result of ErrorData() will be used as the data member of
+// the JSONRPC error when encoding the error.
+type ErrorDataer interface {
+	ErrorData() interface{}
+}
+
 // interceptingWriter intercepts calls to WriteHeader, so that a finalizer
 // can be given the correct status code.
 type interceptingWriter struct {
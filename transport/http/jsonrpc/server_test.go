@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
@@ -12,6 +13,7 @@ import (
 	"time"
 
 	"github.com/tnnyio/yoroi/endpoint"
+	httptransport "github.com/tnnyio/yoroi/transport/http"
 	"github.com/tnnyio/yoroi/transport/http/jsonrpc"
 )
 
@@ -128,6 +130,30 @@ func TestServerBadEndpoint(t *testing.T) {
 	expectValidRequestID(t, 1, buf)
 }
 
+func TestServerRecover(t *testing.T) {
+	ecm := jsonrpc.EndpointCodecMap{
+		"add": jsonrpc.EndpointCodec{
+			Endpoint: func(context.Context, interface{}) (interface{}, error) { panic("oof") },
+			Decode:   nopDecoder,
+			Encode:   nopEncoder,
+		},
+	}
+	logger := mockLogger{}
+	handler := jsonrpc.NewServer(ecm, jsonrpc.ServerRecover(), jsonrpc.ServerErrorLogger(&logger))
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	resp, _ := http.Post(server.URL, "application/json", addBody())
+	if want, have := http.StatusOK, resp.StatusCode; want != have {
+		t.Errorf("want %d, have %d", want, have)
+	}
+	buf, _ := io.ReadAll(resp.Body)
+	expectErrorCode(t, jsonrpc.InternalError, buf)
+	expectValidRequestID(t, 1, buf)
+	if !logger.Called {
+		t.Fatal("Expected logger to be called with error. Wasn't.")
+	}
+}
+
 func TestServerBadEncode(t *testing.T) {
 	ecm := jsonrpc.EndpointCodecMap{
 		"add": jsonrpc.EndpointCodec{
@@ -175,6 +201,42 @@ func TestServerErrorEncoder(t *testing.T) {
 	}
 }
 
+func TestServerErrorEncoderMiddleware(t *testing.T) {
+	errTeapot := errors.New("teapot")
+	ecm := jsonrpc.EndpointCodecMap{
+		"add": jsonrpc.EndpointCodec{
+			Endpoint: func(context.Context, interface{}) (interface{}, error) { return struct{}{}, errTeapot },
+			Decode:   nopDecoder,
+			Encode:   nopEncoder,
+		},
+	}
+	var observed []string
+	observe := func(name string) jsonrpc.ErrorEncoderMiddleware {
+		return func(next httptransport.ErrorEncoder) httptransport.ErrorEncoder {
+			return func(ctx context.Context, err error, w http.ResponseWriter) {
+				observed = append(observed, name+": "+err.Error())
+				next(ctx, err, w)
+			}
+		}
+	}
+	// The middlewares wrap the encoder set after them too.
+	handler := jsonrpc.NewServer(
+		ecm,
+		jsonrpc.ServerErrorEncoderMiddleware(observe("a")),
+		jsonrpc.ServerErrorEncoderMiddleware(observe("b")),
+		jsonrpc.ServerErrorEncoder(func(_ context.Context, err error, w http.ResponseWriter) { w.WriteHeader(http.StatusTeapot) }),
+	)
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	resp, _ := http.Post(server.URL, "application/json", addBody())
+	if want, have := http.StatusTeapot, resp.StatusCode; want != have {
+		t.Errorf("want %d, have %d", want, have)
+	}
+	if want, have := "[a: teapot b: teapot]", fmt.Sprint(observed); want != have {
+		t.Errorf("want %s, have %s", want, have)
+	}
+}
+
 func TestCanRejectNonPostRequest(t *testing.T) {
 	ecm := jsonrpc.EndpointCodecMap{}
 	handler := jsonrpc.NewServer(ecm)
@@ -374,6 +436,30 @@ func TestCanFinalize(t *testing.T) {
 	}
 }
 
+func TestMultipleServerFinalizer(t *testing.T) {
+	var calls []int
+	ecm := jsonrpc.EndpointCodecMap{
+		"add": jsonrpc.EndpointCodec{
+			Endpoint: endpoint.Nop,
+			Decode:   nopDecoder,
+			Encode:   nopEncoder,
+		},
+	}
+	handler := jsonrpc.NewServer(
+		ecm,
+		jsonrpc.ServerFinalizer(func(context.Context, int, *http.Request) { calls = append(calls, 1) }),
+		jsonrpc.ServerFinalizer(
+			func(context.Context, int, *http.Request) { calls = append(calls, 2) },
+			func(context.Context, int, *http.Request) { calls = append(calls, 3) },
+		),
+	)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", addBody()))
+	if want, have := "[1 2 3]", fmt.Sprint(calls); want != have {
+		t.Errorf("want %s, have %s", want, have)
+	}
+}
+
 func testServer(t *testing.T) (step func(), resp <-chan *http.Response) {
 	var (
 		stepch   = make(chan bool)
@@ -7,6 +7,7 @@ import (
 
 	"github.com/tnnyio/log"
 	"github.com/tnnyio/yoroi/endpoint"
+	"github.com/tnnyio/yoroi/recovery"
 	"github.com/tnnyio/yoroi/transport"
 )
 
@@ -18,8 +19,10 @@ type Server[I, O interface{}] struct {
 	before       []RequestFunc
 	after        []ServerResponseFunc
 	errorEncoder ErrorEncoder
+	errorWraps   []ErrorEncoderMiddleware
 	finalizer    []ServerFinalizerFunc
 	errorHandler transport.ErrorHandler
+	recover      bool
 }
 
 // NewServer constructs a new server, which implements http.Handler and wraps
@@ -40,6 +43,9 @@ func NewServer[I, O interface{}](
 	for _, option := range options {
 		option(s)
 	}
+	for i := len(s.errorWraps) - 1; i >= 0; i-- {
+		s.errorEncoder = s.errorWraps[i](s.errorEncoder)
+	}
 	return s
 }
 
@@ -66,6 +72,17 @@ func ServerErrorEncoder[I, O interface{}](ee ErrorEncoder) ServerOption[I, O] {
 	return func(s *Server[I, O]) { s.errorEncoder = ee }
 }
 
+// ErrorEncoderMiddleware wraps an ErrorEncoder, for instance to observe the
+// errors it encodes.
+type ErrorEncoderMiddleware func(ErrorEncoder) ErrorEncoder
+
+// ServerErrorEncoderMiddleware adds middlewares wrapping the ErrorEncoder,
+// whichever option sets it, so that several options can observe the encoded
+// errors. The first middleware added is the outermost.
+func ServerErrorEncoderMiddleware[I, O interface{}](mw ...ErrorEncoderMiddleware) ServerOption[I, O] {
+	return func(s *Server[I, O]) { s.errorWraps = append(s.errorWraps, mw...) }
+}
+
 // ServerErrorLogger is used to log non-terminal errors. By default, no errors
 // are logged. This is intended as a diagnostic measure. Finer-grained control
 // of error handling, including logging in more detail, should be performed in a
@@ -91,6 +108,15 @@ func ServerFinalizer[I, O interface{}](f ...ServerFinalizerFunc) ServerOption[I,
 	return func(s *Server[I, O]) { s.finalizer = append(s.finalizer, f...) }
 }
 
+// ServerRecover makes the server recover panics in any phase of the request,
+// from the ServerBefore functions to the encoder. A recovered panic is turned
+// into a *recovery.PanicError, handled by the ErrorHandler and encoded by the
+// ErrorEncoder. By default, panics are not recovered. Panics with
+// http.ErrAbortHandler are always propagated.
+func ServerRecover[I, O interface{}]() ServerOption[I, O] {
+	return func(s *Server[I, O]) { s.recover = true }
+}
+
 // ServeHTTP implements http.Handler.
 func (s Server[I, O]) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
@@ -107,6 +133,19 @@ func (s Server[I, O]) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		w = iw.reimplementInterfaces()
 	}
 
+	if s.recover {
+		defer func() {
+			if v := recover(); v != nil {
+				if v == http.ErrAbortHandler {
+					panic(v)
+				}
+				err := recovery.NewPanicError(v)
+				s.errorHandler.Handle(ctx, err)
+				s.errorEncoder(ctx, err, w)
+			}
+		}()
+	}
+
 	for _, f := range s.before {
 		ctx = f(ctx, r)
 	}
@@ -11,6 +11,8 @@ import (
 	"time"
 
 	"github.com/tnnyio/yoroi/endpoint"
+	"github.com/tnnyio/yoroi/recovery"
+	"github.com/tnnyio/yoroi/transport"
 	httptransport "github.com/tnnyio/yoroi/transport/http"
 )
 
@@ -78,6 +80,92 @@ func TestServerErrorEncoder(t *testing.T) {
 	}
 }
 
+func TestServerErrorEncoderMiddleware(t *testing.T) {
+	errTeapot := errors.New("teapot")
+	var observed []string
+	observe := func(name string) httptransport.ErrorEncoderMiddleware {
+		return func(next httptransport.ErrorEncoder) httptransport.ErrorEncoder {
+			return func(ctx context.Context, err error, w http.ResponseWriter) {
+				observed = append(observed, name+": "+err.Error())
+				next(ctx, err, w)
+			}
+		}
+	}
+	// The middlewares wrap the encoder set after them too.
+	handler := httptransport.NewServer(
+		func(context.Context, interface{}) (interface{}, error) { return struct{}{}, nil },
+		func(context.Context, *http.Request) (interface{}, error) { return struct{}{}, errTeapot },
+		func(context.Context, http.ResponseWriter, interface{}) error { return nil },
+		httptransport.ServerErrorEncoderMiddleware[interface{}, interface{}](observe("a")),
+		httptransport.ServerErrorEncoderMiddleware[interface{}, interface{}](observe("b")),
+		httptransport.ServerErrorEncoder[interface{}, interface{}](func(_ context.Context, err error, w http.ResponseWriter) { w.WriteHeader(http.StatusTeapot) }),
+	)
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	resp, _ := http.Get(server.URL)
+	if want, have := http.StatusTeapot, resp.StatusCode; want != have {
+		t.Errorf("want %d, have %d", want, have)
+	}
+	if want, have := "a: teapot, b: teapot", strings.Join(observed, ", "); want != have {
+		t.Errorf("want %s, have %s", want, have)
+	}
+}
+
+func TestServerRecover(t *testing.T) {
+	for _, testcase := range []struct {
+		name string
+		dec  httptransport.DecodeRequestFunc[interface{}]
+		e    endpoint.Endpoint[interface{}]
+		enc  httptransport.EncodeResponseFunc[interface{}]
+	}{
+		{
+			name: "decode",
+			dec:  func(context.Context, *http.Request) (interface{}, error) { panic("decode") },
+			e:    endpoint.Nop,
+			enc:  func(context.Context, http.ResponseWriter, interface{}) error { return nil },
+		},
+		{
+			name: "endpoint",
+			dec:  func(context.Context, *http.Request) (interface{}, error) { return struct{}{}, nil },
+			e:    func(context.Context, interface{}) (interface{}, error) { panic("endpoint") },
+			enc:  func(context.Context, http.ResponseWriter, interface{}) error { return nil },
+		},
+		{
+			name: "encode",
+			dec:  func(context.Context, *http.Request) (interface{}, error) { return struct{}{}, nil },
+			e:    endpoint.Nop,
+			enc:  func(context.Context, http.ResponseWriter, interface{}) error { panic("encode") },
+		},
+	} {
+		t.Run(testcase.name, func(t *testing.T) {
+			var handled error
+			handler := httptransport.NewServer(
+				testcase.e, testcase.dec, testcase.enc,
+				httptransport.ServerRecover[interface{}, interface{}](),
+				httptransport.ServerErrorHandler[interface{}, interface{}](transport.ErrorHandlerFunc(func(_ context.Context, err error) {
+					handled = err
+				})),
+			)
+			server := httptest.NewServer(handler)
+			defer server.Close()
+			resp, err := http.Get(server.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want, have := http.StatusInternalServerError, resp.StatusCode; want != have {
+				t.Errorf("want %d, have %d", want, have)
+			}
+			var perr *recovery.PanicError
+			if !errors.As(handled, &perr) {
+				t.Fatalf("want *recovery.PanicError handled, have %v", handled)
+			}
+			if want, have := testcase.name, perr.Value; want != have {
+				t.Errorf("want %v, have %v", want, have)
+			}
+		})
+	}
+}
+
 func TestServerHappyPath(t *testing.T) {
 	step, response := testServer(t)
 	step()
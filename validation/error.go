@@ -0,0 +1,127 @@
+package validation
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// InvalidParamsCode is the JSON RPC error code of a validation Error,
+// "invalid params".
+const InvalidParamsCode = -32602
+
+// FieldError describes a single invalid field of a request.
+type FieldError struct {
+	// Field is the path of the invalid field, e.g. "address.zip" or
+	// "items[2].name". It is empty for errors that concern the request as a
+	// whole.
+	Field string `json:"field,omitempty"`
+
+	// Message describes why the field is invalid.
+	Message string `json:"message"`
+}
+
+// Error is a validation error that may describe several invalid fields. It
+// is encoded by the transports as follows.
+//
+//   - HTTP: status 400, with a JSON body listing the fields.
+//   - gRPC: codes.InvalidArgument, with an errdetails.BadRequest detail.
+//   - JSON RPC: error code -32602 (invalid params), with the fields as data.
+type Error struct {
+	Fields []FieldError
+}
+
+// NewError returns an Error for the given fields.
+func NewError(fields ...FieldError) *Error {
+	return &Error{Fields: fields}
+}
+
+// Add appends an invalid field to the error.
+func (e *Error) Add(field, message string) {
+	e.Fields = append(e.Fields, FieldError{Field: field, Message: message})
+}
+
+// Addf is like Add, but formats the message according to a format specifier.
+func (e *Error) Addf(field, format string, args ...interface{}) {
+	e.Add(field, fmt.Sprintf(format, args...))
+}
+
+// Err returns e if it holds at least one field, and nil otherwise. It allows
+// Validate methods to accumulate fields and return the result directly.
+func (e *Error) Err() error {
+	if e == nil || len(e.Fields) == 0 {
+		return nil
+	}
+	return e
+}
+
+// Error implements error.
+func (e *Error) Error() string {
+	if len(e.Fields) == 0 {
+		return "validation failed"
+	}
+	fields := make([]string, len(e.Fields))
+	for i, f := range e.Fields {
+		fields[i] = f.Message
+		if f.Field != "" {
+			fields[i] = f.Field + ": " + f.Message
+		}
+	}
+	return "validation failed: " + strings.Join(fields, "; ")
+}
+
+// StatusCode implements the StatusCoder interfaces of the HTTP transports.
+func (e *Error) StatusCode() int {
+	return http.StatusBadRequest
+}
+
+// MarshalJSON implements json.Marshaler, which the HTTP transports use to
+// encode the error body.
+func (e *Error) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Error  string       `json:"error"`
+		Fields []FieldError `json:"fields"`
+	}{
+		Error:  "validation failed",
+		Fields: e.fields(),
+	})
+}
+
+// ErrorCode implements the ErrorCoder interface of the JSON RPC transport.
+func (e *Error) ErrorCode() int {
+	return InvalidParamsCode
+}
+
+// ErrorData implements the ErrorDataer interface of the JSON RPC transport.
+func (e *Error) ErrorData() interface{} {
+	return e.fields()
+}
+
+// GRPCStatus makes gRPC servers answer with codes.InvalidArgument and an
+// errdetails.BadRequest describing the invalid fields.
+func (e *Error) GRPCStatus() *status.Status {
+	st := status.New(codes.InvalidArgument, e.Error())
+	br := &errdetails.BadRequest{}
+	for _, f := range e.Fields {
+		br.FieldViolations = append(br.FieldViolations, &errdetails.BadRequest_FieldViolation{
+			Field:       f.Field,
+			Description: f.Message,
+		})
+	}
+	if detailed, err := st.WithDetails(br); err == nil {
+		return detailed
+	}
+	return st
+}
+
+func (e *Error) fields() []FieldError {
+	if e.Fields == nil {
+		return []FieldError{}
+	}
+	return e.Fields
+}
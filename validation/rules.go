@@ -0,0 +1,235 @@
+package validation
+
+import (
+	"fmt"
+	"net/mail"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// TagName is the struct tag holding validation rules.
+const TagName = "validate"
+
+// Struct checks the rules declared in the `validate` struct tags of v, which
+// must be a struct or a pointer to one; other values are considered valid.
+// Rules are separated by commas. The supported rules are:
+//
+//	required    the value must not be the zero value (or a nil pointer)
+//	min=N       numbers must be >= N; strings, slices and maps must have
+//	            at least N elements
+//	max=N       numbers must be <= N; strings, slices and maps must have
+//	            at most N elements
+//	len=N       strings, slices and maps must have exactly N elements
+//	oneof=A B   the value, formatted as a string, must be one of the
+//	            space-separated values
+//	email       strings must be a valid email address
+//	url         strings must be an absolute URL
+//
+// Rules other than required are skipped for zero values, so optional fields
+// only need to be valid when set. Nested structs, pointers to structs, and
+// slices of structs are checked recursively. Fields are named after their
+// JSON name when they have one. Struct returns nil or an *Error, and panics
+// on malformed tags.
+func Struct(v interface{}) error {
+	e := &Error{}
+	checkValue(e, "", reflect.ValueOf(v))
+	return e.Err()
+}
+
+type rule struct {
+	name string
+	arg  string
+	num  float64
+}
+
+type field struct {
+	index int
+	name  string
+	rules []rule
+}
+
+var typeCache sync.Map // map[reflect.Type][]field
+
+func checkValue(e *Error, path string, v reflect.Value) {
+	for v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return
+		}
+		v = v.Elem()
+	}
+	switch v.Kind() {
+	case reflect.Struct:
+		for _, f := range fieldsOf(v.Type()) {
+			fv := v.Field(f.index)
+			fpath := join(path, f.name)
+			if checkRules(e, fpath, fv, f.rules) {
+				checkValue(e, fpath, fv)
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			checkValue(e, fmt.Sprintf("%s[%d]", path, i), v.Index(i))
+		}
+	}
+}
+
+// checkRules reports violations of rules by v, and returns whether v should
+// be descended into.
+func checkRules(e *Error, path string, v reflect.Value, rules []rule) bool {
+	zero := v.IsZero()
+	for _, r := range rules {
+		if r.name == "required" {
+			if zero {
+				e.Add(path, "is required")
+				return false
+			}
+			continue
+		}
+		if zero {
+			continue
+		}
+		if msg := check(r, indirect(v)); msg != "" {
+			e.Add(path, msg)
+		}
+	}
+	return !zero
+}
+
+func check(r rule, v reflect.Value) string {
+	switch r.name {
+	case "min", "max", "len":
+		n, unit, ok := magnitude(v)
+		if !ok {
+			panic(fmt.Sprintf("validation: rule %q is not applicable to %s", r.name, v.Type()))
+		}
+		switch {
+		case r.name == "min" && n < r.num && unit != "":
+			return fmt.Sprintf("must have at least %s %s", r.arg, unit)
+		case r.name == "min" && n < r.num:
+			return fmt.Sprintf("must be at least %s", r.arg)
+		case r.name == "max" && n > r.num && unit != "":
+			return fmt.Sprintf("must have at most %s %s", r.arg, unit)
+		case r.name == "max" && n > r.num:
+			return fmt.Sprintf("must be at most %s", r.arg)
+		case r.name == "len" && n != r.num:
+			return fmt.Sprintf("must have exactly %s %s", r.arg, unit)
+		}
+	case "oneof":
+		s := fmt.Sprint(v.Interface())
+		for _, candidate := range strings.Fields(r.arg) {
+			if s == candidate {
+				return ""
+			}
+		}
+		return fmt.Sprintf("must be one of [%s]", r.arg)
+	case "email":
+		if _, err := mail.ParseAddress(asString(r, v)); err != nil {
+			return "must be a valid email address"
+		}
+	case "url":
+		if u, err := url.Parse(asString(r, v)); err != nil || !u.IsAbs() || u.Host == "" {
+			return "must be an absolute URL"
+		}
+	}
+	return ""
+}
+
+// magnitude returns the value of numbers, or the length of strings, slices,
+// arrays and maps along with the unit of that length.
+func magnitude(v reflect.Value) (n float64, unit string, ok bool) {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), "", true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return float64(v.Uint()), "", true
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), "", true
+	case reflect.String:
+		return float64(len([]rune(v.String()))), "characters", true
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return float64(v.Len()), "elements", true
+	}
+	return 0, "", false
+}
+
+func asString(r rule, v reflect.Value) string {
+	if v.Kind() != reflect.String {
+		panic(fmt.Sprintf("validation: rule %q is not applicable to %s", r.name, v.Type()))
+	}
+	return v.String()
+}
+
+func indirect(v reflect.Value) reflect.Value {
+	for v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+		v = v.Elem()
+	}
+	return v
+}
+
+func fieldsOf(t reflect.Type) []field {
+	if fields, ok := typeCache.Load(t); ok {
+		return fields.([]field)
+	}
+	var fields []field
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if !sf.IsExported() {
+			continue
+		}
+		fields = append(fields, field{
+			index: i,
+			name:  fieldName(sf),
+			rules: parseRules(t, sf),
+		})
+	}
+	typeCache.Store(t, fields)
+	return fields
+}
+
+func fieldName(sf reflect.StructField) string {
+	if tag, ok := sf.Tag.Lookup("json"); ok {
+		if name, _, _ := strings.Cut(tag, ","); name != "" && name != "-" {
+			return name
+		}
+	}
+	return sf.Name
+}
+
+func parseRules(t reflect.Type, sf reflect.StructField) []rule {
+	tag := sf.Tag.Get(TagName)
+	if tag == "" {
+		return nil
+	}
+	var rules []rule
+	for _, part := range strings.Split(tag, ",") {
+		name, arg, _ := strings.Cut(strings.TrimSpace(part), "=")
+		r := rule{name: name, arg: arg}
+		switch name {
+		case "required", "email", "url":
+		case "oneof":
+			if arg == "" {
+				panic(fmt.Sprintf("validation: %s.%s: rule oneof needs values", t, sf.Name))
+			}
+		case "min", "max", "len":
+			n, err := strconv.ParseFloat(arg, 64)
+			if err != nil {
+				panic(fmt.Sprintf("validation: %s.%s: rule %s needs a number, have %q", t, sf.Name, name, arg))
+			}
+			r.num = n
+		default:
+			panic(fmt.Sprintf("validation: %s.%s: unknown rule %q", t, sf.Name, name))
+		}
+		rules = append(rules, r)
+	}
+	return rules
+}
+
+func join(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
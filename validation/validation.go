@@ -0,0 +1,77 @@
+// Package validation provides request validation for endpoints, and an error
+// type that every transport maps to its "bad request" representation.
+//
+// Request types opt in by implementing Validator, or by declaring rules in
+// struct tags and enabling them with the StructTags option. See Struct for
+// the supported rules.
+package validation
+
+import (
+	"context"
+	"errors"
+
+	"github.com/tnnyio/yoroi/endpoint"
+)
+
+// Validator may be implemented by request types. Validate should return nil
+// for valid requests. Returning an *Error allows describing each invalid
+// field; any other error is wrapped into an *Error without field details.
+type Validator interface {
+	Validate() error
+}
+
+// Option sets an optional parameter for the validation middleware.
+type Option func(*options)
+
+// StructTags makes the middleware also check the rules declared in the
+// `validate` struct tags of the request, as described by Struct. Violations
+// of tag rules are reported together with the fields returned by Validate.
+func StructTags() Option {
+	return func(o *options) { o.structTags = true }
+}
+
+type options struct {
+	structTags bool
+}
+
+// New returns an endpoint.Middleware that validates requests before passing
+// them to the next endpoint. Invalid requests are rejected with an *Error.
+// Requests that don't implement Validator are passed through unless
+// StructTags is set.
+func New[O interface{}](opts ...Option) endpoint.Middleware[O] {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return func(next endpoint.Endpoint[O]) endpoint.Endpoint[O] {
+		return func(ctx context.Context, request interface{}) (response O, err error) {
+			if err := Validate(request, o.structTags); err != nil {
+				return response, err
+			}
+			return next(ctx, request)
+		}
+	}
+}
+
+// Validate validates request with its Validate method, if it implements
+// Validator, and with its struct tag rules if structTags is true. It returns
+// nil or an *Error.
+func Validate(request interface{}, structTags bool) error {
+	verr := &Error{}
+	if structTags {
+		if err := Struct(request); err != nil {
+			verr.Fields = append(verr.Fields, err.(*Error).Fields...)
+		}
+	}
+	if v, ok := request.(Validator); ok {
+		if err := v.Validate(); err != nil {
+			var e *Error
+			if errors.As(err, &e) {
+				verr.Fields = append(verr.Fields, e.Fields...)
+			} else {
+				verr.Fields = append(verr.Fields, FieldError{Message: err.Error()})
+			}
+		}
+	}
+	return verr.Err()
+}
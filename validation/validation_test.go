@@ -0,0 +1,226 @@
+package validation_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	httptransport "github.com/tnnyio/yoroi/transport/http"
+	"github.com/tnnyio/yoroi/transport/http/jsonrpc"
+	"github.com/tnnyio/yoroi/validation"
+)
+
+type address struct {
+	Zip string `json:"zip" validate:"required,len=5"`
+}
+
+type createUser struct {
+	Name    string    `json:"name" validate:"required,min=2,max=10"`
+	Email   string    `json:"email" validate:"email"`
+	Age     int       `json:"age" validate:"min=18,max=130"`
+	Role    string    `json:"role" validate:"oneof=admin user"`
+	Website string    `validate:"url"`
+	Tags    []string  `json:"tags" validate:"max=2"`
+	Address *address  `json:"address" validate:"required"`
+	Others  []address `json:"others"`
+}
+
+func (r createUser) Validate() error {
+	if r.Role == "admin" && r.Age < 21 {
+		return validation.NewError(validation.FieldError{Field: "role", Message: "admins must be at least 21"})
+	}
+	return nil
+}
+
+func validUser() createUser {
+	return createUser{
+		Name:    "gopher",
+		Email:   "gopher@example.com",
+		Age:     20,
+		Role:    "user",
+		Website: "https://example.com",
+		Address: &address{Zip: "12345"},
+	}
+}
+
+func TestStructValid(t *testing.T) {
+	if err := validation.Struct(validUser()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	u := validUser()
+	u.Email, u.Age, u.Role, u.Website = "", 0, "", "" // optional fields
+	if err := validation.Struct(&u); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestStructViolations(t *testing.T) {
+	u := createUser{
+		Name:    "g",
+		Email:   "not-an-email",
+		Age:     12,
+		Role:    "root",
+		Website: "/relative",
+		Tags:    []string{"a", "b", "c"},
+		Others:  []address{{Zip: "12345"}, {Zip: "1"}},
+	}
+	err := validation.Struct(u)
+
+	var verr *validation.Error
+	if !errors.As(err, &verr) {
+		t.Fatalf("want *validation.Error, have %v", err)
+	}
+	want := []validation.FieldError{
+		{Field: "name", Message: "must have at least 2 characters"},
+		{Field: "email", Message: "must be a valid email address"},
+		{Field: "age", Message: "must be at least 18"},
+		{Field: "role", Message: "must be one of [admin user]"},
+		{Field: "Website", Message: "must be an absolute URL"},
+		{Field: "tags", Message: "must have at most 2 elements"},
+		{Field: "address", Message: "is required"},
+		{Field: "others[1].zip", Message: "must have exactly 5 characters"},
+	}
+	if !reflect.DeepEqual(want, verr.Fields) {
+		t.Errorf("want %v, have %v", want, verr.Fields)
+	}
+}
+
+func TestStructMalformedTagPanics(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("want panic on malformed tag")
+		}
+	}()
+	validation.Struct(struct {
+		A int `validate:"min=x"`
+	}{})
+}
+
+func TestMiddleware(t *testing.T) {
+	var calls int
+	e := validation.New[string](validation.StructTags())(func(context.Context, interface{}) (string, error) {
+		calls++
+		return "ok", nil
+	})
+
+	if _, err := e(context.Background(), validUser()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	u := validUser()
+	u.Name = ""
+	u.Role = "admin"
+	_, err := e(context.Background(), u)
+	var verr *validation.Error
+	if !errors.As(err, &verr) {
+		t.Fatalf("want *validation.Error, have %v", err)
+	}
+	want := []validation.FieldError{
+		{Field: "name", Message: "is required"},
+		{Field: "role", Message: "admins must be at least 21"},
+	}
+	if !reflect.DeepEqual(want, verr.Fields) {
+		t.Errorf("want %v, have %v", want, verr.Fields)
+	}
+	if want, have := 1, calls; want != have {
+		t.Errorf("calls: want %d, have %d", want, have)
+	}
+}
+
+type plainValidator struct{}
+
+func (plainValidator) Validate() error { return errors.New("nope") }
+
+func TestMiddlewareWrapsPlainErrors(t *testing.T) {
+	e := validation.New[string]()(func(context.Context, interface{}) (string, error) { return "ok", nil })
+	_, err := e(context.Background(), plainValidator{})
+	var verr *validation.Error
+	if !errors.As(err, &verr) {
+		t.Fatalf("want *validation.Error, have %v", err)
+	}
+	if want, have := "validation failed: nope", err.Error(); want != have {
+		t.Errorf("want %q, have %q", want, have)
+	}
+}
+
+func TestMiddlewareWithoutStructTags(t *testing.T) {
+	e := validation.New[string]()(func(context.Context, interface{}) (string, error) { return "ok", nil })
+	if _, err := e(context.Background(), createUser{Address: &address{}}); err != nil {
+		t.Errorf("want tag rules ignored, have %v", err)
+	}
+}
+
+func testError() *validation.Error {
+	e := &validation.Error{}
+	e.Add("name", "is required")
+	e.Addf("age", "must be at least %d", 18)
+	return e
+}
+
+func TestErrorHTTP(t *testing.T) {
+	rec := httptest.NewRecorder()
+	httptransport.DefaultErrorEncoder(context.Background(), testError(), rec)
+
+	if want, have := http.StatusBadRequest, rec.Code; want != have {
+		t.Errorf("status: want %d, have %d", want, have)
+	}
+	var body struct {
+		Error  string                  `json:"error"`
+		Fields []validation.FieldError `json:"fields"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if want, have := testError().Fields, body.Fields; !reflect.DeepEqual(want, have) {
+		t.Errorf("fields: want %v, have %v", want, have)
+	}
+}
+
+func TestErrorGRPC(t *testing.T) {
+	st := status.Convert(testError())
+	if want, have := codes.InvalidArgument, st.Code(); want != have {
+		t.Errorf("code: want %s, have %s", want, have)
+	}
+	if len(st.Details()) != 1 {
+		t.Fatalf("want 1 detail, have %d", len(st.Details()))
+	}
+	br, ok := st.Details()[0].(*errdetails.BadRequest)
+	if !ok {
+		t.Fatalf("want *errdetails.BadRequest, have %T", st.Details()[0])
+	}
+	if want, have := 2, len(br.FieldViolations); want != have {
+		t.Fatalf("violations: want %d, have %d", want, have)
+	}
+	if want, have := "age", br.FieldViolations[1].Field; want != have {
+		t.Errorf("field: want %q, have %q", want, have)
+	}
+}
+
+func TestErrorJSONRPC(t *testing.T) {
+	rec := httptest.NewRecorder()
+	jsonrpc.DefaultErrorEncoder(context.Background(), testError(), rec)
+
+	var resp struct {
+		Error struct {
+			Code int                     `json:"code"`
+			Data []validation.FieldError `json:"data"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if want, have := jsonrpc.InvalidParamsError, resp.Error.Code; want != have {
+		t.Errorf("code: want %d, have %d", want, have)
+	}
+	if want, have := testError().Fields, resp.Error.Data; !reflect.DeepEqual(want, have) {
+		t.Errorf("data: want %v, have %v", want, have)
+	}
+}